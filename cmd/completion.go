@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// cliCommand describes a command of the command line for the completions and the man page
+type cliCommand struct {
+	name    string
+	args    string
+	summary string
+}
+
+var cliCommands = []cliCommand{
+	{"plan", "[record-uid]", "Print the projected changes as a JSON plan without making any change"},
+	{"simulate", "[record-uid]", "Print the projected changes under every destructive level without making any change"},
+	{"sync-user", "<email> [record-uid]", "Sync a single user"},
+	{"sync-group", "<name> [record-uid]", "Sync a single group and its members"},
+	{"daemon", "[record-uid]", "Sync periodically until terminated. SIGHUP triggers an immediate sync"},
+	{"watch", "<https-address> [record-uid]", "Register Google push notification channels that post user and group changes to the address"},
+	{"drift-report", "[record-uid]", "Print the JSON report of SCIM resources that do not exist in Google Workspace"},
+	{"unmanaged-report", "[record-uid]", "Print the JSON inventory of SCIM resources outside the sync scope"},
+	{"backfill-external-id", "[record-uid]", "Set the external ID of SCIM resources that match Google Workspace users and groups"},
+	{"rollback", "<run-id> [record-uid]", "Revert the changes of a recorded run"},
+	{"history", "[show <run-id>] [record-uid]", "List the recorded runs or show a run"},
+	{"diff-runs", "<from-run-id> <to-run-id> [record-uid]", "Compare the source snapshots of two recorded runs"},
+	{"completion", "<bash|zsh|fish>", "Print the shell completion script"},
+	{"man", "", "Print the manual page"},
+	{"version", "", "Print the version and build information"},
+}
+
+var cliFlags = []cliCommand{
+	{"--only-user", "<email>", "Limit the sync run to the user. Can be repeated"},
+	{"--only-group", "<name>", "Limit the sync run to the group and its members. Can be repeated"},
+	{"--version", "", "Print the version and build information"},
+}
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+func commandNames() (names []string) {
+	for _, c := range cliCommands {
+		names = append(names, c.name)
+	}
+	return
+}
+
+// writeCompletion prints the completion script of the shell
+func writeCompletion(w io.Writer, shell string) (err error) {
+	var commands = strings.Join(commandNames(), " ")
+	var shells = strings.Join(completionShells, " ")
+	switch shell {
+	case "bash":
+		_, err = fmt.Fprintf(w, `# bash completion for ksm-scim
+# source <(ksm-scim completion bash)
+_ksm_scim() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        completion)
+            COMPREPLY=($(compgen -W "%s" -- "$cur"))
+            return ;;
+        history)
+            COMPREPLY=($(compgen -W "show" -- "$cur"))
+            return ;;
+    esac
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "%s --only-user --only-group --version" -- "$cur"))
+    elif [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "--only-user --only-group" -- "$cur"))
+    fi
+}
+complete -F _ksm_scim ksm-scim
+`, shells, commands)
+	case "zsh":
+		var sb strings.Builder
+		for _, c := range cliCommands {
+			sb.WriteString(fmt.Sprintf("        '%s:%s'\n", c.name, strings.ReplaceAll(c.summary, "'", "'\\''")))
+		}
+		_, err = fmt.Fprintf(w, `#compdef ksm-scim
+# ksm-scim completion zsh > "${fpath[1]}/_ksm-scim"
+_ksm_scim() {
+    local -a commands
+    commands=(
+%s    )
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        _arguments '--only-user[limit the sync run to the user]:email:' '--only-group[limit the sync run to the group]:name:' '--version[print the version]'
+        return
+    fi
+    case "${words[2]}" in
+        completion) _values 'shell' %s ;;
+        history) (( CURRENT == 3 )) && _values 'subcommand' show ;;
+    esac
+}
+compdef _ksm_scim ksm-scim
+`, sb.String(), shells)
+	case "fish":
+		var sb strings.Builder
+		sb.WriteString("# ksm-scim completion fish > ~/.config/fish/completions/ksm-scim.fish\n")
+		sb.WriteString("complete -c ksm-scim -f\n")
+		for _, c := range cliCommands {
+			sb.WriteString(fmt.Sprintf("complete -c ksm-scim -n '__fish_use_subcommand' -a %s -d '%s'\n", c.name, strings.ReplaceAll(c.summary, "'", "\\'")))
+		}
+		sb.WriteString(fmt.Sprintf("complete -c ksm-scim -n '__fish_seen_subcommand_from completion' -a '%s'\n", shells))
+		sb.WriteString("complete -c ksm-scim -n '__fish_seen_subcommand_from history' -a show\n")
+		for _, f := range cliFlags {
+			sb.WriteString(fmt.Sprintf("complete -c ksm-scim -l %s -d '%s'", strings.TrimPrefix(f.name, "--"), f.summary))
+			if len(f.args) > 0 {
+				sb.WriteString(" -r")
+			}
+			sb.WriteString("\n")
+		}
+		_, err = io.WriteString(w, sb.String())
+	default:
+		err = fmt.Errorf("unsupported shell \"%s\": expected %s", shell, strings.Join(completionShells, ", "))
+	}
+	return
+}
+
+// manEscape escapes the text for roff
+func manEscape(text string) string {
+	text = strings.ReplaceAll(text, "\\", "\\e")
+	text = strings.ReplaceAll(text, "-", "\\-")
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+		text = "\\&" + text
+	}
+	return text
+}
+
+// writeManPage prints the ksm-scim(1) manual page in roff format
+func writeManPage(w io.Writer, version string) (err error) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(".TH KSM\\-SCIM 1 \"\" \"%s\" \"User Commands\"\n", manEscape(version)))
+	sb.WriteString(".SH NAME\nksm\\-scim \\- push Google Workspace users and groups to Keeper SCIM\n")
+	sb.WriteString(".SH SYNOPSIS\n.B ksm\\-scim\n[\\fIflags\\fR] [\\fIrecord\\-uid\\fR]\n.br\n.B ksm\\-scim\n\\fIcommand\\fR [\\fIarguments\\fR]\n")
+	sb.WriteString(".SH DESCRIPTION\nWithout a command, runs a single sync of the Google Workspace users and groups to the Keeper SCIM endpoint. ")
+	sb.WriteString("The configuration is read from the KSM record \\fIrecord\\-uid\\fR or from the environment variables described in ENV_CONFIG.md.\n")
+	sb.WriteString(".SH COMMANDS\n")
+	for _, c := range cliCommands {
+		sb.WriteString(".TP\n\\fB" + manEscape(c.name) + "\\fR")
+		if len(c.args) > 0 {
+			sb.WriteString(" " + manEscape(c.args))
+		}
+		sb.WriteString("\n" + manEscape(c.summary) + "\n")
+	}
+	sb.WriteString(".SH OPTIONS\n")
+	for _, f := range cliFlags {
+		sb.WriteString(".TP\n\\fB" + manEscape(f.name) + "\\fR")
+		if len(f.args) > 0 {
+			sb.WriteString(" " + manEscape(f.args))
+		}
+		sb.WriteString("\n" + manEscape(f.summary) + "\n")
+	}
+	sb.WriteString(".SH ENVIRONMENT\n")
+	sb.WriteString("Settings are read from the environment when the Google credentials, \\fBGOOGLE_ADMIN_ACCOUNT\\fR, \\fBSCIM_GROUPS\\fR, \\fBSCIM_URL\\fR and \\fBSCIM_TOKEN\\fR are set, otherwise from the KSM record. ")
+	sb.WriteString("See ENV_CONFIG.md for the complete list.\n")
+	sb.WriteString(".SH EXIT STATUS\nNon-zero if the configuration cannot be loaded or the command fails.\n")
+	_, err = io.WriteString(w, sb.String())
+	return
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/keeper-security/ksm-google-scim/scim"
+)
+
+// configCacheTtl is the environment variable that enables the encrypted configuration cache
+const configCacheTtl = "KSM_CONFIG_CACHE_TTL"
+
+// cachedConfiguration is the configuration resolved from the KSM record
+type cachedConfiguration struct {
+	RecordUid string                         `json:"recordUid"`
+	Saved     time.Time                      `json:"saved"`
+	Scim      *scim.ScimEndpointParameters   `json:"scim"`
+	Google    *scim.GoogleEndpointParameters `json:"google"`
+}
+
+func configCachePath() (path string, err error) {
+	var homeDir string
+	if homeDir, err = os.UserHomeDir(); err != nil {
+		return
+	}
+	path = filepath.Join(homeDir, ".ksm-scim", "config.cache")
+	return
+}
+
+// getConfigCacheTtl returns zero if the configuration cache is disabled
+func getConfigCacheTtl() (ttl time.Duration, err error) {
+	if value := os.Getenv(configCacheTtl); len(value) > 0 {
+		if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+			err = errors.New("\"" + configCacheTtl + "\" environment variable must be a duration, e.g. \"1h\"")
+		}
+	}
+	return
+}
+
+func newCacheCipher() (aead cipher.AEAD, err error) {
+	var key []byte
+	if key, err = loadCacheKey(); err != nil {
+		return
+	}
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	aead, err = cipher.NewGCM(block)
+	return
+}
+
+// loadCachedParameters returns nil configuration if the cache is missing, expired or belongs to another record
+func loadCachedParameters(recordUid string, ttl time.Duration) (config *cachedConfiguration, err error) {
+	var path string
+	if path, err = configCachePath(); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = newCacheCipher(); err != nil {
+		return
+	}
+	if len(data) < aead.NonceSize() {
+		err = errors.New("configuration cache is corrupted")
+		return
+	}
+	var plain []byte
+	if plain, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil); err != nil {
+		return
+	}
+	var cc = new(cachedConfiguration)
+	if err = json.Unmarshal(plain, cc); err != nil {
+		return
+	}
+	if cc.RecordUid != recordUid || time.Since(cc.Saved) > ttl || cc.Scim == nil || cc.Google == nil {
+		return
+	}
+	config = cc
+	return
+}
+
+func saveCachedParameters(config *cachedConfiguration) (err error) {
+	var path string
+	if path, err = configCachePath(); err != nil {
+		return
+	}
+	var plain []byte
+	if plain, err = json.Marshal(config); err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = newCacheCipher(); err != nil {
+		return
+	}
+	var nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	err = os.WriteFile(path, aead.Seal(nonce, nonce, plain, nil), 0600)
+	return
+}
+
+func newCacheKey() (key []byte, err error) {
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	return
+}
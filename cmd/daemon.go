@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"github.com/keeper-security/ksm-google-scim/scim"
+)
+
+// syncIntervalName is the environment variable with the interval between daemon sync runs
+const syncIntervalName = "SCIM_SYNC_INTERVAL"
+
+const defaultSyncInterval = time.Hour
+
+// daemonState is the state of the daemon reported on the stats dump signal.
+// Signals are handled while a sync runs, so the state is locked
+type daemonState struct {
+	lock     gosync.Mutex
+	started  time.Time
+	runs     int
+	running  bool
+	lastRun  time.Time
+	nextRun  time.Time
+	lastStat *scim.SyncStat
+	lastErr  error
+}
+
+func (ds *daemonState) beginRun() {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.running = true
+	ds.lastRun = time.Now()
+	ds.runs++
+}
+
+func (ds *daemonState) endRun(stat *scim.SyncStat, err error, nextRun time.Time) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.running = false
+	ds.lastStat, ds.lastErr = stat, err
+	ds.nextRun = nextRun
+}
+
+func (ds *daemonState) isRunning() bool {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return ds.running
+}
+
+func (ds *daemonState) dump() {
+	ds.lock.Lock()
+	var started, runs, running, lastRun, nextRun, lastStat, lastErr = ds.started, ds.runs, ds.running, ds.lastRun, ds.nextRun, ds.lastStat, ds.lastErr
+	ds.lock.Unlock()
+	if running {
+		log.Printf("Daemon state: started %s, %d run(s), sync running since %s", started.Format(time.RFC3339), runs, lastRun.Format(time.RFC3339))
+		// the statistics are of the previous run
+		runs--
+	} else {
+		log.Printf("Daemon state: started %s, %d run(s), next run at %s", started.Format(time.RFC3339), runs, nextRun.Format(time.RFC3339))
+	}
+	if runs == 0 {
+		return
+	}
+	if lastErr != nil {
+		log.Printf("Last completed run failed: %s", lastErr.Error())
+	} else {
+		log.Println("Last completed run succeeded")
+	}
+	printStatistics(log.Writer(), lastStat)
+}
+
+// handleSignals serves the sync now and dump signals while the daemon waits or a sync runs.
+// Sync requests received during a run start one sync once the run is finished.
+// A termination signal stops the daemon once the running sync is finished
+func (ds *daemonState) handleSignals(syncNow <-chan os.Signal, dumpStat <-chan os.Signal, terminate <-chan os.Signal,
+	runNow chan<- struct{}, stop chan<- os.Signal) {
+	for {
+		select {
+		case <-syncNow:
+			select {
+			case runNow <- struct{}{}:
+				if ds.isRunning() {
+					log.Println("Sync requested by signal: the sync starts once the running sync is finished")
+				} else {
+					log.Println("Sync requested by signal")
+				}
+			default:
+				log.Println("Sync requested by signal: a sync is already requested")
+			}
+		case <-dumpStat:
+			ds.dump()
+		case sig := <-terminate:
+			if ds.isRunning() {
+				log.Printf("Signal %s received: the daemon stops once the running sync is finished", sig)
+			}
+			stop <- sig
+			return
+		}
+	}
+}
+
+// runDaemon synchronizes periodically until terminated.
+// Sync now signal (SIGHUP) triggers an immediate sync, dump signal (SIGUSR1) logs the daemon state and the last statistics.
+// Signals are handled during a sync run too
+func runDaemon(recordUid string) {
+	var interval = defaultSyncInterval
+	if value := os.Getenv(syncIntervalName); len(value) > 0 {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil || interval < time.Minute {
+			log.Fatalf("\"%s\" environment variable must be a duration of at least 1m, e.g. \"30m\"", syncIntervalName)
+		}
+	}
+
+	var ka, gcp, sm, scimRecord = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+	if address := os.Getenv(pprofAddressName); len(address) > 0 {
+		startProfiler(address)
+	}
+
+	var syncNow = make(chan os.Signal, 1)
+	if len(syncNowSignals) > 0 {
+		signal.Notify(syncNow, syncNowSignals...)
+	}
+	var dumpStat = make(chan os.Signal, 1)
+	if len(dumpStatSignals) > 0 {
+		signal.Notify(dumpStat, dumpStatSignals...)
+	}
+	var terminate = make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+
+	var state = &daemonState{
+		started: time.Now(),
+	}
+	var runNow = make(chan struct{}, 1)
+	var stop = make(chan os.Signal, 1)
+	go state.handleSignals(syncNow, dumpStat, terminate, runNow, stop)
+
+	log.Printf("Daemon started. Sync interval: %s", interval)
+	var timer = time.NewTimer(0)
+	for {
+		// termination takes precedence over a sync that is due
+		select {
+		case sig := <-stop:
+			log.Printf("Daemon stopped by signal %s", sig)
+			return
+		default:
+		}
+		select {
+		case <-timer.C:
+		case <-runNow:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case sig := <-stop:
+			log.Printf("Daemon stopped by signal %s", sig)
+			return
+		}
+
+		state.beginRun()
+		var stat, err = sync.Sync()
+		writeBackStatus(ka, sm, scimRecord, stat, err)
+		if err != nil {
+			log.Printf("Sync failed: %s", err.Error())
+		} else {
+			printStatistics(os.Stdout, stat)
+		}
+		state.endRun(stat, err, time.Now().Add(interval))
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keeper-security/ksm-google-scim/scim"
+)
+
+func historyStateStore(recordUid string) scim.IStateStore {
+	var ka, _, _, _ = loadParameters(recordUid)
+	var store = newStateStore(ka)
+	if store == nil {
+		log.Fatal("Run history requires a state store. Set \"SCIM_STATE_STORE\" or \"State Store\" record field")
+	}
+	return store
+}
+
+// runHistory lists the sync runs kept in the state store, the most recent run first
+func runHistory(recordUid string) {
+	var store = historyStateStore(recordUid)
+	var runs, err = scim.ListRunHistory(store)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(runs) == 0 {
+		fmt.Println("No sync runs are kept in the state store")
+		return
+	}
+	var tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "Run ID\tStarted\tDuration\tSucceeded\tFailed\tRecords")
+	for _, run := range runs {
+		var started, duration, succeeded, failed = "-", "-", "-", "-"
+		var stat *scim.SyncStat
+		if stat, err = scim.LoadRunStat(store, run.RunId); err != nil {
+			log.Printf("Sync run \"%s\": %s", run.RunId, err.Error())
+		}
+		if stat != nil {
+			started = stat.Started.Local().Format(time.RFC3339)
+			duration = stat.Finished.Sub(stat.Started).Round(time.Second).String()
+			succeeded = fmt.Sprintf("%d", len(stat.SuccessGroups)+len(stat.SuccessUsers)+len(stat.SuccessMembership))
+			failed = fmt.Sprintf("%d", len(stat.FailedGroups)+len(stat.FailedUsers)+len(stat.FailedMembership))
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", run.RunId, started, duration, succeeded, failed,
+			strings.Join(run.Records, ", "))
+	}
+	_ = tw.Flush()
+}
+
+// runHistoryShow prints the statistics, plan and journal summary kept for the sync run
+func runHistoryShow(runId string, recordUid string) {
+	var store = historyStateStore(recordUid)
+	var stat, err = scim.LoadRunStat(store, runId)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var plan *scim.SyncPlan
+	if plan, err = scim.LoadRunPlan(store, runId); err != nil {
+		log.Fatal(err.Error())
+	}
+	var journal *scim.RunJournal
+	if journal, err = scim.LoadRunJournal(store, runId); err != nil {
+		journal = nil
+	}
+	if stat == nil && plan == nil && journal == nil {
+		log.Fatalf("sync run \"%s\" was not found", runId)
+	}
+	printRunHistory(os.Stdout, runId, stat, plan, journal)
+}
+
+func printRunHistory(w io.Writer, runId string, stat *scim.SyncStat, plan *scim.SyncPlan, journal *scim.RunJournal) {
+	_, _ = fmt.Fprintf(w, "Run ID: %s\n", runId)
+	if stat != nil {
+		_, _ = fmt.Fprintf(w, "Started: %s\n", stat.Started.Local().Format(time.RFC3339))
+		_, _ = fmt.Fprintf(w, "Finished: %s\n", stat.Finished.Local().Format(time.RFC3339))
+	}
+	if journal != nil {
+		_, _ = fmt.Fprintf(w, "Changes: %d\n", len(journal.Entries))
+		if journal.RolledBack != nil {
+			_, _ = fmt.Fprintf(w, "Rolled back: %s\n", journal.RolledBack.Local().Format(time.RFC3339))
+		}
+	}
+	if stat != nil {
+		printStatistics(w, stat)
+	}
+	if plan != nil {
+		var data, er1 = json.MarshalIndent(plan, "", "  ")
+		if er1 != nil {
+			log.Fatal(er1.Error())
+		}
+		_, _ = fmt.Fprintln(w, string(data))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keychainItemNotFound is the exit code of "security find-generic-password" if the item does not exist
+const keychainItemNotFound = 44
+
+// loadCacheKey reads the configuration cache key from macOS Keychain. The key is created on first use
+func loadCacheKey() (key []byte, err error) {
+	var output []byte
+	if output, err = exec.Command("security", "find-generic-password", "-s", "ksm-scim", "-a", "config-cache", "-w").Output(); err == nil {
+		if key, err = hex.DecodeString(strings.TrimSpace(string(output))); err == nil && len(key) != 32 {
+			err = errors.New("configuration cache key in Keychain is invalid")
+		}
+		return
+	}
+	// other failures, e.g. a locked keychain, must not replace the stored key
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != keychainItemNotFound {
+		if exitErr != nil && len(exitErr.Stderr) > 0 {
+			err = errors.New("keychain: " + strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return
+	}
+	if key, err = newCacheKey(); err != nil {
+		return
+	}
+	// "-w" without a value reads the password from stdin, so the key does not appear in the process list
+	var cmd = exec.Command("security", "add-generic-password", "-U", "-s", "ksm-scim", "-a", "config-cache",
+		"-l", "KSM SCIM configuration cache", "-w")
+	var hexKey = hex.EncodeToString(key)
+	cmd.Stdin = strings.NewReader(hexKey + "\n" + hexKey + "\n")
+	err = cmd.Run()
+	return
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// loadCacheKey reads the configuration cache key from the Secret Service (libsecret) keyring.
+// The key is created on first use. Requires "secret-tool"
+func loadCacheKey() (key []byte, err error) {
+	var output []byte
+	if output, err = exec.Command("secret-tool", "lookup", "service", "ksm-scim", "account", "config-cache").Output(); err == nil {
+		if key, err = hex.DecodeString(strings.TrimSpace(string(output))); err == nil && len(key) != 32 {
+			err = errors.New("configuration cache key in the keyring is invalid")
+		}
+		return
+	}
+	// "secret-tool lookup" exits with 1 and no message if the secret does not exist.
+	// Other failures, e.g. a locked keyring, must not replace the stored key
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || len(strings.TrimSpace(string(exitErr.Stderr))) > 0 {
+		if exitErr != nil && len(exitErr.Stderr) > 0 {
+			err = errors.New("keyring: " + strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return
+	}
+	if key, err = newCacheKey(); err != nil {
+		return
+	}
+	var cmd = exec.Command("secret-tool", "store", "--label=KSM SCIM configuration cache", "service", "ksm-scim", "account", "config-cache")
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	err = cmd.Run()
+	return
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "errors"
+
+func loadCacheKey() (key []byte, err error) {
+	err = errors.New("configuration cache is not supported on this platform")
+	return
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// loadCacheKey reads the configuration cache key protected with Windows DPAPI. The key is created on first use
+func loadCacheKey() (key []byte, err error) {
+	var path string
+	if path, err = configCachePath(); err != nil {
+		return
+	}
+	path = filepath.Join(filepath.Dir(path), "config.key")
+	var data []byte
+	if data, err = os.ReadFile(path); err == nil {
+		if key, err = dpapi(data, false); err == nil && len(key) != 32 {
+			err = errors.New("configuration cache key is invalid")
+		}
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if key, err = newCacheKey(); err != nil {
+		return
+	}
+	if data, err = dpapi(key, true); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	err = os.WriteFile(path, data, 0600)
+	return
+}
+
+func dpapi(data []byte, protect bool) (result []byte, err error) {
+	if len(data) == 0 {
+		err = errors.New("DPAPI: empty data")
+		return
+	}
+	var in = windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if protect {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, _ = windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	}()
+	result = make([]byte, out.Size)
+	copy(result, unsafe.Slice(out.Data, out.Size))
+	return
+}
@@ -1,22 +1,33 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/keeper-security/ksm-google-scim/scim"
 	ksm "github.com/keeper-security/secrets-manager-go/core"
-	"keepersecurity.com/ksm-scim/scim"
 )
 
-func main() {
+// recordStateStore is the "keeper://" state store of the SCIM record loaded by loadParameters
+var recordStateStore scim.IStateStore
+
+// loadParameters loads the configuration. sm and scimRecord are nil if the configuration comes from environment variables
+func loadParameters(recordUid string) (ka *scim.ScimEndpointParameters, gcp *scim.GoogleEndpointParameters, sm *ksm.SecretsManager, scimRecord *ksm.Record) {
 	var err error
-	var ka *scim.ScimEndpointParameters
-	var gcp *scim.GoogleEndpointParameters
+	defer func() {
+		if ka != nil {
+			scim.SetUserAgentSuffix(ka.UserAgentSuffix)
+			scim.SetStatOutput(ka.OutputFormat, ka.OutputLimit)
+		}
+	}()
 
 	// Check if environment variable configuration is available
 	if scim.IsEnvConfigAvailable() {
@@ -25,6 +36,25 @@ func main() {
 			log.Fatal(err)
 		}
 	} else {
+		var cacheTtl time.Duration
+		if cacheTtl, err = getConfigCacheTtl(); err != nil {
+			log.Fatal(err)
+		}
+		if cacheTtl > 0 {
+			var cached *cachedConfiguration
+			if cached, err = loadCachedParameters(recordUid, cacheTtl); err != nil {
+				log.Printf("Failed to read configuration cache: %s", err.Error())
+			} else if cached != nil && scim.IsKeeperStateStore(cached.Scim.StateStore) {
+				log.Println("Configuration cache is skipped: \"keeper://\" state store requires the SCIM record")
+			} else if cached != nil && cached.Scim.WriteBackStatus {
+				log.Println("Configuration cache is skipped: status write-back requires the SCIM record")
+			} else if cached != nil {
+				log.Println("Loading configuration from the encrypted cache")
+				ka, gcp = cached.Scim, cached.Google
+				return
+			}
+		}
+
 		// Fall back to KSM configuration from file
 		log.Println("Loading configuration from Keeper Secrets Manager (config.base64)")
 		var filePath = "config.base64"
@@ -40,12 +70,12 @@ func main() {
 			log.Fatal(err)
 		}
 		var config = ksm.NewMemoryKeyValueStorage(string(data))
-		var sm = ksm.NewSecretsManager(&ksm.ClientOptions{
+		sm = ksm.NewSecretsManager(&ksm.ClientOptions{
 			Config: config,
 		})
 		var filter []string
-		if len(os.Args) == 2 {
-			filter = append(filter, os.Args[1])
+		if len(recordUid) > 0 {
+			filter = append(filter, recordUid)
 		}
 
 		var records []*ksm.Record
@@ -53,7 +83,6 @@ func main() {
 			log.Fatal(err)
 		}
 
-		var scimRecord *ksm.Record
 		for _, r := range records {
 			if r.Type() != "login" {
 				continue
@@ -81,60 +110,401 @@ func main() {
 		}
 
 		if ka, gcp, err = scim.LoadScimParametersFromRecord(scimRecord); err != nil {
-			log.Println(err)
+			log.Fatal(err)
+		}
+		if scim.IsKeeperStateStore(ka.StateStore) {
+			recordStateStore = scim.NewKeeperStateStore(sm, scimRecord)
+		}
+		if cacheTtl > 0 {
+			if err = saveCachedParameters(&cachedConfiguration{
+				RecordUid: recordUid,
+				Saved:     time.Now(),
+				Scim:      ka,
+				Google:    gcp,
+			}); err != nil {
+				log.Printf("Failed to store configuration cache: %s", err.Error())
+			}
+		}
+	}
+	return
+}
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "version" || os.Args[1] == "--version") {
+		fmt.Println(scim.GetBuildInfo())
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if len(os.Args) < 3 {
+			log.Fatalf("Usage: ksm-scim completion <%s>", strings.Join(completionShells, "|"))
+		}
+		if err := writeCompletion(os.Stdout, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "man" {
+		if err := writeManPage(os.Stdout, scim.GetBuildInfo().Version); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := scim.LoadEnvFiles(); err != nil {
+		log.Fatal(err)
+	}
+	if err := scim.ConfigureLogFormat(os.Getenv("SCIM_LOG_FORMAT")); err != nil {
+		log.Fatal(err)
+	}
+	var args, scope = parseRunScope(os.Args[1:])
+	if len(args) > 0 && !scope.IsEmpty() {
+		switch args[0] {
+		case "rollback", "daemon", "plan", "simulate", "drift-report", "unmanaged-report", "backfill-external-id", "watch", "sync-user", "sync-group", "diff-runs", "history":
+			log.Fatalf("\"--only-user\" and \"--only-group\" are not supported by the \"%s\" command", args[0])
+		}
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "rollback":
+			if len(args) < 2 {
+				log.Fatal("Usage: ksm-scim rollback <run-id> [record-uid]")
+			}
+			var recordUid string
+			if len(args) > 2 {
+				recordUid = args[2]
+			}
+			runRollback(args[1], recordUid)
+			return
+		case "history":
+			if len(args) > 1 && args[1] == "show" {
+				if len(args) < 3 {
+					log.Fatal("Usage: ksm-scim history show <run-id> [record-uid]")
+				}
+				var recordUid string
+				if len(args) > 3 {
+					recordUid = args[3]
+				}
+				runHistoryShow(args[2], recordUid)
+				return
+			}
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runHistory(recordUid)
+			return
+		case "diff-runs":
+			if len(args) < 3 {
+				log.Fatal("Usage: ksm-scim diff-runs <from-run-id> <to-run-id> [record-uid]")
+			}
+			var recordUid string
+			if len(args) > 3 {
+				recordUid = args[3]
+			}
+			runDiffRuns(args[1], args[2], recordUid)
+			return
+		case "daemon":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runDaemon(recordUid)
+			return
+		case "plan":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runPlan(recordUid)
+			return
+		case "drift-report":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runDriftReport(recordUid)
+			return
+		case "unmanaged-report":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runUnmanagedReport(recordUid)
+			return
+		case "simulate":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runSimulate(recordUid)
+			return
+		case "backfill-external-id":
+			var recordUid string
+			if len(args) > 1 {
+				recordUid = args[1]
+			}
+			runBackfill(recordUid)
+			return
+		case "sync-user":
+			if len(args) < 2 {
+				log.Fatal("Usage: ksm-scim sync-user <email> [record-uid]")
+			}
+			var recordUid string
+			if len(args) > 2 {
+				recordUid = args[2]
+			}
+			runSyncUser(args[1], recordUid)
+			return
+		case "sync-group":
+			if len(args) < 2 {
+				log.Fatal("Usage: ksm-scim sync-group <name> [record-uid]")
+			}
+			var recordUid string
+			if len(args) > 2 {
+				recordUid = args[2]
+			}
+			runSyncGroup(args[1], recordUid)
+			return
+		case "watch":
+			if len(args) < 2 {
+				log.Fatal("Usage: ksm-scim watch <https-address> [record-uid]")
+			}
+			var recordUid string
+			if len(args) > 2 {
+				recordUid = args[2]
+			}
+			runWatch(args[1], recordUid)
 			return
 		}
 	}
+	var recordUid string
+	if len(args) == 1 {
+		recordUid = args[0]
+	}
+	runSync(recordUid, scope)
+}
 
-	var googleEndpoint = scim.NewGoogleEndpoint(gcp.Credentials, gcp.AdminAccount, gcp.ScimGroups)
+// parseRunScope extracts "--only-user" and "--only-group" flags from the arguments.
+// A flag is either "--only-user=value" or "--only-user value" and can be repeated
+func parseRunScope(args []string) (rest []string, scope *scim.RunScope) {
+	scope = new(scim.RunScope)
+	for i := 0; i < len(args); i++ {
+		var arg = args[i]
+		var name, value, hasValue = strings.Cut(arg, "=")
+		if name != "--only-user" && name != "--only-group" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				log.Fatalf("Flag \"%s\" requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			log.Fatalf("Flag \"%s\" requires a value", name)
+		}
+		if name == "--only-user" {
+			scope.Users = append(scope.Users, value)
+		} else {
+			scope.Groups = append(scope.Groups, value)
+		}
+	}
+	return
+}
 
-	var sync = scim.NewScimSync(googleEndpoint, ka.Url, ka.Token)
-	sync.SetVerbose(ka.Verbose)
-	sync.SetUpdateUsers(ka.UpdateUsers)
-	sync.SetDestructive(ka.Destructive)
+func newStateStore(ka *scim.ScimEndpointParameters) (store scim.IStateStore) {
+	if recordStateStore != nil && scim.IsKeeperStateStore(ka.StateStore) {
+		store = recordStateStore
+	} else if len(ka.StateStore) > 0 {
+		var err error
+		if store, err = scim.NewStateStore(ka.StateStore); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return
+}
 
-	if ka.Verbose {
-		googleEndpoint.TestConnection()
+func runRollback(runId string, recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var store = newStateStore(ka)
+	if store == nil {
+		log.Fatal("Rollback requires a state store. Set \"SCIM_STATE_STORE\" or \"State Store\" record field")
 	}
+	var googleEndpoint = scim.NewGoogleEndpointWithParameters(gcp)
+	var sync = scim.NewScimSync(googleEndpoint, ka.Url, ka.Token,
+		scim.WithVerbose(ka.Verbose),
+		scim.WithTrace(ka.Trace),
+		scim.WithScimPaths(ka.Paths),
+		scim.WithExternalIdPrefix(ka.ExternalIdPrefix),
+		scim.WithStateStore(store))
 
-	var syncStat *scim.SyncStat
-	if syncStat, err = sync.Sync(); err != nil {
+	var syncStat, err = sync.Rollback(runId)
+	printStatistics(os.Stdout, syncStat)
+	if err != nil {
 		log.Fatal(err.Error())
 	}
-	if len(syncStat.SuccessGroups) > 0 {
-		fmt.Printf("Group Success:\n")
-		for _, txt := range syncStat.SuccessGroups {
-			fmt.Printf("\t%s\n", txt)
-		}
+	fmt.Printf("Run ID: %s, rollback of run %s\n", syncStat.RunId, syncStat.RolledBackRunId)
+}
+
+func runDiffRuns(fromRunId string, toRunId string, recordUid string) {
+	var ka, _, _, _ = loadParameters(recordUid)
+	var store = newStateStore(ka)
+	if store == nil {
+		log.Fatal("Run diff requires a state store. Set \"SCIM_STATE_STORE\" or \"State Store\" record field")
 	}
-	if len(syncStat.FailedGroups) > 0 {
-		fmt.Printf("Group Failure:\n")
-		for _, txt := range syncStat.FailedGroups {
-			fmt.Printf("\t%s\n", txt)
-		}
+	var from, to *scim.SourceSnapshot
+	var err error
+	if from, err = scim.LoadSourceSnapshot(store, fromRunId); err != nil {
+		log.Fatal(err.Error())
 	}
-	if len(syncStat.SuccessUsers) > 0 {
-		fmt.Printf("User Success:\n")
-		for _, txt := range syncStat.SuccessUsers {
-			fmt.Printf("\t%s\n", txt)
-		}
+	if to, err = scim.LoadSourceSnapshot(store, toRunId); err != nil {
+		log.Fatal(err.Error())
 	}
-	if len(syncStat.FailedUsers) > 0 {
-		fmt.Printf("User Failure:\n")
-		for _, txt := range syncStat.FailedUsers {
-			fmt.Printf("\t%s\n", txt)
-		}
+	fmt.Print(scim.DiffSnapshots(from, to))
+}
+
+func runBackfill(recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var googleEndpoint = scim.NewGoogleEndpointWithParameters(gcp)
+	var sync = scim.NewScimSync(googleEndpoint, ka.Url, ka.Token,
+		scim.WithVerbose(ka.Verbose),
+		scim.WithTrace(ka.Trace),
+		scim.WithScimPaths(ka.Paths),
+		scim.WithExternalIdPrefix(ka.ExternalIdPrefix),
+		scim.WithUserNameFormat(ka.UserNameFormat),
+		scim.WithStateStore(newStateStore(ka)))
+
+	var syncStat, err = sync.BackfillExternalIds()
+	printStatistics(os.Stdout, syncStat)
+	if err != nil {
+		log.Fatal(err.Error())
 	}
-	if len(syncStat.SuccessMembership) > 0 {
-		fmt.Printf("Membership Success:\n")
-		for _, txt := range syncStat.SuccessMembership {
-			fmt.Printf("\t%s\n", txt)
+	if sync.StateStore() != nil {
+		fmt.Printf("Run ID: %s\n", syncStat.RunId)
+	}
+}
+
+// runPlan prints the projected changes as a JSON plan document without making any change
+func runPlan(recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var plan, err = sync.Plan()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(plan, "", "  "); err != nil {
+		log.Fatal(err.Error())
+	}
+	fmt.Println(string(data))
+	if policy := sync.Policy(); policy != nil {
+		var violations []string
+		if violations, err = policy.Evaluate(plan); err != nil {
+			log.Fatal(err.Error())
 		}
+		if len(violations) > 0 {
+			for _, v := range violations {
+				log.Printf("Policy violation: %s", v)
+			}
+			os.Exit(1)
+		}
+	}
+}
+
+// runDriftReport prints the JSON report of resources that exist in SCIM but not in Google Workspace without making any change
+func runDriftReport(recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var report, err = sync.DriftReport()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+		log.Fatal(err.Error())
 	}
-	if len(syncStat.FailedMembership) > 0 {
-		fmt.Printf("Membership Failure:\n")
-		for _, txt := range syncStat.FailedMembership {
-			fmt.Printf("\t%s\n", txt)
+	fmt.Println(string(data))
+}
+
+// runUnmanagedReport prints the JSON inventory of SCIM resources outside the sync scope without making any change
+func runUnmanagedReport(recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var report, err = sync.UnmanagedReport()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+		log.Fatal(err.Error())
+	}
+	fmt.Println(string(data))
+}
+
+// newScimSync creates the data source and the sync configured with the parameters
+func newScimSync(ka *scim.ScimEndpointParameters, gcp *scim.GoogleEndpointParameters, options ...scim.SyncOption) (sync scim.IScimSync) {
+	var err error
+	if sync, err = scim.NewSync(&scim.Config{Scim: ka, Google: gcp, StateStore: newStateStore(ka)}, options...); err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+// writeBackStatus stores the sync run summary in the SCIM record if configured
+func writeBackStatus(ka *scim.ScimEndpointParameters, sm *ksm.SecretsManager, scimRecord *ksm.Record, syncStat *scim.SyncStat, syncErr error) {
+	if ka.WriteBackStatus && scimRecord != nil {
+		if err := scim.WriteSyncStatusToRecord(sm, scimRecord, syncStat, syncErr); err != nil {
+			log.Printf("Failed to write sync status to the SCIM record: %s", err.Error())
 		}
 	}
 }
+
+func runSync(recordUid string, scope *scim.RunScope) {
+	var err error
+	var ka, gcp, sm, scimRecord = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp, scim.WithRunScope(scope))
+
+	var syncStat *scim.SyncStat
+	syncStat, err = sync.Sync()
+	writeBackStatus(ka, sm, scimRecord, syncStat, err)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	printStatistics(os.Stdout, syncStat)
+	if sync.StateStore() != nil {
+		fmt.Printf("Run ID: %s\n", syncStat.RunId)
+	}
+}
+
+// runSyncUser reconciles a single user with SCIM
+func runSyncUser(user string, recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var syncStat, err = sync.SyncUser(user)
+	printStatistics(os.Stdout, syncStat)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// runSyncGroup reconciles a single group and its members with SCIM
+func runSyncGroup(group string, recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var syncStat, err = sync.SyncGroup(group)
+	printStatistics(os.Stdout, syncStat)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func printStatistics(w io.Writer, syncStat *scim.SyncStat) {
+	scim.WriteSyncStat(w, syncStat)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofAddressName is the environment variable with the listen address of the pprof endpoints in daemon mode
+const pprofAddressName = "SCIM_PPROF_ADDR"
+
+// startProfiler serves the pprof endpoints under "/debug/pprof/" on the address.
+// The endpoints expose process internals, so the address should be bound to localhost or a private network
+func startProfiler(address string) {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Printf("pprof endpoints stopped: %s", err.Error())
+		}
+	}()
+	log.Printf("pprof endpoints listen on %s", address)
+}
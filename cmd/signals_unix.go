@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var syncNowSignals = []os.Signal{syscall.SIGHUP}
+var dumpStatSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// Windows has no user signals. The daemon syncs on schedule only
+var syncNowSignals []os.Signal
+var dumpStatSignals []os.Signal
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/keeper-security/ksm-google-scim/scim"
+)
+
+// runSimulate prints the projected changes under every destructive level side by side without making any change
+func runSimulate(recordUid string) {
+	var ka, gcp, _, _ = loadParameters(recordUid)
+	var sync = newScimSync(ka, gcp)
+
+	var simulation, err = sync.Simulate()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	printSimulation(os.Stdout, simulation, ka.Destructive)
+}
+
+func destructiveLevelName(level int32) string {
+	switch {
+	case level < 0:
+		return fmt.Sprintf("Safe Mode (%d)", level)
+	case level == 0:
+		return "Partial (0)"
+	default:
+		return fmt.Sprintf("Full (%d)", level)
+	}
+}
+
+// printSimulation prints the plan summaries and the changes that differ between destructive levels.
+// The configured level is marked with "*"
+func printSimulation(w io.Writer, simulation *scim.Simulation, configured int32) {
+	if len(simulation.SafeModeReasons) > 0 {
+		_, _ = fmt.Fprintf(w, "Warning: a sync run would switch to the Safe Mode: %s\n\n", strings.Join(simulation.SafeModeReasons, "; "))
+	}
+	var tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var header = []string{"Destructive level"}
+	for _, sp := range simulation.Plans {
+		var name = destructiveLevelName(sp.Destructive)
+		if (sp.Destructive < 0 && configured < 0) || (sp.Destructive == 0 && configured == 0) || (sp.Destructive > 0 && configured > 0) {
+			name += " *"
+		}
+		header = append(header, name)
+	}
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	var row = func(name string, value func(*scim.SyncPlan) int) {
+		var cells = []string{name}
+		for _, sp := range simulation.Plans {
+			cells = append(cells, fmt.Sprint(value(sp.Plan)))
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	row("Users in scope", func(p *scim.SyncPlan) int { return p.UsersInScope })
+	row("User creates", func(p *scim.SyncPlan) int { return p.UserCreates })
+	row("User updates", func(p *scim.SyncPlan) int { return p.UserUpdates })
+	row("User deletes", func(p *scim.SyncPlan) int { return p.UserDeletes })
+	row("Group creates", func(p *scim.SyncPlan) int { return p.GroupCreates })
+	row("Group updates", func(p *scim.SyncPlan) int { return p.GroupUpdates })
+	row("Group deletes", func(p *scim.SyncPlan) int { return p.GroupDeletes })
+	row("Membership adds", func(p *scim.SyncPlan) int { return p.MembershipAdds })
+	row("Membership removes", func(p *scim.SyncPlan) int { return p.MembershipRemoves })
+	row("Role changes", func(p *scim.SyncPlan) int { return p.RoleChanges })
+	_ = tw.Flush()
+
+	var changes = make(map[string][]string)
+	for i, sp := range simulation.Plans {
+		for _, pc := range sp.Plan.Changes {
+			var cell = simulatedChangeCell(pc)
+			if len(cell) == 0 {
+				continue
+			}
+			var key = fmt.Sprintf("%s %s \"%s\"", pc.Action, strings.ToLower(strings.TrimSuffix(pc.ResourceType, "s")), pc.Name)
+			var cells, ok = changes[key]
+			if !ok {
+				cells = make([]string, len(simulation.Plans))
+				for j := range cells {
+					cells[j] = "-"
+				}
+				changes[key] = cells
+			}
+			cells[i] = cell
+		}
+	}
+	var keys []string
+	for key, cells := range changes {
+		for _, cell := range cells[1:] {
+			if cell != cells[0] {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	_, _ = fmt.Fprintln(w)
+	if len(keys) == 0 {
+		_, _ = fmt.Fprintln(w, "The destructive level does not change the sync plan")
+		return
+	}
+	sort.Strings(keys)
+	_, _ = fmt.Fprintln(w, "Changes that depend on the destructive level:")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "Change\t"+strings.Join(header[1:], "\t"))
+	for _, key := range keys {
+		_, _ = fmt.Fprintln(tw, key+"\t"+strings.Join(changes[key], "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// simulatedChangeCell describes a change in the simulation table.
+// Membership changes show the number of added and removed groups
+func simulatedChangeCell(pc *scim.PlannedChange) string {
+	if pc.Action != scim.PlanActionMembership {
+		return "yes"
+	}
+	var before, _ = pc.Before["groups"].([]string)
+	var after, _ = pc.After["groups"].([]string)
+	var beforeSet = scim.MakeSet[string](before)
+	var afterSet = scim.MakeSet[string](after)
+	var added, removed int
+	for _, g := range after {
+		if !beforeSet.Has(g) {
+			added++
+		}
+	}
+	for _, g := range before {
+		if !afterSet.Has(g) {
+			removed++
+		}
+	}
+	var parts []string
+	if added > 0 {
+		parts = append(parts, fmt.Sprintf("+%d", added))
+	}
+	if removed > 0 {
+		parts = append(parts, fmt.Sprintf("-%d", removed))
+	}
+	return strings.Join(parts, " ")
+}
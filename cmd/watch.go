@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keeper-security/ksm-google-scim/scim"
+)
+
+// runWatch registers Google push notification channels that post user and group changes to the address.
+// Channels expire, so the command is meant to be scheduled more often than DefaultWatchTtl
+func runWatch(address string, recordUid string) {
+	var _, gcp, _, _ = loadParameters(recordUid)
+	var source, ok = scim.NewGoogleEndpointWithParameters(gcp).(scim.IWatchSource)
+	if !ok {
+		log.Fatal("Data source does not support push notifications")
+	}
+	var token = os.Getenv("GOOGLE_WATCH_TOKEN")
+	if len(token) == 0 {
+		log.Fatal("\"GOOGLE_WATCH_TOKEN\" is not set. Push notifications cannot be authenticated")
+	}
+	var channels, err = source.Watch(address, token, scim.DefaultWatchTtl)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	var tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "Kind\tEvent\tChannel ID\tResource ID\tExpires")
+	for _, channel := range channels {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", channel.Kind, channel.Event, channel.Id, channel.ResourceId,
+			channel.Expiration.Local().Format(time.RFC3339))
+	}
+	_ = tw.Flush()
+}
@@ -2,6 +2,7 @@ package ksm_google_scim
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,25 +11,64 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/keeper-security/ksm-google-scim/scim"
 	ksm "github.com/keeper-security/secrets-manager-go/core"
-	"keepersecurity.com/ksm-scim/scim"
 )
 
 func init() {
+	if err := scim.LoadEnvFiles(); err != nil {
+		log.Println(err)
+	}
+
+	// Cloud Logging parses JSON log entries written by Cloud Functions
+	var logFormat = os.Getenv("SCIM_LOG_FORMAT")
+	if len(logFormat) == 0 && scim.IsCloudRuntime() {
+		logFormat = "json"
+	}
+	if err := scim.ConfigureLogFormat(logFormat); err != nil {
+		log.Println(err)
+	}
+
 	// Register an HTTP function with the Functions Framework
 	functions.HTTP("GcpScimSyncHttp", gcpScimSyncHttp)
 	functions.CloudEvent("GcpScimSyncPubSub", gcpScimSyncPubSub)
+	functions.HTTP("GcpScimSyncNotification", gcpScimSyncNotification)
 }
 
 const ksmConfigName = "KSM_CONFIG_BASE64"
 const ksmRecordUid = "KSM_RECORD_UID"
+const watchToken = "GOOGLE_WATCH_TOKEN"
+const syncTopic = "SCIM_SYNC_TOPIC"
 
-func runScimSync() (syncStat *scim.SyncStat, err error) {
+// runScimSync runs the sync. scope restricts the run to listed users and groups, nil runs the full sync.
+// The run stops starting changes before the deadline of the function invocation context
+func runScimSync(ctx context.Context, scope *scim.RunScope) (syncStat *scim.SyncStat, err error) {
+	var started = time.Now()
 	var ka *scim.ScimEndpointParameters
 	var gcp *scim.GoogleEndpointParameters
+	var sm *ksm.SecretsManager
+	var scimRecord *ksm.Record
+
+	// Read secrets mounted as files again, they may have been rotated
+	if err = scim.LoadEnvFiles(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Load the YAML configuration from Cloud Storage into the environment
+	if configUri := os.Getenv(scim.ConfigGcsUriVariable); len(configUri) > 0 {
+		var values map[string]string
+		if values, err = scim.LoadConfigFromGcs(configUri); err != nil {
+			log.Println(err)
+			return
+		}
+		scim.ApplyEnvConfig(values)
+		log.Printf("Loaded %d setting(s) from \"%s\"", len(values), configUri)
+	}
 
 	// Check if environment variable configuration is available
 	if scim.IsEnvConfigAvailable() {
@@ -48,7 +88,7 @@ func runScimSync() (syncStat *scim.SyncStat, err error) {
 		}
 
 		var config = ksm.NewMemoryKeyValueStorage(configBase64)
-		var sm = ksm.NewSecretsManager(&ksm.ClientOptions{
+		sm = ksm.NewSecretsManager(&ksm.ClientOptions{
 			Config: config,
 		})
 
@@ -64,7 +104,6 @@ func runScimSync() (syncStat *scim.SyncStat, err error) {
 			return
 		}
 
-		var scimRecord *ksm.Record
 		for _, r := range records {
 			if r.Type() != "login" {
 				continue
@@ -101,76 +140,135 @@ func runScimSync() (syncStat *scim.SyncStat, err error) {
 		}
 	}
 
-	var googleEndpoint = scim.NewGoogleEndpoint(gcp.Credentials, gcp.AdminAccount, gcp.ScimGroups)
-	var sync = scim.NewScimSync(googleEndpoint, ka.Url, ka.Token)
-	sync.SetVerbose(ka.Verbose)
-	sync.SetUpdateUsers(ka.UpdateUsers)
-	sync.SetDestructive(ka.Destructive)
-
-	if ka.Verbose {
-		googleEndpoint.TestConnection()
+	// the function runs one configuration, so the output format and the user agent suffix of the process follow it
+	scim.SetUserAgentSuffix(ka.UserAgentSuffix)
+	scim.SetStatOutput(ka.OutputFormat, ka.OutputLimit)
+	var config = &scim.Config{Scim: ka, Google: gcp}
+	if scimRecord != nil && scim.IsKeeperStateStore(ka.StateStore) {
+		config.StateStore = scim.NewKeeperStateStore(sm, scimRecord)
 	}
-
-	if syncStat, err = sync.Sync(); err == nil {
-		printStatistics(os.Stdout, syncStat)
+	var options = []scim.SyncOption{scim.WithRunScope(scope)}
+	if budget := scim.FunctionTimeBudget(ctx, started); budget > 0 && (ka.TimeBudget == 0 || budget < ka.TimeBudget) {
+		options = append(options, scim.WithTimeBudget(budget))
+	}
+	var sync scim.IScimSync
+	if sync, err = scim.NewSync(config, options...); err != nil {
+		log.Println(err)
+		return
 	}
 
-	return
-}
-
-func printStatistics(w io.Writer, syncStat *scim.SyncStat) {
-	if syncStat != nil {
-		if len(syncStat.SuccessGroups) > 0 {
-			_, _ = fmt.Fprintf(w, "Group Success:\n")
-			for _, txt := range syncStat.SuccessGroups {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
-			}
-		}
-		if len(syncStat.FailedGroups) > 0 {
-			_, _ = fmt.Fprintf(w, "Group Failure:\n")
-			for _, txt := range syncStat.FailedGroups {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
-			}
-		}
-		if len(syncStat.SuccessUsers) > 0 {
-			_, _ = fmt.Fprintf(w, "User Success:\n")
-			for _, txt := range syncStat.SuccessUsers {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
-			}
+	var resumed *scim.ResumeState
+	if store := sync.StateStore(); store != nil && scope.IsEmpty() {
+		var er1 error
+		if resumed, er1 = scim.LoadResumeState(store); er1 != nil {
+			log.Printf("Failed to read the resume state: %s", er1.Error())
 		}
-		if len(syncStat.FailedUsers) > 0 {
-			_, _ = fmt.Fprintf(w, "User Failure:\n")
-			for _, txt := range syncStat.FailedUsers {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
+	}
+	syncStat, err = sync.Sync()
+	if err == nil && syncStat.Partial && scope.IsEmpty() {
+		// the next invocation resumes the run right away, unless the run made no progress
+		var stalled = len(syncStat.Operations) == 0
+		if store := sync.StateStore(); !stalled && store != nil && resumed != nil {
+			if left, er1 := scim.LoadResumeState(store); er1 == nil && resumed.SameWork(left) {
+				stalled = true
 			}
 		}
-		if len(syncStat.SuccessMembership) > 0 {
-			_, _ = fmt.Fprintf(w, "Membership Success:\n")
-			for _, txt := range syncStat.SuccessMembership {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
+		if stalled {
+			log.Printf("Partial sync run made no progress, it is not resumed until the next scheduled run")
+		} else if topic := os.Getenv(syncTopic); len(topic) > 0 {
+			if er1 := scim.PublishRunScope(topic, &scim.RunScope{}); er1 != nil {
+				log.Printf("Failed to schedule the resumed sync run: %s", er1.Error())
+			} else {
+				log.Printf("Partial sync run is resumed through Pub/Sub topic \"%s\"", topic)
 			}
 		}
-		if len(syncStat.FailedMembership) > 0 {
-			_, _ = fmt.Fprintf(w, "Membership Failure:\n")
-			for _, txt := range syncStat.FailedMembership {
-				_, _ = fmt.Fprintf(w, "\t%s\n", txt)
-			}
+	}
+	if ka.WriteBackStatus && scimRecord != nil {
+		if er1 := scim.WriteSyncStatusToRecord(sm, scimRecord, syncStat, err); er1 != nil {
+			log.Printf("Failed to write sync status to the SCIM record: %s", er1.Error())
 		}
 	}
+	if err == nil {
+		printStatistics(os.Stdout, syncStat)
+	}
+
+	return
+}
+
+func printStatistics(w io.Writer, syncStat *scim.SyncStat) {
+	if syncStat != nil && len(syncStat.RunId) > 0 {
+		_, _ = fmt.Fprintf(w, "Run ID: %s\n", syncStat.RunId)
+	}
+	scim.WriteSyncStat(w, syncStat)
 }
 
 // Function gcpScimSync is an HTTP handler
 func gcpScimSyncHttp(w http.ResponseWriter, r *http.Request) {
-	var syncStat, err = runScimSync()
+	var query = r.URL.Query()
+	if _, ok := query["version"]; ok {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(scim.GetBuildInfo())
+		return
+	}
+	var scope = &scim.RunScope{
+		Users:  query["only_user"],
+		Groups: query["only_group"],
+	}
+	var syncStat, err = runScimSync(r.Context(), scope)
 	if err == nil {
 		printStatistics(w, syncStat)
 	} else {
-		log.Fatal(err)
+		scim.ReportError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-// helloPubSub consumes a CloudEvent message and extracts the Pub/Sub message.
-func gcpScimSyncPubSub(_ context.Context, _ event.Event) (err error) {
-	_, err = runScimSync()
+// gcpScimSyncPubSub consumes a CloudEvent message and extracts the Pub/Sub message.
+// A message published by the notification handler runs a targeted sync, any other message runs the full sync
+func gcpScimSyncPubSub(ctx context.Context, e event.Event) (err error) {
+	var message struct {
+		Message struct {
+			Data []byte `json:"data"`
+		} `json:"message"`
+	}
+	var scope *scim.RunScope
+	if er1 := json.Unmarshal(e.Data(), &message); er1 == nil {
+		scope = scim.ParseRunScopeMessage(message.Message.Data)
+	}
+	if _, err = runScimSync(ctx, scope); err != nil {
+		scim.ReportError(err)
+	}
 	return
 }
+
+// gcpScimSyncNotification receives Google Admin SDK push notifications. The affected users and groups are published
+// to the SCIM_SYNC_TOPIC Pub/Sub topic, or synchronized right away if the topic is not set
+func gcpScimSyncNotification(w http.ResponseWriter, r *http.Request) {
+	var body, err = io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var scope *scim.RunScope
+	if scope, err = scim.ParseGoogleNotification(r.Header, body, os.Getenv(watchToken)); err != nil {
+		log.Printf("Push notification rejected: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if scope == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Printf("Push notification: sync of %s", scope)
+	if topic := os.Getenv(syncTopic); len(topic) > 0 {
+		err = scim.PublishRunScope(topic, scope)
+	} else {
+		_, err = runScimSync(r.Context(), scope)
+	}
+	if err != nil {
+		scim.ReportError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
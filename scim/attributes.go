@@ -0,0 +1,366 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Optional SCIM user attributes. They are synchronized only if listed in the attribute allowlist
+const (
+	AttributePhoneNumbers = "phoneNumbers"
+	AttributeAddresses    = "addresses"
+	AttributePhotos       = "photos"
+	AttributeLanguage     = "preferredLanguage"
+	AttributeLocale       = "locale"
+	AttributeTimezone     = "timezone"
+)
+
+var supportedAttributes = []string{AttributePhoneNumbers, AttributeAddresses, AttributePhotos,
+	AttributeLanguage, AttributeLocale, AttributeTimezone}
+
+type PhoneNumber struct {
+	Value   string
+	Type    string
+	Primary bool
+}
+
+type Address struct {
+	Type          string
+	Formatted     string
+	StreetAddress string
+	Locality      string
+	Region        string
+	PostalCode    string
+	Country       string
+	Primary       bool
+}
+
+// ParseAttributeList validates the attribute allowlist
+func ParseAttributeList(entries []string) (attributes []string, err error) {
+	for _, entry := range entries {
+		var found = false
+		for _, a := range supportedAttributes {
+			if strings.EqualFold(a, entry) {
+				attributes = append(attributes, a)
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("attribute \"%s\" is not supported. Supported attributes are %s", entry, strings.Join(supportedAttributes, ", "))
+			return
+		}
+	}
+	return
+}
+
+// parseGoogleLanguage returns the preferred language code of Google Workspace user
+func parseGoogleLanguage(languages any) (result string) {
+	var list, ok = languages.([]any)
+	if !ok {
+		return
+	}
+	for _, l := range list {
+		var language map[string]any
+		if language, ok = l.(map[string]any); ok {
+			var code, _ = toString(language["languageCode"])
+			if len(code) == 0 {
+				continue
+			}
+			if preference, _ := toString(language["preference"]); preference == "preferred" {
+				return code
+			}
+			if len(result) == 0 {
+				result = code
+			}
+		}
+	}
+	return
+}
+
+// parseGoogleCustomField returns a string value of the custom schema field
+// field: "SchemaName.FieldName"
+func parseGoogleCustomField(schemas map[string]googleapi.RawMessage, field string) (result string) {
+	var pos = strings.Index(field, ".")
+	if pos <= 0 {
+		return
+	}
+	var raw, ok = schemas[field[:pos]]
+	if !ok {
+		return
+	}
+	var values map[string]any
+	if err := json.Unmarshal(raw, &values); err == nil {
+		result, _ = toString(values[field[pos+1:]])
+	}
+	return
+}
+
+// googlePhoneType maps Google Workspace phone type to SCIM phone type
+func googlePhoneType(googleType string) string {
+	switch googleType {
+	case "work", "home", "mobile", "pager", "other":
+		return googleType
+	case "work_mobile":
+		return "mobile"
+	case "work_pager":
+		return "pager"
+	case "home_fax", "work_fax", "other_fax":
+		return "fax"
+	}
+	return "other"
+}
+
+// googleAddressType maps Google Workspace address type to SCIM address type
+func googleAddressType(googleType string) string {
+	switch googleType {
+	case "work", "home":
+		return googleType
+	}
+	return "other"
+}
+
+func parseGooglePhones(phones any) (result []PhoneNumber) {
+	var list, ok = phones.([]any)
+	if !ok {
+		return
+	}
+	for _, p := range list {
+		var phone map[string]any
+		if phone, ok = p.(map[string]any); ok {
+			var pn PhoneNumber
+			if pn.Value, _ = toString(phone["value"]); len(pn.Value) == 0 {
+				continue
+			}
+			var googleType, _ = toString(phone["type"])
+			pn.Type = googlePhoneType(googleType)
+			pn.Primary, _ = toBoolean(phone["primary"])
+			result = append(result, pn)
+		}
+	}
+	return
+}
+
+func parseGoogleAddresses(addresses any) (result []Address) {
+	var list, ok = addresses.([]any)
+	if !ok {
+		return
+	}
+	for _, a := range list {
+		var address map[string]any
+		if address, ok = a.(map[string]any); ok {
+			var ad Address
+			var googleType, _ = toString(address["type"])
+			ad.Type = googleAddressType(googleType)
+			ad.Formatted, _ = toString(address["formatted"])
+			ad.StreetAddress, _ = toString(address["streetAddress"])
+			ad.Locality, _ = toString(address["locality"])
+			ad.Region, _ = toString(address["region"])
+			ad.PostalCode, _ = toString(address["postalCode"])
+			if ad.Country, _ = toString(address["countryCode"]); len(ad.Country) == 0 {
+				ad.Country, _ = toString(address["country"])
+			}
+			ad.Primary, _ = toBoolean(address["primary"])
+			result = append(result, ad)
+		}
+	}
+	return
+}
+
+func parseScimPhones(phones any) (result []PhoneNumber) {
+	var list, ok = phones.([]any)
+	if !ok {
+		return
+	}
+	for _, p := range list {
+		var phone map[string]any
+		if phone, ok = p.(map[string]any); ok {
+			var pn PhoneNumber
+			pn.Value, _ = toString(phone["value"])
+			pn.Type, _ = toString(phone["type"])
+			pn.Primary, _ = toBoolean(phone["primary"])
+			result = append(result, pn)
+		}
+	}
+	return
+}
+
+func parseScimAddresses(addresses any) (result []Address) {
+	var list, ok = addresses.([]any)
+	if !ok {
+		return
+	}
+	for _, a := range list {
+		var address map[string]any
+		if address, ok = a.(map[string]any); ok {
+			var ad Address
+			ad.Type, _ = toString(address["type"])
+			ad.Formatted, _ = toString(address["formatted"])
+			ad.StreetAddress, _ = toString(address["streetAddress"])
+			ad.Locality, _ = toString(address["locality"])
+			ad.Region, _ = toString(address["region"])
+			ad.PostalCode, _ = toString(address["postalCode"])
+			ad.Country, _ = toString(address["country"])
+			ad.Primary, _ = toBoolean(address["primary"])
+			result = append(result, ad)
+		}
+	}
+	return
+}
+
+func phonesToScim(phones []PhoneNumber) (result []any) {
+	result = []any{}
+	for _, pn := range phones {
+		var phone = map[string]any{
+			"value": pn.Value,
+			"type":  pn.Type,
+		}
+		if pn.Primary {
+			phone["primary"] = true
+		}
+		result = append(result, phone)
+	}
+	return
+}
+
+func addressesToScim(addresses []Address) (result []any) {
+	result = []any{}
+	for _, ad := range addresses {
+		var address = map[string]any{
+			"type": ad.Type,
+		}
+		for k, v := range map[string]string{
+			"formatted":     ad.Formatted,
+			"streetAddress": ad.StreetAddress,
+			"locality":      ad.Locality,
+			"region":        ad.Region,
+			"postalCode":    ad.PostalCode,
+			"country":       ad.Country,
+		} {
+			if len(v) > 0 {
+				address[k] = v
+			}
+		}
+		if ad.Primary {
+			address["primary"] = true
+		}
+		result = append(result, address)
+	}
+	return
+}
+
+func equalPhones(a []PhoneNumber, b []PhoneNumber) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	var keys = func(phones []PhoneNumber) (result []string) {
+		for _, pn := range phones {
+			result = append(result, fmt.Sprintf("%s|%s|%t", pn.Value, pn.Type, pn.Primary))
+		}
+		sort.Strings(result)
+		return
+	}
+	var ka, kb = keys(a), keys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalAddresses(a []Address, b []Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	var keys = func(addresses []Address) (result []string) {
+		for _, ad := range addresses {
+			result = append(result, fmt.Sprintf("%+v", ad))
+		}
+		sort.Strings(result)
+		return
+	}
+	var ka, kb = keys(a), keys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sync) attributeEnabled(attribute string) bool {
+	return s.attributes != nil && s.attributes.Has(attribute)
+}
+
+// diffUserAttributes adds changed optional attributes to PATCH value and its inverse
+func (s *sync) diffUserAttributes(user *User, keeperUser *scimUser, value map[string]any, inverse map[string]any) {
+	if s.attributeEnabled(AttributePhoneNumbers) && !equalPhones(user.PhoneNumbers, keeperUser.PhoneNumbers) {
+		value[AttributePhoneNumbers] = phonesToScim(user.PhoneNumbers)
+		inverse[AttributePhoneNumbers] = phonesToScim(keeperUser.PhoneNumbers)
+	}
+	if s.attributeEnabled(AttributeAddresses) && !equalAddresses(user.Addresses, keeperUser.Addresses) {
+		value[AttributeAddresses] = addressesToScim(user.Addresses)
+		inverse[AttributeAddresses] = addressesToScim(keeperUser.Addresses)
+	}
+	if s.attributeEnabled(AttributeLanguage) && user.PreferredLanguage != keeperUser.PreferredLanguage {
+		value[AttributeLanguage] = user.PreferredLanguage
+		inverse[AttributeLanguage] = keeperUser.PreferredLanguage
+	}
+	if s.attributeEnabled(AttributeLocale) && user.Locale != keeperUser.Locale {
+		value[AttributeLocale] = user.Locale
+		inverse[AttributeLocale] = keeperUser.Locale
+	}
+	if s.attributeEnabled(AttributeTimezone) && user.Timezone != keeperUser.Timezone {
+		value[AttributeTimezone] = user.Timezone
+		inverse[AttributeTimezone] = keeperUser.Timezone
+	}
+	s.diffStateAttributes(user.State, keeperUser, value, inverse)
+	s.diffEntitlements(user, keeperUser, value, inverse)
+}
+
+// copyUserAttributes updates optional attributes of SCIM user after successful PATCH
+func (s *sync) copyUserAttributes(user *User, keeperUser *scimUser) {
+	if s.attributeEnabled(AttributePhoneNumbers) {
+		keeperUser.PhoneNumbers = user.PhoneNumbers
+	}
+	if s.attributeEnabled(AttributeAddresses) {
+		keeperUser.Addresses = user.Addresses
+	}
+	if s.attributeEnabled(AttributeLanguage) {
+		keeperUser.PreferredLanguage = user.PreferredLanguage
+	}
+	if s.attributeEnabled(AttributeLocale) {
+		keeperUser.Locale = user.Locale
+	}
+	if s.attributeEnabled(AttributeTimezone) {
+		keeperUser.Timezone = user.Timezone
+	}
+	s.copyStateAttributes(user.State, keeperUser)
+	s.copyEntitlements(user, keeperUser)
+}
+
+// addUserAttributes adds optional attributes to POST payload
+func (s *sync) addUserAttributes(user *User, payload map[string]any) {
+	if s.attributeEnabled(AttributePhoneNumbers) && len(user.PhoneNumbers) > 0 {
+		payload[AttributePhoneNumbers] = phonesToScim(user.PhoneNumbers)
+	}
+	if s.attributeEnabled(AttributeAddresses) && len(user.Addresses) > 0 {
+		payload[AttributeAddresses] = addressesToScim(user.Addresses)
+	}
+	if s.attributeEnabled(AttributeLanguage) && len(user.PreferredLanguage) > 0 {
+		payload[AttributeLanguage] = user.PreferredLanguage
+	}
+	if s.attributeEnabled(AttributeLocale) && len(user.Locale) > 0 {
+		payload[AttributeLocale] = user.Locale
+	}
+	if s.attributeEnabled(AttributeTimezone) && len(user.Timezone) > 0 {
+		payload[AttributeTimezone] = user.Timezone
+	}
+	s.addStateAttributes(user, payload)
+	s.addEntitlements(user, payload)
+}
@@ -0,0 +1,71 @@
+package scim
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// BackfillExternalIds binds existing SCIM users to Google users.
+// Every SCIM user matched by userName that has no externalId gets the Google user ID.
+// No other attribute is changed, users and groups are neither created nor deleted
+func (s *sync) BackfillExternalIds() (stat *SyncStat, err error) {
+	s.beginRun()
+	log.Printf("Backfill run ID: %s", s.runId)
+	s.journal = &RunJournal{
+		RunId:   s.runId,
+		Started: time.Now(),
+	}
+	defer func() {
+		s.journal.Finished = time.Now()
+		if s.stateStore != nil && len(s.journal.Entries) > 0 {
+			if er1 := saveRunJournal(s.stateStore, s.journal); er1 != nil {
+				log.Printf("Failed to store journal of backfill run \"%s\": %s", s.runId, er1.Error())
+			}
+		}
+		s.journal = nil
+	}()
+
+	if err = s.Source().Populate(); err != nil {
+		return
+	}
+	if err = s.populateScim(); err != nil {
+		return
+	}
+
+	var userLookup = make(map[string]*scimUser)
+	for _, v := range s.scimUsers {
+		userLookup[foldEmail(v.UserName)] = v
+	}
+
+	stat = &SyncStat{RunId: s.runId}
+	s.source.Users(func(user *User) {
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			return
+		}
+		var keeperUser, ok = userLookup[foldEmail(userName)]
+		var externalId = s.externalId(user.Id)
+		if !ok || keeperUser.ExternalId == externalId {
+			return
+		}
+		if len(keeperUser.ExternalId) > 0 {
+			stat.FailedUsers = append(stat.FailedUsers, fmt.Sprintf("Backfill user \"%s\" skipped: SCIM user is bound to external ID \"%s\"", user.Email, keeperUser.ExternalId))
+			return
+		}
+		var payload = makePatchPayload(makePatchOperation("replace", "", map[string]any{"externalId": externalId}))
+		if er1 := s.patchResource("Users", keeperUser.Id, payload); er1 == nil {
+			s.recordChange(phaseUsers, "PATCH", "Users", keeperUser.Id, user.Email, &ScimOperation{
+				Method:       "PATCH",
+				ResourceType: "Users",
+				ResourceId:   keeperUser.Id,
+				Payload:      makePatchPayload(map[string]any{"op": "remove", "path": "externalId"}),
+			})
+			keeperUser.ExternalId = externalId
+			stat.SuccessUsers = append(stat.SuccessUsers, fmt.Sprintf("SCIM bound user \"%s\" to external ID \"%s\"", user.Email, externalId))
+		} else {
+			stat.FailedUsers = append(stat.FailedUsers, fmt.Sprintf("PATCH user \"%s\" externalId error: %s", user.Email, er1.Error()))
+		}
+	})
+	return
+}
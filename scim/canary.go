@@ -0,0 +1,128 @@
+package scim
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// CanaryScope limits the changes of a sync run to a subset of users.
+// Changes outside of the scope are reported but not applied
+type CanaryScope struct {
+	// Groups are pilot source groups, by name (case-insensitive) or ID. Their members are in the canary
+	Groups []string
+	// Percent of source users in the canary, selected by a stable hash of the user ID
+	Percent int32
+}
+
+func (cs *CanaryScope) String() string {
+	var parts []string
+	if len(cs.Groups) > 0 {
+		parts = append(parts, fmt.Sprintf("group(s) %s", strings.Join(cs.Groups, ", ")))
+	}
+	if cs.Percent > 0 {
+		parts = append(parts, fmt.Sprintf("%d%% of users", cs.Percent))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// ParseCanaryScope parses canary entries separated by comma or new line.
+// An entry is either a percentage of users, e.g. "10%", or a pilot group name or ID
+func ParseCanaryScope(entries []string) (scope *CanaryScope, err error) {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if scope == nil {
+			scope = new(CanaryScope)
+		}
+		if strings.HasSuffix(entry, "%") {
+			var percent int
+			if percent, err = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(entry, "%"))); err != nil || percent < 1 || percent > 100 {
+				err = fmt.Errorf("canary \"%s\": percentage must be between 1%% and 100%%", entry)
+				return
+			}
+			scope.Percent = int32(percent)
+		} else {
+			scope.Groups = append(scope.Groups, entry)
+		}
+	}
+	return
+}
+
+// canaryBucket maps the ID to a stable bucket between 0 and 99
+func canaryBucket(id string) int32 {
+	var h = fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int32(h.Sum32() % 100)
+}
+
+// selectCanary resolves the canary scope to source users and groups. Does nothing if canary is not configured
+func (s *sync) selectCanary() {
+	s.canaryUsers = nil
+	s.canaryGroups = nil
+	s.canaryDeferred = nil
+	if s.canary == nil {
+		return
+	}
+	var fold = cases.Fold()
+	var pilots = NewSet[string]()
+	for _, g := range s.canary.Groups {
+		pilots.Add(fold.String(g))
+	}
+	s.canaryGroups = NewSet[string]()
+	s.source.Groups(func(group *Group) {
+		if pilots.Has(group.Id) || pilots.Has(fold.String(group.Name)) {
+			s.canaryGroups.Add(group.Id)
+		}
+	})
+	s.canaryUsers = NewSet[string]()
+	s.source.Users(func(user *User) {
+		if s.canary.Percent > 0 && canaryBucket(user.Id) < s.canary.Percent {
+			s.canaryUsers.Add(user.Id)
+			return
+		}
+		for _, groupId := range user.Groups {
+			if s.canaryGroups.Has(groupId) {
+				s.canaryUsers.Add(user.Id)
+				return
+			}
+		}
+	})
+	log.Printf("Canary sync: changes are applied to %d user(s) and %d group(s) selected by %s", len(s.canaryUsers), len(s.canaryGroups), s.canary)
+}
+
+// inCanaryUser returns true if changes of the source user are applied
+func (s *sync) inCanaryUser(user *User) bool {
+	return s.canaryUsers == nil || s.canaryUsers.Has(user.Id)
+}
+
+// inCanaryGroup returns true if changes of the source group are applied
+func (s *sync) inCanaryGroup(group *Group) bool {
+	return s.canaryGroups == nil || s.canaryGroups.Has(group.Id)
+}
+
+// inCanaryDelete returns true if the SCIM resource missing in the source can be deleted.
+// Only the percentage selection covers deletes, since deleted resources are not members of pilot groups
+func (s *sync) inCanaryDelete(externalId string, id string) bool {
+	if s.canary == nil {
+		return true
+	}
+	if s.canary.Percent == 0 {
+		return false
+	}
+	if len(externalId) > 0 {
+		return canaryBucket(externalId) < s.canary.Percent
+	}
+	return canaryBucket(id) < s.canary.Percent
+}
+
+// deferCanary records a change that the full run would make outside of the canary
+func (s *sync) deferCanary(change string) {
+	s.canaryDeferred = append(s.canaryDeferred, change)
+}
@@ -0,0 +1,98 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// isCapacityError returns true if the SCIM server rejected the request due to a size limit,
+// e.g. a team that reached its maximum number of members
+func isCapacityError(err error) bool {
+	var se *ScimError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.Status == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	if se.Status != http.StatusBadRequest && se.Status != http.StatusConflict &&
+		se.Status != http.StatusForbidden && se.Status != http.StatusUnprocessableEntity {
+		return false
+	}
+	if se.ScimType == ScimTypeTooMany {
+		return true
+	}
+	var text = strings.ToLower(se.Detail)
+	if len(text) == 0 {
+		text = strings.ToLower(string(se.body))
+	}
+	for _, marker := range []string{"limit", "too many", "exceed", "maximum", "capacity"} {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMembershipByGroup applies membership changes of the user one group at a time after a capacity error,
+// so only the groups at their member limit fail
+func (s *sync) applyMembershipByGroup(keeperUser *scimUser, addGroups []string, removeGroups []string) (added int, removed int, failures []string) {
+	var apply = func(op string, inverseOp string, groupId string) (err error) {
+		var values = []any{map[string]any{"value": groupId}}
+		if err = s.patchResource("Users", keeperUser.Id, makePatchPayload(makePatchOperation(op, "groups", values))); err == nil {
+			s.recordChange(phaseMembership, "PATCH", "Users", keeperUser.Id, keeperUser.Email, &ScimOperation{
+				Method:       "PATCH",
+				ResourceType: "Users",
+				ResourceId:   keeperUser.Id,
+				Payload:      makePatchPayload(makePatchOperation(inverseOp, "groups", values)),
+			})
+		}
+		return
+	}
+	for _, groupId := range addGroups {
+		var er1 = apply("add", "remove", groupId)
+		if er1 == nil {
+			added++
+			continue
+		}
+		var groupName = groupId
+		if sg, ok := s.scimGroups[groupId]; ok {
+			groupName = sg.Name
+		}
+		if isCapacityError(er1) {
+			if s.capacityRejects == nil {
+				s.capacityRejects = make(map[string]int)
+			}
+			s.capacityRejects[groupName]++
+			failures = append(failures, fmt.Sprintf("PATCH user \"%s\" membership: team \"%s\" reached the member limit", keeperUser.Email, groupName))
+		} else {
+			failures = append(failures, fmt.Sprintf("PATCH user \"%s\" membership in team \"%s\" error: %s", keeperUser.Email, groupName, er1.Error()))
+		}
+	}
+	if s.destructive >= 0 {
+		for _, groupId := range removeGroups {
+			if er1 := apply("remove", "add", groupId); er1 == nil {
+				removed++
+			} else {
+				failures = append(failures, fmt.Sprintf("PATCH user \"%s\" membership removal error: %s", keeperUser.Email, er1.Error()))
+			}
+		}
+	}
+	return
+}
+
+// capacityWarnings reports teams that rejected new members due to the member limit
+func (s *sync) capacityWarnings() (warnings []string) {
+	for groupName, count := range s.capacityRejects {
+		warnings = append(warnings, fmt.Sprintf("Team \"%s\" reached the SCIM member limit: %d member(s) could not be added", groupName, count))
+	}
+	sort.Strings(warnings)
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/chat/v1"
+	"google.golang.org/api/option"
+)
+
+// chatTimeout limits a Google Chat request
+const chatTimeout = 30 * time.Second
+
+// chatEntryLimit is the number of failures listed per section of the Google Chat message
+const chatEntryLimit = 10
+
+type googleChatSink struct {
+	webhook      string
+	space        string
+	failuresOnly bool
+}
+
+// NewGoogleChatSink creates IResultSink that posts run summaries and failures to a Google Chat space.
+// webhook: incoming webhook URL of the space. space: "spaces/<id>" posted through the Chat API if webhook is empty.
+// failuresOnly: only runs with failures or errors are posted
+func NewGoogleChatSink(webhook string, space string, failuresOnly bool) IResultSink {
+	if len(webhook) > 0 {
+		if uri, err := url.Parse(webhook); err == nil {
+			var query = uri.Query()
+			RegisterSecrets(query.Get("key"), query.Get("token"))
+		}
+	}
+	return &googleChatSink{
+		webhook:      webhook,
+		space:        space,
+		failuresOnly: failuresOnly,
+	}
+}
+
+// newGoogleChatSinkFromUri parses "googlechat+https://chat.googleapis.com/v1/spaces/<id>/messages?key=..&token=.."
+// or "googlechat://spaces/<id>". The "on=failures" parameter posts only runs with failures
+func newGoogleChatSinkFromUri(uri *url.URL) (sink IResultSink, err error) {
+	var query = uri.Query()
+	var failuresOnly = strings.EqualFold(query.Get("on"), "failures")
+	query.Del("on")
+	switch strings.ToLower(uri.Scheme) {
+	case "googlechat+https":
+		var webhook = *uri
+		webhook.Scheme = "https"
+		webhook.RawQuery = query.Encode()
+		sink = NewGoogleChatSink(webhook.String(), "", failuresOnly)
+	default:
+		var space = strings.Trim(uri.Host+uri.Path, "/")
+		if !strings.HasPrefix(space, "spaces/") || len(space) == len("spaces/") {
+			err = fmt.Errorf("result sink: expected \"googlechat://spaces/<id>\"")
+			return
+		}
+		sink = NewGoogleChatSink("", space, failuresOnly)
+	}
+	return
+}
+
+func (gc *googleChatSink) Export(stat *SyncStat) (err error) {
+	var failures = len(stat.FailedGroups) + len(stat.FailedUsers) + len(stat.FailedMembership)
+	if gc.failuresOnly && failures == 0 {
+		return
+	}
+	return gc.post(chatSummaryText(stat))
+}
+
+func (gc *googleChatSink) ExportError(runId string, syncErr error) error {
+	return gc.post(fmt.Sprintf("*Keeper SCIM sync run %s failed*\n%s", runId, SanitizeText(syncErr.Error())))
+}
+
+// chatSummaryText formats the run summary in the Google Chat text format
+func chatSummaryText(stat *SyncStat) string {
+	var sb strings.Builder
+	var failures = len(stat.FailedGroups) + len(stat.FailedUsers) + len(stat.FailedMembership)
+	if failures > 0 {
+		sb.WriteString(fmt.Sprintf("*Keeper SCIM sync run %s finished with %d failure(s)*\n", stat.RunId, failures))
+	} else {
+		sb.WriteString(fmt.Sprintf("*Keeper SCIM sync run %s finished*\n", stat.RunId))
+	}
+	sb.WriteString(fmt.Sprintf("Groups: %d changed, %d failed\n", len(stat.SuccessGroups), len(stat.FailedGroups)))
+	sb.WriteString(fmt.Sprintf("Users: %d changed, %d failed, %d skipped\n", len(stat.SuccessUsers), len(stat.FailedUsers), len(stat.SkippedUsers)))
+	sb.WriteString(fmt.Sprintf("Memberships: %d changed, %d failed\n", len(stat.SuccessMembership), len(stat.FailedMembership)))
+	var section = func(title string, entries []string) {
+		if len(entries) == 0 {
+			return
+		}
+		sb.WriteString(fmt.Sprintf("\n*%s*\n", title))
+		for i, entry := range entries {
+			if i >= chatEntryLimit {
+				sb.WriteString(fmt.Sprintf("... and %d more\n", len(entries)-chatEntryLimit))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("• %s\n", entry))
+		}
+	}
+	section("Safe Mode", stat.SafeModeReasons)
+	section("Notices", stat.Notices)
+	section("Group Failure", stat.FailedGroups)
+	section("User Failure", stat.FailedUsers)
+	section("Membership Failure", stat.FailedMembership)
+	return sb.String()
+}
+
+func (gc *googleChatSink) post(text string) (err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), chatTimeout)
+	defer cancel()
+	if len(gc.webhook) == 0 {
+		var service *chat.Service
+		if service, err = chat.NewService(ctx, option.WithScopes(chat.ChatBotScope), option.WithUserAgent(UserAgent())); err != nil {
+			return
+		}
+		_, err = service.Spaces.Messages.Create(gc.space, &chat.Message{Text: text}).Context(ctx).Do()
+		return
+	}
+	var payload []byte
+	if payload, err = json.Marshal(map[string]string{"text": text}); err != nil {
+		return
+	}
+	var rq *http.Request
+	if rq, err = http.NewRequestWithContext(ctx, "POST", gc.webhook, bytes.NewReader(payload)); err != nil {
+		return
+	}
+	rq.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	rq.Header.Set("User-Agent", UserAgent())
+	var rs *http.Response
+	if rs, err = http.DefaultClient.Do(rq); err != nil {
+		err = SanitizeError(fmt.Errorf("google chat request error: %w", err))
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	if rs.StatusCode >= 300 {
+		var body, _ = io.ReadAll(io.LimitReader(rs.Body, 1024))
+		err = fmt.Errorf("google chat error: status code %d: %s", rs.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return
+}
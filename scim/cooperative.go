@@ -0,0 +1,79 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SkipReasonForeignUser is the reason of source users that exist in SCIM as resources of another provisioner
+const SkipReasonForeignUser = "foreign_user"
+
+// checkCooperative validates the cooperative mode configuration
+func (s *sync) checkCooperative() error {
+	if s.cooperative && len(s.externalIdPrefix) == 0 {
+		return errors.New("cooperative mode requires an external ID prefix")
+	}
+	return nil
+}
+
+// isOwnResource returns true if the SCIM resource carries the external ID namespace of this sync
+func (s *sync) isOwnResource(externalId string) bool {
+	return len(externalId) > 0 && strings.HasPrefix(externalId, s.externalIdPrefix)
+}
+
+// separateForeignResources removes SCIM users and groups without the external ID namespace of this sync from the
+// synchronized resources in cooperative mode. They are provisioned by other SCIM clients, e.g. Azure AD or Okta,
+// and are never updated, deleted, bound or used in memberships
+func (s *sync) separateForeignResources() {
+	s.foreignUsers = nil
+	s.foreignGroups = nil
+	if !s.cooperative {
+		return
+	}
+	s.foreignGroups = make(map[string]*scimGroup)
+	for id, sg := range s.scimGroups {
+		if !s.isOwnResource(sg.ExternalId) {
+			s.foreignGroups[id] = sg
+			delete(s.scimGroups, id)
+		}
+	}
+	var foreignUsers = make(map[string]*scimUser)
+	for id, su := range s.scimUsers {
+		if !s.isOwnResource(su.ExternalId) {
+			foreignUsers[id] = su
+			delete(s.scimUsers, id)
+		}
+	}
+	s.foreignUsers = newUserInventory(foreignUsers)
+	if len(s.foreignGroups) > 0 || len(foreignUsers) > 0 {
+		log.Printf("Cooperative mode: %d user(s) and %d group(s) of other SCIM clients are left untouched", len(foreignUsers), len(s.foreignGroups))
+	}
+}
+
+// checkForeignUser returns a non-nil SkippedUser if the source user exists in SCIM as a resource of another provisioner
+func (s *sync) checkForeignUser(user *User, userName string) *SkippedUser {
+	if s.foreignUsers == nil {
+		return nil
+	}
+	var su, ok = s.foreignUsers.userNames[foldEmail(userName)]
+	if !ok {
+		su, ok = s.foreignUsers.emails[foldEmail(user.Email)]
+	}
+	if !ok {
+		return nil
+	}
+	return &SkippedUser{
+		Id:     user.Id,
+		Email:  user.Email,
+		Reason: SkipReasonForeignUser,
+		Detail: fmt.Sprintf("SCIM user \"%s\" is provisioned by another SCIM client (external ID \"%s\")", su.UserName, su.ExternalId),
+	}
+}
+
+// isForeignGroup returns true if the SCIM group is provisioned by another SCIM client
+func (s *sync) isForeignGroup(groupId string) bool {
+	var _, ok = s.foreignGroups[groupId]
+	return ok
+}
@@ -0,0 +1,73 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// createdResourceFilters are the attributes that identify a new resource in the filtered GET request
+var createdResourceFilters = map[string]string{
+	"Users":  "userName",
+	"Groups": "displayName",
+}
+
+// readCreatedResource loads the resource created by a POST request whose response has no resource ID,
+// e.g. "201 Created" with the "Location" header only. The resource is read from the location if it belongs
+// to the SCIM endpoint, otherwise with a filtered GET request
+func (s *sync) readCreatedResource(resourceType string, location string, payload any) (resource map[string]any, err error) {
+	if len(location) > 0 {
+		var base, uri *url.URL
+		if base, err = url.Parse(s.baseUrl); err != nil {
+			return
+		}
+		if uri, err = base.Parse(location); err != nil {
+			return
+		}
+		// the bearer token is never sent to another host
+		if strings.EqualFold(uri.Scheme, base.Scheme) && strings.EqualFold(uri.Host, base.Host) {
+			var rq *http.Request
+			if rq, err = http.NewRequest("GET", uri.String(), nil); err != nil {
+				return
+			}
+			rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+			if resource, err = s.executeRequest(rq); err != nil || resource["id"] != nil {
+				return
+			}
+		}
+	}
+
+	var attribute, ok = createdResourceFilters[resourceType]
+	if !ok {
+		return
+	}
+	var po map[string]any
+	if po, ok = payload.(map[string]any); !ok {
+		return
+	}
+	var value string
+	if value, ok = toString(po[attribute]); !ok || len(value) == 0 {
+		return
+	}
+	var uri *url.URL
+	if uri, err = s.composeUrl(resourceType); err != nil {
+		return
+	}
+	var query = uri.Query()
+	query.Set("filter", fmt.Sprintf("%s eq \"%s\"", attribute, strings.ReplaceAll(value, "\"", "\\\"")))
+	uri.RawQuery = query.Encode()
+	var rq *http.Request
+	if rq, err = http.NewRequest("GET", uri.String(), nil); err != nil {
+		return
+	}
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	var response map[string]any
+	if response, err = s.executeRequest(rq); err != nil {
+		return
+	}
+	if resources, ok := response["Resources"].([]any); ok && len(resources) == 1 {
+		resource, _ = resources[0].(map[string]any)
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package scim
+
+import (
+	"fmt"
+)
+
+// createGroupsValue returns the "groups" attribute of the POST payload of a new user:
+// the SCIM groups of the user's source groups that already exist
+func (s *sync) createGroupsValue(user *User) (values []any) {
+	if !s.createWithGroups || s.createGroupsDenied || s.groupsUnsupported || !s.phaseEnabled(SyncPhaseMembership) {
+		return
+	}
+	var keeperGroupMap = make(map[string]string)
+	for _, v := range s.scimGroups {
+		keeperGroupMap[v.ExternalId] = v.Id
+	}
+	var added = NewSet[string]()
+	for _, externalGroupId := range user.Groups {
+		if keeperGroupId, ok := keeperGroupMap[s.externalId(externalGroupId)]; ok && !added.Has(keeperGroupId) {
+			added.Add(keeperGroupId)
+			values = append(values, map[string]any{"value": keeperGroupId})
+		}
+	}
+	return
+}
+
+// resolveCreatedUsers looks up users created in the users phase whose POST response did not identify them,
+// so the membership phase of the same run includes them. Runs right before the membership phase
+func (s *sync) resolveCreatedUsers() (notices []string) {
+	for _, userName := range s.unresolvedUsers {
+		var resource, err = s.readCreatedResource("Users", "", map[string]any{"userName": userName})
+		var au *scimUser
+		if err == nil && resource != nil {
+			au = parseScimUser(resource)
+		}
+		if au == nil {
+			if err != nil {
+				s.debugLogger(fmt.Sprintf("Lookup of created user \"%s\" failed: %s", userName, err.Error()))
+			}
+			notices = append(notices, fmt.Sprintf("User \"%s\" was created but could not be read from SCIM. The membership of the user is synced in the next run", userName))
+			continue
+		}
+		s.readStateAttributes(au, resource)
+		s.scimUsers[au.Id] = au
+		s.createdUsers.Add(au.Id)
+	}
+	s.unresolvedUsers = nil
+	return
+}
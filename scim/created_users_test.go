@@ -0,0 +1,251 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// fakeDirectory is a Google directory of fixed users, groups and members
+type fakeDirectory struct {
+	users   []*admin.User
+	groups  []*admin.Group
+	members map[string][]*admin.Member
+}
+
+func (fd *fakeDirectory) ListUsers(_ context.Context, request *DirectoryListRequest) (*admin.Users, error) {
+	var result = new(admin.Users)
+	var email, byEmail = strings.CutPrefix(request.Query, "email=")
+	for _, user := range fd.users {
+		if !byEmail || strings.EqualFold(user.PrimaryEmail, email) {
+			result.Users = append(result.Users, user)
+		}
+	}
+	return result, nil
+}
+
+func (fd *fakeDirectory) ListGroups(_ context.Context, request *DirectoryListRequest) (*admin.Groups, error) {
+	var result = new(admin.Groups)
+	for _, group := range fd.groups {
+		if request.Query == "email="+group.Email || request.Query == "name='"+group.Name+"'" {
+			result.Groups = append(result.Groups, group)
+		}
+	}
+	return result, nil
+}
+
+func (fd *fakeDirectory) ListMembers(_ context.Context, groupId string, _ *DirectoryListRequest) (*admin.Members, error) {
+	return &admin.Members{Members: fd.members[groupId]}, nil
+}
+
+func (fd *fakeDirectory) GetUserPhoto(_ context.Context, _ string) (*admin.UserPhoto, error) {
+	return nil, errors.New("no photo")
+}
+
+// createdUserServer is a SCIM server that creates users without returning their ID
+type createdUserServer struct {
+	groups []map[string]any
+	// returnId makes POST responses carry the ID of the new user
+	returnId bool
+	// hiddenLookups is the number of filtered lookups that do not find the created user yet
+	hiddenLookups int
+
+	lock    gosync.Mutex
+	created map[string]map[string]any
+	posts   int
+	lookups int
+	patches map[string][]map[string]any
+}
+
+func (cs *createdUserServer) ServeHTTP(w http.ResponseWriter, rq *http.Request) {
+	var body, _ = io.ReadAll(rq.Body)
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	var filter = rq.URL.Query().Get("filter")
+	switch {
+	case rq.Method == http.MethodGet && rq.URL.Path == "/Groups":
+		writeScimList(w, cs.groups)
+	case rq.Method == http.MethodGet && rq.URL.Path == "/Users" && len(filter) > 0:
+		cs.lookups++
+		var resources []map[string]any
+		if cs.lookups > cs.hiddenLookups {
+			var userName = strings.Trim(strings.TrimPrefix(filter, "userName eq "), "\"")
+			if user, ok := cs.created[userName]; ok {
+				resources = append(resources, user)
+			}
+		}
+		writeScimList(w, resources)
+	case rq.Method == http.MethodGet && rq.URL.Path == "/Users":
+		writeScimList(w, nil)
+	case rq.Method == http.MethodPost && rq.URL.Path == "/Users":
+		var user map[string]any
+		_ = json.Unmarshal(body, &user)
+		cs.posts++
+		var response = make(map[string]any)
+		for k, v := range user {
+			response[k] = v
+		}
+		user["id"] = fmt.Sprintf("su%d", cs.posts)
+		if cs.created == nil {
+			cs.created = make(map[string]map[string]any)
+		}
+		cs.created[user["userName"].(string)] = user
+		if cs.returnId {
+			response["id"] = user["id"]
+		}
+		w.Header().Set("Content-Type", "application/scim+json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(response)
+	case rq.Method == http.MethodPatch && strings.HasPrefix(rq.URL.Path, "/Users/"):
+		var patch map[string]any
+		_ = json.Unmarshal(body, &patch)
+		if cs.patches == nil {
+			cs.patches = make(map[string][]map[string]any)
+		}
+		var id = strings.TrimPrefix(rq.URL.Path, "/Users/")
+		cs.patches[id] = append(cs.patches[id], patch)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeScimList(w http.ResponseWriter, resources []map[string]any) {
+	if resources == nil {
+		resources = make([]map[string]any, 0)
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		"totalResults": len(resources),
+		"itemsPerPage": len(resources),
+		"startIndex":   1,
+		"Resources":    resources,
+	})
+}
+
+func newCreatedUsersSource() ICrmDataSource {
+	return NewGoogleEndpointWithDirectory(&GoogleEndpointParameters{
+		AdminAccount: "admin@example.com",
+		ScimGroups:   []string{"team@example.com"},
+	}, &fakeDirectory{
+		users: []*admin.User{{
+			Id:           "101",
+			PrimaryEmail: "a@example.com",
+			Name:         &admin.UserName{GivenName: "Ann", FamilyName: "Lee"},
+		}},
+		groups: []*admin.Group{{Id: "g1", Name: "Team", Email: "team@example.com"}},
+		members: map[string][]*admin.Member{
+			"g1": {{Id: "101", Email: "a@example.com", Type: "USER"}},
+		},
+	})
+}
+
+// TestSyncMembershipOfCreatedUsers verifies that users created without ID in the POST response
+// are looked up again before the membership phase, so they join their groups in the same run
+func TestSyncMembershipOfCreatedUsers(t *testing.T) {
+	var tests = []struct {
+		name          string
+		returnId      bool
+		hiddenLookups int
+		lookups       int
+		patched       bool
+		notice        bool
+	}{
+		{name: "ID in response", returnId: true, lookups: 0, patched: true},
+		{name: "found after create", hiddenLookups: 0, lookups: 1, patched: true},
+		{name: "found before membership", hiddenLookups: 1, lookups: 2, patched: true},
+		{name: "not found", hiddenLookups: 2, lookups: 2, notice: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scim = &createdUserServer{
+				groups:        []map[string]any{{"id": "sg1", "displayName": "Team", "externalId": "g1"}},
+				returnId:      tt.returnId,
+				hiddenLookups: tt.hiddenLookups,
+			}
+			var server = httptest.NewServer(scim)
+			defer server.Close()
+
+			var s = NewScimSync(newCreatedUsersSource(), server.URL, "created-users-token").(*sync)
+			s.updateUsers = true
+			var stat, err = s.Sync()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scim.posts != 1 {
+				t.Errorf("POST requests = %d, want 1", scim.posts)
+			}
+			if scim.lookups != tt.lookups {
+				t.Errorf("lookups = %d, want %d", scim.lookups, tt.lookups)
+			}
+			var wantPatches []map[string]any
+			if tt.patched {
+				wantPatches = []map[string]any{{
+					"schemas": []any{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+					"Operations": []any{map[string]any{
+						"op":    "add",
+						"path":  "groups",
+						"value": []any{map[string]any{"value": "sg1"}},
+					}},
+				}}
+			}
+			if patches := scim.patches["su1"]; !reflect.DeepEqual(patches, wantPatches) {
+				t.Errorf("membership patches = %v, want %v", patches, wantPatches)
+			}
+			if tt.patched && len(stat.SuccessMembership) != 1 {
+				t.Errorf("membership successes = %v, want 1", stat.SuccessMembership)
+			}
+			var noticed = false
+			for _, notice := range stat.Notices {
+				if strings.Contains(notice, "\"a@example.com\" was created but could not be read from SCIM") {
+					noticed = true
+				}
+			}
+			if noticed != tt.notice {
+				t.Errorf("notices = %v, want the unresolved user notice %v", stat.Notices, tt.notice)
+			}
+		})
+	}
+}
+
+func TestResolveCreatedUsers(t *testing.T) {
+	var scim = &createdUserServer{
+		created: map[string]map[string]any{
+			"a@example.com": {"id": "su1", "userName": "a@example.com"},
+		},
+	}
+	var server = httptest.NewServer(scim)
+	defer server.Close()
+
+	var s = &sync{
+		baseUrl:         server.URL,
+		token:           "created-users-token",
+		scimUsers:       make(map[string]*scimUser),
+		createdUsers:    NewSet[string](),
+		unresolvedUsers: []string{"a@example.com", "b@example.com"},
+	}
+	var notices = s.resolveCreatedUsers()
+	if len(notices) != 1 || !strings.Contains(notices[0], "\"b@example.com\"") {
+		t.Errorf("notices = %v, want the notice of b@example.com", notices)
+	}
+	if su, ok := s.scimUsers["su1"]; !ok || su.UserName != "a@example.com" {
+		t.Errorf("SCIM users = %v, want su1", s.scimUsers)
+	}
+	if !s.createdUsers.Has("su1") || len(s.createdUsers) != 1 {
+		t.Errorf("created users = %v, want su1", s.createdUsers)
+	}
+	if s.unresolvedUsers != nil {
+		t.Errorf("unresolved users = %v, want none", s.unresolvedUsers)
+	}
+}
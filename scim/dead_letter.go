@@ -0,0 +1,145 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Machine-readable reasons of users that can never be synced
+const (
+	SkipReasonMissingEmail      = "missing_email"
+	SkipReasonInvalidEmail      = "invalid_email"
+	SkipReasonDomainNotAllowed  = "domain_not_allowed"
+	SkipReasonInvalidUserName   = "invalid_user_name"
+	SkipReasonDuplicateUserName = "duplicate_user_name"
+	SkipReasonDuplicateEmail    = "duplicate_email"
+	SkipReasonInvalidAttribute  = "invalid_attribute"
+	SkipReasonScimUniqueness    = "scim_uniqueness"
+)
+
+// SkippedUser is a source user that cannot be synced until the source data is fixed
+type SkippedUser struct {
+	Id     string `json:"id"`
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+func (su *SkippedUser) String() string {
+	var name = su.Email
+	if len(name) == 0 {
+		name = su.Id
+	}
+	return fmt.Sprintf("%s: %s (%s)", name, su.Reason, su.Detail)
+}
+
+// validateSourceUser returns a non-nil SkippedUser if the user cannot be synced
+func (s *sync) validateSourceUser(user *User) *SkippedUser {
+	var skip = func(reason string, detail string) *SkippedUser {
+		return &SkippedUser{
+			Id:     user.Id,
+			Email:  user.Email,
+			Reason: reason,
+			Detail: detail,
+		}
+	}
+	if len(strings.TrimSpace(user.Email)) == 0 {
+		return skip(SkipReasonMissingEmail, "user has no primary email")
+	}
+	if !isValidEmail(user.Email) {
+		return skip(SkipReasonInvalidEmail, fmt.Sprintf("\"%s\" is not a valid email address", user.Email))
+	}
+	if len(s.allowedDomains) > 0 {
+		var domain = user.Email[strings.LastIndex(user.Email, "@")+1:]
+		if !s.allowedDomains.Has(foldDomain(domain)) {
+			return skip(SkipReasonDomainNotAllowed, fmt.Sprintf("domain \"%s\" is not allowed by the target", domain))
+		}
+	}
+	var userName = s.userName(user)
+	if len(userName) == 0 {
+		return skip(SkipReasonInvalidUserName, fmt.Sprintf("userName cannot be built from \"%s\"", s.userNameFormat))
+	}
+	return s.checkForeignUser(user, userName)
+}
+
+// skippedUserIndex identifies the SCIM users of skipped source users. Skipped users still exist in the source, so they are never deleted.
+// A user skipped for an invalid userName has no userName, so users are also identified by externalId and email
+type skippedUserIndex struct {
+	userNames   Set[string]
+	externalIds Set[string]
+	emails      Set[string]
+}
+
+func newSkippedUserIndex() *skippedUserIndex {
+	return &skippedUserIndex{
+		userNames:   NewSet[string](),
+		externalIds: NewSet[string](),
+		emails:      NewSet[string](),
+	}
+}
+
+func (s *sync) addSkippedUser(index *skippedUserIndex, user *User) {
+	if userName := s.userName(user); len(userName) > 0 {
+		index.userNames.Add(foldEmail(userName))
+	}
+	if externalId := s.externalId(user.Id); len(externalId) > 0 {
+		index.externalIds.Add(externalId)
+	}
+	if email := strings.TrimSpace(user.Email); len(email) > 0 {
+		index.emails.Add(foldEmail(email))
+	}
+}
+
+// has returns true if the SCIM user belongs to a skipped source user
+func (index *skippedUserIndex) has(su *scimUser) bool {
+	if len(su.UserName) > 0 && index.userNames.Has(foldEmail(su.UserName)) {
+		return true
+	}
+	if len(su.ExternalId) > 0 && index.externalIds.Has(su.ExternalId) {
+		return true
+	}
+	return len(su.Email) > 0 && index.emails.Has(foldEmail(su.Email))
+}
+
+// validateUserPayload checks the SCIM attributes the server requires before the user is created,
+// so a predictable "400 Bad Request" is reported as a skipped user with the offending attribute named
+func validateUserPayload(user *User, payload map[string]any) *SkippedUser {
+	var skip = func(attribute string, detail string) *SkippedUser {
+		return &SkippedUser{
+			Id:     user.Id,
+			Email:  user.Email,
+			Reason: SkipReasonInvalidAttribute,
+			Detail: fmt.Sprintf("attribute \"%s\" %s", attribute, detail),
+		}
+	}
+	var userName, _ = payload["userName"].(string)
+	if len(userName) == 0 {
+		return skip("userName", "is empty")
+	}
+	if strings.TrimSpace(userName) != userName || strings.IndexFunc(userName, unicode.IsControl) >= 0 {
+		return skip("userName", fmt.Sprintf("\"%s\" contains leading or trailing spaces or control characters", userName))
+	}
+	if strings.Contains(userName, "@") {
+		if !isValidEmail(userName) {
+			return skip("userName", fmt.Sprintf("\"%s\" is not a valid email address", userName))
+		}
+	}
+	if name, ok := payload["name"].(map[string]any); ok {
+		for _, part := range []string{"givenName", "familyName"} {
+			if value, _ := name[part].(string); len(strings.TrimSpace(value)) == 0 {
+				return skip("name."+part, "is empty")
+			}
+		}
+	}
+	if emails, ok := payload["emails"].([]any); ok {
+		for _, e := range emails {
+			var email, _ = e.(map[string]any)
+			var value, _ = email["value"].(string)
+			if !isValidEmail(value) {
+				return skip("emails", fmt.Sprintf("\"%s\" is not a valid email address", value))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package scim
+
+import (
+	"fmt"
+
+	"golang.org/x/text/cases"
+)
+
+// resolveDefaultGroups resolves the configured default groups to SCIM group IDs.
+// A default group is a SCIM group name (case-insensitive) or SCIM group ID
+func (s *sync) resolveDefaultGroups() (groupIds []string, failures []string) {
+	if len(s.defaultGroups) == 0 {
+		return
+	}
+	var fold = cases.Fold()
+	var found = NewSet[string]()
+	for _, name := range s.defaultGroups {
+		var groupId string
+		if _, ok := s.scimGroups[name]; ok {
+			groupId = name
+		} else {
+			var folded = fold.String(name)
+			for _, sg := range s.scimGroups {
+				if fold.String(sg.Name) == folded {
+					groupId = sg.Id
+					break
+				}
+			}
+		}
+		if len(groupId) == 0 {
+			failures = append(failures, fmt.Sprintf("Default group \"%s\" was not found in SCIM", name))
+			continue
+		}
+		if !found.Has(groupId) {
+			found.Add(groupId)
+			groupIds = append(groupIds, groupId)
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pendingDeletion tracks a user that was deactivated since it is missing in the source
+type pendingDeletion struct {
+	Email string    `json:"email"`
+	Since time.Time `json:"since"`
+	Runs  int32     `json:"runs"`
+}
+
+const pendingDeletionsKey = "pending-deletions"
+
+func loadPendingDeletions(store IStateStore) (pending map[string]*pendingDeletion, err error) {
+	var data []byte
+	if data, err = store.Load(pendingDeletionsKey); err != nil {
+		return
+	}
+	pending = make(map[string]*pendingDeletion)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &pending)
+	}
+	return
+}
+
+func savePendingDeletions(store IStateStore, pending map[string]*pendingDeletion) (err error) {
+	var data []byte
+	if data, err = json.Marshal(pending); err != nil {
+		return
+	}
+	err = store.Save(pendingDeletionsKey, data)
+	return
+}
+
+func (s *sync) gracePeriodEnabled() bool {
+	return s.deleteGraceDays > 0 || s.deleteGraceRuns > 0
+}
+
+// remainingGrace returns the number of days and runs left before the user can be deleted
+func (s *sync) remainingGrace(pending *pendingDeletion, now time.Time) (days int32, runs int32) {
+	if s.deleteGraceDays > 0 {
+		var deadline = pending.Since.Add(time.Duration(s.deleteGraceDays) * 24 * time.Hour)
+		if now.Before(deadline) {
+			days = int32((deadline.Sub(now) + 24*time.Hour - 1) / (24 * time.Hour))
+		}
+	}
+	if s.deleteGraceRuns > pending.Runs {
+		runs = s.deleteGraceRuns - pending.Runs
+	}
+	return
+}
+
+func describeGrace(days int32, runs int32) string {
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%d day(s)", days))
+	}
+	if runs > 0 {
+		parts = append(parts, fmt.Sprintf("%d run(s)", runs))
+	}
+	return strings.Join(parts, " and ")
+}
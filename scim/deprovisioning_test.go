@@ -0,0 +1,40 @@
+package scim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemainingGrace(t *testing.T) {
+	var now = time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name  string
+		days  int32
+		runs  int32
+		since time.Duration
+		seen  int32
+		want  [2]int32
+	}{
+		{name: "no grace period", since: 30 * 24 * time.Hour},
+		{name: "days just started", days: 7, want: [2]int32{7, 0}},
+		{name: "partial day rounds up", days: 7, since: 36 * time.Hour, want: [2]int32{6, 0}},
+		{name: "last hour", days: 1, since: 23 * time.Hour, want: [2]int32{1, 0}},
+		{name: "days expired", days: 7, since: 7 * 24 * time.Hour},
+		{name: "runs left", runs: 3, seen: 1, want: [2]int32{0, 2}},
+		{name: "runs expired", runs: 3, seen: 3},
+		{name: "runs exceeded", runs: 3, seen: 5},
+		{name: "days and runs", days: 2, runs: 3, since: 24 * time.Hour, seen: 1, want: [2]int32{1, 2}},
+		{name: "days expired, runs left", days: 2, runs: 3, since: 3 * 24 * time.Hour, seen: 2, want: [2]int32{0, 1}},
+		{name: "runs expired, days left", days: 2, runs: 3, since: time.Hour, seen: 4, want: [2]int32{2, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = &sync{deleteGraceDays: tt.days, deleteGraceRuns: tt.runs}
+			var pending = &pendingDeletion{Email: "a@example.com", Since: now.Add(-tt.since), Runs: tt.seen}
+			var days, runs = s.remainingGrace(pending, now)
+			if days != tt.want[0] || runs != tt.want[1] {
+				t.Errorf("remaining = %d day(s), %d run(s), want %d, %d", days, runs, tt.want[0], tt.want[1])
+			}
+		})
+	}
+}
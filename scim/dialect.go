@@ -0,0 +1,319 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dialectKey is the state store key of the write encoding that works for the SCIM target
+const dialectKey = "dialect"
+
+// dialectProbeLimit is the number of rejected writes per run that are probed with alternate encodings
+const dialectProbeLimit = 3
+
+// ServerDialect is the encoding of SCIM writes accepted by the target, found by probing rejected writes
+type ServerDialect struct {
+	// PathPatch sends PATCH attributes as path operations instead of a value map
+	PathPatch bool `json:"pathPatch,omitempty"`
+	// PutUpdates updates resources by GET and PUT of the whole resource instead of PATCH
+	PutUpdates bool `json:"putUpdates,omitempty"`
+	// GroupMembership changes memberships by PATCH of the group members instead of the user groups
+	GroupMembership bool      `json:"groupMembership,omitempty"`
+	Probed          time.Time `json:"probed"`
+}
+
+func (sd *ServerDialect) String() string {
+	var parts []string
+	if sd.PathPatch {
+		parts = append(parts, "path operations")
+	}
+	if sd.PutUpdates {
+		parts = append(parts, "PUT updates")
+	}
+	if sd.GroupMembership {
+		parts = append(parts, "group-side membership")
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadDialect restores the write encoding found for the SCIM target by a previous run
+func (s *sync) loadDialect() {
+	s.probes = 0
+	if (s.patchStyle != PatchStyleAuto && !s.probeDialect) || s.stateStore == nil {
+		return
+	}
+	var data, err = s.stateStore.Load(dialectKey)
+	if err != nil || data == nil {
+		return
+	}
+	var dialect ServerDialect
+	if err = json.Unmarshal(data, &dialect); err != nil {
+		log.Printf("Failed to parse the stored SCIM dialect: %s", err.Error())
+		return
+	}
+	if !s.probeDialect {
+		// the "auto" PATCH style detects path operations only
+		dialect = ServerDialect{PathPatch: dialect.PathPatch, Probed: dialect.Probed}
+	}
+	s.dialect = dialect
+	if dialect != (ServerDialect{Probed: dialect.Probed}) {
+		s.debugLogger(fmt.Sprintf("SCIM dialect: %s", dialect.String()))
+	}
+}
+
+// saveDialect keeps the write encoding found for the SCIM target
+func (s *sync) saveDialect() {
+	s.dialect.Probed = time.Now()
+	log.Printf("SCIM server accepted a rejected write with %s. Later writes use it", s.dialect.String())
+	if s.stateStore == nil {
+		return
+	}
+	if data, err := json.Marshal(&s.dialect); err == nil {
+		if err = s.stateStore.Save(dialectKey, data); err != nil {
+			log.Printf("Failed to store the SCIM dialect: %s", err.Error())
+		}
+	}
+}
+
+// probeWorkarounds retries the rejected PATCH with alternate encodings. The first encoding the server accepts
+// is used for the rest of the run and stored for the target. Returns true if the change was applied
+func (s *sync) probeWorkarounds(resourceType string, resourceId string, payload any) bool {
+	if s.probes >= dialectProbeLimit {
+		return false
+	}
+	s.probes++
+	var membership = hasMembershipOperations(payload)
+	if !s.dialect.PathPatch && (s.patchStyle == PatchStyleAuto || s.probeDialect) {
+		if converted := toPathPatch(payload); converted != nil {
+			if er1 := s.sendPatch(resourceType, resourceId, converted); er1 == nil {
+				s.dialect.PathPatch = true
+				s.saveDialect()
+				return true
+			}
+		}
+	}
+	if !s.probeDialect {
+		return false
+	}
+	if membership && resourceType == "Users" && !s.dialect.GroupMembership {
+		if rest, er1 := s.patchMembershipByGroups(resourceId, payload); er1 == nil {
+			if rest == nil || s.sendPatch(resourceType, resourceId, rest) == nil {
+				s.dialect.GroupMembership = true
+				s.saveDialect()
+				return true
+			}
+		}
+	}
+	if !membership && !s.dialect.PutUpdates {
+		if er1 := s.putPatched(resourceType, resourceId, payload); er1 == nil {
+			s.dialect.PutUpdates = true
+			s.saveDialect()
+			return true
+		}
+	}
+	return false
+}
+
+// patchOperations returns the operations of the PATCH payload
+func patchOperations(payload any) (operations []map[string]any) {
+	var patch, ok = payload.(map[string]any)
+	if !ok {
+		return
+	}
+	switch ops := patch["Operations"].(type) {
+	case []any:
+		for _, o := range ops {
+			if operation, ok := o.(map[string]any); ok {
+				operations = append(operations, operation)
+			}
+		}
+	case []map[string]any:
+		operations = ops
+	}
+	return
+}
+
+func isMembershipOperation(operation map[string]any) bool {
+	var path, _ = operation["path"].(string)
+	return path == "groups" || strings.HasPrefix(path, "groups[")
+}
+
+func hasMembershipOperations(payload any) bool {
+	for _, operation := range patchOperations(payload) {
+		if isMembershipOperation(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchMembershipByGroups applies the "groups" operations of the user PATCH as PATCH requests of the group members.
+// rest is the payload with the other operations, nil if there are none
+func (s *sync) patchMembershipByGroups(userId string, payload any) (rest any, err error) {
+	var others []any
+	for _, operation := range patchOperations(payload) {
+		if !isMembershipOperation(operation) || operation["path"] != "groups" {
+			others = append(others, operation)
+			continue
+		}
+		var op, _ = operation["op"].(string)
+		var values, _ = operation["value"].([]any)
+		for _, v := range values {
+			var groupId string
+			if vo, ok := v.(map[string]any); ok {
+				groupId, _ = toString(vo["value"])
+			}
+			if len(groupId) == 0 {
+				continue
+			}
+			var groupOperation map[string]any
+			switch strings.ToLower(op) {
+			case "add":
+				groupOperation = makePatchOperation("add", "members", []any{map[string]any{"value": userId}})
+			case "remove":
+				groupOperation = map[string]any{"op": "remove", "path": fmt.Sprintf("members[value eq \"%s\"]", userId)}
+			default:
+				err = fmt.Errorf("membership operation \"%s\" is not supported", op)
+				return
+			}
+			if err = s.sendPatch("Groups", groupId, makePatchPayload(groupOperation)); err != nil {
+				return
+			}
+		}
+	}
+	if len(others) > 0 {
+		rest = makePatchPayload(others...)
+	}
+	return
+}
+
+// putPatched applies the PATCH operations to the current resource and replaces the resource with PUT
+func (s *sync) putPatched(resourceType string, resourceId string, payload any) (err error) {
+	var uri *url.URL
+	if uri, err = s.composeUrl(resourceType, resourceId); err != nil {
+		return
+	}
+	var rq *http.Request
+	if rq, err = http.NewRequest("GET", uri.String(), nil); err != nil {
+		return
+	}
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	var resource map[string]any
+	if resource, err = s.executeRequest(rq); err != nil {
+		return
+	}
+	if resource == nil {
+		err = fmt.Errorf("SCIM %s \"%s\" was not returned", resourceType, resourceId)
+		return
+	}
+	for _, operation := range patchOperations(payload) {
+		if err = applyPatchOperation(resource, operation); err != nil {
+			return
+		}
+	}
+	delete(resource, "meta")
+	var data []byte
+	if data, err = json.Marshal(resource); err != nil {
+		return
+	}
+	if rq, err = http.NewRequest("PUT", uri.String(), bytes.NewBuffer(data)); err != nil {
+		return
+	}
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	rq.Header.Add("Content-Type", "application/json")
+	_, err = s.executeRequest(rq)
+	return
+}
+
+// applyPatchOperation applies the PATCH operation to the resource. Filtered paths are not supported
+func applyPatchOperation(resource map[string]any, operation map[string]any) error {
+	var op, _ = operation["op"].(string)
+	var path, _ = operation["path"].(string)
+	if strings.Contains(path, "[") {
+		return fmt.Errorf("PATCH path \"%s\" cannot be applied with PUT", path)
+	}
+	var value = operation["value"]
+	switch strings.ToLower(op) {
+	case "remove":
+		if len(path) == 0 {
+			return fmt.Errorf("PATCH remove requires a path")
+		}
+		setResourceAttribute(resource, path, nil, false)
+	case "add", "replace":
+		if len(path) > 0 {
+			setResourceAttribute(resource, path, value, strings.EqualFold(op, "add"))
+			return nil
+		}
+		var attributes, ok = value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("PATCH %s without path requires a value map", op)
+		}
+		for key, v := range attributes {
+			if extension, ok := v.(map[string]any); ok && strings.HasPrefix(key, "urn:") {
+				for name, ev := range extension {
+					setResourceAttribute(resource, key+":"+name, ev, false)
+				}
+				continue
+			}
+			setResourceAttribute(resource, key, v, false)
+		}
+	default:
+		return fmt.Errorf("PATCH operation \"%s\" is not supported", op)
+	}
+	return nil
+}
+
+// setResourceAttribute sets the attribute at the path, e.g. "name.givenName" or "<schema URN>:manager".
+// A nil value removes the attribute. Values added to a multi-valued attribute are appended
+func setResourceAttribute(resource map[string]any, path string, value any, add bool) {
+	var target = resource
+	if strings.HasPrefix(path, "urn:") {
+		var pos = strings.LastIndex(path, ":")
+		var schema = path[:pos]
+		var extension, ok = target[schema].(map[string]any)
+		if !ok {
+			if value == nil {
+				return
+			}
+			extension = make(map[string]any)
+			target[schema] = extension
+		}
+		target = extension
+		path = path[pos+1:]
+	}
+	var names = strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		var child, ok = target[name].(map[string]any)
+		if !ok {
+			if value == nil {
+				return
+			}
+			child = make(map[string]any)
+			target[name] = child
+		}
+		target = child
+	}
+	var name = names[len(names)-1]
+	if value == nil {
+		delete(target, name)
+		return
+	}
+	if add {
+		if existing, ok := target[name].([]any); ok {
+			if values, ok := value.([]any); ok {
+				target[name] = append(existing, values...)
+				return
+			}
+		}
+	}
+	target[name] = value
+}
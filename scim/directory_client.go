@@ -0,0 +1,99 @@
+package scim
+
+import (
+	"context"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// DirectoryListRequest selects a page of a Google Directory listing.
+// The listing is scoped to Domain if set, otherwise to Customer
+type DirectoryListRequest struct {
+	Customer   string
+	Domain     string
+	Query      string
+	Projection string
+	PageToken  string
+	MaxResults int64
+}
+
+// DirectoryClient reads users, groups and group members of Google Workspace.
+// The Admin SDK Directory API is the default implementation; tests and alternative transports,
+// such as the Cloud Identity API or a replay of recorded responses, plug in their own
+type DirectoryClient interface {
+	// ListUsers returns a page of users
+	ListUsers(ctx context.Context, request *DirectoryListRequest) (*admin.Users, error)
+	// ListGroups returns a page of groups
+	ListGroups(ctx context.Context, request *DirectoryListRequest) (*admin.Groups, error)
+	// ListMembers returns a page of direct members of the group. Only PageToken and MaxResults of the request are used
+	ListMembers(ctx context.Context, groupId string, request *DirectoryListRequest) (*admin.Members, error)
+	// GetUserPhoto returns the profile photo of the user
+	GetUserPhoto(ctx context.Context, userId string) (*admin.UserPhoto, error)
+}
+
+type adminDirectoryClient struct {
+	service *admin.Service
+}
+
+// NewDirectoryClient creates DirectoryClient on the Admin SDK Directory service
+func NewDirectoryClient(service *admin.Service) DirectoryClient {
+	return &adminDirectoryClient{
+		service: service,
+	}
+}
+
+func (dc *adminDirectoryClient) ListUsers(ctx context.Context, request *DirectoryListRequest) (*admin.Users, error) {
+	var call = dc.service.Users.List().Context(ctx)
+	if len(request.Domain) > 0 {
+		call = call.Domain(request.Domain)
+	} else if len(request.Customer) > 0 {
+		call = call.Customer(request.Customer)
+	}
+	if len(request.Query) > 0 {
+		call = call.Query(request.Query)
+	}
+	if len(request.Projection) > 0 {
+		call = call.Projection(request.Projection)
+	}
+	if len(request.PageToken) > 0 {
+		call = call.PageToken(request.PageToken)
+	}
+	if request.MaxResults > 0 {
+		call = call.MaxResults(request.MaxResults)
+	}
+	return call.Do()
+}
+
+func (dc *adminDirectoryClient) ListGroups(ctx context.Context, request *DirectoryListRequest) (*admin.Groups, error) {
+	var call = dc.service.Groups.List().Context(ctx)
+	if len(request.Domain) > 0 {
+		call = call.Domain(request.Domain)
+	} else if len(request.Customer) > 0 {
+		call = call.Customer(request.Customer)
+	}
+	if len(request.Query) > 0 {
+		call = call.Query(request.Query)
+	}
+	if len(request.PageToken) > 0 {
+		call = call.PageToken(request.PageToken)
+	}
+	if request.MaxResults > 0 {
+		call = call.MaxResults(request.MaxResults)
+	}
+	return call.Do()
+}
+
+func (dc *adminDirectoryClient) ListMembers(ctx context.Context, groupId string, request *DirectoryListRequest) (*admin.Members, error) {
+	var call = dc.service.Members.List(groupId).Context(ctx)
+	if len(request.PageToken) > 0 {
+		call = call.PageToken(request.PageToken)
+	}
+	if request.MaxResults > 0 {
+		call = call.MaxResults(request.MaxResults)
+	}
+	return call.Do()
+}
+
+func (dc *adminDirectoryClient) GetUserPhoto(ctx context.Context, userId string) (*admin.UserPhoto, error) {
+	return dc.service.Users.Photos.Get(userId).Context(ctx).Do()
+}
@@ -0,0 +1,170 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/text/cases"
+)
+
+// HookStageDrift is the stage of the drift report delivery
+const HookStageDrift = "drift"
+
+// DefaultDriftReportInterval sends the drift report weekly
+const DefaultDriftReportInterval = 7 * 24 * time.Hour
+
+const driftReportKey = "drift-report"
+
+// Destructive levels that deprovision a drifted resource
+const (
+	// DriftDeletedByPartial resources are deleted with destructive level 0 or higher
+	DriftDeletedByPartial = "partial"
+	// DriftDeletedByFull resources are deleted with destructive level 1 only
+	DriftDeletedByFull = "full"
+)
+
+// DriftReport lists SCIM resources that exist in SCIM but not in the source, i.e. resources
+// that would be deprovisioned in destructive mode
+type DriftReport struct {
+	RunId     string        `json:"runId"`
+	Generated time.Time     `json:"generated"`
+	Summary   string        `json:"summary"`
+	Users     []*DriftEntry `json:"users"`
+	Groups    []*DriftEntry `json:"groups"`
+}
+
+// DriftEntry is a SCIM resource missing in the source
+type DriftEntry struct {
+	Id         string `json:"id"`
+	ExternalId string `json:"externalId,omitempty"`
+	Name       string `json:"name"`
+	// DeletedBy is the lowest destructive level that deletes the resource: "partial" or "full"
+	DeletedBy string `json:"deletedBy"`
+}
+
+// driftReportState is kept in the state store to schedule the drift report
+type driftReportState struct {
+	LastSent time.Time `json:"lastSent"`
+}
+
+// driftReport collects SCIM users and groups missing in the populated source
+func (s *sync) driftReport() (report *DriftReport) {
+	report = &DriftReport{
+		RunId:     s.runId,
+		Generated: time.Now().UTC(),
+		Users:     []*DriftEntry{},
+		Groups:    []*DriftEntry{},
+	}
+	var fold = cases.Fold()
+	var deletedBy = func(externalId string) string {
+		if len(externalId) > 0 {
+			return DriftDeletedByPartial
+		}
+		return DriftDeletedByFull
+	}
+
+	var sourceGroupIds = NewSet[string]()
+	var sourceGroupNames = NewSet[string]()
+	s.source.Groups(func(group *Group) {
+		sourceGroupIds.Add(s.externalId(group.Id))
+		sourceGroupNames.Add(fold.String(group.Name))
+	})
+	for _, sg := range s.scimGroups {
+		if sourceGroupIds.Has(sg.ExternalId) || sourceGroupNames.Has(fold.String(sg.Name)) {
+			continue
+		}
+		var policy = s.groupPolicy(sg)
+		if policy == GroupPolicyCreateOnly || policy == GroupPolicyMembership {
+			continue
+		}
+		var by = deletedBy(sg.ExternalId)
+		if policy == GroupPolicyManaged {
+			by = DriftDeletedByPartial
+		}
+		report.Groups = append(report.Groups, &DriftEntry{
+			Id:         sg.Id,
+			ExternalId: sg.ExternalId,
+			Name:       sg.Name,
+			DeletedBy:  by,
+		})
+	}
+
+	var sourceUserNames = NewSet[string]()
+	var sourceExternalIds = NewSet[string]()
+	s.source.Users(func(user *User) {
+		sourceUserNames.Add(foldEmail(s.userName(user)))
+		sourceExternalIds.Add(s.externalId(user.Id))
+	})
+	for _, su := range s.scimUsers {
+		if !su.Active || sourceUserNames.Has(foldEmail(su.UserName)) || (len(su.ExternalId) > 0 && sourceExternalIds.Has(su.ExternalId)) {
+			continue
+		}
+		report.Users = append(report.Users, &DriftEntry{
+			Id:         su.Id,
+			ExternalId: su.ExternalId,
+			Name:       su.Email,
+			DeletedBy:  deletedBy(su.ExternalId),
+		})
+	}
+	for _, entries := range [][]*DriftEntry{report.Users, report.Groups} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	report.Summary = fmt.Sprintf("%d user(s) and %d group(s) exist in SCIM but not in the source", len(report.Users), len(report.Groups))
+	return
+}
+
+// DriftReport loads the source and SCIM data and reports resources that would be deprovisioned. No change is made
+func (s *sync) DriftReport() (report *DriftReport, err error) {
+	s.beginRun()
+	if _, err = s.populate(); err != nil {
+		return
+	}
+	report = s.driftReport()
+	return
+}
+
+// sendDriftReport delivers the drift report if it is due. Delivery errors are logged only.
+// Without a state store the report is sent on every sync run
+func (s *sync) sendDriftReport() {
+	if s.driftReportHook == nil {
+		return
+	}
+	var interval = s.driftReportInterval
+	if interval <= 0 {
+		interval = DefaultDriftReportInterval
+	}
+	var state = new(driftReportState)
+	if s.stateStore != nil {
+		if data, er1 := s.stateStore.Load(driftReportKey); er1 == nil && len(data) > 0 {
+			_ = json.Unmarshal(data, state)
+		}
+		if time.Since(state.LastSent) < interval {
+			return
+		}
+	}
+	var report = s.driftReport()
+	var payload, err = json.Marshal(report)
+	if err == nil {
+		err = s.driftReportHook.Execute(HookStageDrift, s.runId, payload)
+	}
+	if err != nil {
+		log.Printf("Drift report of sync run \"%s\" failed: %s", s.runId, err.Error())
+		return
+	}
+	log.Printf("Drift report sent: %s", report.Summary)
+	if s.stateStore != nil {
+		state.LastSent = time.Now()
+		var data []byte
+		if data, err = json.Marshal(state); err == nil {
+			err = s.stateStore.Save(driftReportKey, data)
+		}
+		if err != nil {
+			log.Printf("Failed to store drift report state: %s", err.Error())
+		}
+	}
+}
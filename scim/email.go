@@ -0,0 +1,40 @@
+package scim
+
+import (
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldEmail returns the comparison key of an email address or a user name.
+// Internationalized addresses (RFC 6532) are compared in Unicode normalization form C with case folding,
+// and IDN domains in their ASCII form, so "anna@bücher.example" and "Anna@xn--bcher-kva.example" are the same address
+func foldEmail(value string) string {
+	value = norm.NFC.String(cases.Fold().String(norm.NFC.String(value)))
+	if pos := strings.LastIndex(value, "@"); pos >= 0 {
+		value = value[:pos+1] + foldDomain(value[pos+1:])
+	}
+	return value
+}
+
+// foldDomain returns the ASCII form of the domain. Domains that are not valid IDNs are case folded
+func foldDomain(domain string) string {
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return cases.Fold().String(norm.NFC.String(domain))
+}
+
+// isValidEmail returns true if the value is a bare email address. UTF-8 local parts and IDN domains are accepted
+func isValidEmail(value string) bool {
+	var address, err = mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		return false
+	}
+	var domain = value[strings.LastIndex(value, "@")+1:]
+	_, err = idna.Lookup.ToASCII(domain)
+	return err == nil
+}
@@ -0,0 +1,170 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// GroupValueMapping assigns values of a multi-valued SCIM user attribute, e.g. roles or entitlements, to members of
+// source groups. Keys are group names, group emails (case-insensitive) or Google group IDs
+type GroupValueMapping map[string][]string
+
+// EntitlementMapping assigns SCIM entitlements to members of source groups
+type EntitlementMapping = GroupValueMapping
+
+// parseGroupValueMapping parses "group=value" entries separated by comma or new line. A group may be listed several times
+func parseGroupValueMapping(kind string, entries []string) (mapping GroupValueMapping, err error) {
+	for _, entry := range entries {
+		var pos = strings.LastIndex(entry, "=")
+		var group, value string
+		if pos > 0 {
+			group = strings.TrimSpace(entry[:pos])
+			value = strings.TrimSpace(entry[pos+1:])
+		}
+		if len(group) == 0 || len(value) == 0 {
+			err = fmt.Errorf("%s mapping \"%s\" is not in \"group=%s\" format", kind, entry, kind)
+			return
+		}
+		if mapping == nil {
+			mapping = make(GroupValueMapping)
+		}
+		mapping[group] = append(mapping[group], value)
+	}
+	return
+}
+
+// ParseEntitlementMapping parses "group=entitlement" entries separated by comma or new line
+func ParseEntitlementMapping(entries []string) (EntitlementMapping, error) {
+	return parseGroupValueMapping("entitlement", entries)
+}
+
+// groupValues is a group value mapping resolved to source group IDs
+type groupValues struct {
+	byGroup map[string][]string
+	// managed are all mapped values. Other values of SCIM users are never changed
+	managed Set[string]
+}
+
+// resolveGroupValues resolves the mapping to source group IDs. Returns nil if the mapping is empty
+func (s *sync) resolveGroupValues(mapping GroupValueMapping) (gv *groupValues) {
+	if len(mapping) == 0 {
+		return
+	}
+	var fold = cases.Fold()
+	gv = &groupValues{
+		byGroup: make(map[string][]string),
+		managed: NewSet[string](),
+	}
+	var folded = make(map[string][]string)
+	for group, values := range mapping {
+		var key = fold.String(group)
+		folded[key] = append(folded[key], values...)
+		for _, value := range values {
+			gv.managed.Add(fold.String(value))
+		}
+	}
+	s.source.Groups(func(group *Group) {
+		var found = NewSet[string]()
+		for _, key := range []string{fold.String(group.Id), fold.String(group.Name), fold.String(group.AttributeString(SourceAttributeEmail))} {
+			if len(key) == 0 || found.Has(key) {
+				continue
+			}
+			found.Add(key)
+			gv.byGroup[group.Id] = append(gv.byGroup[group.Id], folded[key]...)
+		}
+	})
+	return
+}
+
+// target returns the sorted values the SCIM user should have: the mapped values of the user's groups and unmanaged values
+// the SCIM user already has. changed is false if current contains exactly these values
+func (gv *groupValues) target(user *User, current []string) (values []string, changed bool) {
+	var fold = cases.Fold()
+	var found = NewSet[string]()
+	for _, value := range current {
+		if !gv.managed.Has(fold.String(value)) && !found.Has(fold.String(value)) {
+			found.Add(fold.String(value))
+			values = append(values, value)
+		}
+	}
+	for _, groupId := range user.Groups {
+		for _, value := range gv.byGroup[groupId] {
+			if !found.Has(fold.String(value)) {
+				found.Add(fold.String(value))
+				values = append(values, value)
+			}
+		}
+	}
+	sort.Strings(values)
+	if len(values) != len(current) {
+		changed = true
+		return
+	}
+	var existing = NewSet[string]()
+	for _, value := range current {
+		existing.Add(fold.String(value))
+	}
+	for _, value := range values {
+		if !existing.Has(fold.String(value)) {
+			changed = true
+			break
+		}
+	}
+	return
+}
+
+// multiValuesToScim converts values to a SCIM multi-valued attribute
+func multiValuesToScim(values []string) (result []any) {
+	result = []any{}
+	for _, value := range values {
+		result = append(result, map[string]any{"value": value})
+	}
+	return
+}
+
+// parseScimMultiValues returns the values of a SCIM multi-valued attribute
+func parseScimMultiValues(attribute any) (result []string) {
+	var list, ok = attribute.([]any)
+	if !ok {
+		return
+	}
+	for _, a := range list {
+		var item map[string]any
+		if item, ok = a.(map[string]any); ok {
+			if value, _ := toString(item["value"]); len(value) > 0 {
+				result = append(result, value)
+			}
+		}
+	}
+	return
+}
+
+// diffEntitlements adds changed entitlements to PATCH value and its inverse
+func (s *sync) diffEntitlements(user *User, keeperUser *scimUser, value map[string]any, inverse map[string]any) {
+	if s.entitlements == nil {
+		return
+	}
+	if entitlements, changed := s.entitlements.target(user, keeperUser.Entitlements); changed {
+		value["entitlements"] = multiValuesToScim(entitlements)
+		inverse["entitlements"] = multiValuesToScim(keeperUser.Entitlements)
+	}
+}
+
+// copyEntitlements updates entitlements of SCIM user after successful PATCH
+func (s *sync) copyEntitlements(user *User, keeperUser *scimUser) {
+	if s.entitlements != nil {
+		keeperUser.Entitlements, _ = s.entitlements.target(user, keeperUser.Entitlements)
+	}
+}
+
+// addEntitlements adds entitlements to POST payload
+func (s *sync) addEntitlements(user *User, payload map[string]any) {
+	if s.entitlements != nil {
+		if entitlements, _ := s.entitlements.target(user, nil); len(entitlements) > 0 {
+			payload["entitlements"] = multiValuesToScim(entitlements)
+		}
+	}
+}
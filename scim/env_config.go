@@ -3,16 +3,19 @@ package scim
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // LoadScimParametersFromEnv loads SCIM configuration from environment variables
 // instead of Keeper Secrets Manager.
 //
 // Required environment variables:
-//   - GOOGLE_CREDENTIALS: GCP service account credentials JSON (can be base64 encoded)
+//   - GOOGLE_CREDENTIALS: GCP service account credentials JSON (can be base64 encoded).
+//     Optional with GOOGLE_IMPERSONATE_SERVICE_ACCOUNT: Application Default Credentials are used if not set
 //   - GOOGLE_ADMIN_ACCOUNT: Google Workspace admin account email
 //   - SCIM_GROUPS: Comma or newline separated list of Google groups/users to sync
 //   - SCIM_URL: SCIM endpoint URL
@@ -20,30 +23,94 @@ import (
 //
 // Optional environment variables:
 //   - SCIM_VERBOSE: Enable verbose logging (true/false/1/0)
+//   - SCIM_TRACE: Log SCIM and Google HTTP traffic with secrets redacted (true/false/1/0)
 //   - SCIM_DESTRUCTIVE: Deletion behavior (-1=safe mode, 0=partial, >0=full)
 //   - SCIM_UPDATE_USERS: Enable Users creation/update in Keeper (true/false/1/0), default true.
+//   - SCIM_MAX_DELETES: Maximum number of users and groups deleted per run, 0 means no limit
+//   - SCIM_DELETE_GRACE_DAYS: Days a deactivated user is kept before deletion
+//   - SCIM_DELETE_GRACE_RUNS: Sync runs a deactivated user is kept before deletion
+//   - SCIM_RESOURCE_PATHS: Comma separated "base=path", "users=path", "groups=path" overrides of SCIM resource paths
+//   - SCIM_GROUP_POLICIES: Comma or newline separated "group=policy" overrides of the destructive setting
+//   - SCIM_EXTERNAL_ID_PREFIX: Namespace prefix of externalId values, e.g. "google:"
+//   - SCIM_COOPERATIVE: Manage only SCIM resources with the external ID prefix (true/false/1/0)
+//   - SCIM_CREATE_WITH_GROUPS: Send memberships of new users in the user create request (true/false/1/0)
+//   - SCIM_CREATE_WITH_MEMBERS: Send members of new groups in the group create request (true/false/1/0)
+//   - SCIM_RENAME_CONFLICTS: Policy of group renames that collide with an existing SCIM group: "skip" (default) or "merge"
+//   - SCIM_PATCH_STYLE: PATCH attributes as "value" map (default), "path" operations, or "auto" detected from rejections
+//   - SCIM_COMPRESS_REQUESTS: Compress SCIM request bodies with gzip (true/false/1/0)
+//   - SCIM_LIST_PARALLELISM: Number of SCIM listing pages fetched concurrently (default 4, 1 pages sequentially)
+//   - SCIM_PROBE_DIALECT: Retry rejected writes with alternate encodings and keep the working one (true/false/1/0)
+//   - SCIM_GROUP_MATCH_FALLBACK: Pairing of groups left unmatched by external ID and name: "none" (default), "position" or "overlap[:threshold]"
+//   - SCIM_MEMBERSHIP_TRANSACTION: Order of membership additions and removals: "off" (default), "add-first" or "remove-first"
+//   - SCIM_USER_STATES: Comma or newline separated "state=action" and "state.attribute=value" user state mapping
+//   - SCIM_ROLES: Comma or newline separated "group=role" mapping of Google groups to SCIM user roles
+//   - SCIM_ENTITLEMENTS: Comma or newline separated "group=entitlement" mapping of Google groups to SCIM user entitlements
+//   - SCIM_SYNC_MANAGER: Sync user's manager into SCIM enterprise extension (true/false/1/0)
+//   - SCIM_ATTRIBUTES: Comma separated allowlist of optional user attributes to sync (phoneNumbers, addresses, photos,
+//     preferredLanguage, locale, timezone)
+//   - SCIM_IGNORED_ATTRIBUTES: Comma separated user attributes whose differences do not update existing users, e.g. "displayName"
+//   - SCIM_USERNAME: Source of SCIM userName: "email" (default), "employeeId" or a template like "{localPart}@corp.example.com"
+//   - SCIM_ALLOWED_DOMAINS: Comma separated email domains accepted by the target. Users from other domains are skipped
+//   - GOOGLE_TIMEZONE_FIELD: Google custom schema field "Schema.Field" that contains user's timezone
+//   - GOOGLE_CUSTOMER_ID: Google Workspace customer ID. Defaults to "my_customer"
+//   - GOOGLE_DOMAIN: Google Workspace domain. Takes precedence over GOOGLE_CUSTOMER_ID
+//   - GOOGLE_IMPERSONATE_SERVICE_ACCOUNT: Comma-separated service account chain impersonated before domain-wide delegation.
+//     The last account is delegated; preceding accounts are intermediate delegates
+//   - GOOGLE_SKIP_NESTED_GROUPS: Do not provision members of nested groups (true/false/1/0)
+//   - GOOGLE_MAX_NESTED_DEPTH: Maximum nesting depth of the nested group expansion. Default 20
+//   - GOOGLE_MAX_NESTED_GROUPS: Maximum number of nested groups expanded per group. Default 5000
+//   - GOOGLE_EXCLUDED_GROUPS: Comma-separated nested group emails or email patterns that are not expanded
+//   - SCIM_STRICT_RESOLUTION: Abort the sync if any SCIM_GROUPS entry cannot be resolved (true/false/1/0)
+//   - SCIM_SYNC_PHASES: Comma-separated phases to run: groups, users, membership, roles. All phases run by default
+//   - SCIM_PHASE_ORDER: Comma-separated preferred order of sync steps: groups, users, managers, membership, roles
+//   - SCIM_SYNC_CAPS: Comma-separated "name=limit" caps of the sync plan (users, creates, updates, deletes)
+//   - SCIM_STATE_STORE: Folder or URI of the state store that keeps sync run journals
+//   - SCIM_HISTORY_RUNS: Number of most recent sync runs kept in the state store
+//   - SCIM_CACHE_LISTINGS: Cache SCIM listing pages in the state store and send conditional GET requests
+//   - SCIM_OUTPUT: Format of the sync statistics output: "full" (default) or "summary"
+//   - SCIM_OUTPUT_LIMIT: Number of entries listed per section of the sync statistics output
+//   - SCIM_TIME_BUDGET: Execution time of a sync run, e.g. "9m". No SCIM change is started close to the end of the budget
+//   - SCIM_USER_NOTIFY: "smtp://", "smtps://" or "gmail://" connection string of email notifications to users
+//   - SCIM_USER_NOTIFY_PROVISIONED / SCIM_USER_NOTIFY_DEPROVISIONING: Templates of the notification messages
+//   - SCIM_USER_AGENT_SUFFIX: Identifying string appended to the User-Agent of SCIM and Google requests
+//   - SCIM_TELEMETRY_URL: HTTPS endpoint of opt-in anonymized run metrics
+//   - SCIM_RESULT_SINKS: Comma-separated destinations of sync results, e.g. "bigquery://project/dataset/table"
+//   - SCIM_DEFAULT_GROUPS: Comma-separated SCIM group names or IDs every newly created user is added to
+//   - SCIM_DRIFT_REPORT: Shell command or HTTP URL that receives the report of resources missing in the source
+//   - SCIM_DRIFT_REPORT_INTERVAL: Interval between drift reports, e.g. "24h". Default weekly
+//   - SCIM_CANARY: Comma-separated pilot groups and/or percentage of users, e.g. "10%". Only their changes are applied
+//   - SCIM_PRE_SYNC_HOOK: Shell command or HTTP URL that receives the sync plan before changes are applied
+//   - SCIM_POST_SYNC_HOOK: Shell command or HTTP URL that receives the sync result
+//   - SCIM_POLICY_URL: OPA Data API URL that evaluates the sync plan. Policy violations block the sync
+//   - SCIM_LOG_FORMAT: Log output format "text" or "json". Read by the entry points
+//
+// Every variable can be read from a file instead: "<NAME>_FILE" contains the path of the file with the value,
+// e.g. SCIM_TOKEN_FILE=/run/secrets/scim_token. See LoadEnvFiles
 func LoadScimParametersFromEnv() (ka *ScimEndpointParameters, gcp *GoogleEndpointParameters, err error) {
 	// Load Google credentials
 	var credentials []byte
+	var impersonate = parseScimGroupsFromString(os.Getenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"))
 	credentialsStr := os.Getenv("GOOGLE_CREDENTIALS")
-	if len(credentialsStr) == 0 {
+	if len(credentialsStr) == 0 && len(impersonate) == 0 {
 		err = errors.New("environment variable \"GOOGLE_CREDENTIALS\" is not set")
 		return
 	}
 
-	// Try to decode as base64 first, if that fails, use as-is
-	if decoded, err2 := base64.StdEncoding.DecodeString(credentialsStr); err2 == nil {
-		credentials = decoded
-	} else {
-		// If not base64, assume it's the raw JSON
-		credentials = []byte(credentialsStr)
-	}
+	if len(credentialsStr) > 0 {
+		// Try to decode as base64 first, if that fails, use as-is
+		if decoded, err2 := base64.StdEncoding.DecodeString(credentialsStr); err2 == nil {
+			credentials = decoded
+		} else {
+			// If not base64, assume it's the raw JSON
+			credentials = []byte(credentialsStr)
+		}
 
-	// Validate that credentials look like JSON
-	credStr := strings.TrimSpace(string(credentials))
-	if !strings.HasPrefix(credStr, "{") {
-		err = errors.New("GOOGLE_CREDENTIALS does not appear to be valid JSON")
-		return
+		// Validate that credentials look like JSON
+		credStr := strings.TrimSpace(string(credentials))
+		if !strings.HasPrefix(credStr, "{") {
+			err = errors.New("GOOGLE_CREDENTIALS does not appear to be valid JSON")
+			return
+		}
 	}
 
 	// Load Google admin account
@@ -86,6 +153,25 @@ func LoadScimParametersFromEnv() (ka *ScimEndpointParameters, gcp *GoogleEndpoin
 		ScimGroups:   scimGroups,
 	}
 
+	gcp.ImpersonateServiceAccount = impersonate
+	gcp.TimezoneField = strings.TrimSpace(os.Getenv("GOOGLE_TIMEZONE_FIELD"))
+	gcp.CustomerId = strings.TrimSpace(os.Getenv("GOOGLE_CUSTOMER_ID"))
+	gcp.Domain = strings.TrimSpace(os.Getenv("GOOGLE_DOMAIN"))
+	if skipNestedStr := os.Getenv("GOOGLE_SKIP_NESTED_GROUPS"); len(skipNestedStr) > 0 {
+		if bv, ok := toBoolean(skipNestedStr); ok {
+			gcp.SkipNestedGroups = bv
+		}
+	}
+	if excludedStr := os.Getenv("GOOGLE_EXCLUDED_GROUPS"); len(strings.TrimSpace(excludedStr)) > 0 {
+		gcp.ExcludedGroups = parseScimGroupsFromString(excludedStr)
+	}
+	if gcp.MaxNestedDepth, err = getEnvNonNegativeInt("GOOGLE_MAX_NESTED_DEPTH"); err != nil {
+		return
+	}
+	if gcp.MaxNestedGroups, err = getEnvNonNegativeInt("GOOGLE_MAX_NESTED_GROUPS"); err != nil {
+		return
+	}
+
 	// Build SCIM endpoint parameters
 	ka = &ScimEndpointParameters{
 		Url:   scimUrl,
@@ -99,6 +185,14 @@ func LoadScimParametersFromEnv() (ka *ScimEndpointParameters, gcp *GoogleEndpoin
 		}
 	}
 
+	// Load optional trace flag
+	if traceStr := os.Getenv("SCIM_TRACE"); len(traceStr) > 0 {
+		if bv, ok := toBoolean(traceStr); ok {
+			ka.Trace = bv
+			gcp.Trace = bv
+		}
+	}
+
 	// Load optional destructive flag
 	if destructiveStr := os.Getenv("SCIM_DESTRUCTIVE"); len(destructiveStr) > 0 {
 		if iv, err2 := strconv.Atoi(destructiveStr); err2 == nil {
@@ -117,6 +211,265 @@ func LoadScimParametersFromEnv() (ka *ScimEndpointParameters, gcp *GoogleEndpoin
 		}
 	}
 
+	// Load optional deletion limit
+	if ka.MaxDeletes, err = getEnvNonNegativeInt("SCIM_MAX_DELETES"); err != nil {
+		return
+	}
+
+	// Load optional grace period of user deletion
+	if ka.DeleteGraceDays, err = getEnvNonNegativeInt("SCIM_DELETE_GRACE_DAYS"); err != nil {
+		return
+	}
+	if ka.DeleteGraceRuns, err = getEnvNonNegativeInt("SCIM_DELETE_GRACE_RUNS"); err != nil {
+		return
+	}
+
+	// Load optional SCIM resource paths
+	if pathsStr := os.Getenv("SCIM_RESOURCE_PATHS"); len(strings.TrimSpace(pathsStr)) > 0 {
+		if ka.Paths, err = ParseScimPaths(parseScimGroupsFromString(pathsStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional per-group policies
+	if policiesStr := os.Getenv("SCIM_GROUP_POLICIES"); len(strings.TrimSpace(policiesStr)) > 0 {
+		if ka.GroupPolicies, err = ParseGroupPolicies(parseScimGroupsFromString(policiesStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional external ID namespace prefix
+	if ka.ExternalIdPrefix, err = ValidateExternalIdPrefix(os.Getenv("SCIM_EXTERNAL_ID_PREFIX")); err != nil {
+		return
+	}
+
+	// Load optional cooperative mode flag
+	if cooperativeStr := os.Getenv("SCIM_COOPERATIVE"); len(cooperativeStr) > 0 {
+		if bv, ok := toBoolean(cooperativeStr); ok {
+			ka.Cooperative = bv
+		}
+	}
+	if ka.Cooperative && len(ka.ExternalIdPrefix) == 0 {
+		err = errors.New("\"SCIM_COOPERATIVE\" requires \"SCIM_EXTERNAL_ID_PREFIX\"")
+		return
+	}
+
+	// Load optional flag of memberships in the user create request
+	if createStr := os.Getenv("SCIM_CREATE_WITH_GROUPS"); len(createStr) > 0 {
+		if bv, ok := toBoolean(createStr); ok {
+			ka.CreateWithGroups = bv
+		}
+	}
+
+	// Load optional flag of members in the group create request
+	if createStr := os.Getenv("SCIM_CREATE_WITH_MEMBERS"); len(createStr) > 0 {
+		if bv, ok := toBoolean(createStr); ok {
+			ka.CreateWithMembers = bv
+		}
+	}
+
+	// Load optional group rename conflict policy
+	if ka.RenameConflicts, err = ParseRenameConflictPolicy(os.Getenv("SCIM_RENAME_CONFLICTS")); err != nil {
+		return
+	}
+	if ka.GroupMatchFallback, err = ParseGroupMatchFallback(os.Getenv("SCIM_GROUP_MATCH_FALLBACK")); err != nil {
+		return
+	}
+	if ka.PatchStyle, err = ParsePatchStyle(os.Getenv("SCIM_PATCH_STYLE")); err != nil {
+		return
+	}
+	if compressStr := os.Getenv("SCIM_COMPRESS_REQUESTS"); len(compressStr) > 0 {
+		if bv, ok := toBoolean(compressStr); ok {
+			ka.CompressRequests = bv
+		}
+	}
+	if ka.ListParallelism, err = getEnvNonNegativeInt("SCIM_LIST_PARALLELISM"); err != nil {
+		return
+	}
+	if probeStr := os.Getenv("SCIM_PROBE_DIALECT"); len(probeStr) > 0 {
+		if bv, ok := toBoolean(probeStr); ok {
+			ka.ProbeDialect = bv
+		}
+	}
+
+	// Load optional membership transaction policy
+	if ka.MembershipTransaction, err = ParseMembershipTransaction(os.Getenv("SCIM_MEMBERSHIP_TRANSACTION")); err != nil {
+		return
+	}
+
+	// Load optional user state mapping
+	if statesStr := os.Getenv("SCIM_USER_STATES"); len(strings.TrimSpace(statesStr)) > 0 {
+		if ka.UserStates, err = ParseUserStateMapping(parseScimGroupsFromString(statesStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional role mapping
+	if rolesStr := os.Getenv("SCIM_ROLES"); len(strings.TrimSpace(rolesStr)) > 0 {
+		if ka.RoleMapping, err = ParseRoleMapping(parseScimGroupsFromString(rolesStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional entitlement mapping
+	if entitlementsStr := os.Getenv("SCIM_ENTITLEMENTS"); len(strings.TrimSpace(entitlementsStr)) > 0 {
+		if ka.EntitlementMapping, err = ParseEntitlementMapping(parseScimGroupsFromString(entitlementsStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional SCIM listing cache flag
+	if cacheStr := os.Getenv("SCIM_CACHE_LISTINGS"); len(cacheStr) > 0 {
+		if bv, ok := toBoolean(cacheStr); ok {
+			ka.CacheListings = bv
+		}
+	}
+
+	// Load optional "sync manager" flag
+	if syncManagerStr := os.Getenv("SCIM_SYNC_MANAGER"); len(syncManagerStr) > 0 {
+		if bv, ok := toBoolean(syncManagerStr); ok {
+			ka.SyncManager = bv
+		}
+	}
+
+	// Load optional attribute allowlist
+	if attributesStr := os.Getenv("SCIM_ATTRIBUTES"); len(strings.TrimSpace(attributesStr)) > 0 {
+		if ka.Attributes, err = ParseAttributeList(parseScimGroupsFromString(attributesStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional ignored attributes
+	if ignoredStr := os.Getenv("SCIM_IGNORED_ATTRIBUTES"); len(strings.TrimSpace(ignoredStr)) > 0 {
+		if ka.IgnoredAttributes, err = ParseIgnoredAttributes(parseScimGroupsFromString(ignoredStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional userName format
+	if ka.UserNameFormat, err = ParseUserNameFormat(os.Getenv("SCIM_USERNAME")); err != nil {
+		return
+	}
+
+	// Load optional allowed email domains
+	if domainsStr := os.Getenv("SCIM_ALLOWED_DOMAINS"); len(strings.TrimSpace(domainsStr)) > 0 {
+		ka.AllowedDomains = parseScimGroupsFromString(domainsStr)
+	}
+
+	// Load optional strict resolution flag
+	if strictStr := os.Getenv("SCIM_STRICT_RESOLUTION"); len(strictStr) > 0 {
+		if bv, ok := toBoolean(strictStr); ok {
+			ka.StrictResolution = bv
+		}
+	}
+
+	// Load optional sync plan caps
+	if phasesStr := os.Getenv("SCIM_SYNC_PHASES"); len(strings.TrimSpace(phasesStr)) > 0 {
+		if ka.Phases, err = ParseSyncPhases(parseScimGroupsFromString(phasesStr)); err != nil {
+			return
+		}
+	}
+	if orderStr := os.Getenv("SCIM_PHASE_ORDER"); len(strings.TrimSpace(orderStr)) > 0 {
+		if ka.PhaseOrder, err = ParsePhaseOrder(parseScimGroupsFromString(orderStr)); err != nil {
+			return
+		}
+	}
+
+	if capsStr := os.Getenv("SCIM_SYNC_CAPS"); len(strings.TrimSpace(capsStr)) > 0 {
+		if ka.SyncCaps, err = ParseSyncCaps(parseScimGroupsFromString(capsStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional state store location
+	ka.StateStore = strings.TrimSpace(os.Getenv("SCIM_STATE_STORE"))
+	if ka.HistoryRuns, err = getEnvNonNegativeInt("SCIM_HISTORY_RUNS"); err != nil {
+		return
+	}
+
+	// Load optional result sinks
+	if sinksStr := os.Getenv("SCIM_RESULT_SINKS"); len(strings.TrimSpace(sinksStr)) > 0 {
+		ka.ResultSinks = parseScimGroupsFromString(sinksStr)
+		if _, err = NewResultSinks(ka.ResultSinks); err != nil {
+			return
+		}
+	}
+
+	// Load optional format of the sync statistics output
+	if ka.OutputFormat, err = ParseStatOutput(os.Getenv("SCIM_OUTPUT")); err != nil {
+		return
+	}
+	if ka.OutputLimit, err = getEnvNonNegativeInt("SCIM_OUTPUT_LIMIT"); err != nil {
+		return
+	}
+
+	// Load optional user notifications
+	ka.UserNotify = strings.TrimSpace(os.Getenv("SCIM_USER_NOTIFY"))
+	if ka.NotifyTemplates, err = loadNotifyTemplates(map[string]string{
+		NotifyProvisioned:    os.Getenv("SCIM_USER_NOTIFY_PROVISIONED"),
+		NotifyDeprovisioning: os.Getenv("SCIM_USER_NOTIFY_DEPROVISIONING"),
+	}); err != nil {
+		return
+	}
+
+	// Load optional time budget of a sync run
+	if budgetStr := strings.TrimSpace(os.Getenv("SCIM_TIME_BUDGET")); len(budgetStr) > 0 {
+		if ka.TimeBudget, err = time.ParseDuration(budgetStr); err != nil || ka.TimeBudget <= 0 {
+			err = fmt.Errorf("environment variable \"SCIM_TIME_BUDGET\" must be a duration, e.g. \"9m\"")
+			return
+		}
+	}
+
+	// Load optional client identification
+	if ka.UserAgentSuffix, err = ValidateUserAgentSuffix(os.Getenv("SCIM_USER_AGENT_SUFFIX")); err != nil {
+		return
+	}
+
+	// Load optional telemetry endpoint
+	if ka.TelemetryUrl, err = ValidateTelemetryUrl(os.Getenv("SCIM_TELEMETRY_URL")); err != nil {
+		return
+	}
+
+	if defaultStr := os.Getenv("SCIM_DEFAULT_GROUPS"); len(strings.TrimSpace(defaultStr)) > 0 {
+		ka.DefaultGroups = parseScimGroupsFromString(defaultStr)
+	}
+
+	// Load optional drift report
+	ka.DriftReport = strings.TrimSpace(os.Getenv("SCIM_DRIFT_REPORT"))
+	if intervalStr := strings.TrimSpace(os.Getenv("SCIM_DRIFT_REPORT_INTERVAL")); len(intervalStr) > 0 {
+		if ka.DriftReportInterval, err = time.ParseDuration(intervalStr); err != nil || ka.DriftReportInterval <= 0 {
+			err = fmt.Errorf("environment variable \"SCIM_DRIFT_REPORT_INTERVAL\" must be a duration, e.g. \"168h\"")
+			return
+		}
+	}
+
+	// Load optional canary scope
+	if canaryStr := os.Getenv("SCIM_CANARY"); len(strings.TrimSpace(canaryStr)) > 0 {
+		if ka.Canary, err = ParseCanaryScope(parseScimGroupsFromString(canaryStr)); err != nil {
+			return
+		}
+	}
+
+	// Load optional sync hooks
+	ka.PreSyncHook = strings.TrimSpace(os.Getenv("SCIM_PRE_SYNC_HOOK"))
+	ka.PostSyncHook = strings.TrimSpace(os.Getenv("SCIM_POST_SYNC_HOOK"))
+
+	// Load optional policy
+	ka.PolicyUrl = strings.TrimSpace(os.Getenv("SCIM_POLICY_URL"))
+
+	return
+}
+
+// getEnvNonNegativeInt parses an optional numeric environment variable
+func getEnvNonNegativeInt(name string) (result int32, err error) {
+	var value = strings.TrimSpace(os.Getenv(name))
+	if len(value) > 0 {
+		if iv, err2 := strconv.Atoi(value); err2 == nil && iv >= 0 {
+			result = int32(iv)
+		} else {
+			err = fmt.Errorf("\"%s\" environment variable must be a non-negative number", name)
+		}
+	}
 	return
 }
 
@@ -147,8 +500,10 @@ func parseScimGroupsFromString(groupsStr string) []string {
 // IsEnvConfigAvailable checks if the required environment variables for
 // environment-based configuration are present.
 func IsEnvConfigAvailable() bool {
+	if len(os.Getenv("GOOGLE_CREDENTIALS")) == 0 && len(os.Getenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")) == 0 {
+		return false
+	}
 	requiredVars := []string{
-		"GOOGLE_CREDENTIALS",
 		"GOOGLE_ADMIN_ACCOUNT",
 		"SCIM_GROUPS",
 		"SCIM_URL",
@@ -0,0 +1,58 @@
+package scim
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	gosync "sync"
+)
+
+// envFileSuffix marks environment variables that contain a path of the file with the value, e.g. "SCIM_TOKEN_FILE"
+const envFileSuffix = "_FILE"
+
+// envFileLimit limits the size of a secret file
+const envFileLimit = 1024 * 1024
+
+var envFiles = struct {
+	lock gosync.Mutex
+	keys Set[string]
+}{keys: NewSet[string]()}
+
+// LoadEnvFiles reads "<NAME>_FILE" variables, the pattern of Docker and Kubernetes secrets, and sets "<NAME>" to
+// the file content with the trailing new line removed. Setting both "<NAME>" and "<NAME>_FILE" is an error.
+// Files are read again on every call, so rotated secrets are picked up by long-running processes
+func LoadEnvFiles() (err error) {
+	envFiles.lock.Lock()
+	defer envFiles.lock.Unlock()
+	for _, env := range os.Environ() {
+		var key, path, _ = strings.Cut(env, "=")
+		if !strings.HasSuffix(key, envFileSuffix) || len(key) == len(envFileSuffix) || len(path) == 0 {
+			continue
+		}
+		var name = strings.TrimSuffix(key, envFileSuffix)
+		if len(os.Getenv(name)) > 0 && !envFiles.keys.Has(name) {
+			err = fmt.Errorf("environment variables \"%s\" and \"%s\" are mutually exclusive", name, key)
+			return
+		}
+		var info, er1 = os.Stat(path)
+		if er1 != nil {
+			err = fmt.Errorf("environment variable \"%s\": %w", key, er1)
+			return
+		}
+		if info.Size() > envFileLimit {
+			err = fmt.Errorf("environment variable \"%s\": file \"%s\" exceeds %d bytes", key, path, envFileLimit)
+			return
+		}
+		var data []byte
+		if data, er1 = os.ReadFile(path); er1 != nil {
+			err = fmt.Errorf("environment variable \"%s\": %w", key, er1)
+			return
+		}
+		var value = strings.TrimRight(string(data), "\r\n")
+		if err = os.Setenv(name, value); err != nil {
+			return
+		}
+		envFiles.keys.Add(name)
+	}
+	return
+}
@@ -0,0 +1,32 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateExternalIdPrefix checks the namespace prefix of external IDs, e.g. "google:" or "google-acme:"
+func ValidateExternalIdPrefix(prefix string) (result string, err error) {
+	result = strings.TrimSpace(prefix)
+	for _, ch := range result {
+		if ch <= ' ' || ch == '"' || ch == '/' {
+			err = fmt.Errorf("external ID prefix \"%s\" contains unsupported characters", prefix)
+			return
+		}
+	}
+	return
+}
+
+// externalId returns the SCIM externalId of the source user or group ID.
+// The namespace prefix keeps IDs of different sources or tenants apart
+func (s *sync) externalId(sourceId string) string {
+	if len(sourceId) == 0 {
+		return sourceId
+	}
+	return s.externalIdPrefix + sourceId
+}
+
+// sourceId returns the source ID of the SCIM externalId. External IDs without the namespace prefix are returned as is
+func (s *sync) sourceId(externalId string) string {
+	return strings.TrimPrefix(externalId, s.externalIdPrefix)
+}
@@ -0,0 +1,223 @@
+package scim
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	firestore "google.golang.org/api/firestore/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// firestoreExpireField is the document field of the Firestore TTL policy
+const firestoreExpireField = "expireAt"
+
+type firestoreStateStore struct {
+	service *firestore.Service
+	// parent is the tenant document, e.g. "projects/p/databases/(default)/documents/ksm-scim/tenant"
+	parent string
+	ttl    time.Duration
+}
+
+// NewFirestoreStateStore creates IStateStore for Cloud Functions, which have no persistent file system.
+// storeUri: "firestore://project/collection[?tenant=name][&database=id][&ttl=720h]".
+// Every tenant keeps its state in the "entries" subcollection of the tenant document. With ttl every document
+// gets an "expireAt" field, so a Firestore TTL policy removes state that was not written for that long.
+// The store implements IStateLocker. Application Default Credentials are used
+func NewFirestoreStateStore(storeUri string) (store IStateStore, err error) {
+	var uri *url.URL
+	if uri, err = url.Parse(storeUri); err != nil {
+		return
+	}
+	var project = uri.Host
+	var collection = strings.Trim(uri.Path, "/")
+	if len(project) == 0 || len(collection) == 0 || strings.Contains(collection, "/") {
+		err = fmt.Errorf("Firestore state store \"%s\" must be in \"firestore://project/collection\" format", storeUri)
+		return
+	}
+	var query = uri.Query()
+	var tenant = query.Get("tenant")
+	if len(tenant) == 0 {
+		tenant = "default"
+	}
+	var database = query.Get("database")
+	if len(database) == 0 {
+		database = "(default)"
+	}
+	var fs = &firestoreStateStore{
+		parent: fmt.Sprintf("projects/%s/databases/%s/documents/%s/%s", project, database, collection, tenant),
+	}
+	if ttl := query.Get("ttl"); len(ttl) > 0 {
+		if fs.ttl, err = time.ParseDuration(ttl); err != nil || fs.ttl < time.Hour {
+			err = fmt.Errorf("Firestore state store \"%s\": ttl must be a duration of at least 1h, e.g. \"720h\"", storeUri)
+			return
+		}
+	}
+	if fs.service, err = firestore.NewService(context.Background()); err != nil {
+		return
+	}
+	store = fs
+	return
+}
+
+// documentId encodes the state key. Document IDs cannot contain slashes
+func (fs *firestoreStateStore) documentId(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func (fs *firestoreStateStore) documentName(collection string, id string) string {
+	return fs.parent + "/" + collection + "/" + id
+}
+
+// isExpiredDocument returns true if the document outlived its TTL but was not removed by Firestore yet
+func isExpiredDocument(doc *firestore.Document) bool {
+	if v, ok := doc.Fields[firestoreExpireField]; ok && len(v.TimestampValue) > 0 {
+		if expireAt, er1 := time.Parse(time.RFC3339Nano, v.TimestampValue); er1 == nil {
+			return time.Now().After(expireAt)
+		}
+	}
+	return false
+}
+
+func isFirestoreStatus(err error, status int) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == status
+}
+
+func (fs *firestoreStateStore) Load(key string) (data []byte, err error) {
+	var doc *firestore.Document
+	if doc, err = fs.service.Projects.Databases.Documents.Get(fs.documentName("entries", fs.documentId(key))).Do(); err != nil {
+		if isFirestoreStatus(err, http.StatusNotFound) {
+			err = nil
+		}
+		return
+	}
+	if isExpiredDocument(doc) {
+		return
+	}
+	data, err = base64.StdEncoding.DecodeString(doc.Fields["data"].BytesValue)
+	return
+}
+
+func (fs *firestoreStateStore) Save(key string, data []byte) (err error) {
+	var doc = &firestore.Document{
+		Fields: map[string]firestore.Value{
+			"data": {BytesValue: base64.StdEncoding.EncodeToString(data)},
+		},
+	}
+	if fs.ttl > 0 {
+		doc.Fields[firestoreExpireField] = firestore.Value{TimestampValue: time.Now().Add(fs.ttl).UTC().Format(time.RFC3339Nano)}
+	}
+	_, err = fs.service.Projects.Databases.Documents.Patch(fs.documentName("entries", fs.documentId(key)), doc).Do()
+	return
+}
+
+func (fs *firestoreStateStore) Delete(key string) (err error) {
+	if _, err = fs.service.Projects.Databases.Documents.Delete(fs.documentName("entries", fs.documentId(key))).Do(); err != nil {
+		if isFirestoreStatus(err, http.StatusNotFound) {
+			err = nil
+		}
+	}
+	return
+}
+
+func (fs *firestoreStateStore) List(prefix string) (keys []string, err error) {
+	var pageToken string
+	for {
+		var call = fs.service.Projects.Databases.Documents.List(fs.parent, "entries").PageSize(300).MaskFieldPaths(firestoreExpireField)
+		if len(pageToken) > 0 {
+			call = call.PageToken(pageToken)
+		}
+		var rs *firestore.ListDocumentsResponse
+		if rs, err = call.Do(); err != nil {
+			return
+		}
+		for _, doc := range rs.Documents {
+			if isExpiredDocument(doc) {
+				continue
+			}
+			var id = doc.Name[strings.LastIndex(doc.Name, "/")+1:]
+			var key, er1 = base64.RawURLEncoding.DecodeString(id)
+			if er1 == nil && strings.HasPrefix(string(key), prefix) {
+				keys = append(keys, string(key))
+			}
+		}
+		if pageToken = rs.NextPageToken; len(pageToken) == 0 {
+			break
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (fs *firestoreStateStore) TryLock(name string, ttl time.Duration) (release func(), acquired bool, err error) {
+	var documents = fs.service.Projects.Databases.Documents
+	var doc = &firestore.Document{
+		Fields: map[string]firestore.Value{
+			"token":              {BytesValue: base64.StdEncoding.EncodeToString([]byte(newLockToken()))},
+			firestoreExpireField: {TimestampValue: time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)},
+		},
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		var created *firestore.Document
+		if created, err = documents.CreateDocument(fs.parent, "locks", doc).DocumentId(name).Do(); err == nil {
+			acquired = true
+			var updateTime = created.UpdateTime
+			var stop = keepLock(name, ttl, func() (held bool, er1 error) {
+				var renewal = &firestore.Document{
+					Fields: map[string]firestore.Value{
+						firestoreExpireField: {TimestampValue: time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)},
+					},
+				}
+				var renewed *firestore.Document
+				if renewed, er1 = documents.Patch(created.Name, renewal).UpdateMaskFieldPaths(firestoreExpireField).
+					CurrentDocumentUpdateTime(updateTime).Do(); er1 != nil {
+					if isFirestoreStatus(er1, http.StatusNotFound) || isFirestoreStatus(er1, http.StatusBadRequest) || isFirestoreStatus(er1, http.StatusConflict) {
+						// the lock expired and was removed or taken by another replica
+						er1 = nil
+					}
+					return
+				}
+				updateTime = renewed.UpdateTime
+				held = true
+				return
+			})
+			release = func() {
+				stop()
+				_, _ = documents.Delete(created.Name).CurrentDocumentUpdateTime(updateTime).Do()
+			}
+			return
+		}
+		if !isFirestoreStatus(err, http.StatusConflict) {
+			return
+		}
+		err = nil
+		// the lock exists. A lock of a crashed holder is removed once it expires
+		var existing *firestore.Document
+		if existing, err = documents.Get(fs.documentName("locks", name)).Do(); err != nil {
+			if isFirestoreStatus(err, http.StatusNotFound) {
+				err = nil
+				continue
+			}
+			return
+		}
+		if !isExpiredDocument(existing) {
+			return
+		}
+		if _, err = documents.Delete(existing.Name).CurrentDocumentUpdateTime(existing.UpdateTime).Do(); err != nil {
+			if isFirestoreStatus(err, http.StatusNotFound) || isFirestoreStatus(err, http.StatusBadRequest) || isFirestoreStatus(err, http.StatusConflict) {
+				// another replica removed or renewed the lock first
+				err = nil
+				continue
+			}
+			return
+		}
+	}
+	return
+}
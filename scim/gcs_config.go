@@ -0,0 +1,207 @@
+package scim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	gosync "sync"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// ConfigGcsUriVariable is the environment variable with the "gs://bucket/object" URI of the YAML configuration
+const ConfigGcsUriVariable = "CONFIG_GCS_URI"
+
+// configFileLimit limits the size of the configuration document
+const configFileLimit = 4 * 1024 * 1024
+
+// LoadConfigFromGcs downloads the YAML configuration from Google Cloud Storage with Application Default Credentials
+func LoadConfigFromGcs(uri string) (values map[string]string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(strings.TrimSpace(uri)); err != nil || !strings.EqualFold(u.Scheme, "gs") || len(u.Host) == 0 || len(u.Path) <= 1 {
+		err = fmt.Errorf("configuration URI \"%s\" must be in \"gs://bucket/object\" format", uri)
+		return
+	}
+	var ctx = context.Background()
+	var service *storage.Service
+	if service, err = storage.NewService(ctx); err != nil {
+		return
+	}
+	var object = strings.TrimPrefix(u.Path, "/")
+	var rs, er1 = service.Objects.Get(u.Host, object).Download()
+	if er1 != nil {
+		err = fmt.Errorf("download configuration \"%s\": %w", uri, er1)
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	var data []byte
+	if data, err = io.ReadAll(io.LimitReader(rs.Body, configFileLimit)); err != nil {
+		return
+	}
+	if values, err = ParseYamlConfig(data); err != nil {
+		err = fmt.Errorf("configuration \"%s\": %w", uri, err)
+	}
+	return
+}
+
+// ParseYamlConfig parses a flat YAML mapping of environment variable names to values, the format of ".env.yaml".
+// Values are plain or quoted scalars, literal ("|") or folded (">") block scalars, or lists of scalars.
+// Lists are joined with new lines, so mapping documents such as group policies can be written one entry per line
+func ParseYamlConfig(data []byte) (values map[string]string, err error) {
+	values = make(map[string]string)
+	var lines []string
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), configFileLimit)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), " \t\r"))
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	for i := 0; i < len(lines); i++ {
+		var line = lines[i]
+		var trimmed = strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			err = fmt.Errorf("line %d: unexpected indentation", i+1)
+			return
+		}
+		var key, value, ok = strings.Cut(line, ":")
+		if !ok || len(strings.TrimSpace(key)) == 0 {
+			err = fmt.Errorf("line %d: \"key: value\" expected", i+1)
+			return
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		// nested lines: block scalar content or list items
+		var nested []string
+		for i+1 < len(lines) && (len(lines[i+1]) == 0 || lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+			i++
+			nested = append(nested, lines[i])
+		}
+		switch {
+		case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			values[key] = yamlBlockScalar(value, nested)
+		case len(value) == 0 && len(nested) > 0:
+			var items []string
+			for _, n := range nested {
+				var item = strings.TrimSpace(n)
+				if len(item) == 0 || strings.HasPrefix(item, "#") {
+					continue
+				}
+				if !strings.HasPrefix(item, "- ") && item != "-" {
+					err = fmt.Errorf("key \"%s\": only scalars, block scalars and lists are supported", key)
+					return
+				}
+				var v string
+				if v, err = yamlScalar(strings.TrimSpace(strings.TrimPrefix(item, "-"))); err != nil {
+					err = fmt.Errorf("key \"%s\": %w", key, err)
+					return
+				}
+				items = append(items, v)
+			}
+			values[key] = strings.Join(items, "\n")
+		default:
+			if values[key], err = yamlScalar(value); err != nil {
+				err = fmt.Errorf("key \"%s\": %w", key, err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// yamlScalar unquotes a single-line scalar and strips its comment
+func yamlScalar(value string) (result string, err error) {
+	switch {
+	case strings.HasPrefix(value, "\""):
+		var end = strings.LastIndex(value, "\"")
+		if end == 0 {
+			err = fmt.Errorf("unterminated quoted value")
+			return
+		}
+		result, err = strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		var end = strings.LastIndex(value, "'")
+		if end == 0 {
+			err = fmt.Errorf("unterminated quoted value")
+			return
+		}
+		result = strings.ReplaceAll(value[1:end], "''", "'")
+	default:
+		if pos := strings.Index(value, " #"); pos >= 0 {
+			value = strings.TrimSpace(value[:pos])
+		}
+		result = value
+	}
+	return
+}
+
+// yamlBlockScalar joins the lines of a literal or folded block scalar with the chomping indicator of the header
+func yamlBlockScalar(header string, lines []string) string {
+	var indent = -1
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) > 0 {
+			var n = len(line) - len(strings.TrimLeft(line, " \t"))
+			if indent < 0 || n < indent {
+				indent = n
+			}
+		}
+	}
+	var content []string
+	for _, line := range lines {
+		if len(line) >= indent && indent >= 0 {
+			line = line[indent:]
+		} else {
+			line = strings.TrimSpace(line)
+		}
+		content = append(content, line)
+	}
+	for len(content) > 0 && len(content[len(content)-1]) == 0 {
+		content = content[:len(content)-1]
+	}
+	var text string
+	if strings.HasPrefix(header, ">") {
+		text = strings.Join(content, " ")
+	} else {
+		text = strings.Join(content, "\n")
+	}
+	if !strings.Contains(header, "-") && len(text) > 0 {
+		text += "\n"
+	}
+	return text
+}
+
+var gcsConfig = struct {
+	lock gosync.Mutex
+	keys Set[string]
+}{keys: NewSet[string]()}
+
+// ApplyEnvConfig sets the configuration values as environment variables. Variables set in the process environment
+// take precedence; variables set by a previous call are replaced, so a warm Cloud Function picks up configuration changes
+func ApplyEnvConfig(values map[string]string) {
+	gcsConfig.lock.Lock()
+	defer gcsConfig.lock.Unlock()
+	for _, key := range gcsConfig.keys.ToArray() {
+		if _, ok := values[key]; !ok {
+			_ = os.Unsetenv(key)
+			gcsConfig.keys.Delete(key)
+		}
+	}
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok && !gcsConfig.keys.Has(key) {
+			continue
+		}
+		if err := os.Setenv(key, value); err == nil {
+			gcsConfig.keys.Add(key)
+		}
+	}
+}
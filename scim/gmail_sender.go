@@ -0,0 +1,42 @@
+package scim
+
+import (
+	"context"
+	"encoding/base64"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+type gmailSender struct {
+	ge     *googleEndpoint
+	sender string
+}
+
+// GmailSender returns IMailSender that sends through the Gmail API as the sender with domain-wide delegation.
+// The delegated client must be allowed the "https://www.googleapis.com/auth/gmail.send" scope
+func (ge *googleEndpoint) GmailSender(sender string) (IMailSender, error) {
+	if len(sender) == 0 {
+		sender = ge.subject
+	}
+	return &gmailSender{
+		ge:     ge,
+		sender: sender,
+	}, nil
+}
+
+func (gs *gmailSender) SendMail(to string, subject string, body string) (err error) {
+	var ctx = context.Background()
+	var tokenSource oauth2.TokenSource
+	if tokenSource, err = gs.ge.newDelegatedTokenSource(ctx, []string{gmail.GmailSendScope}, gs.sender); err != nil {
+		return
+	}
+	var service *gmail.Service
+	if service, err = gmail.NewService(ctx, option.WithTokenSource(tokenSource), option.WithUserAgent(gs.ge.userAgent())); err != nil {
+		return
+	}
+	var raw = base64.URLEncoding.EncodeToString(composeMail(gs.sender, to, subject, body))
+	_, err = service.Users.Messages.Send("me", &gmail.Message{Raw: raw}).Context(ctx).Do()
+	return
+}
@@ -2,37 +2,118 @@ package scim
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"net/mail"
+	"sort"
 	"strings"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
 type googleEndpoint struct {
-	users          map[string]*User
-	groups         map[string]*Group
-	jwtCredentials []byte
-	subject        string
-	scimGroups     []string
-	logger         SyncDebugLogger
-	loadErrors     bool
+	users           map[string]*User
+	groups          map[string]*Group
+	jwtCredentials  []byte
+	impersonate     []string
+	subject         string
+	scimGroups      []string
+	logger          SyncDebugLogger
+	loadErrors      bool
+	loadFailures    []string
+	resolutions     []*EntryResolution
+	directory       DirectoryClient
+	directoryClient DirectoryClient
+	timezoneField   string
+	customerId      string
+	domain          string
+	skipNested      bool
+	maxNestedDepth  int32
+	maxNestedGroups int32
+	apiStats        *SourceApiStats
+	excludedGroups  []string
+	trace           bool
+	userAgentSuffix string
 }
 
+// defaultCustomerId refers to the Google Workspace account of the admin account
+const defaultCustomerId = "my_customer"
+
 // NewGoogleEndpoint creates an ICrmDataSource for accessing Users and Groups in Google Workspace
 // credentials: GCP service account JWT credentials
 // subject: Google Workspace admin account
 // scimGroup: Google Workspace Group that
 func NewGoogleEndpoint(credentials []byte, subject string, scimGroups []string) ICrmDataSource {
+	registerCredentialSecrets(credentials)
 	return &googleEndpoint{
 		jwtCredentials: credentials,
 		subject:        subject,
 		scimGroups:     scimGroups,
 	}
 }
+
+// NewGoogleEndpointWithParameters creates an ICrmDataSource for accessing Users and Groups in Google Workspace
+// parameters: Google Workspace connection and resolution parameters
+func NewGoogleEndpointWithParameters(parameters *GoogleEndpointParameters) ICrmDataSource {
+	registerCredentialSecrets(parameters.Credentials)
+	return &googleEndpoint{
+		jwtCredentials:  parameters.Credentials,
+		impersonate:     parameters.ImpersonateServiceAccount,
+		subject:         parameters.AdminAccount,
+		scimGroups:      parameters.ScimGroups,
+		timezoneField:   parameters.TimezoneField,
+		customerId:      parameters.CustomerId,
+		domain:          parameters.Domain,
+		skipNested:      parameters.SkipNestedGroups,
+		maxNestedDepth:  parameters.MaxNestedDepth,
+		maxNestedGroups: parameters.MaxNestedGroups,
+		excludedGroups:  parameters.ExcludedGroups,
+		trace:           parameters.Trace,
+	}
+}
+
+// NewGoogleEndpointWithDirectory creates an ICrmDataSource that reads Google Workspace through the directory client
+// instead of the Admin SDK Directory service built from the credentials.
+// parameters: resolution parameters; credentials are used only by push notifications
+// directory: Google Directory client
+func NewGoogleEndpointWithDirectory(parameters *GoogleEndpointParameters, directory DirectoryClient) ICrmDataSource {
+	var ge = NewGoogleEndpointWithParameters(parameters).(*googleEndpoint)
+	ge.directoryClient = directory
+	return ge
+}
+
+// Default limits of the nested group expansion
+const (
+	defaultMaxNestedDepth  = 20
+	defaultMaxNestedGroups = 5000
+)
+
+// nestedGroupCycle returns the cycle path if the member group is an ancestor of the group in the expansion tree
+func nestedGroupCycle(memberId string, groupId string, parents map[string]string) (path []string) {
+	var chain = []string{groupId}
+	var id = groupId
+	for id != memberId {
+		var parentId, ok = parents[id]
+		if !ok {
+			return nil
+		}
+		chain = append(chain, parentId)
+		id = parentId
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		path = append(path, chain[i])
+	}
+	path = append(path, memberId)
+	return
+}
+
 func (ge *googleEndpoint) DebugLogger() SyncDebugLogger {
 	if ge.logger != nil {
 		return ge.logger
@@ -46,30 +127,78 @@ func (ge *googleEndpoint) SetDebugLogger(logger SyncDebugLogger) {
 	} else {
 	}
 }
+
+// userAgent identifies the client in Google API requests. The suffix is set by NewSync
+func (ge *googleEndpoint) userAgent() string {
+	if len(ge.userAgentSuffix) > 0 {
+		return userAgentWithSuffix(ge.userAgentSuffix)
+	}
+	return UserAgent()
+}
+
+// listRequest creates a users or groups listing request scoped to the configured customer or domain
+func (ge *googleEndpoint) listRequest(query string) *DirectoryListRequest {
+	var request = &DirectoryListRequest{
+		Query: query,
+	}
+	if len(ge.domain) > 0 {
+		request.Domain = ge.domain
+	} else if len(ge.customerId) > 0 {
+		request.Customer = ge.customerId
+	} else {
+		request.Customer = defaultCustomerId
+	}
+	return request
+}
+
 func (ge *googleEndpoint) LoadErrors() bool {
 	return ge.loadErrors
 }
+func (ge *googleEndpoint) Resolutions() []*EntryResolution {
+	return ge.resolutions
+}
+func (ge *googleEndpoint) LoadFailures() []string {
+	return ge.loadFailures
+}
+
+// loadFailure marks the populate as incomplete and records the reason
+func (ge *googleEndpoint) loadFailure(message string) {
+	ge.DebugLogger()(message)
+	ge.loadErrors = true
+	ge.loadFailures = append(ge.loadFailures, message)
+}
+
+// Users enumerates users ordered by email
 func (ge *googleEndpoint) Users(cb func(*User)) {
 	if ge.users != nil {
-		for _, v := range ge.users {
+		for _, v := range sortedValues(ge.users, userSortKey) {
 			cb(v)
 		}
 	}
 }
 
+// Groups enumerates groups ordered by name
 func (ge *googleEndpoint) Groups(cb func(*Group)) {
 	if ge.users != nil {
-		for _, v := range ge.groups {
+		for _, v := range sortedValues(ge.groups, groupSortKey) {
 			cb(v)
 		}
 	}
 }
 
-func parseGoogleUser(gu *admin.User) (su *User) {
+func (ge *googleEndpoint) parseGoogleUser(gu *admin.User) (su *User) {
 	su = &User{
 		Id:     gu.Id,
 		Email:  gu.PrimaryEmail,
 		Active: !gu.Suspended,
+		State:  UserStateActive,
+
+		SchemaVersion: ModelVersion,
+	}
+	if gu.Archived {
+		su.State = UserStateArchived
+	} else if gu.Suspended {
+		su.State = UserStateSuspended
 	}
 	if gu.Name != nil {
 		su.FirstName = gu.Name.GivenName
@@ -80,20 +209,136 @@ func parseGoogleUser(gu *admin.User) (su *User) {
 			su.FullName = strings.TrimSpace(strings.Join([]string{gu.Name.GivenName, gu.Name.FamilyName}, " "))
 		}
 	}
+	if len(gu.ThumbnailPhotoUrl) > 0 {
+		su.PhotoEtag = gu.ThumbnailPhotoEtag
+	}
+	if externalIds, ok := gu.ExternalIds.([]any); ok {
+		for _, e := range externalIds {
+			if externalId, ok := e.(map[string]any); ok {
+				if et, _ := toString(externalId["type"]); et == "organization" {
+					su.EmployeeId, _ = toString(externalId["value"])
+					break
+				}
+			}
+		}
+	}
+	su.PreferredLanguage = parseGoogleLanguage(gu.Languages)
+	su.Locale = su.PreferredLanguage
+	if len(ge.timezoneField) > 0 {
+		su.Timezone = parseGoogleCustomField(gu.CustomSchemas, ge.timezoneField)
+	}
+	googleUserAttributes(su, gu.OrgUnitPath, gu.IsAdmin, gu.Organizations, gu.CustomSchemas)
+	su.PhoneNumbers = parseGooglePhones(gu.Phones)
+	su.Addresses = parseGoogleAddresses(gu.Addresses)
+	if relations, ok := gu.Relations.([]any); ok {
+		for _, r := range relations {
+			if relation, ok := r.(map[string]any); ok {
+				if rt, _ := toString(relation["type"]); rt == "manager" {
+					su.Manager, _ = toString(relation["value"])
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// UserPhoto fetches the profile photo of the Google Workspace user
+func (ge *googleEndpoint) UserPhoto(user *User) (mimeType string, data []byte, err error) {
+	if ge.directory == nil {
+		err = errors.New("google endpoint is not populated")
+		return
+	}
+	var photo *admin.UserPhoto
+	if photo, err = ge.directory.GetUserPhoto(context.Background(), user.Id); err != nil {
+		return
+	}
+	mimeType = photo.MimeType
+	if data, err = base64.URLEncoding.DecodeString(photo.PhotoData); err != nil {
+		data, err = base64.RawURLEncoding.DecodeString(photo.PhotoData)
+	}
+	return
+}
+
+// newDirectoryClient returns the injected directory client, or the client of the Admin SDK Directory service
+func (ge *googleEndpoint) newDirectoryClient(ctx context.Context) (directory DirectoryClient, err error) {
+	if ge.directoryClient != nil {
+		directory = ge.directoryClient
+		return
+	}
+	var service *admin.Service
+	if service, err = ge.newDirectoryService(ctx); err != nil {
+		return
+	}
+	directory = NewDirectoryClient(service)
+	return
+}
+
+func (ge *googleEndpoint) newDirectoryService(ctx context.Context) (directory *admin.Service, err error) {
+	if ge.trace {
+		// token and API requests share the tracing transport
+		var client = &http.Client{
+			Transport: newTraceTransport(http.DefaultTransport, "Google"),
+		}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	var tokenSource oauth2.TokenSource
+	if tokenSource, err = ge.newTokenSource(ctx); err != nil {
+		return
+	}
+	if !ge.trace {
+		directory, err = admin.NewService(ctx, option.WithTokenSource(tokenSource), option.WithUserAgent(ge.userAgent()))
+		return
+	}
+	directory, err = admin.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, tokenSource)), option.WithUserAgent(ge.userAgent()))
+	return
+}
+
+// newTokenSource creates the domain-wide delegation token source of the admin account.
+// With an impersonation chain, the delegated service account key is not needed: the credentials sign the delegation JWT through the IAM Credentials API
+func (ge *googleEndpoint) newTokenSource(ctx context.Context) (tokenSource oauth2.TokenSource, err error) {
+	return ge.newScopedTokenSource(ctx, []string{admin.AdminDirectoryUserReadonlyScope,
+		admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryGroupMemberReadonlyScope})
+}
+
+// newScopedTokenSource creates the domain-wide delegation token source of the admin account with the OAuth scopes
+func (ge *googleEndpoint) newScopedTokenSource(ctx context.Context, scopes []string) (tokenSource oauth2.TokenSource, err error) {
+	return ge.newDelegatedTokenSource(ctx, scopes, ge.subject)
+}
+
+// newDelegatedTokenSource creates the domain-wide delegation token source of the subject with the OAuth scopes
+func (ge *googleEndpoint) newDelegatedTokenSource(ctx context.Context, scopes []string, subject string) (tokenSource oauth2.TokenSource, err error) {
+	if len(ge.impersonate) == 0 {
+		var cred *google.Credentials
+		if cred, err = google.CredentialsFromJSONWithParams(ctx, ge.jwtCredentials, google.CredentialsParams{
+			Scopes:  scopes,
+			Subject: subject,
+		}); err != nil {
+			return
+		}
+		tokenSource = cred.TokenSource
+		return
+	}
+	var opts []option.ClientOption
+	if len(ge.jwtCredentials) > 0 {
+		opts = append(opts, option.WithCredentialsJSON(ge.jwtCredentials))
+	}
+	var last = len(ge.impersonate) - 1
+	if tokenSource, err = impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: ge.impersonate[last],
+		Delegates:       ge.impersonate[:last],
+		Scopes:          scopes,
+		Subject:         subject,
+	}, opts...); err != nil {
+		err = fmt.Errorf("impersonate service account \"%s\": %w", ge.impersonate[last], err)
+	}
 	return
 }
 
 // TestConnection verifies that the credentials and subject are valid by making a minimal API call
 func (ge *googleEndpoint) TestConnection() (err error) {
-	params := google.CredentialsParams{
-		Scopes: []string{admin.AdminDirectoryUserReadonlyScope,
-			admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryGroupMemberReadonlyScope},
-		Subject: ge.subject,
-	}
 	var ctx = context.Background()
-	cred, _ := google.CredentialsFromJSONWithParams(ctx, ge.jwtCredentials, params)
-
-	directory, err := admin.NewService(ctx, option.WithCredentials(cred))
+	directory, err := ge.newDirectoryClient(ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to create Google Directory service: %w", err)
 		ge.DebugLogger()(err.Error())
@@ -101,7 +346,9 @@ func (ge *googleEndpoint) TestConnection() (err error) {
 	}
 
 	// Make a minimal API call to verify credentials work
-	_, err = directory.Users.List().Customer("my_customer").MaxResults(1).Do()
+	var request = ge.listRequest("")
+	request.MaxResults = 1
+	_, err = directory.ListUsers(ctx, request)
 	if err != nil {
 		err = fmt.Errorf("failed to connect to Google Workspace API: %w", err)
 		ge.DebugLogger()(err.Error())
@@ -114,17 +361,15 @@ func (ge *googleEndpoint) TestConnection() (err error) {
 
 func (ge *googleEndpoint) Populate() (err error) {
 	ge.loadErrors = false
-	params := google.CredentialsParams{
-		Scopes: []string{admin.AdminDirectoryUserReadonlyScope,
-			admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryGroupMemberReadonlyScope},
-		Subject: ge.subject,
-	}
+	ge.loadFailures = nil
+	ge.resolutions = nil
+	ge.apiStats = &SourceApiStats{}
 	var ctx = context.Background()
-	cred, _ := google.CredentialsFromJSONWithParams(ctx, ge.jwtCredentials, params)
-	var directory *admin.Service
-	if directory, err = admin.NewService(ctx, option.WithCredentials(cred)); err != nil {
+	var directory DirectoryClient
+	if directory, err = ge.newDirectoryClient(ctx); err != nil {
 		return
 	}
+	ge.directory = directory
 
 	var scimGroups = NewSet[string]()
 	for _, x := range ge.scimGroups {
@@ -157,48 +402,112 @@ func (ge *googleEndpoint) Populate() (err error) {
 	ge.DebugLogger()("Resolving \"SCIM Group\" content")
 	var users *admin.Users
 	var groups *admin.Groups
+	var allGroups []*admin.Group
 	for entry := range scimGroups {
+		var resolution = &EntryResolution{
+			Entry: entry,
+			Kind:  ResolvedNothing,
+		}
+		ge.resolutions = append(ge.resolutions, resolution)
+		var resolveGroup = func(g *admin.Group) {
+			resolution.Kind = ResolvedGroup
+			resolution.Matches = append(resolution.Matches, fmt.Sprintf("\"%s\" <%s>", g.Name, g.Email))
+			var group = &Group{
+				Id:   g.Id,
+				Name: g.Name,
+
+				SchemaVersion: ModelVersion,
+			}
+			group.SetAttribute(SourceAttributeEmail, g.Email)
+			if len(g.Description) > 0 {
+				group.SetAttribute(SourceAttributeDescription, g.Description)
+			}
+			ge.groups[g.Id] = group
+		}
+		if isGroupPattern(entry) {
+			var pattern *groupPattern
+			if pattern, err = parseGroupPattern(entry); err != nil {
+				return
+			}
+			if allGroups == nil {
+				if allGroups, err = ge.listAllGroups(ctx, directory); err != nil {
+					err = googleApiError("querying groups", err)
+					return
+				}
+			}
+			var found = 0
+			for _, g := range allGroups {
+				if pattern.matches(g) {
+					ge.DebugLogger()(fmt.Sprintf("Found Google group \"%s\" for pattern \"%s\"", g.Name, entry))
+					resolveGroup(g)
+					found++
+				}
+			}
+			if found == 0 {
+				ge.loadFailure(fmt.Sprintf("A pattern \"%s\" does not match any Google Group", entry))
+			}
+			continue
+		}
 		var address *mail.Address
 		if address, err = mail.ParseAddress(entry); err == nil {
-			var gl = directory.Groups.List().Customer("my_customer").Query(fmt.Sprintf("email=%s", address.Address))
-			if groups, err = gl.Do(); err == nil && len(groups.Groups) > 0 {
+			groups, err = directory.ListGroups(ctx, ge.listRequest(fmt.Sprintf("email=%s", address.Address)))
+			ge.recordApiCall(err)
+			var groupErr = err
+			if err == nil && len(groups.Groups) > 0 {
 				for _, g := range groups.Groups {
 					ge.DebugLogger()(fmt.Sprintf("Found Google group \"%s\" for email \"%s\"", g.Name, g.Email))
-					ge.groups[g.Id] = &Group{
-						Id:   g.Id,
-						Name: g.Name,
-					}
+					resolveGroup(g)
 				}
 			} else {
-				var ul = directory.Users.List().Customer("my_customer").Query(fmt.Sprintf("email=%s", address.Address))
-				if users, err = ul.Do(); err == nil && len(users.Users) > 0 {
+				var ul = ge.listRequest(fmt.Sprintf("email=%s", address.Address))
+				if len(ge.timezoneField) > 0 {
+					ul.Projection = "full"
+				}
+				users, err = directory.ListUsers(ctx, ul)
+				ge.recordApiCall(err)
+				if err == nil && len(users.Users) > 0 {
 					for _, u := range users.Users {
 						ge.DebugLogger()(fmt.Sprintf("Found Google user for email \"%s\"", u.PrimaryEmail))
-						var su = parseGoogleUser(u)
+						resolution.Kind = ResolvedUser
+						resolution.Matches = append(resolution.Matches, u.PrimaryEmail)
+						var su = ge.parseGoogleUser(u)
 						ge.users[su.Id] = su
 					}
 				} else {
-					ge.DebugLogger()(fmt.Sprintf("An email \"%s\" could not be resolved as either Google User or Group", address.Address))
-					ge.loadErrors = true
+					var message = fmt.Sprintf("An email \"%s\" could not be resolved as either Google User or Group", address.Address)
+					if err == nil {
+						err = groupErr
+					}
+					if err != nil {
+						resolution.Error = describeGoogleError(err)
+						message += ": " + resolution.Error
+					}
+					ge.loadFailure(message)
 				}
 			}
 		} else {
-			var gl = directory.Groups.List().Customer("my_customer").Query(fmt.Sprintf("name='%s'", entry))
-			if groups, err = gl.Do(); err == nil && len(groups.Groups) > 0 {
+			groups, err = directory.ListGroups(ctx, ge.listRequest(fmt.Sprintf("name='%s'", entry)))
+			ge.recordApiCall(err)
+			if err == nil && len(groups.Groups) > 0 {
 				for _, g := range groups.Groups {
 					ge.DebugLogger()(fmt.Sprintf("Found Google group \"%s\" by name", g.Name))
-					ge.groups[g.Id] = &Group{
-						Id:   g.Id,
-						Name: g.Name,
-					}
+					resolveGroup(g)
 				}
 			} else {
-				ge.DebugLogger()(fmt.Sprintf("A name \"%s\" could not be resolved to Google Group. Names are case sensitive", entry))
-				ge.loadErrors = true
+				var message = fmt.Sprintf("A name \"%s\" could not be resolved to Google Group. Names are case sensitive", entry)
+				if err != nil {
+					resolution.Error = describeGoogleError(err)
+					message += ": " + resolution.Error
+				}
+				ge.loadFailure(message)
 			}
 		}
 	}
 
+	sort.Slice(ge.resolutions, func(i, j int) bool {
+		return ge.resolutions[i].Entry < ge.resolutions[j].Entry
+	})
+
 	if len(ge.groups) == 0 && len(ge.users) == 0 {
 		err = errors.New("no Google Workspace groups could be resolved")
 		return
@@ -206,27 +515,63 @@ func (ge *googleEndpoint) Populate() (err error) {
 
 	ge.DebugLogger()("Loading all users")
 	var userLookup = make(map[string]*User)
-	if err = directory.Users.List().Customer("my_customer").MaxResults(200).Pages(ctx, func(users *admin.Users) error {
+	var userList = ge.listRequest("")
+	userList.MaxResults = 200
+	if len(ge.timezoneField) > 0 {
+		userList.Projection = "full"
+	}
+	if err = ge.loadPages("users", func(pageToken string) (nextPageToken string, er1 error) {
+		var users *admin.Users
+		userList.PageToken = pageToken
+		if users, er1 = directory.ListUsers(ctx, userList); er1 != nil {
+			return
+		}
 		var no = 0
 		for _, u := range users.Users {
-			var su = parseGoogleUser(u)
+			var su = ge.parseGoogleUser(u)
 			userLookup[su.Id] = su
 			no++
 		}
 		ge.DebugLogger()(fmt.Sprintf("User page contains %d element(s)", no))
-		return nil
+		nextPageToken = users.NextPageToken
+		return
 	}); err != nil {
-		err = errors.New("google directory API: error querying users")
+		err = googleApiError("querying users", err)
 		return
 	}
 	ge.DebugLogger()(fmt.Sprintf("Total %d Google user(s) loaded", len(userLookup)))
 
+	var excluded []*groupPattern
+	for _, entry := range ge.excludedGroups {
+		var pattern *groupPattern
+		if pattern, err = parseExcludedGroup(entry); err != nil {
+			return
+		}
+		excluded = append(excluded, pattern)
+	}
+
+	var maxDepth = ge.maxNestedDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxNestedDepth
+	}
+	var maxGroups = ge.maxNestedGroups
+	if maxGroups <= 0 {
+		maxGroups = defaultMaxNestedGroups
+	}
+
 	var ok bool
 	// expand embedded groups
 	var membershipCache = make(map[string][]string)
-	for groupId, group := range ge.groups {
+	var groupEmails = make(map[string]string)
+	var reportedCycles = NewSet[string]()
+	var throttle = newQuotaThrottle()
+	for _, group := range sortedValues(ge.groups, groupSortKey) {
+		var groupId = group.Id
 		var groupIds = []string{groupId}
 		var queuedIds = MakeSet[string](groupIds)
+		var parents = make(map[string]string)
+		var depths = map[string]int32{groupId: 0}
+		var limitReason string
 		var pos = 0
 		for pos < len(groupIds) {
 			var gId = groupIds[pos]
@@ -234,13 +579,26 @@ func (ge *googleEndpoint) Populate() (err error) {
 
 			var memberIds []string
 			if memberIds, ok = membershipCache[gId]; !ok {
-				if err = directory.Members.List(gId).Pages(ctx, func(members *admin.Members) error {
+				if err = ge.loadThrottledPages("group members", throttle, func(pageToken string, pageSize int64) (nextPageToken string, er1 error) {
+					var members *admin.Members
+					if members, er1 = directory.ListMembers(ctx, gId, &DirectoryListRequest{PageToken: pageToken, MaxResults: pageSize}); er1 != nil {
+						return
+					}
 					for _, m := range members.Members {
 						memberIds = append(memberIds, m.Id)
+						if m.Type == "GROUP" {
+							groupEmails[m.Id] = m.Email
+						}
 					}
-					return nil
+					nextPageToken = members.NextPageToken
+					return
 				}); err != nil {
-					ge.DebugLogger()(fmt.Sprintf("Loaded group \"%s\" membership failed: %s", group.Name, err.Error()))
+					if classifyGoogleError(err) == GoogleErrorQuota {
+						// partial membership must not drive membership removals
+						ge.loadFailure(fmt.Sprintf("Membership of group \"%s\" is incomplete: %s", group.Name, describeGoogleError(err)))
+					} else {
+						ge.DebugLogger()(fmt.Sprintf("Loaded group \"%s\" membership failed: %s", group.Name, describeGoogleError(err)))
+					}
 				}
 				membershipCache[gId] = memberIds
 			}
@@ -253,12 +611,53 @@ func (ge *googleEndpoint) Populate() (err error) {
 					}
 				} else {
 					if !queuedIds.Has(mId) {
-						groupIds = append(groupIds, mId)
 						queuedIds.Add(mId)
+						if ge.skipNested {
+							continue
+						}
+						if isExcludedGroup(groupEmails[mId], excluded) {
+							ge.DebugLogger()(fmt.Sprintf("Nested group \"%s\" of group \"%s\" is excluded from expansion", groupEmails[mId], group.Name))
+							continue
+						}
+						if depths[gId]+1 > maxDepth {
+							if len(limitReason) == 0 {
+								limitReason = fmt.Sprintf("nesting depth exceeds %d at group \"%s\"", maxDepth, groupEmails[mId])
+							}
+							continue
+						}
+						if int32(len(groupIds)) >= maxGroups {
+							if len(limitReason) == 0 {
+								limitReason = fmt.Sprintf("more than %d nested groups", maxGroups)
+							}
+							continue
+						}
+						parents[mId] = gId
+						depths[mId] = depths[gId] + 1
+						groupIds = append(groupIds, mId)
+					} else if path := nestedGroupCycle(mId, gId, parents); path != nil {
+						var names = make([]string, 0, len(path))
+						for _, id := range path {
+							if email, found := groupEmails[id]; found {
+								names = append(names, email)
+							} else if id == groupId {
+								names = append(names, group.Name)
+							} else {
+								names = append(names, id)
+							}
+						}
+						var cycle = strings.Join(names, " -> ")
+						if !reportedCycles.Has(cycle) {
+							reportedCycles.Add(cycle)
+							log.Printf("Nested group cycle detected in group \"%s\": %s", group.Name, cycle)
+						}
 					}
 				}
 			}
 		}
+		ge.DebugLogger()(fmt.Sprintf("Group \"%s\" expanded to %d group(s)", group.Name, len(groupIds)))
+		if len(limitReason) > 0 {
+			ge.loadFailure(fmt.Sprintf("Nested group expansion of group \"%s\" is incomplete: %s", group.Name, limitReason))
+		}
 	}
 
 	return
@@ -0,0 +1,128 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Classes of Google Admin SDK errors
+const (
+	GoogleErrorQuota      = "quota"
+	GoogleErrorPermission = "permission"
+	GoogleErrorNotFound   = "notFound"
+	GoogleErrorInvalid    = "invalid"
+	GoogleErrorServer     = "server"
+	GoogleErrorTransport  = "transport"
+	GoogleErrorOther      = "other"
+)
+
+// SourceApiStats are counters of data source API calls made by Populate
+type SourceApiStats struct {
+	Requests int `json:"requests"`
+	Retries  int `json:"retries"`
+	// Throttled counts quota errors that reduced the page size of a listing
+	Throttled int `json:"throttled,omitempty"`
+	// Errors are failed requests by error class, retried requests included
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+func (sas *SourceApiStats) String() string {
+	var text = fmt.Sprintf("%d request(s), %d retried", sas.Requests, sas.Retries)
+	if sas.Throttled > 0 {
+		text += fmt.Sprintf(", %d throttled", sas.Throttled)
+	}
+	if len(sas.Errors) > 0 {
+		var classes []string
+		for class := range sas.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		var counts []string
+		for _, class := range classes {
+			counts = append(counts, fmt.Sprintf("%s=%d", class, sas.Errors[class]))
+		}
+		text += ", errors: " + strings.Join(counts, ", ")
+	}
+	return text
+}
+
+// ISourceApiStatsSource is implemented by data sources that count their API calls
+type ISourceApiStatsSource interface {
+	ApiStats() *SourceApiStats
+}
+
+// classifyGoogleError maps a Google Admin SDK error to an error class
+func classifyGoogleError(err error) string {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return GoogleErrorTransport
+	}
+	for _, item := range gErr.Errors {
+		switch item.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded", "dailyLimitExceeded":
+			return GoogleErrorQuota
+		}
+	}
+	switch {
+	case gErr.Code == http.StatusTooManyRequests:
+		return GoogleErrorQuota
+	case gErr.Code == http.StatusUnauthorized || gErr.Code == http.StatusForbidden:
+		return GoogleErrorPermission
+	case gErr.Code == http.StatusNotFound:
+		return GoogleErrorNotFound
+	case gErr.Code == http.StatusBadRequest || gErr.Code == http.StatusPreconditionFailed:
+		return GoogleErrorInvalid
+	case gErr.Code >= 500:
+		return GoogleErrorServer
+	}
+	return GoogleErrorOther
+}
+
+// describeGoogleError formats the error class, status code, reason and message of a Google Admin SDK error
+func describeGoogleError(err error) string {
+	var class = classifyGoogleError(err)
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return fmt.Sprintf("%s: %s", class, err.Error())
+	}
+	var message = gErr.Message
+	var reason string
+	if len(gErr.Errors) > 0 {
+		reason = gErr.Errors[0].Reason
+		if len(message) == 0 {
+			message = gErr.Errors[0].Message
+		}
+	}
+	if len(reason) > 0 {
+		return fmt.Sprintf("%s: %d %s: %s", class, gErr.Code, reason, message)
+	}
+	return fmt.Sprintf("%s: %d %s", class, gErr.Code, message)
+}
+
+// googleApiError wraps a failed Google Admin SDK call with its classified description
+func googleApiError(operation string, err error) error {
+	return fmt.Errorf("google directory API: error %s (%s): %w", operation, classifyGoogleError(err), err)
+}
+
+// recordApiCall counts a Google Admin SDK call and classifies its error
+func (ge *googleEndpoint) recordApiCall(err error) {
+	if ge.apiStats == nil {
+		ge.apiStats = &SourceApiStats{}
+	}
+	ge.apiStats.Requests++
+	if err != nil {
+		if ge.apiStats.Errors == nil {
+			ge.apiStats.Errors = make(map[string]int)
+		}
+		ge.apiStats.Errors[classifyGoogleError(err)]++
+	}
+}
+
+func (ge *googleEndpoint) ApiStats() *SourceApiStats {
+	return ge.apiStats
+}
@@ -0,0 +1,121 @@
+package scim
+
+import (
+	"fmt"
+	"time"
+)
+
+// Page sizes of Members.List. The Directory API returns at most 200 members per page
+const (
+	googleMembersPageSize    = 200
+	googleMembersMinPageSize = 25
+)
+
+// googleQuotaAttempts is the number of attempts to load a page that fails with quota errors.
+// Every quota error reduces the page size and lengthens the pause between pages
+const googleQuotaAttempts = 10
+
+// googleQuotaMaxPause caps the pause between pages
+const googleQuotaMaxPause = 30 * time.Second
+
+// googleQuotaRecovery is the number of consecutive successful pages after which the throttle is relaxed
+const googleQuotaRecovery = 5
+
+// quotaThrottle adapts the page size and the pause between pages of a Directory API listing to quota errors.
+// The throttle is shared by all listings of a populate, so the reduced rate carries over to the next group
+type quotaThrottle struct {
+	pageSize  int64
+	pause     time.Duration
+	successes int
+}
+
+func newQuotaThrottle() *quotaThrottle {
+	return &quotaThrottle{
+		pageSize: googleMembersPageSize,
+	}
+}
+
+// throttle halves the page size and doubles the pause after a quota error
+func (qt *quotaThrottle) throttle() {
+	qt.successes = 0
+	qt.pageSize /= 2
+	if qt.pageSize < googleMembersMinPageSize {
+		qt.pageSize = googleMembersMinPageSize
+	}
+	if qt.pause == 0 {
+		qt.pause = time.Second
+	} else {
+		qt.pause *= 2
+	}
+	if qt.pause > googleQuotaMaxPause {
+		qt.pause = googleQuotaMaxPause
+	}
+}
+
+// succeeded relaxes the throttle step by step once the listing runs without quota errors
+func (qt *quotaThrottle) succeeded() {
+	if qt.pause == 0 && qt.pageSize >= googleMembersPageSize {
+		return
+	}
+	qt.successes++
+	if qt.successes < googleQuotaRecovery {
+		return
+	}
+	qt.successes = 0
+	qt.pageSize *= 2
+	if qt.pageSize > googleMembersPageSize {
+		qt.pageSize = googleMembersPageSize
+	}
+	qt.pause /= 2
+	if qt.pause < 250*time.Millisecond {
+		qt.pause = 0
+	}
+}
+
+// loadThrottledPages loads all pages of a Google Directory list call like loadPages.
+// Quota errors do not abort the listing: the page is retried with a smaller page size, and the following pages
+// are loaded with a pause, until the quota recovers
+// loadPage: loads the page of at most pageSize items and returns the next page token
+func (ge *googleEndpoint) loadThrottledPages(name string, qt *quotaThrottle, loadPage func(pageToken string, pageSize int64) (string, error)) (err error) {
+	var pageToken string
+	for {
+		var nextPageToken string
+		var attempts, quotaAttempts int
+		for {
+			if qt.pause > 0 {
+				time.Sleep(qt.pause)
+			}
+			nextPageToken, err = loadPage(pageToken, qt.pageSize)
+			ge.recordApiCall(err)
+			if err == nil {
+				qt.succeeded()
+				break
+			}
+			var delay time.Duration
+			if classifyGoogleError(err) == GoogleErrorQuota {
+				quotaAttempts++
+				if quotaAttempts >= googleQuotaAttempts {
+					return
+				}
+				qt.throttle()
+				ge.apiStats.Throttled++
+				delay = googleRetryDelay(err, 0)
+				ge.DebugLogger()(fmt.Sprintf("Loading %s page exceeded the quota: %s. Page size reduced to %d, pause between pages %s",
+					name, describeGoogleError(err), qt.pageSize, qt.pause))
+			} else {
+				attempts++
+				if attempts >= googlePageAttempts || !isRetryableGoogleError(err) {
+					return
+				}
+				delay = googleRetryDelay(err, attempts-1)
+				ge.DebugLogger()(fmt.Sprintf("Loading %s page failed: %s. Retrying in %s", name, describeGoogleError(err), delay))
+			}
+			ge.apiStats.Retries++
+			time.Sleep(delay)
+		}
+		if len(nextPageToken) == 0 {
+			return
+		}
+		pageToken = nextPageToken
+	}
+}
@@ -0,0 +1,84 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// googlePageAttempts is the number of attempts to load a single page of Google Directory results
+const googlePageAttempts = 5
+
+// googleMaxBackoff caps the delay between attempts
+const googleMaxBackoff = 60 * time.Second
+
+// isRetryableGoogleError returns true for rate limits, server errors and transport errors
+func isRetryableGoogleError(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return true
+	}
+	switch gErr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		for _, item := range gErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" || item.Reason == "quotaExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// googleRetryDelay returns the server requested delay or exponential backoff
+func googleRetryDelay(err error, attempt int) (delay time.Duration) {
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) && gErr.Header != nil {
+		if seconds, er1 := strconv.Atoi(gErr.Header.Get("Retry-After")); er1 == nil && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		} else if at, er1 := http.ParseTime(gErr.Header.Get("Retry-After")); er1 == nil {
+			delay = time.Until(at)
+		}
+	}
+	if delay <= 0 {
+		delay = time.Second << attempt
+	}
+	if delay > googleMaxBackoff {
+		delay = googleMaxBackoff
+	}
+	return
+}
+
+// loadPages loads all pages of a Google Directory list call.
+// A failed page is retried after backoff and the pagination resumes from the last successful page token
+// loadPage: loads the page and returns the next page token
+func (ge *googleEndpoint) loadPages(name string, loadPage func(pageToken string) (string, error)) (err error) {
+	var pageToken string
+	for {
+		var nextPageToken string
+		for attempt := 0; ; attempt++ {
+			nextPageToken, err = loadPage(pageToken)
+			ge.recordApiCall(err)
+			if err == nil {
+				break
+			}
+			if attempt+1 >= googlePageAttempts || !isRetryableGoogleError(err) {
+				return
+			}
+			ge.apiStats.Retries++
+			var delay = googleRetryDelay(err, attempt)
+			ge.DebugLogger()(fmt.Sprintf("Loading %s page failed: %s. Retrying in %s", name, describeGoogleError(err), delay))
+			time.Sleep(delay)
+		}
+		if len(nextPageToken) == 0 {
+			return
+		}
+		pageToken = nextPageToken
+	}
+}
@@ -0,0 +1,70 @@
+package scim
+
+import (
+	"fmt"
+)
+
+// createMembersValue returns the "members" attribute of the POST payload of a new group:
+// the existing SCIM users whose source user is a member of the source group
+func (s *sync) createMembersValue(group *Group) (values []any) {
+	if !s.createWithMembers || s.createMembersDenied || !s.phaseEnabled(SyncPhaseMembership) {
+		return
+	}
+	var keeperUserLookup = make(map[string]*scimUser)
+	for _, v := range s.scimUsers {
+		keeperUserLookup[foldEmail(v.UserName)] = v
+	}
+	var members = make(map[string]*scimUser)
+	s.source.Users(func(user *User) {
+		var userName = s.userName(user)
+		if len(userName) == 0 || !s.inScopeUser(user) || !s.inCanaryUser(user) {
+			return
+		}
+		var keeperUser, ok = keeperUserLookup[foldEmail(userName)]
+		if !ok {
+			return
+		}
+		for _, groupId := range user.Groups {
+			if groupId == group.Id {
+				members[keeperUser.Id] = keeperUser
+				break
+			}
+		}
+	})
+	for _, keeperUser := range sortedValues(members, scimUserSortKey) {
+		values = append(values, map[string]any{"value": keeperUser.Id})
+	}
+	return
+}
+
+// applyCreatedMembers adds the created group to the SCIM users listed in the "members" attribute of the POST response,
+// so the membership phase does not add them again. Members are added by the membership phase if the response omits them
+func (s *sync) applyCreatedMembers(sg *scimGroup, response map[string]any) (count int) {
+	var members, ok = response["members"].([]any)
+	if !ok {
+		return
+	}
+	for _, j := range members {
+		var jo map[string]any
+		if jo, ok = j.(map[string]any); !ok {
+			continue
+		}
+		var userId string
+		if userId, ok = toString(jo["value"]); !ok {
+			continue
+		}
+		if keeperUser, ok := s.scimUsers[userId]; ok {
+			keeperUser.Groups = append(keeperUser.Groups, sg.Id)
+			count++
+		}
+	}
+	return
+}
+
+// describeCreatedGroup is the success message of the group creation
+func describeCreatedGroup(name string, members int) string {
+	if members > 0 {
+		return fmt.Sprintf("SCIM added group \"%s\" with %d member(s)", name, members)
+	}
+	return fmt.Sprintf("SCIM added group \"%s\"", name)
+}
@@ -0,0 +1,96 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+)
+
+// groupMembersByName returns the folded user names of the source members of every source group
+func (s *sync) groupMembersByName(groups []*Group) (members map[string]Set[string]) {
+	members = make(map[string]Set[string])
+	for _, group := range groups {
+		members[group.Id] = NewSet[string]()
+	}
+	s.source.Users(func(user *User) {
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			return
+		}
+		for _, groupId := range user.Groups {
+			if set, ok := members[groupId]; ok {
+				set.Add(foldEmail(userName))
+			}
+		}
+	})
+	return
+}
+
+// scimGroupMembersByName returns the folded user names of the SCIM members of every SCIM group
+func (s *sync) scimGroupMembersByName(scimGroups []*scimGroup) (members map[string]Set[string]) {
+	members = make(map[string]Set[string])
+	for _, sg := range scimGroups {
+		members[sg.Id] = NewSet[string]()
+	}
+	for _, su := range s.scimUsers {
+		for _, groupId := range su.Groups {
+			if set, ok := members[groupId]; ok {
+				set.Add(foldEmail(su.UserName))
+			}
+		}
+	}
+	return
+}
+
+// matchGroupsByOverlap pairs source groups with SCIM groups by the Jaccard similarity of their member user names.
+// The most similar pairs are taken first; pairs below the threshold and groups without members are not matched
+func (s *sync) matchGroupsByOverlap(groups []*Group, scimGroups []*scimGroup, threshold float64) (matches []*groupMatch) {
+	var sourceMembers = s.groupMembersByName(groups)
+	var scimMembers = s.scimGroupMembersByName(scimGroups)
+	// SCIM groups by member, so only groups that share a member are scored
+	var memberOf = make(map[string][]int)
+	for j, sg := range scimGroups {
+		for userName := range scimMembers[sg.Id] {
+			memberOf[userName] = append(memberOf[userName], j)
+		}
+	}
+	var candidates []*groupMatch
+	for _, group := range groups {
+		var members = sourceMembers[group.Id]
+		var shared = make(map[int]int)
+		for userName := range members {
+			for _, j := range memberOf[userName] {
+				shared[j]++
+			}
+		}
+		for j, count := range shared {
+			var sg = scimGroups[j]
+			var union = len(members) + len(scimMembers[sg.Id]) - count
+			var score = float64(count) / float64(union)
+			if score >= threshold {
+				candidates = append(candidates, &groupMatch{group: group, scimGroup: sg, by: matchByOverlap, score: score,
+					rationale: fmt.Sprintf("%d of %d member(s) shared, similarity %.2f, threshold %.2f", count, union, score, threshold)})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		var gi, gj = groupSortKey(candidates[i].group), groupSortKey(candidates[j].group)
+		if gi != gj {
+			return gi < gj
+		}
+		return scimGroupSortKey(candidates[i].scimGroup) < scimGroupSortKey(candidates[j].scimGroup)
+	})
+	var pairedGroups = NewSet[string]()
+	var pairedScimGroups = NewSet[string]()
+	for _, candidate := range candidates {
+		if pairedGroups.Has(candidate.group.Id) || pairedScimGroups.Has(candidate.scimGroup.Id) {
+			continue
+		}
+		pairedGroups.Add(candidate.group.Id)
+		pairedScimGroups.Add(candidate.scimGroup.Id)
+		matches = append(matches, candidate)
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// groupPattern is a "SCIM Group" entry that selects groups by pattern
+// Glob: "keeper-*@example.com" matches group emails, "Keeper *" matches group names.
+// Regular expression: "/^keeper-.+@example\.com$/" matches either group email or name
+type groupPattern struct {
+	entry      string
+	expression *regexp.Regexp
+	matchEmail bool
+	matchName  bool
+}
+
+func isGroupPattern(entry string) bool {
+	if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+		return true
+	}
+	return strings.ContainsAny(entry, "*?")
+}
+
+func parseGroupPattern(entry string) (pattern *groupPattern, err error) {
+	pattern = &groupPattern{
+		entry: entry,
+	}
+	if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+		if pattern.expression, err = regexp.Compile(entry[1 : len(entry)-1]); err != nil {
+			err = fmt.Errorf("group pattern \"%s\": %w", entry, err)
+			return
+		}
+		pattern.matchEmail = true
+		pattern.matchName = true
+		return
+	}
+	var expr = regexp.QuoteMeta(entry)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	if strings.Contains(entry, "@") {
+		pattern.expression = regexp.MustCompile("(?i)^" + expr + "$")
+		pattern.matchEmail = true
+	} else {
+		pattern.expression = regexp.MustCompile("^" + expr + "$")
+		pattern.matchName = true
+	}
+	return
+}
+
+func (gp *groupPattern) matches(group *admin.Group) bool {
+	return (gp.matchEmail && gp.expression.MatchString(group.Email)) ||
+		(gp.matchName && gp.expression.MatchString(group.Name))
+}
+
+// parseExcludedGroup parses a nested group exclusion: a group email or a group email pattern
+func parseExcludedGroup(entry string) (pattern *groupPattern, err error) {
+	if pattern, err = parseGroupPattern(entry); err != nil {
+		return
+	}
+	if !pattern.matchEmail {
+		err = fmt.Errorf("excluded group \"%s\" must be a group email or email pattern", entry)
+	}
+	return
+}
+
+func isExcludedGroup(email string, excluded []*groupPattern) bool {
+	if len(email) == 0 {
+		return false
+	}
+	for _, pattern := range excluded {
+		if pattern.expression.MatchString(email) {
+			return true
+		}
+	}
+	return false
+}
+
+// listAllGroups loads all groups of the Google Workspace account
+func (ge *googleEndpoint) listAllGroups(ctx context.Context, directory DirectoryClient) (groups []*admin.Group, err error) {
+	var groupList = ge.listRequest("")
+	groupList.MaxResults = 200
+	err = ge.loadPages("groups", func(pageToken string) (nextPageToken string, er1 error) {
+		var page *admin.Groups
+		groupList.PageToken = pageToken
+		if page, er1 = directory.ListGroups(ctx, groupList); er1 != nil {
+			return
+		}
+		groups = append(groups, page.Groups...)
+		nextPageToken = page.NextPageToken
+		return
+	})
+	return
+}
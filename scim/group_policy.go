@@ -0,0 +1,82 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// GroupPolicy overrides the global destructive setting for a single group
+type GroupPolicy int32
+
+const (
+	// GroupPolicyDefault follows the global destructive setting
+	GroupPolicyDefault GroupPolicy = iota
+	// GroupPolicyCreateOnly never deletes the group and never removes its members
+	GroupPolicyCreateOnly
+	// GroupPolicyMembership removes members that left the group but never deletes the group
+	GroupPolicyMembership
+	// GroupPolicyManaged deletes the group and removes its members even if they are not controlled by SCIM
+	GroupPolicyManaged
+)
+
+func (gp GroupPolicy) String() string {
+	switch gp {
+	case GroupPolicyCreateOnly:
+		return "create-only"
+	case GroupPolicyMembership:
+		return "membership"
+	case GroupPolicyManaged:
+		return "managed"
+	}
+	return "default"
+}
+
+// ParseGroupPolicies parses "group=policy" entries separated by comma or new line.
+// A group is either a group name (case-insensitive) or Google group ID.
+// Policy is one of "default", "create-only", "membership", "managed"
+func ParseGroupPolicies(entries []string) (policies map[string]GroupPolicy, err error) {
+	var fold = cases.Fold()
+	for _, entry := range entries {
+		var pos = strings.LastIndex(entry, "=")
+		if pos <= 0 {
+			err = fmt.Errorf("group policy \"%s\" is not in \"group=policy\" format", entry)
+			return
+		}
+		var group = strings.TrimSpace(entry[:pos])
+		var policy GroupPolicy
+		switch strings.ToLower(strings.TrimSpace(entry[pos+1:])) {
+		case "default":
+			policy = GroupPolicyDefault
+		case "create-only", "createonly":
+			policy = GroupPolicyCreateOnly
+		case "membership":
+			policy = GroupPolicyMembership
+		case "managed":
+			policy = GroupPolicyManaged
+		default:
+			err = fmt.Errorf("group policy \"%s\": unsupported policy. Valid policies are default, create-only, membership, managed", entry)
+			return
+		}
+		if policies == nil {
+			policies = make(map[string]GroupPolicy)
+		}
+		policies[fold.String(group)] = policy
+	}
+	return
+}
+
+func (s *sync) groupPolicy(group *scimGroup) (policy GroupPolicy) {
+	if len(s.groupPolicies) == 0 {
+		return
+	}
+	var ok bool
+	if policy, ok = s.groupPolicies[cases.Fold().String(group.Name)]; ok {
+		return
+	}
+	if len(group.ExternalId) > 0 {
+		policy = s.groupPolicies[s.sourceId(group.ExternalId)]
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Records of a sync run kept in the state store
+const (
+	RunRecordJournal  = "journal"
+	RunRecordPlan     = "plan"
+	RunRecordSnapshot = "snapshot"
+	RunRecordStat     = "stat"
+)
+
+// RunHistory lists the records the state store keeps for a sync run
+type RunHistory struct {
+	RunId   string
+	Records []string
+}
+
+func runStatKey(runId string) string {
+	return fmt.Sprintf("runs/%s/stat", runId)
+}
+
+func runPlanKey(runId string) string {
+	return fmt.Sprintf("runs/%s/plan", runId)
+}
+
+func saveRunStat(store IStateStore, stat *SyncStat) (err error) {
+	var data []byte
+	if data, err = json.Marshal(stat); err != nil {
+		return
+	}
+	err = store.Save(runStatKey(stat.RunId), data)
+	return
+}
+
+func saveRunPlan(store IStateStore, plan *SyncPlan) (err error) {
+	var data []byte
+	if data, err = json.Marshal(plan); err != nil {
+		return
+	}
+	err = store.Save(runPlanKey(plan.RunId), data)
+	return
+}
+
+// LoadRunStat reads the statistics of the sync run from the state store. Returns nil if the run kept no statistics
+func LoadRunStat(store IStateStore, runId string) (stat *SyncStat, err error) {
+	var data []byte
+	if data, err = store.Load(runStatKey(runId)); err != nil || data == nil {
+		return
+	}
+	stat = new(SyncStat)
+	err = json.Unmarshal(data, stat)
+	return
+}
+
+// LoadRunPlan reads the plan of the plan run from the state store. Returns nil if the run kept no plan
+func LoadRunPlan(store IStateStore, runId string) (plan *SyncPlan, err error) {
+	var data []byte
+	if data, err = store.Load(runPlanKey(runId)); err != nil || data == nil {
+		return
+	}
+	plan = new(SyncPlan)
+	err = json.Unmarshal(data, plan)
+	return
+}
+
+// ListRunHistory returns the sync runs kept in the state store, the most recent run first.
+// Run IDs start with the UTC start time, so they are ordered by time
+func ListRunHistory(store IStateStore) (runs []*RunHistory, err error) {
+	var keys []string
+	if keys, err = store.List("runs/"); err != nil {
+		return
+	}
+	var byRunId = make(map[string]*RunHistory)
+	for _, key := range keys {
+		var runId, record, ok = strings.Cut(strings.TrimPrefix(key, "runs/"), "/")
+		if !ok || len(runId) == 0 {
+			continue
+		}
+		var run = byRunId[runId]
+		if run == nil {
+			run = &RunHistory{RunId: runId}
+			byRunId[runId] = run
+			runs = append(runs, run)
+		}
+		run.Records = append(run.Records, record)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].RunId > runs[j].RunId
+	})
+	return
+}
+
+// PruneRunHistory deletes every record of sync runs older than the most recent keep runs.
+// Pruned runs cannot be rolled back or compared
+func PruneRunHistory(store IStateStore, keep int) (pruned []string, err error) {
+	var runs []*RunHistory
+	if runs, err = ListRunHistory(store); err != nil {
+		return
+	}
+	if keep < 0 || len(runs) <= keep {
+		return
+	}
+	for _, run := range runs[keep:] {
+		for _, record := range run.Records {
+			if err = store.Delete(fmt.Sprintf("runs/%s/%s", run.RunId, record)); err != nil {
+				return
+			}
+		}
+		pruned = append(pruned, run.RunId)
+	}
+	return
+}
+
+// pruneRunHistory keeps the configured number of sync runs in the state store. Errors do not fail the run
+func (s *sync) pruneRunHistory() {
+	if s.stateStore == nil || s.historyRuns <= 0 {
+		return
+	}
+	var pruned, er1 = PruneRunHistory(s.stateStore, int(s.historyRuns))
+	if er1 != nil {
+		log.Printf("Failed to prune sync run history: %s", er1.Error())
+		return
+	}
+	if len(pruned) > 0 && s.verbose {
+		log.Printf("Pruned %d sync run(s) from history", len(pruned))
+	}
+}
@@ -0,0 +1,158 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Stages of a sync run a hook is executed at
+const (
+	HookStagePre  = "pre"
+	HookStagePost = "post"
+)
+
+// hookTimeout limits the execution time of a single hook
+const hookTimeout = 5 * time.Minute
+
+// hookEnvironment are the process environment variables passed to hook commands.
+// Other variables, e.g. SCIM_TOKEN or GOOGLE_CREDENTIALS, are not visible to the hook
+var hookEnvironment = []string{"PATH", "HOME"}
+
+// ISyncHook is executed before changes of a sync run are applied and after the sync run.
+// payload is the JSON document passed to the hook
+type ISyncHook interface {
+	Execute(stage string, runId string, payload []byte) error
+}
+
+// NewSyncHook creates ISyncHook from the hook definition
+// hook: "http://" or "https://" URL that receives the payload as POST body, or a shell command that receives the payload on stdin
+func NewSyncHook(hook string) (result ISyncHook, err error) {
+	hook = strings.TrimSpace(hook)
+	if len(hook) == 0 {
+		err = fmt.Errorf("sync hook is empty")
+		return
+	}
+	var lower = strings.ToLower(hook)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		result = &httpHook{url: hook}
+	} else {
+		result = &commandHook{command: hook}
+	}
+	return
+}
+
+type commandHook struct {
+	command string
+}
+
+// Execute runs the command with the system shell. The environment contains PATH, HOME, SCIM_HOOK_STAGE and SCIM_RUN_ID only
+func (ch *commandHook) Execute(stage string, runId string, payload []byte) (err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", ch.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", ch.command)
+	}
+	for _, name := range hookEnvironment {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	cmd.Env = append(cmd.Env, "SCIM_HOOK_STAGE="+stage, "SCIM_RUN_ID="+runId)
+	cmd.Stdin = bytes.NewReader(payload)
+	var output []byte
+	output, err = cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Printf("%s-sync hook output:\n%s", stage, strings.TrimRight(string(output), "\n"))
+	}
+	if err != nil {
+		err = fmt.Errorf("%s-sync hook command error: %w", stage, err)
+	}
+	return
+}
+
+type httpHook struct {
+	url string
+}
+
+// Execute posts the payload to the URL. X-Scim-Hook-Stage and X-Correlation-Id headers identify the call
+func (hh *httpHook) Execute(stage string, runId string, payload []byte) (err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	var rq *http.Request
+	if rq, err = http.NewRequestWithContext(ctx, "POST", hh.url, bytes.NewReader(payload)); err != nil {
+		return
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set("X-Scim-Hook-Stage", stage)
+	rq.Header.Set("X-Correlation-Id", runId)
+	var rs *http.Response
+	if rs, err = http.DefaultClient.Do(rq); err != nil {
+		err = fmt.Errorf("%s-sync hook request error: %w", stage, err)
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	if rs.StatusCode >= 300 {
+		var body, _ = io.ReadAll(io.LimitReader(rs.Body, 1024))
+		err = fmt.Errorf("%s-sync hook error: status code %d: %s", stage, rs.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return
+}
+
+// postSyncDocument is the payload of the post-sync hook
+type postSyncDocument struct {
+	RunId  string    `json:"runId"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Stat   *SyncStat `json:"stat,omitempty"`
+}
+
+// runPreSyncHook passes the sync plan to the pre-sync hook. An error aborts the sync run
+func (s *sync) runPreSyncHook(plan *SyncPlan) (err error) {
+	if s.preSyncHook == nil {
+		return
+	}
+	var payload []byte
+	if payload, err = json.Marshal(plan); err != nil {
+		return
+	}
+	if err = s.preSyncHook.Execute(HookStagePre, s.runId, payload); err != nil {
+		err = fmt.Errorf("sync aborted by pre-sync hook: %w", err)
+	}
+	return
+}
+
+// runPostSyncHook passes the sync result to the post-sync hook. Hook errors are logged only
+func (s *sync) runPostSyncHook(stat *SyncStat, syncErr error) {
+	if s.postSyncHook == nil {
+		return
+	}
+	var document = &postSyncDocument{
+		RunId:  s.runId,
+		Status: "success",
+		Stat:   stat,
+	}
+	if syncErr != nil {
+		document.Status = "failed"
+		document.Error = syncErr.Error()
+	}
+	var payload, err = json.Marshal(document)
+	if err == nil {
+		err = s.postSyncHook.Execute(HookStagePost, s.runId, payload)
+	}
+	if err != nil {
+		log.Printf("Post-sync hook of sync run \"%s\" failed: %s", s.runId, err.Error())
+	}
+}
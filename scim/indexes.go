@@ -0,0 +1,93 @@
+package scim
+
+import (
+	"sort"
+	"time"
+)
+
+// ScimUser is a SCIM user as loaded by the last populate
+type ScimUser struct {
+	User
+	UserName     string
+	ExternalId   string
+	ManagerId    string
+	Roles        []string
+	Entitlements []string
+	// Created and LastModified are zero if the SCIM server does not report them
+	Created      time.Time
+	LastModified time.Time
+}
+
+// ScimGroup is a SCIM group as loaded by the last populate
+type ScimGroup struct {
+	Group
+	ExternalId   string
+	Created      time.Time
+	LastModified time.Time
+}
+
+// syncIndexes is a read-only copy of the in-memory indexes. It is replaced, never modified, so it is shared without copying
+type syncIndexes struct {
+	scimUsers   []*ScimUser
+	scimGroups  []*ScimGroup
+	sourceUsers []*User
+}
+
+// publishIndexes copies the source and SCIM data loaded by populate for ScimUsers, ScimGroups and SourceUsers.
+// Later changes of the run do not affect the published copy
+func (s *sync) publishIndexes() {
+	var indexes = new(syncIndexes)
+	for _, su := range sortedValues(s.scimUsers, scimUserSortKey) {
+		var user = &ScimUser{
+			User:         su.User,
+			UserName:     su.UserName,
+			ExternalId:   su.ExternalId,
+			ManagerId:    su.ManagerId,
+			Roles:        append([]string(nil), su.Roles...),
+			Entitlements: append([]string(nil), su.Entitlements...),
+			Created:      su.meta.Created,
+			LastModified: su.meta.LastModified,
+		}
+		user.Groups = append([]string(nil), su.Groups...)
+		indexes.scimUsers = append(indexes.scimUsers, user)
+	}
+	for _, sg := range sortedValues(s.scimGroups, scimGroupSortKey) {
+		indexes.scimGroups = append(indexes.scimGroups, &ScimGroup{
+			Group:        sg.Group,
+			ExternalId:   sg.ExternalId,
+			Created:      sg.meta.Created,
+			LastModified: sg.meta.LastModified,
+		})
+	}
+	s.Source().Users(func(u *User) {
+		var user = *u
+		user.Groups = append([]string(nil), u.Groups...)
+		indexes.sourceUsers = append(indexes.sourceUsers, &user)
+	})
+	sort.SliceStable(indexes.sourceUsers, func(i, j int) bool {
+		return userSortKey(indexes.sourceUsers[i]) < userSortKey(indexes.sourceUsers[j])
+	})
+	s.indexLock.Lock()
+	s.indexes = indexes
+	s.indexLock.Unlock()
+}
+
+func (s *sync) publishedIndexes() (indexes *syncIndexes) {
+	s.indexLock.RLock()
+	indexes = s.indexes
+	s.indexLock.RUnlock()
+	if indexes == nil {
+		indexes = new(syncIndexes)
+	}
+	return
+}
+
+func (s *sync) ScimUsers() []*ScimUser {
+	return s.publishedIndexes().scimUsers
+}
+func (s *sync) ScimGroups() []*ScimGroup {
+	return s.publishedIndexes().scimGroups
+}
+func (s *sync) SourceUsers() []*User {
+	return s.publishedIndexes().sourceUsers
+}
@@ -0,0 +1,215 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ScimOperation is a single SCIM write request
+type ScimOperation struct {
+	Method       string         `json:"method"`
+	ResourceType string         `json:"resourceType"`
+	ResourceId   string         `json:"resourceId,omitempty"`
+	Payload      map[string]any `json:"payload,omitempty"`
+}
+
+// JournalEntry records a change made by a sync run and the operation that reverts it.
+// Inverse is nil if the change cannot be reverted, e.g. a DELETE
+type JournalEntry struct {
+	Phase        string         `json:"phase"`
+	OperationId  string         `json:"operationId"`
+	Method       string         `json:"method"`
+	ResourceType string         `json:"resourceType"`
+	ResourceId   string         `json:"resourceId"`
+	Name         string         `json:"name"`
+	Inverse      *ScimOperation `json:"inverse,omitempty"`
+	// Reverted is set once the inverse operation succeeded, so a repeated rollback skips the entry
+	Reverted bool `json:"reverted,omitempty"`
+}
+
+// RunJournal contains all changes made by a sync run in execution order
+type RunJournal struct {
+	RunId    string    `json:"runId"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// RolledBack is set once every revertible entry is reverted
+	RolledBack *time.Time      `json:"rolledBack,omitempty"`
+	Entries    []*JournalEntry `json:"entries"`
+}
+
+const (
+	phaseGroups     = SyncPhaseGroups
+	phaseUsers      = SyncPhaseUsers
+	phaseMembership = SyncPhaseMembership
+	phaseRoles      = SyncPhaseRoles
+)
+
+func runJournalKey(runId string) string {
+	return fmt.Sprintf("runs/%s/journal", runId)
+}
+
+// LoadRunJournal reads the journal of the sync run from the state store
+func LoadRunJournal(store IStateStore, runId string) (journal *RunJournal, err error) {
+	var data []byte
+	if data, err = store.Load(runJournalKey(runId)); err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("sync run \"%s\" was not found", runId)
+		return
+	}
+	journal = new(RunJournal)
+	err = json.Unmarshal(data, journal)
+	return
+}
+
+func saveRunJournal(store IStateStore, journal *RunJournal) (err error) {
+	var data []byte
+	if data, err = json.Marshal(journal); err != nil {
+		return
+	}
+	err = store.Save(runJournalKey(journal.RunId), data)
+	return
+}
+
+func makePatchPayload(operations ...any) map[string]any {
+	var payload = make(map[string]any)
+	payload["schemas"] = []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"}
+	payload["Operations"] = operations
+	return payload
+}
+
+func makePatchOperation(op string, path string, value any) map[string]any {
+	var operation = make(map[string]any)
+	operation["op"] = op
+	if len(path) > 0 {
+		operation["path"] = path
+	}
+	operation["value"] = value
+	return operation
+}
+
+func (s *sync) recordChange(phase string, method string, resourceType string, resourceId string, name string, inverse *ScimOperation) {
+	if s.journal == nil {
+		return
+	}
+	s.journal.Entries = append(s.journal.Entries, &JournalEntry{
+		Phase:        phase,
+		OperationId:  s.lastOperationId,
+		Method:       method,
+		ResourceType: resourceType,
+		ResourceId:   resourceId,
+		Name:         name,
+		Inverse:      inverse,
+	})
+}
+
+func (s *sync) executeOperation(op *ScimOperation) (err error) {
+	switch op.Method {
+	case "POST":
+		_, err = s.postResource(op.ResourceType, op.Payload)
+	case "PATCH":
+		err = s.patchResource(op.ResourceType, op.ResourceId, op.Payload)
+	case "DELETE":
+		err = s.deleteResource(op.ResourceType, op.ResourceId)
+	default:
+		err = fmt.Errorf("unsupported SCIM method \"%s\"", op.Method)
+	}
+	return
+}
+
+// notReversibleDelete explains why deletes are not reverted
+const notReversibleDelete = "a deleted resource cannot be restored with its ID and group memberships"
+
+// notReversible returns the reason the entry cannot be reverted, or an empty string.
+// Journals of earlier versions record a POST that recreates the deleted resource; it is not replayed
+func (entry *JournalEntry) notReversible() string {
+	if entry.Method == "DELETE" {
+		return notReversibleDelete
+	}
+	if entry.Inverse == nil {
+		return "the change cannot be reverted"
+	}
+	return ""
+}
+
+func describeMethod(method string) string {
+	switch method {
+	case "POST":
+		return "create"
+	case "PATCH":
+		return "update"
+	case "DELETE":
+		return "delete"
+	}
+	return method
+}
+
+// Rollback reverts changes made by the sync run in reverse order
+func (s *sync) Rollback(runId string) (stat *SyncStat, err error) {
+	if s.stateStore == nil {
+		err = errors.New("rollback requires a state store")
+		return
+	}
+	// a rollback must not run while a sync changes the same resources on another replica
+	var releaseLock func()
+	if releaseLock, err = s.acquireSyncLock(); err != nil {
+		return
+	}
+	defer releaseLock()
+	var journal *RunJournal
+	if journal, err = LoadRunJournal(s.stateStore, runId); err != nil {
+		return
+	}
+	if journal.RolledBack != nil {
+		err = fmt.Errorf("sync run \"%s\" has already been rolled back at %s", runId, journal.RolledBack.Format(time.RFC3339))
+		return
+	}
+
+	s.beginRun()
+	s.loadDialect()
+	log.Printf("Rollback of sync run \"%s\" run ID: %s", runId, s.runId)
+	stat = &SyncStat{RunId: s.runId, RolledBackRunId: runId}
+	var complete = true
+	for i := len(journal.Entries) - 1; i >= 0; i-- {
+		var entry = journal.Entries[i]
+		if entry.Reverted {
+			continue
+		}
+		var success, failure *[]string
+		switch entry.Phase {
+		case phaseGroups:
+			success, failure = &stat.SuccessGroups, &stat.FailedGroups
+		case phaseUsers, phaseRoles:
+			success, failure = &stat.SuccessUsers, &stat.FailedUsers
+		default:
+			success, failure = &stat.SuccessMembership, &stat.FailedMembership
+		}
+		var action = describeMethod(entry.Method)
+		if entry.Phase == phaseMembership {
+			action = "membership change"
+		}
+		if reason := entry.notReversible(); len(reason) > 0 {
+			*failure = append(*failure, fmt.Sprintf("Rollback %s of %s \"%s\" skipped: %s", action, entry.ResourceType, entry.Name, reason))
+			continue
+		}
+		if er1 := s.executeOperation(entry.Inverse); er1 == nil {
+			entry.Reverted = true
+			*success = append(*success, fmt.Sprintf("SCIM reverted %s of %s \"%s\"", action, entry.ResourceType, entry.Name))
+		} else {
+			complete = false
+			*failure = append(*failure, fmt.Sprintf("Rollback %s of %s \"%s\" error: %s", action, entry.ResourceType, entry.Name, er1.Error()))
+		}
+	}
+
+	// a partial rollback can be repeated: reverted entries are skipped
+	if complete {
+		var now = time.Now()
+		journal.RolledBack = &now
+	}
+	err = saveRunJournal(s.stateStore, journal)
+	return
+}
@@ -0,0 +1,168 @@
+package scim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"testing"
+)
+
+// rollbackServer accepts every write and fails the requests listed in failing
+type rollbackServer struct {
+	lock      gosync.Mutex
+	failing   map[string]bool
+	requested []string
+}
+
+func (rs *rollbackServer) ServeHTTP(w http.ResponseWriter, rq *http.Request) {
+	var request = rq.Method + " " + rq.URL.Path
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.requested = append(rs.requested, request)
+	if rs.failing[request] {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rs *rollbackServer) take() (requested []string) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	requested, rs.requested = rs.requested, nil
+	return
+}
+
+func testPatchInverse(resourceType string, resourceId string) *ScimOperation {
+	return &ScimOperation{
+		Method:       "PATCH",
+		ResourceType: resourceType,
+		ResourceId:   resourceId,
+		Payload:      makePatchPayload(makePatchOperation("replace", "", map[string]any{"displayName": "Before"})),
+	}
+}
+
+func newRollbackSync(t *testing.T, entries ...*JournalEntry) (s *sync, server *rollbackServer) {
+	server = &rollbackServer{failing: make(map[string]bool)}
+	var hs = httptest.NewServer(server)
+	t.Cleanup(hs.Close)
+	s = &sync{baseUrl: hs.URL, token: "rollback-token", stateStore: NewFileStateStore(t.TempDir())}
+	if err := saveRunJournal(s.stateStore, &RunJournal{RunId: "run-1", Entries: entries}); err != nil {
+		t.Fatalf("save journal: %v", err)
+	}
+	return
+}
+
+func loadTestJournal(t *testing.T, s *sync) *RunJournal {
+	var journal, err = LoadRunJournal(s.stateStore, "run-1")
+	if err != nil {
+		t.Fatalf("load journal: %v", err)
+	}
+	return journal
+}
+
+func TestRollbackReverseOrder(t *testing.T) {
+	var s, server = newRollbackSync(t,
+		&JournalEntry{Phase: phaseGroups, Method: "POST", ResourceType: "Groups", ResourceId: "g1", Name: "Team",
+			Inverse: &ScimOperation{Method: "DELETE", ResourceType: "Groups", ResourceId: "g1"}},
+		&JournalEntry{Phase: phaseUsers, Method: "PATCH", ResourceType: "Users", ResourceId: "u1", Name: "a@example.com",
+			Inverse: testPatchInverse("Users", "u1")},
+		&JournalEntry{Phase: phaseMembership, Method: "PATCH", ResourceType: "Users", ResourceId: "u2", Name: "b@example.com",
+			Inverse: testPatchInverse("Users", "u2")},
+	)
+	var stat, err = s.Rollback("run-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"PATCH /Users/u2", "PATCH /Users/u1", "DELETE /Groups/g1"}; !reflect.DeepEqual(server.take(), want) {
+		t.Errorf("requests are not in reverse journal order, want %v", want)
+	}
+	if stat.RolledBackRunId != "run-1" || len(stat.RunId) == 0 || stat.RunId == "run-1" {
+		t.Errorf("run ID = %s, rolled back run ID = %s, want a new run ID and run-1", stat.RunId, stat.RolledBackRunId)
+	}
+	if len(stat.SuccessGroups) != 1 || len(stat.SuccessUsers) != 1 || len(stat.SuccessMembership) != 1 {
+		t.Errorf("successes = %v %v %v, want one of each", stat.SuccessGroups, stat.SuccessUsers, stat.SuccessMembership)
+	}
+	if journal := loadTestJournal(t, s); journal.RolledBack == nil {
+		t.Error("journal is not marked as rolled back")
+	}
+}
+
+func TestRollbackRepeated(t *testing.T) {
+	var s, server = newRollbackSync(t,
+		&JournalEntry{Phase: phaseUsers, Method: "PATCH", ResourceType: "Users", ResourceId: "u1", Name: "a@example.com",
+			Inverse: testPatchInverse("Users", "u1")},
+		&JournalEntry{Phase: phaseUsers, Method: "PATCH", ResourceType: "Users", ResourceId: "u2", Name: "b@example.com",
+			Inverse: testPatchInverse("Users", "u2")},
+	)
+	server.failing["PATCH /Users/u1"] = true
+
+	var stat, err = s.Rollback("run-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stat.SuccessUsers) != 1 || len(stat.FailedUsers) != 1 {
+		t.Errorf("partial rollback: successes = %v, failures = %v", stat.SuccessUsers, stat.FailedUsers)
+	}
+	var journal = loadTestJournal(t, s)
+	if journal.RolledBack != nil {
+		t.Error("partial rollback marked the journal as rolled back")
+	}
+	if journal.Entries[0].Reverted || !journal.Entries[1].Reverted {
+		t.Errorf("reverted = %v, %v, want false, true", journal.Entries[0].Reverted, journal.Entries[1].Reverted)
+	}
+	server.take()
+
+	delete(server.failing, "PATCH /Users/u1")
+	if stat, err = s.Rollback("run-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"PATCH /Users/u1"}; !reflect.DeepEqual(server.take(), want) {
+		t.Errorf("repeated rollback requests, want only %v", want)
+	}
+	if len(stat.SuccessUsers) != 1 || len(stat.FailedUsers) != 0 {
+		t.Errorf("repeated rollback: successes = %v, failures = %v", stat.SuccessUsers, stat.FailedUsers)
+	}
+	if journal = loadTestJournal(t, s); journal.RolledBack == nil {
+		t.Error("journal is not marked as rolled back")
+	}
+
+	if _, err = s.Rollback("run-1"); err == nil || !strings.Contains(err.Error(), "has already been rolled back") {
+		t.Errorf("error = %v, want already rolled back", err)
+	}
+	if requested := server.take(); len(requested) > 0 {
+		t.Errorf("rolled back run sent requests %v", requested)
+	}
+}
+
+func TestRollbackDeleteNotReversible(t *testing.T) {
+	var s, server = newRollbackSync(t,
+		// recorded by a sync run
+		&JournalEntry{Phase: phaseUsers, Method: "DELETE", ResourceType: "Users", ResourceId: "u1", Name: "a@example.com"},
+		// recorded by an earlier version with a POST that recreates the group without its members
+		&JournalEntry{Phase: phaseGroups, Method: "DELETE", ResourceType: "Groups", ResourceId: "g1", Name: "Team",
+			Inverse: &ScimOperation{Method: "POST", ResourceType: "Groups", Payload: map[string]any{"displayName": "Team"}}},
+	)
+	var stat, err = s.Rollback("run-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requested := server.take(); len(requested) > 0 {
+		t.Errorf("deletes are reverted with %v", requested)
+	}
+	if len(stat.SuccessUsers) > 0 || len(stat.SuccessGroups) > 0 {
+		t.Errorf("deletes are reported as reverted: %v %v", stat.SuccessUsers, stat.SuccessGroups)
+	}
+	for _, failures := range [][]string{stat.FailedUsers, stat.FailedGroups} {
+		if len(failures) != 1 || !strings.Contains(failures[0], notReversibleDelete) {
+			t.Errorf("failures = %v, want the not reversible delete", failures)
+		}
+	}
+	for _, entry := range loadTestJournal(t, s).Entries {
+		if entry.Reverted {
+			t.Errorf("delete of %s is marked as reverted", entry.Name)
+		}
+	}
+}
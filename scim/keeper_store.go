@@ -0,0 +1,192 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	ksm "github.com/keeper-security/secrets-manager-go/core"
+)
+
+// keeperStateFileName is the attachment of the SCIM record that contains the state
+const keeperStateFileName = "ksm-scim-state.json"
+
+// IsKeeperStateStore returns true if the state store URI selects the attachment of the SCIM record, "keeper://"
+func IsKeeperStateStore(storeUri string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(storeUri)), "keeper:")
+}
+
+type keeperStateStore struct {
+	sm      *ksm.SecretsManager
+	record  *ksm.Record
+	lock    gosync.Mutex
+	entries map[string][]byte
+}
+
+// NewKeeperStateStore creates IStateStore that keeps the state in the "ksm-scim-state.json" attachment of the SCIM record,
+// so the state travels with the configuration and needs no other infrastructure. Every write uploads the whole state,
+// so the store suits small deployments; limit the kept runs with SCIM_HISTORY_RUNS.
+// The record is updated in place, so later saves of the record use its current revision
+func NewKeeperStateStore(sm *ksm.SecretsManager, record *ksm.Record) IStateStore {
+	return &keeperStateStore{
+		sm:     sm,
+		record: record,
+	}
+}
+
+func (ks *keeperStateStore) load() (err error) {
+	if ks.entries != nil {
+		return
+	}
+	var entries = make(map[string][]byte)
+	if file := newestKeeperFile(ks.record.FindFiles(keeperStateFileName)); file != nil {
+		var data = file.GetFileData()
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &entries); err != nil {
+				return
+			}
+		}
+	}
+	ks.entries = entries
+	return
+}
+
+// newestKeeperFile returns the most recently modified attachment. A failed cleanup of flush leaves several state attachments
+func newestKeeperFile(files []*ksm.KeeperFile) (newest *ksm.KeeperFile) {
+	for _, file := range files {
+		if newest == nil || file.LastModified > newest.LastModified ||
+			(file.LastModified == newest.LastModified && file.Uid > newest.Uid) {
+			newest = file
+		}
+	}
+	return
+}
+
+// flush uploads the state as a new attachment, then removes the previous attachments.
+// An error is returned only if the state was not uploaded. Previous attachments that could not be removed
+// are older than the uploaded one, so load ignores them, and the next flush removes them
+func (ks *keeperStateStore) flush() (err error) {
+	var data []byte
+	if data, err = json.Marshal(ks.entries); err != nil {
+		return
+	}
+	var previous []string
+	for _, file := range ks.record.FindFiles(keeperStateFileName) {
+		previous = append(previous, file.Uid)
+	}
+	if _, err = ks.sm.UploadFile(ks.record, &ksm.KeeperFileUpload{
+		Name:  keeperStateFileName,
+		Title: keeperStateFileName,
+		Type:  "application/json",
+		Data:  data,
+	}); err != nil {
+		return
+	}
+	var er1 error
+	if er1 = ks.refresh(); er1 == nil && len(previous) > 0 {
+		if er1 = unlinkKeeperFiles(ks.record, previous); er1 == nil {
+			if er1 = ks.sm.Save(ks.record); er1 == nil {
+				er1 = ks.refresh()
+			}
+		}
+	}
+	if er1 != nil {
+		log.Printf("Failed to remove the previous \"%s\" attachments: %s", keeperStateFileName, er1.Error())
+	}
+	return
+}
+
+// unlinkKeeperFiles removes the attachments from the "fileRef" field of the record
+func unlinkKeeperFiles(record *ksm.Record, fileUids []string) (err error) {
+	var refs []any
+	if refs, err = record.GetStandardFieldValue("fileRef", false); err != nil {
+		return
+	}
+	var removed = MakeSet[string](fileUids)
+	var kept = make([]any, 0, len(refs))
+	for _, ref := range refs {
+		if uid, ok := ref.(string); ok && removed.Has(uid) {
+			continue
+		}
+		kept = append(kept, ref)
+	}
+	err = record.SetStandardFieldValue("fileRef", kept)
+	return
+}
+
+// refresh reads the current revision of the record into the shared record
+func (ks *keeperStateStore) refresh() (err error) {
+	var records []*ksm.Record
+	if records, err = ks.sm.GetSecrets([]string{ks.record.Uid}); err != nil {
+		return
+	}
+	if len(records) == 0 {
+		err = errors.New("SCIM record was not found")
+		return
+	}
+	*ks.record = *records[0]
+	return
+}
+
+func (ks *keeperStateStore) Load(key string) (data []byte, err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if err = ks.load(); err != nil {
+		return
+	}
+	data = ks.entries[key]
+	return
+}
+
+func (ks *keeperStateStore) Save(key string, data []byte) (err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if err = ks.load(); err != nil {
+		return
+	}
+	var previous, ok = ks.entries[key]
+	ks.entries[key] = data
+	if err = ks.flush(); err != nil {
+		if ok {
+			ks.entries[key] = previous
+		} else {
+			delete(ks.entries, key)
+		}
+	}
+	return
+}
+
+func (ks *keeperStateStore) Delete(key string) (err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if err = ks.load(); err != nil {
+		return
+	}
+	var previous, ok = ks.entries[key]
+	if !ok {
+		return
+	}
+	delete(ks.entries, key)
+	if err = ks.flush(); err != nil {
+		ks.entries[key] = previous
+	}
+	return
+}
+
+func (ks *keeperStateStore) List(prefix string) (keys []string, err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if err = ks.load(); err != nil {
+		return
+	}
+	for key := range ks.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
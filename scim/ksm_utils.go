@@ -2,13 +2,26 @@ package scim
 
 import (
 	"errors"
+	"fmt"
 	ksm "github.com/keeper-security/secrets-manager-go/core"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func LoadScimParametersFromRecord(scimRecord *ksm.Record) (ka *ScimEndpointParameters, gcp *GoogleEndpointParameters, err error) {
+	var impersonate []string
+	if fields := scimRecord.GetCustomFieldsByLabel("Impersonate Service Account"); len(fields) > 0 {
+		impersonate = parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))
+	}
+	var credentials []byte
 	var files = scimRecord.FindFiles("credentials.json")
-	var credentials = files[0].GetFileData()
+	if len(files) > 0 {
+		credentials = files[0].GetFileData()
+	} else if len(impersonate) == 0 {
+		err = errors.New("\"credentials.json\" attachment was not found. Please attach the service account credentials to your record")
+		return
+	}
 	var subject = scimRecord.GetFieldValueByType("login")
 
 	var fields = scimRecord.GetCustomFieldsByLabel("SCIM Group")
@@ -28,6 +41,25 @@ func LoadScimParametersFromRecord(scimRecord *ksm.Record) (ka *ScimEndpointParam
 		ScimGroups:   scimGroups,
 	}
 
+	gcp.ImpersonateServiceAccount = impersonate
+	gcp.TimezoneField = getCustomFieldString(scimRecord, "Timezone Field")
+	gcp.CustomerId = getCustomFieldString(scimRecord, "Customer ID")
+	gcp.Domain = getCustomFieldString(scimRecord, "Domain")
+	if fields := scimRecord.GetCustomFieldsByLabel("Skip Nested Groups"); len(fields) > 0 {
+		if bv, ok := toBoolean(fields[0]["value"]); ok {
+			gcp.SkipNestedGroups = bv
+		}
+	}
+	if fields := scimRecord.GetCustomFieldsByLabel("Excluded Groups"); len(fields) > 0 {
+		gcp.ExcludedGroups = parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))
+	}
+	if gcp.MaxNestedDepth, err = getCustomFieldNonNegativeInt(scimRecord, "Max Nested Depth"); err != nil {
+		return
+	}
+	if gcp.MaxNestedGroups, err = getCustomFieldNonNegativeInt(scimRecord, "Max Nested Groups"); err != nil {
+		return
+	}
+
 	ka = &ScimEndpointParameters{
 		Url:   scimRecord.GetFieldValueByType("url"),
 		Token: scimRecord.Password(),
@@ -42,6 +74,14 @@ func LoadScimParametersFromRecord(scimRecord *ksm.Record) (ka *ScimEndpointParam
 		}
 	}
 
+	fields = scimRecord.GetCustomFieldsByLabel("Trace")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.Trace = bv
+			gcp.Trace = bv
+		}
+	}
+
 	var sv string
 	fields = scimRecord.GetCustomFieldsByLabel("Destructive")
 	if len(fields) > 0 {
@@ -59,5 +99,318 @@ func LoadScimParametersFromRecord(scimRecord *ksm.Record) (ka *ScimEndpointParam
 			}
 		}
 	}
+
+	fields = scimRecord.GetCustomFieldsByLabel("Cache Listings")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.CacheListings = bv
+		}
+	}
+
+	fields = scimRecord.GetCustomFieldsByLabel("Sync Manager")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.SyncManager = bv
+		}
+	}
+
+	if ka.MaxDeletes, err = getCustomFieldNonNegativeInt(scimRecord, "Max Deletes"); err != nil {
+		return
+	}
+	if ka.DeleteGraceDays, err = getCustomFieldNonNegativeInt(scimRecord, "Delete Grace Days"); err != nil {
+		return
+	}
+	if ka.DeleteGraceRuns, err = getCustomFieldNonNegativeInt(scimRecord, "Delete Grace Runs"); err != nil {
+		return
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Resource Paths"); len(fields) > 0 {
+		if ka.Paths, err = ParseScimPaths(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Group Policies"); len(fields) > 0 {
+		if ka.GroupPolicies, err = ParseGroupPolicies(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if ka.ExternalIdPrefix, err = ValidateExternalIdPrefix(getCustomFieldString(scimRecord, "External ID Prefix")); err != nil {
+		return
+	}
+	fields = scimRecord.GetCustomFieldsByLabel("Cooperative")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.Cooperative = bv
+		}
+	}
+	fields = scimRecord.GetCustomFieldsByLabel("Create With Groups")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.CreateWithGroups = bv
+		}
+	}
+	fields = scimRecord.GetCustomFieldsByLabel("Create With Members")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.CreateWithMembers = bv
+		}
+	}
+	if ka.Cooperative && len(ka.ExternalIdPrefix) == 0 {
+		err = errors.New("\"Cooperative\" requires \"External ID Prefix\"")
+		return
+	}
+	if ka.RenameConflicts, err = ParseRenameConflictPolicy(getCustomFieldString(scimRecord, "Rename Conflicts")); err != nil {
+		return
+	}
+	if ka.GroupMatchFallback, err = ParseGroupMatchFallback(getCustomFieldString(scimRecord, "Group Match Fallback")); err != nil {
+		return
+	}
+	if ka.PatchStyle, err = ParsePatchStyle(getCustomFieldString(scimRecord, "Patch Style")); err != nil {
+		return
+	}
+	fields = scimRecord.GetCustomFieldsByLabel("Compress Requests")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.CompressRequests = bv
+		}
+	}
+	if ka.ListParallelism, err = getCustomFieldNonNegativeInt(scimRecord, "List Parallelism"); err != nil {
+		return
+	}
+	fields = scimRecord.GetCustomFieldsByLabel("Probe Dialect")
+	if len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.ProbeDialect = bv
+		}
+	}
+	if ka.MembershipTransaction, err = ParseMembershipTransaction(getCustomFieldString(scimRecord, "Membership Transaction")); err != nil {
+		return
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("User States"); len(fields) > 0 {
+		if ka.UserStates, err = ParseUserStateMapping(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Roles"); len(fields) > 0 {
+		if ka.RoleMapping, err = ParseRoleMapping(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Entitlements"); len(fields) > 0 {
+		if ka.EntitlementMapping, err = ParseEntitlementMapping(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Attributes"); len(fields) > 0 {
+		if ka.Attributes, err = ParseAttributeList(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Ignored Attributes"); len(fields) > 0 {
+		if ka.IgnoredAttributes, err = ParseIgnoredAttributes(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	if ka.UserNameFormat, err = ParseUserNameFormat(getCustomFieldString(scimRecord, "User Name")); err != nil {
+		return
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Allowed Domains"); len(fields) > 0 {
+		ka.AllowedDomains = parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Strict Resolution"); len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.StrictResolution = bv
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Sync Phases"); len(fields) > 0 {
+		if ka.Phases, err = ParseSyncPhases(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+	if fields = scimRecord.GetCustomFieldsByLabel("Phase Order"); len(fields) > 0 {
+		if ka.PhaseOrder, err = ParsePhaseOrder(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+	if fields = scimRecord.GetCustomFieldsByLabel("Sync Caps"); len(fields) > 0 {
+		if ka.SyncCaps, err = ParseSyncCaps(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	ka.StateStore = getCustomFieldString(scimRecord, "State Store")
+	if ka.HistoryRuns, err = getCustomFieldNonNegativeInt(scimRecord, "History Runs"); err != nil {
+		return
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Write Back Status"); len(fields) > 0 {
+		if bv, ok = toBoolean(fields[0]["value"]); ok {
+			ka.WriteBackStatus = bv
+		}
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Result Sinks"); len(fields) > 0 {
+		ka.ResultSinks = parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))
+		if _, err = NewResultSinks(ka.ResultSinks); err != nil {
+			return
+		}
+	}
+	if ka.OutputFormat, err = ParseStatOutput(getCustomFieldString(scimRecord, "Output")); err != nil {
+		return
+	}
+	if ka.OutputLimit, err = getCustomFieldNonNegativeInt(scimRecord, "Output Limit"); err != nil {
+		return
+	}
+	ka.UserNotify = getCustomFieldString(scimRecord, "User Notify")
+	if ka.NotifyTemplates, err = loadNotifyTemplates(map[string]string{
+		NotifyProvisioned:    getCustomFieldString(scimRecord, "User Notify Provisioned"),
+		NotifyDeprovisioning: getCustomFieldString(scimRecord, "User Notify Deprovisioning"),
+	}); err != nil {
+		return
+	}
+	if budgetStr := getCustomFieldString(scimRecord, "Time Budget"); len(budgetStr) > 0 {
+		if ka.TimeBudget, err = time.ParseDuration(budgetStr); err != nil || ka.TimeBudget <= 0 {
+			err = fmt.Errorf("\"Time Budget\" custom field must be a duration, e.g. \"9m\"")
+			return
+		}
+	}
+
+	if ka.UserAgentSuffix, err = ValidateUserAgentSuffix(getCustomFieldString(scimRecord, "User Agent Suffix")); err != nil {
+		return
+	}
+	if ka.TelemetryUrl, err = ValidateTelemetryUrl(getCustomFieldString(scimRecord, "Telemetry URL")); err != nil {
+		return
+	}
+
+	if fields = scimRecord.GetCustomFieldsByLabel("Default Groups"); len(fields) > 0 {
+		ka.DefaultGroups = parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))
+	}
+	ka.DriftReport = getCustomFieldString(scimRecord, "Drift Report")
+	if intervalStr := getCustomFieldString(scimRecord, "Drift Report Interval"); len(intervalStr) > 0 {
+		if ka.DriftReportInterval, err = time.ParseDuration(intervalStr); err != nil || ka.DriftReportInterval <= 0 {
+			err = fmt.Errorf("\"Drift Report Interval\" custom field must be a duration, e.g. \"168h\"")
+			return
+		}
+	}
+	if fields = scimRecord.GetCustomFieldsByLabel("Canary"); len(fields) > 0 {
+		if ka.Canary, err = ParseCanaryScope(parseScimGroupsFromString(strings.Join(ParseScimGroups(fields), "\n"))); err != nil {
+			return
+		}
+	}
+
+	ka.PreSyncHook = getCustomFieldString(scimRecord, "Pre Sync Hook")
+	ka.PostSyncHook = getCustomFieldString(scimRecord, "Post Sync Hook")
+	ka.PolicyUrl = getCustomFieldString(scimRecord, "Policy URL")
+	return
+}
+
+func getCustomFieldNonNegativeInt(scimRecord *ksm.Record, label string) (result int32, err error) {
+	if sv := getCustomFieldString(scimRecord, label); len(sv) > 0 {
+		if iv, er1 := strconv.Atoi(sv); er1 == nil && iv >= 0 {
+			result = int32(iv)
+		} else {
+			err = fmt.Errorf("\"%s\" custom field must be a non-negative number", label)
+		}
+	}
+	return
+}
+
+func getCustomFieldString(scimRecord *ksm.Record, label string) (result string) {
+	var fields = scimRecord.GetCustomFieldsByLabel(label)
+	if len(fields) > 0 {
+		var value = fields[0]["value"]
+		if av, ok := value.([]any); ok {
+			if len(av) > 0 {
+				result, _ = toString(av[0])
+			}
+		} else {
+			result, _ = toString(value)
+		}
+	}
+	result = strings.TrimSpace(result)
+	return
+}
+
+// syncStatusLabel is the custom field of the SCIM record that receives the last sync status
+const syncStatusLabel = "Last Sync Status"
+
+// maxStatusFailures limits the number of failures written to the SCIM record
+const maxStatusFailures = 20
+
+// FormatSyncStatus returns the sync run summary written back to the SCIM record
+func FormatSyncStatus(stat *SyncStat, syncErr error) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Time: %s", time.Now().UTC().Format(time.RFC3339)))
+	if syncErr != nil {
+		lines = append(lines, fmt.Sprintf("Status: failed: %s", syncErr.Error()))
+	} else {
+		lines = append(lines, "Status: success")
+	}
+	if stat == nil {
+		return strings.Join(lines, "\n")
+	}
+	if len(stat.RunId) > 0 {
+		lines = append(lines, fmt.Sprintf("Run ID: %s", stat.RunId))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Groups: %d succeeded, %d failed", len(stat.SuccessGroups), len(stat.FailedGroups)),
+		fmt.Sprintf("Users: %d succeeded, %d failed, %d skipped", len(stat.SuccessUsers), len(stat.FailedUsers), len(stat.SkippedUsers)),
+		fmt.Sprintf("Membership: %d succeeded, %d failed", len(stat.SuccessMembership), len(stat.FailedMembership)))
+	if len(stat.SafeModeReasons) > 0 {
+		lines = append(lines, "Safe Mode: "+strings.Join(stat.SafeModeReasons, "; "))
+	}
+	for _, notice := range stat.Notices {
+		lines = append(lines, "Notice: "+notice)
+	}
+	if len(stat.CanaryDeferred) > 0 {
+		lines = append(lines, fmt.Sprintf("Canary: %d change(s) deferred", len(stat.CanaryDeferred)))
+	}
+	if len(stat.PendingDeletions) > 0 {
+		lines = append(lines, fmt.Sprintf("Pending deletions: %d user(s)", len(stat.PendingDeletions)))
+	}
+	for _, warning := range stat.CapacityWarnings {
+		lines = append(lines, "Capacity: "+warning)
+	}
+	var failures []string
+	failures = append(failures, stat.FailedGroups...)
+	failures = append(failures, stat.FailedUsers...)
+	failures = append(failures, stat.FailedMembership...)
+	if len(failures) > 0 {
+		lines = append(lines, "Failures:")
+		for i, failure := range failures {
+			if i >= maxStatusFailures {
+				lines = append(lines, fmt.Sprintf("  ... and %d more", len(failures)-maxStatusFailures))
+				break
+			}
+			lines = append(lines, "  "+failure)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WriteSyncStatusToRecord stores the sync run summary in "Last Sync Status" custom field of the SCIM record
+func WriteSyncStatusToRecord(sm *ksm.SecretsManager, scimRecord *ksm.Record, stat *SyncStat, syncErr error) (err error) {
+	var status = FormatSyncStatus(stat, syncErr)
+	if fields := scimRecord.GetCustomFieldsByLabel(syncStatusLabel); len(fields) > 0 {
+		fields[0]["value"] = []any{status}
+	} else {
+		var field = ksm.NewMultiline(status)
+		field.Label = syncStatusLabel
+		if err = scimRecord.AddCustomField(field); err != nil {
+			return
+		}
+	}
+	err = sm.Save(scimRecord)
 	return
 }
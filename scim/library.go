@@ -0,0 +1,126 @@
+package scim
+
+import (
+	"errors"
+)
+
+// Config is the configuration of the sync engine embedded in another Go program.
+//
+//	import "github.com/keeper-security/ksm-google-scim/scim"
+//
+//	var config, err = scim.LoadConfigFromEnv()
+//	...
+//	var syncStat, err = scim.Run(config)
+type Config struct {
+	// Scim contains the SCIM endpoint and the sync settings
+	Scim *ScimEndpointParameters
+	// Google contains the Google Workspace endpoint settings. Ignored if Source is set
+	Google *GoogleEndpointParameters
+	// Source replaces the Google Workspace data source
+	Source ICrmDataSource
+	// StateStore replaces the state store of the SCIM parameters, e.g. the store created with NewKeeperStateStore
+	StateStore IStateStore
+}
+
+// LoadConfigFromEnv loads the configuration from environment variables. See LoadScimParametersFromEnv
+func LoadConfigFromEnv() (config *Config, err error) {
+	var ka *ScimEndpointParameters
+	var gcp *GoogleEndpointParameters
+	if ka, gcp, err = LoadScimParametersFromEnv(); err != nil {
+		return
+	}
+	config = &Config{Scim: ka, Google: gcp}
+	return
+}
+
+// NewSync creates the sync engine with every setting of the configuration applied.
+// Options are applied after the configuration, so they take precedence
+func NewSync(config *Config, options ...SyncOption) (sync IScimSync, err error) {
+	if config == nil || config.Scim == nil {
+		err = errors.New("SCIM parameters are not set")
+		return
+	}
+	var ka = config.Scim
+	var source = config.Source
+	if source == nil {
+		if config.Google == nil {
+			err = errors.New("Google Workspace parameters are not set")
+			return
+		}
+		var ge = NewGoogleEndpointWithParameters(config.Google).(*googleEndpoint)
+		ge.userAgentSuffix = ka.UserAgentSuffix
+		source = ge
+	}
+
+	// the settings of the configuration are options too, so the options of the caller take precedence
+	var configured = []SyncOption{WithParameters(ka)}
+	if len(ka.UserNotify) > 0 {
+		var sender IMailSender
+		if sender, err = NewMailSender(ka.UserNotify, source); err != nil {
+			return
+		}
+		configured = append(configured, WithUserNotifier(sender, ka.NotifyTemplates))
+	}
+	if config.StateStore != nil {
+		configured = append(configured, WithStateStore(config.StateStore))
+	} else if len(ka.StateStore) > 0 {
+		var store IStateStore
+		if store, err = NewStateStore(ka.StateStore); err != nil {
+			return
+		}
+		configured = append(configured, WithStateStore(store))
+	}
+	var sinks []IResultSink
+	if sinks, err = NewResultSinks(ka.ResultSinks); err != nil {
+		return
+	}
+	if len(ka.TelemetryUrl) > 0 {
+		sinks = append(sinks, NewTelemetrySink(ka.TelemetryUrl))
+	}
+	configured = append(configured, WithResultSinks(sinks...))
+	if len(ka.PreSyncHook) > 0 {
+		var hook ISyncHook
+		if hook, err = NewSyncHook(ka.PreSyncHook); err != nil {
+			return
+		}
+		configured = append(configured, WithPreSyncHook(hook))
+	}
+	if len(ka.PostSyncHook) > 0 {
+		var hook ISyncHook
+		if hook, err = NewSyncHook(ka.PostSyncHook); err != nil {
+			return
+		}
+		configured = append(configured, WithPostSyncHook(hook))
+	}
+	if len(ka.DriftReport) > 0 {
+		var hook ISyncHook
+		if hook, err = NewSyncHook(ka.DriftReport); err != nil {
+			return
+		}
+		configured = append(configured, WithDriftReportHook(hook, ka.DriftReportInterval))
+	}
+	if len(ka.PolicyUrl) > 0 {
+		var policy IPlanPolicy
+		if policy, err = NewOpaPolicy(ka.PolicyUrl); err != nil {
+			return
+		}
+		configured = append(configured, WithPolicy(policy))
+	}
+
+	sync = NewScimSync(source, ka.Url, ka.Token, append(configured, options...)...)
+
+	if sync.Verbose() {
+		_ = source.TestConnection()
+	}
+	return
+}
+
+// Run creates the sync engine and runs the full sync
+func Run(config *Config, options ...SyncOption) (syncStat *SyncStat, err error) {
+	var sync IScimSync
+	if sync, err = NewSync(config, options...); err != nil {
+		return
+	}
+	syncStat, err = sync.Sync()
+	return
+}
@@ -0,0 +1,164 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// ILogLabeler is implemented by log writers that attach labels to every log entry
+type ILogLabeler interface {
+	SetLabel(key string, value string)
+}
+
+// ConfigureLogFormat switches the standard logger output format.
+// format: "text" (default) or "json". JSON entries follow Cloud Logging structured log format
+func ConfigureLogFormat(format string) (err error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		log.SetOutput(&sanitizingWriter{out: os.Stderr})
+	case "json":
+		log.SetOutput(NewStructuredLogWriter(os.Stderr))
+		log.SetFlags(log.Lshortfile)
+	default:
+		err = fmt.Errorf("log format \"%s\" is not supported. Supported formats are text, json", format)
+	}
+	return
+}
+
+// IsCloudRuntime returns true when running as a Cloud Function or a Cloud Run service
+func IsCloudRuntime() bool {
+	return len(os.Getenv("K_SERVICE")) > 0 || len(os.Getenv("FUNCTION_TARGET")) > 0
+}
+
+var logSourcePrefix = regexp.MustCompile(`^([^\s:]+\.go):(\d+): `)
+
+type structuredLogWriter struct {
+	out    io.Writer
+	lock   gosync.Mutex
+	labels map[string]string
+}
+
+// NewStructuredLogWriter creates log writer that emits every log line as a Cloud Logging JSON entry
+// with severity, labels and source location
+func NewStructuredLogWriter(out io.Writer) io.Writer {
+	return &structuredLogWriter{
+		out:    out,
+		labels: make(map[string]string),
+	}
+}
+
+func (w *structuredLogWriter) SetLabel(key string, value string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(value) > 0 {
+		w.labels[key] = value
+	} else {
+		delete(w.labels, key)
+	}
+}
+
+// logSeverity guesses Cloud Logging severity of the log message
+func logSeverity(message string) string {
+	var lower = strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "error"), strings.Contains(lower, "failed"):
+		return "ERROR"
+	case strings.HasPrefix(lower, "warning"), strings.Contains(lower, "skipped"), strings.Contains(lower, "safe mode"):
+		return "WARNING"
+	}
+	return "INFO"
+}
+
+func (w *structuredLogWriter) Write(p []byte) (n int, err error) {
+	var message = strings.TrimRight(string(p), "\n")
+	var entry = make(map[string]any)
+	if m := logSourcePrefix.FindStringSubmatch(message); m != nil {
+		entry["logging.googleapis.com/sourceLocation"] = map[string]any{
+			"file": m[1],
+			"line": m[2],
+		}
+		message = message[len(m[0]):]
+	}
+	message = SanitizeText(message)
+	entry["severity"] = logSeverity(message)
+	entry["message"] = message
+	if err = w.writeEntry(entry); err != nil {
+		return
+	}
+	n = len(p)
+	return
+}
+
+func (w *structuredLogWriter) writeEntry(entry map[string]any) (err error) {
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.labels) > 0 {
+		var labels = make(map[string]string)
+		for k, v := range w.labels {
+			labels[k] = v
+		}
+		entry["logging.googleapis.com/labels"] = labels
+	}
+	var data []byte
+	if data, err = json.Marshal(entry); err != nil {
+		return
+	}
+	_, err = w.out.Write(append(data, '\n'))
+	return
+}
+
+// ReportError logs the unrecoverable error together with the stack trace where the error was created, if the error carries one.
+// With JSON log format the entry is picked up by Google Error Reporting.
+// If SENTRY_DSN is set, the error is also sent to Sentry with the log labels as tags
+func ReportError(err error) {
+	var message = err.Error()
+	if frames := errorFrames(err); len(frames) > 0 {
+		message += "\n\n" + formatFrames(frames)
+	}
+	if dsn := os.Getenv(sentryDsnEnv); len(dsn) > 0 {
+		if er1 := reportToSentry(dsn, err); er1 != nil {
+			log.Printf("Failed to report the error to Sentry: %s", er1.Error())
+		}
+	}
+	if w, ok := log.Writer().(*structuredLogWriter); ok {
+		var service = os.Getenv("K_SERVICE")
+		if len(service) == 0 {
+			service = os.Getenv("FUNCTION_TARGET")
+		}
+		if len(service) == 0 {
+			service = "ksm-scim"
+		}
+		var entry = map[string]any{
+			"@type":    "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+			"severity": "ERROR",
+			"message":  message,
+			"serviceContext": map[string]any{
+				"service": service,
+			},
+		}
+		if er1 := w.writeEntry(entry); er1 == nil {
+			return
+		}
+	}
+	log.Println(message)
+}
+
+// setLogLabel attaches the label to subsequent log entries if the log output supports labels, and to error reports
+func setLogLabel(key string, value string) {
+	if len(value) > 0 {
+		errorTags.Store(key, value)
+	} else {
+		errorTags.Delete(key)
+	}
+	if labeler, ok := log.Writer().(ILogLabeler); ok {
+		labeler.SetLabel(key, value)
+	}
+}
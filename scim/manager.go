@@ -0,0 +1,114 @@
+package scim
+
+import "fmt"
+
+const enterpriseUserSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+
+// sortUsersByManager orders users so that every manager precedes the users reporting to them
+func sortUsersByManager(users []*User) (sorted []*User) {
+	var lookup = make(map[string]*User)
+	for _, u := range users {
+		lookup[foldEmail(u.Email)] = u
+	}
+	var visited = NewSet[string]()
+	var visit func(*User)
+	visit = func(u *User) {
+		var key = foldEmail(u.Email)
+		if visited.Has(key) {
+			return
+		}
+		visited.Add(key)
+		if len(u.Manager) > 0 {
+			if m, ok := lookup[foldEmail(u.Manager)]; ok {
+				visit(m)
+			}
+		}
+		sorted = append(sorted, u)
+	}
+	for _, u := range users {
+		visit(u)
+	}
+	return
+}
+
+// managerValue returns SCIM enterprise extension value for the manager
+func managerValue(managerId string) map[string]any {
+	return map[string]any{
+		"manager": map[string]any{
+			"value": managerId,
+		},
+	}
+}
+
+// syncManagers sets the enterprise extension manager of every source user
+// to the SCIM user ID of the manager. Runs after all users are created
+func (s *sync) syncManagers() (successes []string, failures []string) {
+	var keeperUserLookup = make(map[string]*scimUser)
+	var managerLookup = make(map[string]*scimUser)
+	for _, v := range s.scimUsers {
+		keeperUserLookup[foldEmail(v.UserName)] = v
+		managerLookup[foldEmail(v.Email)] = v
+	}
+	s.source.Users(func(user *User) {
+		var keeperUser *scimUser
+		var ok bool
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			return
+		}
+		if keeperUser, ok = keeperUserLookup[foldEmail(userName)]; !ok {
+			return
+		}
+		var managerId string
+		if len(user.Manager) > 0 {
+			var manager *scimUser
+			if manager, ok = managerLookup[foldEmail(user.Manager)]; ok {
+				managerId = manager.Id
+			} else {
+				if s.verbose {
+					failures = append(failures, fmt.Sprintf("Set manager of user \"%s\" skipped. Manager \"%s\" is not provisioned", user.Email, user.Manager))
+				}
+				return
+			}
+		}
+		if managerId == keeperUser.ManagerId {
+			return
+		}
+		if !s.inScopeUser(user) {
+			return
+		}
+		if !s.inCanaryUser(user) {
+			s.deferCanary(fmt.Sprintf("set user \"%s\" manager", user.Email))
+			return
+		}
+
+		var operation, inverse map[string]any
+		if len(managerId) > 0 {
+			operation = makePatchOperation("replace", "", map[string]any{enterpriseUserSchema: managerValue(managerId)})
+		} else {
+			operation = map[string]any{"op": "remove", "path": enterpriseUserSchema + ":manager"}
+		}
+		if len(keeperUser.ManagerId) > 0 {
+			inverse = makePatchOperation("replace", "", map[string]any{enterpriseUserSchema: managerValue(keeperUser.ManagerId)})
+		} else {
+			inverse = map[string]any{"op": "remove", "path": enterpriseUserSchema + ":manager"}
+		}
+		if er1 := s.patchResource("Users", keeperUser.Id, makePatchPayload(operation)); er1 == nil {
+			s.recordChange(phaseUsers, "PATCH", "Users", keeperUser.Id, keeperUser.Email, &ScimOperation{
+				Method:       "PATCH",
+				ResourceType: "Users",
+				ResourceId:   keeperUser.Id,
+				Payload:      makePatchPayload(inverse),
+			})
+			keeperUser.ManagerId = managerId
+			if len(managerId) > 0 {
+				successes = append(successes, fmt.Sprintf("SCIM set user \"%s\" manager to \"%s\"", user.Email, user.Manager))
+			} else {
+				successes = append(successes, fmt.Sprintf("SCIM removed user \"%s\" manager", user.Email))
+			}
+		} else {
+			failures = append(failures, fmt.Sprintf("PATCH user \"%s\" manager error: %s", user.Email, er1.Error()))
+		}
+	})
+	return
+}
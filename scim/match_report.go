@@ -0,0 +1,62 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Actions of heuristic match decisions
+const (
+	// MatchActionBind binds the SCIM group to the source group, renaming it if the names differ
+	MatchActionBind = "bind"
+	// MatchActionMerge binds an unmanaged SCIM group with the new name of a renamed source group
+	MatchActionMerge = "merge"
+)
+
+// MatchDecision records why a source group was paired with a SCIM group by a heuristic rather than by external ID
+type MatchDecision struct {
+	Action     string `json:"action"`
+	SourceId   string `json:"sourceId"`
+	SourceName string `json:"sourceName"`
+	ScimId     string `json:"scimId"`
+	ScimName   string `json:"scimName"`
+	// Rule is the matching rule that decided: "name", "overlap" or "position"
+	Rule string `json:"rule"`
+	// Confidence is between 0 and 1: 1 for the same name, the member similarity for "overlap", 0 for "position"
+	Confidence float64 `json:"confidence"`
+	Rationale  string  `json:"rationale"`
+}
+
+func (md *MatchDecision) String() string {
+	return fmt.Sprintf("%s group \"%s\" to SCIM group \"%s\" (%s) by %s, confidence %.2f: %s",
+		md.Action, md.SourceName, md.ScimName, md.ScimId, md.Rule, md.Confidence, md.Rationale)
+}
+
+// recordMatchDecision records the heuristic match of the group. Matches by external ID are not recorded
+func (s *sync) recordMatchDecision(action string, match *groupMatch, sg *scimGroup) {
+	if match.by == matchByExternalId {
+		return
+	}
+	s.matchDecisions = append(s.matchDecisions, &MatchDecision{
+		Action:     action,
+		SourceId:   match.group.Id,
+		SourceName: match.group.Name,
+		ScimId:     sg.Id,
+		ScimName:   sg.Name,
+		Rule:       match.by,
+		Confidence: match.score,
+		Rationale:  match.rationale,
+	})
+}
+
+// sortedMatchDecisions returns the match decisions of the run in a stable order
+func (s *sync) sortedMatchDecisions() (decisions []*MatchDecision) {
+	decisions = append(decisions, s.matchDecisions...)
+	sort.SliceStable(decisions, func(i, j int) bool {
+		if decisions[i].SourceName != decisions[j].SourceName {
+			return decisions[i].SourceName < decisions[j].SourceName
+		}
+		return decisions[i].ScimId < decisions[j].ScimId
+	})
+	return
+}
@@ -0,0 +1,243 @@
+package scim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// How a source resource is matched to a SCIM resource
+const (
+	matchByExternalId = "externalId"
+	matchByName       = "name"
+	matchByPosition   = "position"
+	matchByOverlap    = "overlap"
+)
+
+// Fallbacks that pair source groups and SCIM groups left unmatched by external ID and name
+const (
+	// GroupMatchNone leaves the groups unmatched: source groups are created, SCIM groups are deleted subject to the destructive setting
+	GroupMatchNone = "none"
+	// GroupMatchPosition pairs the groups in sort order and renames the SCIM groups. Pairs are arbitrary; kept for compatibility
+	GroupMatchPosition = "position"
+	// GroupMatchOverlap pairs the groups with the most similar members, "overlap:<threshold>" sets the minimum similarity
+	GroupMatchOverlap = "overlap"
+)
+
+// defaultOverlapThreshold is the minimum Jaccard similarity of members of groups paired by the "overlap" fallback
+const defaultOverlapThreshold = 0.5
+
+// ParseGroupMatchFallback validates the fallback of group matching. Empty fallback is "none".
+// The "overlap" fallback takes an optional threshold between 0 and 1, e.g. "overlap:0.7"
+func ParseGroupMatchFallback(fallback string) (result string, err error) {
+	var name, threshold, hasThreshold = strings.Cut(strings.ToLower(strings.TrimSpace(fallback)), ":")
+	switch {
+	case (name == "" || name == GroupMatchNone) && !hasThreshold:
+		result = GroupMatchNone
+	case name == GroupMatchPosition && !hasThreshold:
+		result = GroupMatchPosition
+	case name == GroupMatchOverlap && !hasThreshold:
+		result = GroupMatchOverlap
+	case name == GroupMatchOverlap:
+		var value, er1 = strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+		if er1 != nil || value <= 0 || value > 1 {
+			err = fmt.Errorf("group match fallback \"%s\": threshold must be a number greater than 0 and not greater than 1", fallback)
+			return
+		}
+		result = GroupMatchOverlap + ":" + strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		err = fmt.Errorf("group match fallback \"%s\" is not supported. Valid fallbacks are none, position, overlap", fallback)
+	}
+	return
+}
+
+// splitGroupMatchFallback returns the name of the validated fallback and the threshold of the "overlap" fallback
+func splitGroupMatchFallback(fallback string) (name string, threshold float64) {
+	var value string
+	name, value, _ = strings.Cut(fallback, ":")
+	threshold = defaultOverlapThreshold
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		threshold = v
+	}
+	return
+}
+
+// groupMatch pairs a source group with a SCIM group
+type groupMatch struct {
+	group     *Group
+	scimGroup *scimGroup
+	by        string
+	// score is the confidence of the match: 1 for external ID and exact name, lower for heuristics
+	score float64
+	// rationale explains a heuristic match
+	rationale string
+}
+
+// userMatch pairs a source user with a SCIM user
+type userMatch struct {
+	user     *User
+	scimUser *scimUser
+}
+
+// scimGroupIndex indexes SCIM groups by external ID and folded name. Groups of a key are kept in stable order
+type scimGroupIndex struct {
+	fold         cases.Caser
+	byExternalId map[string]*scimGroup
+	byName       map[string][]*scimGroup
+	matched      Set[string]
+}
+
+func newScimGroupIndex(scimGroups map[string]*scimGroup) (index *scimGroupIndex) {
+	index = &scimGroupIndex{
+		fold:         cases.Fold(),
+		byExternalId: make(map[string]*scimGroup),
+		byName:       make(map[string][]*scimGroup),
+		matched:      NewSet[string](),
+	}
+	for _, sg := range sortedValues(scimGroups, scimGroupSortKey) {
+		if len(sg.ExternalId) > 0 {
+			if _, ok := index.byExternalId[sg.ExternalId]; !ok {
+				index.byExternalId[sg.ExternalId] = sg
+			}
+		}
+		var name = index.fold.String(sg.Name)
+		index.byName[name] = append(index.byName[name], sg)
+	}
+	return
+}
+
+// findByName returns the first SCIM group with the folded name that is not matched yet
+func (ix *scimGroupIndex) findByName(name string) *scimGroup {
+	for _, sg := range ix.byName[ix.fold.String(name)] {
+		if !ix.matched.Has(sg.Id) {
+			return sg
+		}
+	}
+	return nil
+}
+
+// matchGroups pairs source groups with SCIM groups using the index, without rescanning the groups for every rule.
+// Source groups are matched by external ID first, then by folded name to SCIM groups that are not matched by external ID.
+// The groups left on both sides are paired by the configured fallback; only SCIM groups with an external ID take part in it
+func (s *sync) matchGroups(groups map[string]*Group, scimGroups map[string]*scimGroup) (matches []*groupMatch) {
+	var index = newScimGroupIndex(scimGroups)
+	var sourceGroups = sortedValues(groups, groupSortKey)
+	var matched = NewSet[string]()
+	var pair = func(match *groupMatch) {
+		matched.Add(match.group.Id)
+		index.matched.Add(match.scimGroup.Id)
+		matches = append(matches, match)
+	}
+	for _, group := range sourceGroups {
+		if sg, ok := index.byExternalId[s.externalId(group.Id)]; ok && !index.matched.Has(sg.Id) {
+			pair(&groupMatch{group: group, scimGroup: sg, by: matchByExternalId, score: 1})
+		}
+	}
+	for _, group := range sourceGroups {
+		if matched.Has(group.Id) {
+			continue
+		}
+		if sg := index.findByName(group.Name); sg != nil {
+			var match = &groupMatch{group: group, scimGroup: sg, by: matchByName, score: 1,
+				rationale: fmt.Sprintf("same name \"%s\"", sg.Name)}
+			if sg.Name != group.Name {
+				// names differ in case only
+				match.score = 0.9
+				match.rationale = fmt.Sprintf("case-insensitive name \"%s\"", sg.Name)
+			}
+			if len(sg.ExternalId) > 0 {
+				match.rationale += fmt.Sprintf("; the SCIM group was bound to external ID \"%s\" that is not in the source", sg.ExternalId)
+			} else {
+				match.rationale += "; the SCIM group had no external ID"
+			}
+			pair(match)
+		}
+	}
+	var fallback, threshold = splitGroupMatchFallback(s.groupMatchFallback)
+	if fallback != GroupMatchPosition && fallback != GroupMatchOverlap {
+		return
+	}
+	var leftover []*scimGroup
+	for _, sg := range sortedValues(scimGroups, scimGroupSortKey) {
+		if !index.matched.Has(sg.Id) && len(sg.ExternalId) > 0 {
+			leftover = append(leftover, sg)
+		}
+	}
+	var unmatched []*Group
+	for _, group := range sourceGroups {
+		if !matched.Has(group.Id) {
+			unmatched = append(unmatched, group)
+		}
+	}
+	if len(leftover) == 0 || len(unmatched) == 0 {
+		return
+	}
+	if fallback == GroupMatchOverlap {
+		matches = append(matches, s.matchGroupsByOverlap(unmatched, leftover, threshold)...)
+		return
+	}
+	for i, group := range unmatched {
+		if i >= len(leftover) {
+			break
+		}
+		pair(&groupMatch{group: group, scimGroup: leftover[i], by: matchByPosition,
+			rationale: fmt.Sprintf("unmatched group #%d in alphabetical order on both sides", i+1)})
+	}
+	return
+}
+
+// matchUsers pairs source users with SCIM users by external ID, then by folded user name.
+// Matching by external ID first keeps users matched when the user name format changes; their userName is updated.
+// Matches are returned in the processing order of the users
+func (s *sync) matchUsers(users map[string]*User, scimUsers map[string]*scimUser) (matches []*userMatch) {
+	var byExternalId = make(map[string]*scimUser)
+	for _, su := range sortedValues(scimUsers, scimUserSortKey) {
+		if len(su.ExternalId) > 0 {
+			if _, ok := byExternalId[su.ExternalId]; !ok {
+				byExternalId[su.ExternalId] = su
+			}
+		}
+	}
+	var sortedUsers = sortedValues(users, s.userOrderKey)
+	var matched = make(map[string]*scimUser)
+	var matchedScimUsers = NewSet[string]()
+	for _, user := range sortedUsers {
+		if su, ok := byExternalId[s.externalId(user.Id)]; ok {
+			delete(byExternalId, su.ExternalId)
+			matched[user.Id] = su
+			matchedScimUsers.Add(su.Id)
+		}
+	}
+	var byUserName = make(map[string]*scimUser)
+	for _, su := range sortedValues(scimUsers, scimUserSortKey) {
+		if matchedScimUsers.Has(su.Id) {
+			continue
+		}
+		var key = foldEmail(su.UserName)
+		if _, ok := byUserName[key]; !ok {
+			byUserName[key] = su
+		}
+	}
+	for _, user := range sortedUsers {
+		if _, ok := matched[user.Id]; ok {
+			continue
+		}
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			continue
+		}
+		var key = foldEmail(userName)
+		if su, ok := byUserName[key]; ok {
+			delete(byUserName, key)
+			matched[user.Id] = su
+		}
+	}
+	for _, user := range sortedUsers {
+		if su, ok := matched[user.Id]; ok {
+			matches = append(matches, &userMatch{user: user, scimUser: su})
+		}
+	}
+	return
+}
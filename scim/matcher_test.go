@@ -0,0 +1,264 @@
+package scim
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// staticSource is a data source of fixed users and groups
+type staticSource struct {
+	users  []*User
+	groups []*Group
+	logger SyncDebugLogger
+}
+
+func (ss *staticSource) Users(cb func(*User)) {
+	for _, user := range ss.users {
+		cb(user)
+	}
+}
+func (ss *staticSource) Groups(cb func(*Group)) {
+	for _, group := range ss.groups {
+		cb(group)
+	}
+}
+func (ss *staticSource) TestConnection() error                 { return nil }
+func (ss *staticSource) Populate() error                       { return nil }
+func (ss *staticSource) DebugLogger() SyncDebugLogger          { return ss.logger }
+func (ss *staticSource) SetDebugLogger(logger SyncDebugLogger) { ss.logger = logger }
+func (ss *staticSource) LoadErrors() bool                      { return false }
+func (ss *staticSource) Resolutions() []*EntryResolution       { return nil }
+func (ss *staticSource) groupMap() (groups map[string]*Group)  { return groupsById(ss.groups) }
+func (ss *staticSource) userMap() (users map[string]*User)     { return usersById(ss.users) }
+
+func (ss *staticSource) with(users ...*User) *staticSource {
+	ss.users = users
+	return ss
+}
+func (ss *staticSource) withGroups(groups ...*Group) *staticSource {
+	ss.groups = groups
+	return ss
+}
+
+func groupsById(groups []*Group) (result map[string]*Group) {
+	result = make(map[string]*Group)
+	for _, group := range groups {
+		result[group.Id] = group
+	}
+	return
+}
+
+func usersById(users []*User) (result map[string]*User) {
+	result = make(map[string]*User)
+	for _, user := range users {
+		result[user.Id] = user
+	}
+	return
+}
+
+func testScimGroup(id string, name string, externalId string) *scimGroup {
+	return &scimGroup{Group: Group{Id: id, Name: name}, ExternalId: externalId}
+}
+
+func testScimUser(id string, userName string, externalId string, groups ...string) *scimUser {
+	return &scimUser{User: User{Id: id, Email: userName, Groups: groups}, UserName: userName, ExternalId: externalId}
+}
+
+func scimGroupsById(groups ...*scimGroup) (result map[string]*scimGroup) {
+	result = make(map[string]*scimGroup)
+	for _, sg := range groups {
+		result[sg.Id] = sg
+	}
+	return
+}
+
+func scimUsersById(users ...*scimUser) (result map[string]*scimUser) {
+	result = make(map[string]*scimUser)
+	for _, su := range users {
+		result[su.Id] = su
+	}
+	return
+}
+
+func describeGroupMatches(matches []*groupMatch) (result []string) {
+	for _, match := range matches {
+		result = append(result, fmt.Sprintf("%s>%s %s %.2f", match.group.Id, match.scimGroup.Id, match.by, match.score))
+	}
+	return
+}
+
+func TestMatchGroups(t *testing.T) {
+	var source = new(staticSource).withGroups(
+		&Group{Id: "g1", Name: "Sales"},
+		&Group{Id: "g2", Name: "Engineering"},
+		&Group{Id: "g3", Name: "Marketing"},
+		&Group{Id: "g4", Name: "Support"},
+	).with(
+		&User{Id: "u1", Email: "a@example.com", Groups: []string{"g3"}},
+		&User{Id: "u2", Email: "b@example.com", Groups: []string{"g3"}},
+		&User{Id: "u3", Email: "c@example.com", Groups: []string{"g3", "g4"}},
+	)
+	var scimGroups = scimGroupsById(
+		// bound to the source group by external ID despite the name
+		testScimGroup("s1", "Revenue", "g1"),
+		// has the name of a group matched by external ID
+		testScimGroup("s2", "Sales", ""),
+		testScimGroup("s3", "engineering", "x9"),
+		testScimGroup("s4", "Legacy", "x8"),
+		testScimGroup("s5", "Archive", "x7"),
+		testScimGroup("s6", "No External ID", ""),
+	)
+	var scimUsers = scimUsersById(
+		testScimUser("k1", "a@example.com", "u1", "s5"),
+		testScimUser("k2", "B@example.com", "u2", "s5"),
+		testScimUser("k3", "d@example.com", "", "s4", "s6"),
+	)
+	var tests = []struct {
+		name     string
+		fallback string
+		matches  []string
+	}{
+		{name: "no fallback", fallback: GroupMatchNone,
+			matches: []string{"g1>s1 externalId 1.00", "g2>s3 name 0.90"}},
+		{name: "position fallback", fallback: GroupMatchPosition,
+			matches: []string{"g1>s1 externalId 1.00", "g2>s3 name 0.90", "g3>s5 position 0.00", "g4>s4 position 0.00"}},
+		{name: "overlap fallback", fallback: GroupMatchOverlap,
+			matches: []string{"g1>s1 externalId 1.00", "g2>s3 name 0.90", "g3>s5 overlap 0.67"}},
+		{name: "overlap fallback above similarity", fallback: GroupMatchOverlap + ":0.7",
+			matches: []string{"g1>s1 externalId 1.00", "g2>s3 name 0.90"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = &sync{source: source, scimUsers: scimUsers, groupMatchFallback: tt.fallback}
+			var matches = describeGroupMatches(s.matchGroups(source.groupMap(), scimGroups))
+			if !reflect.DeepEqual(matches, tt.matches) {
+				t.Errorf("matches = %v, want %v", matches, tt.matches)
+			}
+		})
+	}
+}
+
+func TestMatchGroupsExternalIdPrefix(t *testing.T) {
+	var source = new(staticSource).withGroups(&Group{Id: "g1", Name: "Sales"})
+	var scimGroups = scimGroupsById(
+		testScimGroup("s1", "Sales", "g1"),
+		testScimGroup("s2", "Sales Team", "google:g1"),
+	)
+	var s = &sync{source: source, externalIdPrefix: "google:"}
+	var matches = describeGroupMatches(s.matchGroups(source.groupMap(), scimGroups))
+	if want := []string{"g1>s2 externalId 1.00"}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("matches = %v, want %v", matches, want)
+	}
+}
+
+func TestMatchGroupsByOverlap(t *testing.T) {
+	var tests = []struct {
+		name       string
+		users      []*User
+		groups     []*Group
+		scimUsers  []*scimUser
+		scimGroups []*scimGroup
+		threshold  float64
+		matches    []string
+	}{
+		{name: "most similar pairs first",
+			users: []*User{
+				{Id: "u1", Email: "a@example.com", Groups: []string{"g1", "g2"}},
+				{Id: "u2", Email: "b@example.com", Groups: []string{"g1", "g2"}},
+				{Id: "u3", Email: "c@example.com", Groups: []string{"g1"}},
+				{Id: "u4", Email: "d@example.com", Groups: []string{"g1"}},
+			},
+			groups: []*Group{{Id: "g1", Name: "Alpha"}, {Id: "g2", Name: "Beta"}},
+			scimUsers: []*scimUser{
+				testScimUser("k1", "a@example.com", "", "s1", "s2"),
+				testScimUser("k2", "b@example.com", "", "s1", "s2"),
+				testScimUser("k3", "c@example.com", "", "s2"),
+				testScimUser("k4", "d@example.com", "", "s2"),
+			},
+			scimGroups: []*scimGroup{testScimGroup("s1", "Old Beta", "x1"), testScimGroup("s2", "Old Alpha", "x2")},
+			threshold:  0.5,
+			matches:    []string{"g1>s2 overlap 1.00", "g2>s1 overlap 1.00"},
+		},
+		{name: "paired groups are not matched again",
+			users: []*User{
+				{Id: "u1", Email: "a@example.com", Groups: []string{"g1", "g2"}},
+				{Id: "u2", Email: "b@example.com", Groups: []string{"g1", "g2"}},
+				{Id: "u3", Email: "c@example.com", Groups: []string{"g1"}},
+			},
+			groups: []*Group{{Id: "g1", Name: "Alpha"}, {Id: "g2", Name: "Beta"}},
+			scimUsers: []*scimUser{
+				testScimUser("k1", "a@example.com", "", "s1"),
+				testScimUser("k2", "b@example.com", "", "s1"),
+			},
+			scimGroups: []*scimGroup{testScimGroup("s1", "Old", "x1")},
+			threshold:  0.5,
+			matches:    []string{"g2>s1 overlap 1.00"},
+		},
+		{name: "below threshold",
+			users: []*User{
+				{Id: "u1", Email: "a@example.com", Groups: []string{"g1"}},
+				{Id: "u2", Email: "b@example.com", Groups: []string{"g1"}},
+				{Id: "u3", Email: "c@example.com", Groups: []string{"g1"}},
+			},
+			groups: []*Group{{Id: "g1", Name: "Alpha"}},
+			scimUsers: []*scimUser{
+				testScimUser("k1", "a@example.com", "", "s1"),
+				testScimUser("k2", "b@example.com", "", "s1"),
+				testScimUser("k4", "d@example.com", "", "s1"),
+			},
+			scimGroups: []*scimGroup{testScimGroup("s1", "Old", "x1")},
+			threshold:  0.7,
+		},
+		{name: "user names are compared case-insensitively",
+			users:      []*User{{Id: "u1", Email: "A@Example.com", Groups: []string{"g1"}}},
+			groups:     []*Group{{Id: "g1", Name: "Alpha"}},
+			scimUsers:  []*scimUser{testScimUser("k1", "a@example.COM", "", "s1")},
+			scimGroups: []*scimGroup{testScimGroup("s1", "Old", "x1")},
+			threshold:  0.5,
+			matches:    []string{"g1>s1 overlap 1.00"},
+		},
+		{name: "groups without members",
+			groups:     []*Group{{Id: "g1", Name: "Alpha"}},
+			scimGroups: []*scimGroup{testScimGroup("s1", "Old", "x1")},
+			threshold:  0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = &sync{source: new(staticSource).with(tt.users...), scimUsers: scimUsersById(tt.scimUsers...)}
+			var matches = describeGroupMatches(s.matchGroupsByOverlap(tt.groups, tt.scimGroups, tt.threshold))
+			if !reflect.DeepEqual(matches, tt.matches) {
+				t.Errorf("matches = %v, want %v", matches, tt.matches)
+			}
+		})
+	}
+}
+
+func TestMatchUsers(t *testing.T) {
+	var source = new(staticSource).with(
+		&User{Id: "u1", Email: "a@example.com"},
+		&User{Id: "u2", Email: "b@example.com"},
+		&User{Id: "u3", Email: "c@example.com"},
+		&User{Id: "u4", Email: "d@example.com"},
+		&User{Id: "u5", Email: "e@example.com"},
+	)
+	var scimUsers = scimUsersById(
+		// same user name as u1, but u1 is bound to k5 by external ID
+		testScimUser("k1", "a@example.com", ""),
+		testScimUser("k2", "B@Example.com", ""),
+		// user name changed in the source
+		testScimUser("k3", "old-c@example.com", "u3"),
+		// bound to a user that is not in the source
+		testScimUser("k4", "d@example.com", "u9"),
+		testScimUser("k5", "x@example.com", "u1"),
+	)
+	var s = &sync{source: source}
+	var matches []string
+	for _, match := range s.matchUsers(source.userMap(), scimUsers) {
+		matches = append(matches, match.user.Id+">"+match.scimUser.Id)
+	}
+	if want := []string{"u1>k5", "u2>k2", "u3>k3", "u4>k4"}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("matches = %v, want %v", matches, want)
+	}
+}
@@ -0,0 +1,91 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies of membership changes that add and remove groups of the same user
+const (
+	// MembershipTransactionOff sends the additions and the removals in one PATCH request
+	MembershipTransactionOff = "off"
+	// MembershipTransactionAddFirst adds groups, then removes groups. Added groups are removed again if the removal fails
+	MembershipTransactionAddFirst = "add-first"
+	// MembershipTransactionRemoveFirst removes groups, then adds groups. Removed groups are added again if the addition fails
+	MembershipTransactionRemoveFirst = "remove-first"
+)
+
+// ParseMembershipTransaction validates the membership transaction policy. Empty policy is "off"
+func ParseMembershipTransaction(policy string) (result string, err error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", MembershipTransactionOff:
+		result = MembershipTransactionOff
+	case MembershipTransactionAddFirst:
+		result = MembershipTransactionAddFirst
+	case MembershipTransactionRemoveFirst:
+		result = MembershipTransactionRemoveFirst
+	default:
+		err = fmt.Errorf("membership transaction policy \"%s\" is not supported. Valid policies are off, add-first, remove-first", policy)
+	}
+	return
+}
+
+// membershipTransactionEnabled returns true if membership additions and removals are applied as a transaction
+func (s *sync) membershipTransactionEnabled() bool {
+	return s.membershipTx == MembershipTransactionAddFirst || s.membershipTx == MembershipTransactionRemoveFirst
+}
+
+// patchMembership adds or removes groups of the user in a single PATCH request and records the change
+func (s *sync) patchMembership(keeperUser *scimUser, op string, groupIds []string) (err error) {
+	var values []any
+	for _, groupId := range groupIds {
+		values = append(values, map[string]any{"value": groupId})
+	}
+	var inverseOp = "add"
+	if op == "add" {
+		inverseOp = "remove"
+	}
+	if err = s.patchResource("Users", keeperUser.Id, makePatchPayload(makePatchOperation(op, "groups", values))); err != nil {
+		return
+	}
+	s.recordChange(phaseMembership, "PATCH", "Users", keeperUser.Id, keeperUser.Email, &ScimOperation{
+		Method:       "PATCH",
+		ResourceType: "Users",
+		ResourceId:   keeperUser.Id,
+		Payload:      makePatchPayload(makePatchOperation(inverseOp, "groups", values)),
+	})
+	return
+}
+
+// applyMembershipTransaction applies the membership changes of the user as two ordered steps.
+// If the second step fails, the first step is reverted, so the user never keeps a half-applied membership
+// that the next run would misinterpret
+func (s *sync) applyMembershipTransaction(keeperUser *scimUser, addGroups []string, removeGroups []string) (success string, failure string) {
+	var firstOp, secondOp = "add", "remove"
+	var firstGroups, secondGroups = addGroups, removeGroups
+	if s.membershipTx == MembershipTransactionRemoveFirst {
+		firstOp, secondOp = "remove", "add"
+		firstGroups, secondGroups = removeGroups, addGroups
+	}
+	if er1 := s.patchMembership(keeperUser, firstOp, firstGroups); er1 != nil {
+		failure = fmt.Sprintf("PATCH user \"%s\" membership error: %s", keeperUser.Email, er1.Error())
+		return
+	}
+	var er1 = s.patchMembership(keeperUser, secondOp, secondGroups)
+	if er1 == nil {
+		success = fmt.Sprintf("SCIM changed user \"%s\" membership: %d added; %d removed", keeperUser.Email, len(addGroups), len(removeGroups))
+		return
+	}
+	var compensateOp = "remove"
+	if firstOp == "remove" {
+		compensateOp = "add"
+	}
+	if er2 := s.patchMembership(keeperUser, compensateOp, firstGroups); er2 != nil {
+		failure = fmt.Sprintf("PATCH user \"%s\" membership error: %s: %s. Reverting %d group %s(s) failed: %s",
+			keeperUser.Email, secondOp, er1.Error(), len(firstGroups), firstOp, er2.Error())
+	} else {
+		failure = fmt.Sprintf("PATCH user \"%s\" membership error: %s: %s. Reverted %d group %s(s)",
+			keeperUser.Email, secondOp, er1.Error(), len(firstGroups), firstOp)
+	}
+	return
+}
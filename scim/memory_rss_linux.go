@@ -0,0 +1,38 @@
+package scim
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// resetPeakRss resets the peak resident set size of the process, so the peak of every sync run is reported.
+// Kernels and sandboxes that do not support it keep the peak since the process start
+func resetPeakRss() {
+	_ = os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
+
+// peakRss returns the "VmHWM" peak resident set size of the process in bytes
+func peakRss() (size uint64) {
+	var file, err = os.Open("/proc/self/status")
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var name, value, ok = strings.Cut(scanner.Text(), ":")
+		if !ok || name != "VmHWM" {
+			continue
+		}
+		var fields = strings.Fields(value)
+		if len(fields) > 0 {
+			if kb, er1 := strconv.ParseUint(fields[0], 10, 64); er1 == nil {
+				size = kb * 1024
+			}
+		}
+		break
+	}
+	return
+}
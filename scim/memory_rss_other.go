@@ -0,0 +1,11 @@
+//go:build !linux
+
+package scim
+
+// resetPeakRss is not supported on this platform
+func resetPeakRss() {}
+
+// peakRss is not reported on this platform
+func peakRss() uint64 {
+	return 0
+}
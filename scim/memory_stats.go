@@ -0,0 +1,97 @@
+package scim
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// memorySampleInterval is the interval of heap usage samples during a sync run
+const memorySampleInterval = 500 * time.Millisecond
+
+// MemoryStats is the memory usage of a sync run. Sizes are in bytes
+type MemoryStats struct {
+	// PeakRss is the peak resident set size of the process during the run. Zero if the platform does not report it.
+	// It is the peak since the process start where the peak cannot be reset, e.g. on a warm Cloud Function instance
+	PeakRss uint64 `json:"peakRss,omitempty"`
+	// PeakHeap is the highest sampled size of the heap in use
+	PeakHeap uint64 `json:"peakHeap"`
+	// TotalAlloc is the size of heap objects allocated during the run
+	TotalAlloc uint64 `json:"totalAlloc"`
+	// NumGC is the number of garbage collections during the run
+	NumGC uint32 `json:"numGc"`
+}
+
+func (ms *MemoryStats) String() string {
+	var text = fmt.Sprintf("peak heap %s, allocated %s, %d GC cycle(s)", formatBytes(ms.PeakHeap), formatBytes(ms.TotalAlloc), ms.NumGC)
+	if ms.PeakRss > 0 {
+		text = fmt.Sprintf("peak RSS %s, %s", formatBytes(ms.PeakRss), text)
+	}
+	return text
+}
+
+func formatBytes(size uint64) string {
+	const mib = 1024 * 1024
+	if size >= mib {
+		return fmt.Sprintf("%.1f MiB", float64(size)/mib)
+	}
+	return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+}
+
+// memoryMonitor samples the heap usage of a sync run
+type memoryMonitor struct {
+	stop       chan struct{}
+	done       chan struct{}
+	peakHeap   uint64
+	startAlloc uint64
+	startGC    uint32
+}
+
+// startMemoryMonitor resets the peak RSS of the process, if supported, and starts sampling the heap
+func startMemoryMonitor() (mm *memoryMonitor) {
+	resetPeakRss()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	mm = &memoryMonitor{
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		peakHeap:   ms.HeapInuse,
+		startAlloc: ms.TotalAlloc,
+		startGC:    ms.NumGC,
+	}
+	go func() {
+		defer close(mm.done)
+		var ticker = time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mm.stop:
+				return
+			case <-ticker.C:
+				var sample runtime.MemStats
+				runtime.ReadMemStats(&sample)
+				if sample.HeapInuse > mm.peakHeap {
+					mm.peakHeap = sample.HeapInuse
+				}
+			}
+		}
+	}()
+	return
+}
+
+// finish stops sampling and returns the memory usage of the run
+func (mm *memoryMonitor) finish() *MemoryStats {
+	close(mm.stop)
+	<-mm.done
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapInuse > mm.peakHeap {
+		mm.peakHeap = ms.HeapInuse
+	}
+	return &MemoryStats{
+		PeakRss:    peakRss(),
+		PeakHeap:   mm.peakHeap,
+		TotalAlloc: ms.TotalAlloc - mm.startAlloc,
+		NumGC:      ms.NumGC - mm.startGC,
+	}
+}
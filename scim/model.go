@@ -0,0 +1,109 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ModelVersion is the version of the User and Group model. Data sources set it to the version they populate,
+// so the mapping layer can tell a missing attribute from an attribute the data source does not know.
+// Version 2 adds the Attributes bag. Zero means version 1
+const ModelVersion = 2
+
+// Well-known source attributes kept in the Attributes bag
+const (
+	SourceAttributeOrgUnitPath = "orgUnitPath"
+	SourceAttributeIsAdmin     = "isAdmin"
+	SourceAttributeDepartment  = "department"
+	SourceAttributeTitle       = "title"
+	SourceAttributeCostCenter  = "costCenter"
+	SourceAttributeEmail       = "email"
+	SourceAttributeDescription = "description"
+)
+
+// Attribute returns the source attribute of the user
+func (u *User) Attribute(name string) (value any, ok bool) {
+	value, ok = u.Attributes[name]
+	return
+}
+
+// AttributeString returns the source attribute of the user as a string. Returns an empty string if the attribute is missing
+func (u *User) AttributeString(name string) string {
+	return attributeString(u.Attributes, name)
+}
+
+// SetAttribute stores the source attribute of the user
+func (u *User) SetAttribute(name string, value any) {
+	if u.Attributes == nil {
+		u.Attributes = make(map[string]any)
+	}
+	u.Attributes[name] = value
+}
+
+// Attribute returns the source attribute of the group
+func (g *Group) Attribute(name string) (value any, ok bool) {
+	value, ok = g.Attributes[name]
+	return
+}
+
+// AttributeString returns the source attribute of the group as a string. Returns an empty string if the attribute is missing
+func (g *Group) AttributeString(name string) string {
+	return attributeString(g.Attributes, name)
+}
+
+// SetAttribute stores the source attribute of the group
+func (g *Group) SetAttribute(name string, value any) {
+	if g.Attributes == nil {
+		g.Attributes = make(map[string]any)
+	}
+	g.Attributes[name] = value
+}
+
+func attributeString(attributes map[string]any, name string) string {
+	var value, ok = attributes[name]
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := toString(value); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// googleUserAttributes copies Google user properties without a dedicated User field into the Attributes bag.
+// Custom schema fields are stored as "SchemaName.FieldName"
+func googleUserAttributes(su *User, orgUnitPath string, isAdmin bool, organizations any, schemas map[string]googleapi.RawMessage) {
+	if len(orgUnitPath) > 0 {
+		su.SetAttribute(SourceAttributeOrgUnitPath, orgUnitPath)
+	}
+	su.SetAttribute(SourceAttributeIsAdmin, isAdmin)
+	if orgs, ok := organizations.([]any); ok {
+		var organization map[string]any
+		for _, o := range orgs {
+			if org, ok := o.(map[string]any); ok {
+				if organization == nil {
+					organization = org
+				}
+				if primary, _ := toBoolean(org["primary"]); primary {
+					organization = org
+					break
+				}
+			}
+		}
+		for _, name := range []string{SourceAttributeDepartment, SourceAttributeTitle, SourceAttributeCostCenter} {
+			if value, _ := toString(organization[name]); len(value) > 0 {
+				su.SetAttribute(name, value)
+			}
+		}
+	}
+	for schema, raw := range schemas {
+		var values map[string]any
+		if err := json.Unmarshal(raw, &values); err == nil {
+			for field, value := range values {
+				su.SetAttribute(schema+"."+field, value)
+			}
+		}
+	}
+}
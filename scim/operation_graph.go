@@ -0,0 +1,204 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyncStepManagers sets the manager of provisioned users. It runs as part of the users phase
+const SyncStepManagers = "managers"
+
+// defaultStepOrder is the execution order of sync steps that do not depend on each other
+var defaultStepOrder = []string{SyncPhaseGroups, SyncPhaseUsers, SyncStepManagers, SyncPhaseMembership, SyncPhaseRoles}
+
+// ParsePhaseOrder parses the preferred execution order of sync steps separated by comma or new line.
+// Steps that are not listed run after the listed ones in the default order
+func ParsePhaseOrder(entries []string) (order []string, err error) {
+	var found = NewSet[string]()
+	for _, entry := range entries {
+		var step = strings.ToLower(strings.TrimSpace(entry))
+		if len(step) == 0 {
+			continue
+		}
+		switch step {
+		case SyncPhaseGroups, SyncPhaseUsers, SyncStepManagers, SyncPhaseMembership, SyncPhaseRoles:
+			if !found.Has(step) {
+				found.Add(step)
+				order = append(order, step)
+			}
+		default:
+			err = fmt.Errorf("sync step \"%s\" is not supported. Valid steps are groups, users, managers, membership, roles", entry)
+			return
+		}
+	}
+	return
+}
+
+// operationGraph is the dependency graph of the steps of a sync run
+type operationGraph struct {
+	steps []string
+	// dependsOn lists the steps that must complete before the step runs
+	dependsOn map[string][]string
+}
+
+// operationGraph builds the dependency graph of the enabled sync steps:
+//   - membership needs the SCIM IDs of groups and users, so it runs after both
+//   - managers and roles reference SCIM users, so they run after users. Within users, managers are created before their reports
+//   - users created with their groups need the groups, so users run after groups
+//   - groups created with their members need the users, so groups run after users unless users are created with groups
+func (s *sync) operationGraph(syncUsers bool) (graph *operationGraph) {
+	graph = &operationGraph{
+		dependsOn: make(map[string][]string),
+	}
+	var enabled = NewSet[string]()
+	if s.phaseEnabled(SyncPhaseGroups) {
+		enabled.Add(SyncPhaseGroups)
+	}
+	if syncUsers {
+		enabled.Add(SyncPhaseUsers)
+		if s.syncManager {
+			enabled.Add(SyncStepManagers)
+		}
+	}
+	if s.phaseEnabled(SyncPhaseMembership) {
+		enabled.Add(SyncPhaseMembership)
+	}
+	if s.rolesEnabled() {
+		enabled.Add(SyncPhaseRoles)
+	}
+	var depend = func(step string, dependency string) {
+		if enabled.Has(step) && enabled.Has(dependency) {
+			graph.dependsOn[step] = append(graph.dependsOn[step], dependency)
+		}
+	}
+	depend(SyncStepManagers, SyncPhaseUsers)
+	depend(SyncPhaseMembership, SyncPhaseGroups)
+	depend(SyncPhaseMembership, SyncPhaseUsers)
+	depend(SyncPhaseRoles, SyncPhaseUsers)
+	if s.createWithGroups {
+		depend(SyncPhaseUsers, SyncPhaseGroups)
+	} else if s.createWithMembers {
+		depend(SyncPhaseGroups, SyncPhaseUsers)
+	} else {
+		// groups are deleted before users, as without the dependency graph
+		depend(SyncPhaseUsers, SyncPhaseGroups)
+	}
+	for _, step := range defaultStepOrder {
+		if enabled.Has(step) {
+			graph.steps = append(graph.steps, step)
+		}
+	}
+	return
+}
+
+// order sorts the steps topologically. Among the steps ready to run, the step listed first in preferred runs first.
+// Dependencies of a listed step run before it even if they are not listed.
+// Returns an error if preferred lists a step before a listed step it depends on
+func (g *operationGraph) order(preferred []string) (ordered []string, err error) {
+	var priority = make(map[string]int)
+	var listed = NewSet[string]()
+	for i, step := range preferred {
+		priority[step] = i
+		listed.Add(step)
+	}
+	for i, step := range g.steps {
+		if _, ok := priority[step]; !ok {
+			priority[step] = len(preferred) + i
+		}
+	}
+	var done = NewSet[string]()
+	for len(ordered) < len(g.steps) {
+		var next string
+		for _, step := range g.steps {
+			if done.Has(step) {
+				continue
+			}
+			var ready = true
+			for _, dependency := range g.dependsOn[step] {
+				if !done.Has(dependency) {
+					ready = false
+					break
+				}
+			}
+			if ready && (len(next) == 0 || priority[step] < priority[next]) {
+				next = step
+			}
+		}
+		if len(next) == 0 {
+			err = fmt.Errorf("sync steps %s depend on each other", strings.Join(g.pending(done), ", "))
+			return
+		}
+		if listed.Has(next) {
+			for _, step := range g.steps {
+				if done.Has(step) || step == next || !listed.Has(step) || priority[step] > priority[next] {
+					continue
+				}
+				for _, dependency := range g.dependsOn[step] {
+					if !done.Has(dependency) {
+						err = fmt.Errorf("sync step order: \"%s\" must run after \"%s\"", step, dependency)
+						return
+					}
+				}
+			}
+		}
+		done.Add(next)
+		ordered = append(ordered, next)
+	}
+	return
+}
+
+func (g *operationGraph) pending(done Set[string]) (steps []string) {
+	for _, step := range g.steps {
+		if !done.Has(step) {
+			steps = append(steps, step)
+		}
+	}
+	return
+}
+
+// runStep executes a single step of the sync run and adds its outcome to the statistics
+func (s *sync) runStep(step string, syncStat *SyncStat) (err error) {
+	switch step {
+	case SyncPhaseGroups:
+		s.debugLogger("Synchronize groups")
+		var successes, failures []string
+		if successes, failures, err = s.syncGroups(); err != nil {
+			return
+		}
+		syncStat.SuccessGroups = append(syncStat.SuccessGroups, successes...)
+		syncStat.FailedGroups = append(syncStat.FailedGroups, failures...)
+	case SyncPhaseUsers:
+		s.debugLogger("Synchronize users")
+		var successes, failures []string
+		var skipped []*SkippedUser
+		if successes, failures, skipped, err = s.syncUsers(); err != nil {
+			return
+		}
+		syncStat.SuccessUsers = append(syncStat.SuccessUsers, successes...)
+		syncStat.FailedUsers = append(syncStat.FailedUsers, failures...)
+		syncStat.SkippedUsers = append(syncStat.SkippedUsers, skipped...)
+	case SyncStepManagers:
+		s.debugLogger("Synchronize managers")
+		var successes, failures = s.syncManagers()
+		syncStat.SuccessUsers = append(syncStat.SuccessUsers, successes...)
+		syncStat.FailedUsers = append(syncStat.FailedUsers, failures...)
+	case SyncPhaseMembership:
+		// users created without ID in the response are looked up again, so they get their memberships in this run
+		syncStat.Notices = append(syncStat.Notices, s.resolveCreatedUsers()...)
+		s.debugLogger("Synchronize membership")
+		s.capacityRejects = make(map[string]int)
+		var successes, failures []string
+		if successes, failures, err = s.syncMembership(); err != nil {
+			return
+		}
+		syncStat.SuccessMembership = append(syncStat.SuccessMembership, successes...)
+		syncStat.FailedMembership = append(syncStat.FailedMembership, failures...)
+		syncStat.CapacityWarnings = s.capacityWarnings()
+	case SyncPhaseRoles:
+		s.debugLogger("Synchronize roles")
+		var successes, failures = s.syncRoles()
+		syncStat.SuccessUsers = append(syncStat.SuccessUsers, successes...)
+		syncStat.FailedUsers = append(syncStat.FailedUsers, failures...)
+	}
+	return
+}
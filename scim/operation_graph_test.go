@@ -0,0 +1,98 @@
+package scim
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOperationGraphOrder(t *testing.T) {
+	var tests = []struct {
+		name      string
+		s         *sync
+		syncUsers bool
+		preferred []string
+		ordered   []string
+		wantErr   string
+	}{
+		{name: "default", s: &sync{syncManager: true, roleMapping: RoleMapping{"admins": {"Admin"}}}, syncUsers: true,
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncStepManagers, SyncPhaseMembership, SyncPhaseRoles}},
+		{name: "without managers and roles", s: &sync{}, syncUsers: true,
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership}},
+		{name: "users not synced", s: &sync{syncManager: true}, syncUsers: false,
+			ordered: []string{SyncPhaseGroups, SyncPhaseMembership}},
+		{name: "groups created with members", s: &sync{createWithMembers: true, syncManager: true}, syncUsers: true,
+			ordered: []string{SyncPhaseUsers, SyncPhaseGroups, SyncStepManagers, SyncPhaseMembership}},
+		{name: "users created with groups", s: &sync{createWithGroups: true, createWithMembers: true}, syncUsers: true,
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership}},
+		{name: "groups unsupported", s: &sync{groupsUnsupported: true, syncManager: true}, syncUsers: true,
+			ordered: []string{SyncPhaseUsers, SyncStepManagers}},
+		{name: "enabled phases", s: &sync{phases: []string{SyncPhaseUsers}, syncManager: true}, syncUsers: true,
+			ordered: []string{SyncPhaseUsers, SyncStepManagers}},
+		{name: "preferred step runs when ready", s: &sync{syncManager: true}, syncUsers: true, preferred: []string{SyncPhaseMembership},
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership, SyncStepManagers}},
+		{name: "dependencies of preferred step run first", s: &sync{createWithMembers: true, syncManager: true, roleMapping: RoleMapping{"admins": {"Admin"}}},
+			syncUsers: true, preferred: []string{SyncPhaseRoles},
+			ordered: []string{SyncPhaseUsers, SyncPhaseRoles, SyncPhaseGroups, SyncStepManagers, SyncPhaseMembership}},
+		{name: "preferred steps in dependency order", s: &sync{}, syncUsers: true, preferred: []string{SyncPhaseGroups, SyncPhaseUsers},
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership}},
+		{name: "preferred steps against dependency", s: &sync{}, syncUsers: true, preferred: []string{SyncPhaseUsers, SyncPhaseGroups},
+			wantErr: "sync step order: \"users\" must run after \"groups\""},
+		{name: "disabled preferred step", s: &sync{}, syncUsers: true, preferred: []string{SyncPhaseRoles},
+			ordered: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ordered, err = tt.s.operationGraph(tt.syncUsers).order(tt.preferred)
+			if len(tt.wantErr) > 0 {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(ordered, tt.ordered) {
+				t.Errorf("order = %v, want %v", ordered, tt.ordered)
+			}
+		})
+	}
+}
+
+func TestOperationGraphOrderCycle(t *testing.T) {
+	var graph = &operationGraph{
+		steps: []string{SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership},
+		dependsOn: map[string][]string{
+			SyncPhaseGroups: {SyncPhaseUsers},
+			SyncPhaseUsers:  {SyncPhaseGroups},
+		},
+	}
+	var _, err = graph.order(nil)
+	if err == nil || err.Error() != "sync steps groups, users depend on each other" {
+		t.Fatalf("error = %v, want cycle error", err)
+	}
+}
+
+func TestParsePhaseOrder(t *testing.T) {
+	var tests = []struct {
+		name    string
+		entries []string
+		order   []string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "normalized", entries: []string{" Roles ", "", "users", "roles"}, order: []string{SyncPhaseRoles, SyncPhaseUsers}},
+		{name: "unknown step", entries: []string{"users", "photos"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var order, err = ParsePhaseOrder(tt.entries)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("order = %v, want %v", order, tt.order)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// isRejectedPatch returns true if the SCIM server rejected the content of the PATCH request,
+// so the attributes of the request may succeed one at a time
+func isRejectedPatch(err error) bool {
+	switch scimStatus(err) {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// patchUserAttributes applies the attributes of a rejected multi-attribute PATCH one attribute per request.
+// applied are the attributes the server accepted; every rejected attribute is reported with its name
+func (s *sync) patchUserAttributes(keeperUser *scimUser, email string, value map[string]any, inverse map[string]any) (applied Set[string], failures []string) {
+	applied = NewSet[string]()
+	var keys = make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var operation = makePatchOperation("replace", "", map[string]any{key: value[key]})
+		if er1 := s.patchResource("Users", keeperUser.Id, makePatchPayload(operation)); er1 != nil {
+			failures = append(failures, fmt.Sprintf("PATCH user \"%s\" attribute \"%s\" error: %s", email, key, er1.Error()))
+			continue
+		}
+		s.recordChange(phaseUsers, "PATCH", "Users", keeperUser.Id, email, &ScimOperation{
+			Method:       "PATCH",
+			ResourceType: "Users",
+			ResourceId:   keeperUser.Id,
+			Payload:      makePatchPayload(makePatchOperation("replace", "", map[string]any{key: inverse[key]})),
+		})
+		applied.Add(key)
+	}
+	return
+}
+
+// copyAppliedAttributes updates the SCIM user with the source attributes the server accepted.
+// Optional attributes are copied only if all of them were accepted; the rest is retried in the next run
+func (s *sync) copyAppliedAttributes(user *User, keeperUser *scimUser, value map[string]any, applied Set[string]) {
+	var optional = true
+	for key := range value {
+		switch key {
+		case "userName":
+			if applied.Has(key) {
+				keeperUser.UserName = s.userName(user)
+			}
+		case "externalId":
+			if applied.Has(key) {
+				keeperUser.ExternalId = s.externalId(user.Id)
+			}
+		case "displayName":
+			if applied.Has(key) {
+				keeperUser.FullName = user.FullName
+			}
+		case "name.givenName":
+			if applied.Has(key) {
+				keeperUser.FirstName = user.FirstName
+			}
+		case "name.familyName":
+			if applied.Has(key) {
+				keeperUser.LastName = user.LastName
+			}
+		case "active":
+			if applied.Has(key) {
+				keeperUser.Active = user.Active
+			}
+		default:
+			if !applied.Has(key) {
+				optional = false
+			}
+		}
+	}
+	if optional {
+		s.copyUserAttributes(user, keeperUser)
+	}
+}
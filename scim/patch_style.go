@@ -0,0 +1,86 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Styles of SCIM PATCH operations
+const (
+	// PatchStyleValue sends attributes in the value map of a single operation without path, e.g. {"name.givenName": "John"}
+	PatchStyleValue = "value"
+	// PatchStylePath sends an operation with a path per attribute, e.g. {"path": "name.givenName", "value": "John"}
+	PatchStylePath = "path"
+	// PatchStyleAuto sends value maps and switches to path operations if the server rejects a value map that it accepts as paths
+	PatchStyleAuto = "auto"
+)
+
+// ParsePatchStyle validates the PATCH style. Empty style is "value"
+func ParsePatchStyle(style string) (result string, err error) {
+	switch strings.ToLower(strings.TrimSpace(style)) {
+	case "", PatchStyleValue:
+		result = PatchStyleValue
+	case PatchStylePath:
+		result = PatchStylePath
+	case PatchStyleAuto:
+		result = PatchStyleAuto
+	default:
+		err = fmt.Errorf("PATCH style \"%s\" is not supported. Valid styles are value, path, auto", style)
+	}
+	return
+}
+
+// usePathPatch returns true if PATCH requests are sent as path operations
+func (s *sync) usePathPatch() bool {
+	return s.patchStyle == PatchStylePath || s.dialect.PathPatch
+}
+
+// toPathPatch converts operations without path into one operation per attribute of the value map.
+// Attributes of an extension schema get the path "<schema URN>:<attribute>". Returns nil if there is nothing to convert
+func toPathPatch(payload any) map[string]any {
+	var patch, ok = payload.(map[string]any)
+	if !ok {
+		return nil
+	}
+	var operations = patchOperations(patch)
+	var converted []any
+	var changed bool
+	for _, operation := range operations {
+		var value, isMap = operation["value"].(map[string]any)
+		if _, hasPath := operation["path"]; hasPath || !isMap {
+			converted = append(converted, operation)
+			continue
+		}
+		changed = true
+		var op = operation["op"]
+		var keys = make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if extension, ok := value[key].(map[string]any); ok && strings.HasPrefix(key, "urn:") {
+				var names = make([]string, 0, len(extension))
+				for name := range extension {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					converted = append(converted, map[string]any{"op": op, "path": key + ":" + name, "value": extension[name]})
+				}
+				continue
+			}
+			converted = append(converted, map[string]any{"op": op, "path": key, "value": value[key]})
+		}
+	}
+	if !changed {
+		return nil
+	}
+	var result = make(map[string]any)
+	for k, v := range patch {
+		result[k] = v
+	}
+	result["Operations"] = converted
+	return result
+}
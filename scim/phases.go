@@ -0,0 +1,55 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phases of a sync run
+const (
+	SyncPhaseGroups     = "groups"
+	SyncPhaseUsers      = "users"
+	SyncPhaseMembership = "membership"
+	// SyncPhaseRoles sets SCIM roles of users from the role mapping. Runs only if the role mapping is configured
+	SyncPhaseRoles = "roles"
+)
+
+// ParseSyncPhases parses phase names separated by comma or new line.
+// Returns nil if no phase is listed, i.e. all phases run
+func ParseSyncPhases(entries []string) (phases []string, err error) {
+	var found = NewSet[string]()
+	for _, entry := range entries {
+		var phase = strings.ToLower(strings.TrimSpace(entry))
+		if len(phase) == 0 {
+			continue
+		}
+		switch phase {
+		case SyncPhaseGroups, SyncPhaseUsers, SyncPhaseMembership, SyncPhaseRoles:
+			if !found.Has(phase) {
+				found.Add(phase)
+				phases = append(phases, phase)
+			}
+		default:
+			err = fmt.Errorf("sync phase \"%s\" is not supported. Valid phases are groups, users, membership, roles", entry)
+			return
+		}
+	}
+	return
+}
+
+// phaseEnabled returns true if the phase runs. All phases run if no phase is configured.
+// Groups and membership phases never run if the SCIM server does not support groups
+func (s *sync) phaseEnabled(phase string) bool {
+	if s.groupsUnsupported && (phase == SyncPhaseGroups || phase == SyncPhaseMembership) {
+		return false
+	}
+	if len(s.phases) == 0 {
+		return true
+	}
+	for _, p := range s.phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package scim
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// IPhotoSource is implemented by data sources that can provide user profile photos
+type IPhotoSource interface {
+	UserPhoto(user *User) (mimeType string, data []byte, err error)
+}
+
+// photoEtagsKey stores Google photo etags already pushed to SCIM, keyed by SCIM user ID
+const photoEtagsKey = "photo-etags"
+
+func loadPhotoEtags(store IStateStore) (etags map[string]string, err error) {
+	var data []byte
+	if data, err = store.Load(photoEtagsKey); err != nil {
+		return
+	}
+	etags = make(map[string]string)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &etags)
+	}
+	return
+}
+
+func savePhotoEtags(store IStateStore, etags map[string]string) (err error) {
+	var data []byte
+	if data, err = json.Marshal(etags); err != nil {
+		return
+	}
+	err = store.Save(photoEtagsKey, data)
+	return
+}
+
+// userPhotoValue fetches the user photo and returns SCIM "photos" attribute value
+func (s *sync) userPhotoValue(user *User) (value []any, err error) {
+	value = []any{}
+	if len(user.PhotoEtag) == 0 {
+		return
+	}
+	var photoSource, ok = s.source.(IPhotoSource)
+	if !ok {
+		err = errors.New("data source does not support user photos")
+		return
+	}
+	var mimeType string
+	var data []byte
+	if mimeType, data, err = photoSource.UserPhoto(user); err != nil {
+		return
+	}
+	if len(mimeType) == 0 {
+		mimeType = "image/jpeg"
+	}
+	value = append(value, map[string]any{
+		"value":   fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)),
+		"type":    "photo",
+		"primary": true,
+	})
+	return
+}
+
+// diffUserPhoto adds "photos" to PATCH value if the source photo etag differs from the one pushed last time.
+// The inverse restores the photos the SCIM user had before the change
+func (s *sync) diffUserPhoto(user *User, keeperUser *scimUser, pushedEtag string, value map[string]any, inverse map[string]any) (changed bool, err error) {
+	if user.PhotoEtag == pushedEtag {
+		return
+	}
+	var photos []any
+	if photos, err = s.userPhotoValue(user); err != nil {
+		return
+	}
+	value[AttributePhotos] = photos
+	if keeperUser.Photos != nil {
+		inverse[AttributePhotos] = keeperUser.Photos
+	} else {
+		inverse[AttributePhotos] = []any{}
+	}
+	changed = true
+	return
+}
@@ -0,0 +1,458 @@
+package scim
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SyncPlan contains the projected changes of a sync run
+type SyncPlan struct {
+	RunId         string
+	UsersInScope  int
+	GroupsInScope int
+	UserCreates   int
+	UserUpdates   int
+	UserDeletes   int
+	GroupCreates  int
+	GroupUpdates  int
+	GroupDeletes  int
+	// MembershipAdds and MembershipRemoves are projected group memberships of existing users
+	MembershipAdds    int
+	MembershipRemoves int
+	// RoleChanges are projected role changes of existing users
+	RoleChanges int
+	Changes     []*PlannedChange
+}
+
+// Actions of planned changes
+const (
+	PlanActionCreate = "create"
+	PlanActionUpdate = "update"
+	PlanActionDelete = "delete"
+	// PlanActionMembership changes group memberships of an existing user
+	PlanActionMembership = "membership"
+	// PlanActionRoles changes roles of an existing user
+	PlanActionRoles = "roles"
+)
+
+// PlannedChange is a projected change of a single SCIM resource.
+// Before and After contain SCIM attributes: all attributes for creates and deletes, changed attributes for updates
+type PlannedChange struct {
+	ResourceType string
+	Action       string
+	Id           string
+	ExternalId   string
+	Name         string
+	Before       map[string]any
+	After        map[string]any
+}
+
+func (sp *SyncPlan) String() string {
+	return fmt.Sprintf("%d user(s) in scope, %d group(s) in scope. Projected users: %d create(s), %d update(s), %d delete(s). Projected groups: %d create(s), %d update(s), %d delete(s). Projected memberships: %d add(s), %d remove(s). Projected roles: %d change(s)",
+		sp.UsersInScope, sp.GroupsInScope, sp.UserCreates, sp.UserUpdates, sp.UserDeletes, sp.GroupCreates, sp.GroupUpdates, sp.GroupDeletes,
+		sp.MembershipAdds, sp.MembershipRemoves, sp.RoleChanges)
+}
+
+// SimulatedDestructiveLevels are the destructive levels compared by Simulate: Safe Mode, partial and full
+var SimulatedDestructiveLevels = []int32{-1, 0, 1}
+
+// SimulatedPlan is the sync plan projected under a destructive level
+type SimulatedPlan struct {
+	Destructive int32
+	Plan        *SyncPlan
+}
+
+// Simulation contains sync plans of the same source and SCIM data under different destructive levels
+type Simulation struct {
+	Plans []*SimulatedPlan
+	// SafeModeReasons are set if the data source reported load errors. A sync run would be forced to the Safe Mode
+	SafeModeReasons []string
+}
+
+// SyncCaps are upper limits of the sync plan. Zero means no limit
+type SyncCaps struct {
+	// Users limits users in scope, e.g. to the number of purchased licenses
+	Users   int32
+	Creates int32
+	Updates int32
+	Deletes int32
+}
+
+// ParseSyncCaps parses "name=limit" entries separated by comma or new line.
+// Name is one of "users", "creates", "updates", "deletes"
+func ParseSyncCaps(entries []string) (caps *SyncCaps, err error) {
+	for _, entry := range entries {
+		var pos = strings.Index(entry, "=")
+		if pos <= 0 {
+			err = fmt.Errorf("sync cap \"%s\" is not in \"name=limit\" format", entry)
+			return
+		}
+		var limit int
+		if limit, err = strconv.Atoi(strings.TrimSpace(entry[pos+1:])); err != nil || limit < 0 {
+			err = fmt.Errorf("sync cap \"%s\": limit must be a non-negative number", entry)
+			return
+		}
+		if caps == nil {
+			caps = new(SyncCaps)
+		}
+		switch strings.ToLower(strings.TrimSpace(entry[:pos])) {
+		case "users":
+			caps.Users = int32(limit)
+		case "creates":
+			caps.Creates = int32(limit)
+		case "updates":
+			caps.Updates = int32(limit)
+		case "deletes":
+			caps.Deletes = int32(limit)
+		default:
+			err = fmt.Errorf("sync cap \"%s\": unsupported name. Valid names are users, creates, updates, deletes", entry)
+			return
+		}
+	}
+	return
+}
+
+// planSync projects the changes of the sync run from populated source and SCIM data
+func (s *sync) planSync() (plan *SyncPlan) {
+	plan = &SyncPlan{RunId: s.runId}
+
+	var unmatchedGroups = make(map[string]*scimGroup)
+	for k, v := range s.scimGroups {
+		unmatchedGroups[k] = v
+	}
+	var syncGroups = s.phaseEnabled(SyncPhaseGroups)
+	// groups are matched as the groups phase matches them
+	var groupMatches = make(map[string]*scimGroup)
+	if syncGroups {
+		var sourceGroups = make(map[string]*Group)
+		s.source.Groups(func(group *Group) {
+			sourceGroups[group.Id] = group
+		})
+		for _, match := range s.matchGroups(sourceGroups, s.scimGroups) {
+			groupMatches[match.group.Id] = match.scimGroup
+		}
+	}
+	s.source.Groups(func(group *Group) {
+		plan.GroupsInScope++
+		if !syncGroups {
+			return
+		}
+		var externalId = s.externalId(group.Id)
+		var sg, ok = groupMatches[group.Id]
+		if !ok {
+			plan.GroupCreates++
+			plan.Changes = append(plan.Changes, &PlannedChange{
+				ResourceType: "Groups",
+				Action:       PlanActionCreate,
+				ExternalId:   externalId,
+				Name:         group.Name,
+				After:        map[string]any{"displayName": group.Name, "externalId": externalId},
+			})
+			return
+		}
+		var conflict *scimGroup
+		if sg.Name != group.Name {
+			conflict = s.findRenameConflict(sg, group.Name)
+		}
+		if conflict != nil && s.canMergeRename(conflict) {
+			// the renamed group is merged into the existing group. The previously bound group is unmatched
+			sg = conflict
+		}
+		delete(unmatchedGroups, sg.Id)
+		var before = make(map[string]any)
+		var after = make(map[string]any)
+		if sg.ExternalId != externalId {
+			before["externalId"], after["externalId"] = sg.ExternalId, externalId
+		}
+		if conflict != nil && sg != conflict {
+			if len(conflict.ExternalId) == 0 {
+				delete(unmatchedGroups, conflict.Id)
+			}
+		} else if sg.Name != group.Name {
+			before["displayName"], after["displayName"] = sg.Name, group.Name
+		}
+		if len(after) > 0 {
+			plan.GroupUpdates++
+			plan.Changes = append(plan.Changes, &PlannedChange{
+				ResourceType: "Groups",
+				Action:       PlanActionUpdate,
+				Id:           sg.Id,
+				ExternalId:   externalId,
+				Name:         group.Name,
+				Before:       before,
+				After:        after,
+			})
+		}
+	})
+	if syncGroups && s.destructive >= 0 {
+		for _, sg := range sortedValues(unmatchedGroups, scimGroupSortKey) {
+			var policy = s.groupPolicy(sg)
+			if policy == GroupPolicyCreateOnly || policy == GroupPolicyMembership {
+				continue
+			}
+			if s.destructive > 0 || policy == GroupPolicyManaged || len(sg.ExternalId) > 0 {
+				plan.GroupDeletes++
+				plan.Changes = append(plan.Changes, &PlannedChange{
+					ResourceType: "Groups",
+					Action:       PlanActionDelete,
+					Id:           sg.Id,
+					ExternalId:   sg.ExternalId,
+					Name:         sg.Name,
+					Before:       map[string]any{"displayName": sg.Name, "externalId": sg.ExternalId},
+				})
+			}
+		}
+	}
+
+	var unmatchedUsers = make(map[string]*scimUser)
+	for k, v := range s.scimUsers {
+		unmatchedUsers[k] = v
+	}
+	var sourceUsers = make(map[string]*User)
+	s.source.Users(func(user *User) {
+		sourceUsers[user.Id] = user
+	})
+	var userMatches = make(map[string]*scimUser)
+	for _, match := range s.matchUsers(sourceUsers, s.scimUsers) {
+		userMatches[match.user.Id] = match.scimUser
+	}
+	var userChanges []*PlannedChange
+	var skippedUsers = newSkippedUserIndex()
+	s.source.Users(func(user *User) {
+		var userName = s.userName(user)
+		var su, ok = userMatches[user.Id]
+		if ok {
+			delete(unmatchedUsers, su.Id)
+		}
+		if s.validateSourceUser(user) != nil {
+			s.addSkippedUser(skippedUsers, user)
+			return
+		}
+		plan.UsersInScope++
+		if !ok {
+			if user.Active {
+				plan.UserCreates++
+				var after = map[string]any{
+					"userName":        userName,
+					"externalId":      s.externalId(user.Id),
+					"displayName":     user.FullName,
+					"name.givenName":  user.FirstName,
+					"name.familyName": user.LastName,
+					"active":          user.Active,
+				}
+				s.addUserAttributes(user, after)
+				userChanges = append(userChanges, &PlannedChange{
+					ResourceType: "Users",
+					Action:       PlanActionCreate,
+					ExternalId:   user.Id,
+					Name:         user.Email,
+					After:        after,
+				})
+			}
+			return
+		}
+		var before = make(map[string]any)
+		var after = make(map[string]any)
+		if len(userName) > 0 && foldEmail(su.UserName) != foldEmail(userName) {
+			before["userName"], after["userName"] = su.UserName, userName
+		}
+		if su.ExternalId != s.externalId(user.Id) {
+			before["externalId"], after["externalId"] = su.ExternalId, s.externalId(user.Id)
+		}
+		if su.FullName != user.FullName {
+			before["displayName"], after["displayName"] = su.FullName, user.FullName
+		}
+		if su.FirstName != user.FirstName {
+			before["name.givenName"], after["name.givenName"] = su.FirstName, user.FirstName
+		}
+		if su.LastName != user.LastName {
+			before["name.familyName"], after["name.familyName"] = su.LastName, user.LastName
+		}
+		if su.Active != user.Active {
+			before["active"], after["active"] = su.Active, user.Active
+		}
+		s.diffUserAttributes(user, su, after, before)
+		s.compareUser(user, after, before)
+		if len(after) > 0 {
+			plan.UserUpdates++
+			userChanges = append(userChanges, &PlannedChange{
+				ResourceType: "Users",
+				Action:       PlanActionUpdate,
+				Id:           su.Id,
+				ExternalId:   user.Id,
+				Name:         user.Email,
+				Before:       before,
+				After:        after,
+			})
+		}
+	})
+	for k, v := range unmatchedUsers {
+		if skippedUsers.has(v) {
+			delete(unmatchedUsers, k)
+		}
+	}
+	if s.updateUsers && s.phaseEnabled(SyncPhaseUsers) {
+		plan.Changes = append(plan.Changes, userChanges...)
+		if s.destructive >= 0 {
+			for _, su := range sortedValues(unmatchedUsers, scimUserSortKey) {
+				if su.Active && s.keepDeletedUsers() {
+					var before = map[string]any{"active": true}
+					var after = map[string]any{"active": false}
+					s.diffStateAttributes(UserStateDeleted, su, after, before)
+					plan.UserUpdates++
+					plan.Changes = append(plan.Changes, &PlannedChange{
+						ResourceType: "Users",
+						Action:       PlanActionUpdate,
+						Id:           su.Id,
+						ExternalId:   su.ExternalId,
+						Name:         su.Email,
+						Before:       before,
+						After:        after,
+					})
+				} else if su.Active {
+					plan.UserDeletes++
+					plan.Changes = append(plan.Changes, &PlannedChange{
+						ResourceType: "Users",
+						Action:       PlanActionDelete,
+						Id:           su.Id,
+						ExternalId:   su.ExternalId,
+						Name:         su.Email,
+						Before: map[string]any{
+							"userName":    su.UserName,
+							"externalId":  su.ExternalId,
+							"displayName": su.FullName,
+							"active":      su.Active,
+							"groups":      s.scimGroupNames(su.Groups),
+						},
+					})
+				}
+			}
+		}
+	} else {
+		plan.UserCreates, plan.UserUpdates = 0, 0
+	}
+	if s.phaseEnabled(SyncPhaseMembership) {
+		s.planMembership(plan, userMatches)
+	}
+	if s.rolesEnabled() {
+		s.planRoles(plan, userMatches)
+	}
+	return
+}
+
+// planMembership projects membership changes of existing SCIM users.
+// Before and After of a membership change contain the sorted group names of the user
+func (s *sync) planMembership(plan *SyncPlan, userMatches map[string]*scimUser) {
+	var defaultGroupIds, _ = s.resolveDefaultGroups()
+	var defaultGroups = MakeSet[string](defaultGroupIds)
+	var groupIds = make(map[string]string)
+	for _, sg := range s.scimGroups {
+		if len(sg.ExternalId) > 0 {
+			groupIds[sg.ExternalId] = sg.Id
+		}
+	}
+	s.source.Users(func(user *User) {
+		var su, ok = userMatches[user.Id]
+		if !ok {
+			return
+		}
+		var current = MakeSet[string](su.Groups)
+		var remaining = MakeSet[string](su.Groups)
+		var added []string
+		for _, externalGroupId := range user.Groups {
+			var groupId string
+			if groupId, ok = groupIds[s.externalId(externalGroupId)]; !ok {
+				continue
+			}
+			if remaining.Has(groupId) {
+				remaining.Delete(groupId)
+			} else if !current.Has(groupId) {
+				current.Add(groupId)
+				added = append(added, groupId)
+			}
+		}
+		var removed []string
+		if s.destructive >= 0 {
+			for groupId := range remaining {
+				if defaultGroups.Has(groupId) || s.isForeignGroup(groupId) {
+					continue
+				}
+				var policy = GroupPolicyDefault
+				var sg *scimGroup
+				if sg, ok = s.scimGroups[groupId]; ok {
+					policy = s.groupPolicy(sg)
+				}
+				if policy == GroupPolicyCreateOnly {
+					continue
+				}
+				if s.destructive > 0 || policy == GroupPolicyMembership || policy == GroupPolicyManaged || (ok && len(sg.ExternalId) > 0) {
+					removed = append(removed, groupId)
+				}
+			}
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			return
+		}
+		plan.MembershipAdds += len(added)
+		plan.MembershipRemoves += len(removed)
+		var after = MakeSet[string](su.Groups)
+		for _, groupId := range added {
+			after.Add(groupId)
+		}
+		for _, groupId := range removed {
+			after.Delete(groupId)
+		}
+		plan.Changes = append(plan.Changes, &PlannedChange{
+			ResourceType: "Users",
+			Action:       PlanActionMembership,
+			Id:           su.Id,
+			ExternalId:   user.Id,
+			Name:         user.Email,
+			Before:       map[string]any{"groups": s.scimGroupNames(su.Groups)},
+			After:        map[string]any{"groups": s.scimGroupNames(after.ToArray())},
+		})
+	})
+}
+
+// scimGroupNames resolves SCIM group IDs to sorted group names. Unknown groups are returned by ID
+func (s *sync) scimGroupNames(groupIds []string) (names []string) {
+	names = make([]string, 0, len(groupIds))
+	for _, groupId := range groupIds {
+		if sg, ok := s.scimGroups[groupId]; ok {
+			names = append(names, sg.Name)
+		} else {
+			names = append(names, groupId)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// checkSyncCaps fails the sync run if the plan exceeds any configured cap
+func (s *sync) checkSyncCaps(plan *SyncPlan) (err error) {
+	if s.syncCaps == nil {
+		return
+	}
+	var exceeded []string
+	var check = func(name string, projected int, limit int32) {
+		if limit <= 0 {
+			return
+		}
+		if projected > int(limit) {
+			exceeded = append(exceeded, fmt.Sprintf("%s: %d > %d", name, projected, limit))
+		} else if projected*10 >= int(limit)*9 {
+			log.Printf("Warning: %d projected %s are close to the cap of %d", projected, name, limit)
+		}
+	}
+	check("users", plan.UsersInScope, s.syncCaps.Users)
+	check("creates", plan.UserCreates+plan.GroupCreates, s.syncCaps.Creates)
+	check("updates", plan.UserUpdates+plan.GroupUpdates, s.syncCaps.Updates)
+	check("deletes", plan.UserDeletes+plan.GroupDeletes, s.syncCaps.Deletes)
+	if len(exceeded) > 0 {
+		err = fmt.Errorf("sync aborted: the sync plan exceeds configured caps (%s)", strings.Join(exceeded, ", "))
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package scim
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// PlanFormatVersion is the version of the JSON plan document.
+// The major version changes only when existing fields are removed or change their meaning
+const PlanFormatVersion = "1.1"
+
+// planDocument is the JSON representation of SyncPlan consumed by change management tools
+type planDocument struct {
+	FormatVersion   string                `json:"format_version"`
+	RunId           string                `json:"run_id,omitempty"`
+	Summary         planSummary           `json:"summary"`
+	ResourceChanges []*planResourceChange `json:"resource_changes"`
+}
+
+type planSummary struct {
+	UsersInScope      int `json:"users_in_scope"`
+	GroupsInScope     int `json:"groups_in_scope"`
+	UserCreates       int `json:"user_creates"`
+	UserUpdates       int `json:"user_updates"`
+	UserDeletes       int `json:"user_deletes"`
+	GroupCreates      int `json:"group_creates"`
+	GroupUpdates      int `json:"group_updates"`
+	GroupDeletes      int `json:"group_deletes"`
+	MembershipAdds    int `json:"membership_adds"`
+	MembershipRemoves int `json:"membership_removes"`
+	RoleChanges       int `json:"role_changes"`
+}
+
+type planResourceChange struct {
+	Address      string     `json:"address"`
+	ResourceType string     `json:"resource_type"`
+	Id           string     `json:"id,omitempty"`
+	ExternalId   string     `json:"external_id,omitempty"`
+	Name         string     `json:"name"`
+	Change       planChange `json:"change"`
+}
+
+type planChange struct {
+	Actions []string       `json:"actions"`
+	Before  map[string]any `json:"before"`
+	After   map[string]any `json:"after"`
+}
+
+// MarshalJSON encodes the plan in the versioned plan document format.
+// Resource changes are ordered by resource type, action and name, so the same plan always produces the same document
+func (sp *SyncPlan) MarshalJSON() ([]byte, error) {
+	var document = &planDocument{
+		FormatVersion: PlanFormatVersion,
+		RunId:         sp.RunId,
+		Summary: planSummary{
+			UsersInScope:      sp.UsersInScope,
+			GroupsInScope:     sp.GroupsInScope,
+			UserCreates:       sp.UserCreates,
+			UserUpdates:       sp.UserUpdates,
+			UserDeletes:       sp.UserDeletes,
+			GroupCreates:      sp.GroupCreates,
+			GroupUpdates:      sp.GroupUpdates,
+			GroupDeletes:      sp.GroupDeletes,
+			MembershipAdds:    sp.MembershipAdds,
+			MembershipRemoves: sp.MembershipRemoves,
+			RoleChanges:       sp.RoleChanges,
+		},
+		ResourceChanges: []*planResourceChange{},
+	}
+	var changes = make([]*PlannedChange, len(sp.Changes))
+	copy(changes, sp.Changes)
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].ResourceType != changes[j].ResourceType {
+			return changes[i].ResourceType < changes[j].ResourceType
+		}
+		if changes[i].Action != changes[j].Action {
+			return changes[i].Action < changes[j].Action
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	for _, pc := range changes {
+		var key = pc.Id
+		if len(key) == 0 {
+			key = pc.ExternalId
+		}
+		document.ResourceChanges = append(document.ResourceChanges, &planResourceChange{
+			Address:      pc.ResourceType + "/" + key,
+			ResourceType: pc.ResourceType,
+			Id:           pc.Id,
+			ExternalId:   pc.ExternalId,
+			Name:         pc.Name,
+			Change: planChange{
+				Actions: []string{pc.Action},
+				Before:  pc.Before,
+				After:   pc.After,
+			},
+		})
+	}
+	return json.Marshal(document)
+}
+
+// UnmarshalJSON decodes the plan from the versioned plan document format, e.g. a plan kept in the run history
+func (sp *SyncPlan) UnmarshalJSON(data []byte) (err error) {
+	var document planDocument
+	if err = json.Unmarshal(data, &document); err != nil {
+		return
+	}
+	*sp = SyncPlan{
+		RunId:             document.RunId,
+		UsersInScope:      document.Summary.UsersInScope,
+		GroupsInScope:     document.Summary.GroupsInScope,
+		UserCreates:       document.Summary.UserCreates,
+		UserUpdates:       document.Summary.UserUpdates,
+		UserDeletes:       document.Summary.UserDeletes,
+		GroupCreates:      document.Summary.GroupCreates,
+		GroupUpdates:      document.Summary.GroupUpdates,
+		GroupDeletes:      document.Summary.GroupDeletes,
+		MembershipAdds:    document.Summary.MembershipAdds,
+		MembershipRemoves: document.Summary.MembershipRemoves,
+		RoleChanges:       document.Summary.RoleChanges,
+	}
+	for _, rc := range document.ResourceChanges {
+		var pc = &PlannedChange{
+			ResourceType: rc.ResourceType,
+			Id:           rc.Id,
+			ExternalId:   rc.ExternalId,
+			Name:         rc.Name,
+			Before:       rc.Change.Before,
+			After:        rc.Change.After,
+		}
+		if len(rc.Change.Actions) > 0 {
+			pc.Action = rc.Change.Actions[0]
+		}
+		sp.Changes = append(sp.Changes, pc)
+	}
+	return
+}
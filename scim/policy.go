@@ -0,0 +1,155 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// policyTimeout limits the time of a single policy evaluation
+const policyTimeout = time.Minute
+
+// IPlanPolicy evaluates the sync plan before any change is applied.
+// violations are messages of denied changes; any violation blocks the sync run
+type IPlanPolicy interface {
+	Evaluate(plan *SyncPlan) (violations []string, err error)
+}
+
+// NewOpaPolicy creates IPlanPolicy that queries the OPA Data API, e.g. "http://localhost:8181/v1/data/scim/sync".
+// The JSON plan document is passed as the policy input. The decision is either a boolean (allow),
+// a set of deny messages, or an object with "allow" and/or "deny" rules
+func NewOpaPolicy(url string) (result IPlanPolicy, err error) {
+	url = strings.TrimSpace(url)
+	var lower = strings.ToLower(url)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		err = fmt.Errorf("policy URL \"%s\" must be an http:// or https:// URL", url)
+		return
+	}
+	result = &opaPolicy{url: url}
+	return
+}
+
+type opaPolicy struct {
+	url string
+}
+
+func (op *opaPolicy) Evaluate(plan *SyncPlan) (violations []string, err error) {
+	var payload []byte
+	if payload, err = json.Marshal(map[string]any{"input": plan}); err != nil {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), policyTimeout)
+	defer cancel()
+	var rq *http.Request
+	if rq, err = http.NewRequestWithContext(ctx, "POST", op.url, bytes.NewReader(payload)); err != nil {
+		return
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set("X-Correlation-Id", plan.RunId)
+	var rs *http.Response
+	if rs, err = http.DefaultClient.Do(rq); err != nil {
+		err = fmt.Errorf("policy request error: %w", err)
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	var body []byte
+	if body, err = io.ReadAll(rs.Body); err != nil {
+		return
+	}
+	if rs.StatusCode >= 300 {
+		if len(body) > 1024 {
+			body = body[:1024]
+		}
+		err = fmt.Errorf("policy error: status code %d: %s", rs.StatusCode, strings.TrimSpace(string(body)))
+		return
+	}
+	var response struct {
+		Result any `json:"result"`
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		err = fmt.Errorf("policy response error: %w", err)
+		return
+	}
+	violations, err = parsePolicyDecision(response.Result)
+	return
+}
+
+// parsePolicyDecision converts the OPA decision to violation messages.
+// An undefined decision is an error, so a misspelled policy path does not allow every plan
+func parsePolicyDecision(decision any) (violations []string, err error) {
+	switch d := decision.(type) {
+	case nil:
+		err = fmt.Errorf("policy decision is undefined. Check the policy URL")
+	case bool:
+		if !d {
+			violations = append(violations, "sync plan is not allowed by policy")
+		}
+	case []any:
+		violations = policyMessages(d)
+	case map[string]any:
+		var ok bool
+		if deny, found := d["deny"]; found {
+			var messages []any
+			if messages, ok = deny.([]any); !ok {
+				err = fmt.Errorf("policy \"deny\" rule must be a set of messages")
+				return
+			}
+			violations = policyMessages(messages)
+		}
+		if allow, found := d["allow"]; found {
+			var allowed bool
+			if allowed, ok = allow.(bool); !ok {
+				err = fmt.Errorf("policy \"allow\" rule must be a boolean")
+				return
+			}
+			if !allowed && len(violations) == 0 {
+				violations = append(violations, "sync plan is not allowed by policy")
+			}
+		}
+	default:
+		err = fmt.Errorf("policy decision of type %T is not supported", decision)
+	}
+	return
+}
+
+// policyMessages converts deny entries to strings. Objects use their "msg" property
+func policyMessages(entries []any) (messages []string) {
+	for _, entry := range entries {
+		switch e := entry.(type) {
+		case string:
+			messages = append(messages, e)
+		case map[string]any:
+			if msg, ok := e["msg"].(string); ok {
+				messages = append(messages, msg)
+				continue
+			}
+			var data, _ = json.Marshal(e)
+			messages = append(messages, string(data))
+		default:
+			messages = append(messages, fmt.Sprint(e))
+		}
+	}
+	return
+}
+
+// checkPolicy blocks the sync run if the plan violates the configured policy.
+// The sync run is also blocked if the policy cannot be evaluated
+func (s *sync) checkPolicy(plan *SyncPlan) (err error) {
+	if s.policy == nil {
+		return
+	}
+	var violations []string
+	if violations, err = s.policy.Evaluate(plan); err != nil {
+		err = fmt.Errorf("sync aborted: policy evaluation failed: %w", err)
+		return
+	}
+	if len(violations) > 0 {
+		err = fmt.Errorf("sync aborted: the sync plan violates policy (%s)", strings.Join(violations, "; "))
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicyDecision(t *testing.T) {
+	const notAllowed = "sync plan is not allowed by policy"
+	var tests = []struct {
+		name       string
+		decision   any
+		violations []string
+		err        string
+	}{
+		{name: "undefined", decision: nil, err: "policy decision is undefined"},
+		{name: "allowed", decision: true},
+		{name: "not allowed", decision: false, violations: []string{notAllowed}},
+		{name: "empty deny set", decision: []any{}},
+		{name: "deny set", decision: []any{"too many deletes", map[string]any{"msg": "admin removed"}, 42},
+			violations: []string{"too many deletes", "admin removed", "42"}},
+		{name: "deny object without msg", decision: []any{map[string]any{"code": "D1"}},
+			violations: []string{`{"code":"D1"}`}},
+		{name: "document allow", decision: map[string]any{"allow": true}},
+		{name: "document not allowed", decision: map[string]any{"allow": false}, violations: []string{notAllowed}},
+		{name: "document deny", decision: map[string]any{"deny": []any{"too many deletes"}, "allow": false},
+			violations: []string{"too many deletes"}},
+		{name: "document deny with allow", decision: map[string]any{"deny": []any{"too many deletes"}, "allow": true},
+			violations: []string{"too many deletes"}},
+		{name: "document without rules", decision: map[string]any{}},
+		{name: "deny is not a set", decision: map[string]any{"deny": "too many deletes"}, err: `"deny" rule must be a set`},
+		{name: "allow is not a boolean", decision: map[string]any{"allow": "yes"}, err: `"allow" rule must be a boolean`},
+		{name: "unsupported", decision: "allow", err: "type string is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var violations, err = parsePolicyDecision(tt.decision)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(violations, tt.violations) {
+				t.Errorf("violations = %q, want %q", violations, tt.violations)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package scim
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	admin "google.golang.org/api/admin/directory/v1"
+	reports "google.golang.org/api/admin/reports/v1"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// DefaultWatchTtl is the requested lifetime of push notification channels. Google may shorten it
+const DefaultWatchTtl = 6 * time.Hour
+
+// Kinds of push notification channels
+const (
+	WatchKindUsers    = "users"
+	WatchKindActivity = "activity"
+)
+
+// watchedUserEvents are Directory API user events that change provisioning
+var watchedUserEvents = []string{"add", "delete", "update", "undelete"}
+
+// groupActivityEvents are Admin audit events of groups and their membership
+var groupActivityEvents = MakeSet[string]([]string{"ADD_GROUP_MEMBER", "REMOVE_GROUP_MEMBER", "UPDATE_GROUP_MEMBER",
+	"CREATE_GROUP", "DELETE_GROUP", "CHANGE_GROUP_NAME"})
+
+// WatchChannel is a registered Google push notification channel
+type WatchChannel struct {
+	Kind       string
+	Event      string
+	Id         string
+	ResourceId string
+	Expiration time.Time
+}
+
+// IWatchSource is implemented by data sources that deliver push notifications of source changes
+type IWatchSource interface {
+	// Watch registers push notification channels that post changes to the HTTPS address.
+	// token is echoed in every notification to authenticate it. It is required
+	Watch(address string, token string, ttl time.Duration) ([]*WatchChannel, error)
+}
+
+// Watch registers Directory API channels of user changes and an Admin Reports API channel of group changes.
+// The Reports API channel requires the "admin.reports.audit.readonly" scope in the domain-wide delegation
+func (ge *googleEndpoint) Watch(address string, token string, ttl time.Duration) (channels []*WatchChannel, err error) {
+	if !strings.HasPrefix(strings.ToLower(address), "https://") {
+		err = fmt.Errorf("push notification address \"%s\" must be an https:// URL", address)
+		return
+	}
+	if len(token) == 0 {
+		err = errors.New("push notification channel token is required: notifications without a token are rejected")
+		return
+	}
+	if ttl <= 0 {
+		ttl = DefaultWatchTtl
+	}
+	var expiration = time.Now().Add(ttl).UnixMilli()
+	var ctx = context.Background()
+	var directory *admin.Service
+	if directory, err = ge.newDirectoryService(ctx); err != nil {
+		return
+	}
+	for _, event := range watchedUserEvents {
+		var call = directory.Users.Watch(&admin.Channel{
+			Id:         "ksm-scim-users-" + event + "-" + newRunId(),
+			Type:       "web_hook",
+			Address:    address,
+			Token:      token,
+			Expiration: expiration,
+		}).Event(event)
+		if len(ge.domain) > 0 {
+			call = call.Domain(ge.domain)
+		} else if len(ge.customerId) > 0 {
+			call = call.Customer(ge.customerId)
+		} else {
+			call = call.Customer(defaultCustomerId)
+		}
+		var channel *admin.Channel
+		if channel, err = call.Do(); err != nil {
+			err = googleApiError(fmt.Sprintf("watching user \"%s\" events", event), err)
+			return
+		}
+		channels = append(channels, &WatchChannel{
+			Kind:       WatchKindUsers,
+			Event:      event,
+			Id:         channel.Id,
+			ResourceId: channel.ResourceId,
+			Expiration: time.UnixMilli(channel.Expiration),
+		})
+	}
+
+	var tokenSource oauth2.TokenSource
+	if tokenSource, err = ge.newScopedTokenSource(ctx, []string{reports.AdminReportsAuditReadonlyScope}); err != nil {
+		return
+	}
+	var audit *reports.Service
+	if audit, err = reports.NewService(ctx, option.WithTokenSource(tokenSource), option.WithUserAgent(ge.userAgent())); err != nil {
+		return
+	}
+	var channel *reports.Channel
+	if channel, err = audit.Activities.Watch("all", "admin", &reports.Channel{
+		Id:         "ksm-scim-activity-" + newRunId(),
+		Type:       "web_hook",
+		Address:    address,
+		Token:      token,
+		Expiration: expiration,
+	}).Do(); err != nil {
+		err = googleApiError("watching admin activities", err)
+		return
+	}
+	channels = append(channels, &WatchChannel{
+		Kind:       WatchKindActivity,
+		Event:      "admin",
+		Id:         channel.Id,
+		ResourceId: channel.ResourceId,
+		Expiration: time.UnixMilli(channel.Expiration),
+	})
+	return
+}
+
+// ParseGoogleNotification converts a Google push notification to the scope of a targeted sync run.
+// token: the channel token. Notifications with a different token are rejected. All notifications are rejected if the token is empty:
+// the notification endpoint is public and a forged notification would start a run that deletes the named users and groups.
+// Returns nil scope if the notification does not require a sync, e.g. the channel "sync" message
+func ParseGoogleNotification(header http.Header, body []byte, token string) (scope *RunScope, err error) {
+	if len(token) == 0 {
+		err = errors.New("push notification channel token is not configured")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(header.Get("X-Goog-Channel-Token")), []byte(token)) != 1 {
+		err = errors.New("push notification channel token does not match")
+		return
+	}
+	if strings.EqualFold(header.Get("X-Goog-Resource-State"), "sync") || len(body) == 0 {
+		return
+	}
+	var resource struct {
+		Kind         string `json:"kind"`
+		Id           string `json:"id"`
+		PrimaryEmail string `json:"primaryEmail"`
+		Events       []struct {
+			Name       string `json:"name"`
+			Parameters []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"parameters"`
+		} `json:"events"`
+	}
+	if err = json.Unmarshal(body, &resource); err != nil {
+		err = fmt.Errorf("push notification payload error: %w", err)
+		return
+	}
+	scope = new(RunScope)
+	switch resource.Kind {
+	case "admin#directory#user":
+		if len(resource.PrimaryEmail) > 0 {
+			scope.Users = append(scope.Users, resource.PrimaryEmail)
+		} else if len(resource.Id) > 0 {
+			scope.Users = append(scope.Users, resource.Id)
+		}
+	case "admin#reports#activity":
+		for _, event := range resource.Events {
+			if !groupActivityEvents.Has(event.Name) {
+				continue
+			}
+			for _, parameter := range event.Parameters {
+				switch parameter.Name {
+				case "USER_EMAIL":
+					scope.Users = append(scope.Users, parameter.Value)
+				case "GROUP_EMAIL":
+					scope.Groups = append(scope.Groups, parameter.Value)
+				}
+			}
+		}
+	}
+	if scope.IsEmpty() {
+		scope = nil
+	}
+	return
+}
+
+// ParseRunScopeMessage parses the Pub/Sub message published by PublishRunScope.
+// Returns nil if the message is not a run scope, e.g. a message of Cloud Scheduler that triggers the full sync
+func ParseRunScopeMessage(data []byte) (scope *RunScope) {
+	var message = new(RunScope)
+	if err := json.Unmarshal(data, message); err == nil && !message.IsEmpty() {
+		scope = message
+	}
+	return
+}
+
+// PublishRunScope publishes the scope of a targeted sync run to the Pub/Sub topic "projects/{project}/topics/{topic}".
+// Application Default Credentials are used
+func PublishRunScope(topic string, scope *RunScope) (err error) {
+	var data []byte
+	if data, err = json.Marshal(scope); err != nil {
+		return
+	}
+	var ctx = context.Background()
+	var service *pubsub.Service
+	if service, err = pubsub.NewService(ctx); err != nil {
+		return
+	}
+	if _, err = service.Projects.Topics.Publish(topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{Data: base64.StdEncoding.EncodeToString(data)}},
+	}).Do(); err != nil {
+		err = fmt.Errorf("publish to Pub/Sub topic \"%s\": %w", topic, err)
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package scim
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoogleNotification(t *testing.T) {
+	const token = "channel-token"
+	var notification = func(channelToken string, state string) http.Header {
+		var header = make(http.Header)
+		if len(channelToken) > 0 {
+			header.Set("X-Goog-Channel-Token", channelToken)
+		}
+		if len(state) > 0 {
+			header.Set("X-Goog-Resource-State", state)
+		}
+		return header
+	}
+	var tests = []struct {
+		name    string
+		header  http.Header
+		body    string
+		token   string
+		scope   *RunScope
+		wantErr string
+	}{
+		{name: "token not configured", header: notification("", "update"), body: `{"kind":"admin#directory#user","primaryEmail":"a@example.com"}`,
+			wantErr: "push notification channel token is not configured"},
+		{name: "token not configured and sent", header: notification(token, "update"), body: `{"kind":"admin#directory#user","primaryEmail":"a@example.com"}`,
+			wantErr: "push notification channel token is not configured"},
+		{name: "token missing", header: notification("", "update"), body: `{"kind":"admin#directory#user","primaryEmail":"a@example.com"}`, token: token,
+			wantErr: "push notification channel token does not match"},
+		{name: "token mismatch", header: notification("other", "update"), body: `{"kind":"admin#directory#user","primaryEmail":"a@example.com"}`, token: token,
+			wantErr: "push notification channel token does not match"},
+		{name: "sync message", header: notification(token, "sync"), body: `{"kind":"admin#directory#user","primaryEmail":"a@example.com"}`, token: token},
+		{name: "empty body", header: notification(token, "update"), token: token},
+		{name: "malformed body", header: notification(token, "update"), body: `{"kind":`, token: token,
+			wantErr: "push notification payload error: unexpected end of JSON input"},
+		{name: "user by email", header: notification(token, "update"), body: `{"kind":"admin#directory#user","id":"101","primaryEmail":"a@example.com"}`, token: token,
+			scope: &RunScope{Users: []string{"a@example.com"}}},
+		{name: "user by id", header: notification(token, "delete"), body: `{"kind":"admin#directory#user","id":"101"}`, token: token,
+			scope: &RunScope{Users: []string{"101"}}},
+		{name: "group activity", header: notification(token, "update"), token: token,
+			body: `{"kind":"admin#reports#activity","events":[` +
+				`{"name":"ADD_GROUP_MEMBER","parameters":[{"name":"USER_EMAIL","value":"a@example.com"},{"name":"GROUP_EMAIL","value":"team@example.com"}]},` +
+				`{"name":"CHANGE_GROUP_NAME","parameters":[{"name":"GROUP_EMAIL","value":"sales@example.com"}]}]}`,
+			scope: &RunScope{Users: []string{"a@example.com"}, Groups: []string{"team@example.com", "sales@example.com"}}},
+		{name: "unrelated activity", header: notification(token, "update"), token: token,
+			body:  `{"kind":"admin#reports#activity","events":[{"name":"CHANGE_PASSWORD","parameters":[{"name":"USER_EMAIL","value":"a@example.com"}]}]}`,
+			scope: nil},
+		{name: "unknown kind", header: notification(token, "update"), body: `{"kind":"admin#directory#group","id":"201"}`, token: token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scope, err = ParseGoogleNotification(tt.header, []byte(tt.body), tt.token)
+			if len(tt.wantErr) > 0 {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				if scope != nil {
+					t.Errorf("scope = %v, want nil on error", scope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(scope, tt.scope) {
+				t.Errorf("scope = %#v, want %#v", scope, tt.scope)
+			}
+		})
+	}
+}
+
+func TestParseRunScopeMessage(t *testing.T) {
+	var tests = []struct {
+		name  string
+		data  string
+		scope *RunScope
+	}{
+		{name: "run scope", data: `{"users":["a@example.com"],"groups":["team"]}`, scope: &RunScope{Users: []string{"a@example.com"}, Groups: []string{"team"}}},
+		{name: "empty scope", data: `{}`},
+		{name: "scheduler message", data: `sync`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if scope := ParseRunScopeMessage([]byte(tt.data)); !reflect.DeepEqual(scope, tt.scope) {
+				t.Errorf("scope = %#v, want %#v", scope, tt.scope)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package scim
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// redisTimeout limits the duration of a Redis command including the connection
+const redisTimeout = 10 * time.Second
+
+// redisError is an error reply of the Redis server
+type redisError string
+
+func (re redisError) Error() string {
+	return "Redis error: " + string(re)
+}
+
+type redisStateStore struct {
+	address  string
+	useTls   bool
+	username string
+	password string
+	db       int
+	prefix   string
+	lock     gosync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// NewRedisStateStore creates IStateStore shared by several replicas of the sync.
+// storeUri: "redis://[user:password@]host:port[/db][?prefix=name]", "rediss://" connects with TLS.
+// Keys are stored under the prefix, "ksm-scim:" by default. The store implements IStateLocker
+func NewRedisStateStore(storeUri string) (store IStateStore, err error) {
+	var uri *url.URL
+	if uri, err = url.Parse(storeUri); err != nil {
+		return
+	}
+	var rs = &redisStateStore{
+		address: uri.Host,
+		useTls:  strings.EqualFold(uri.Scheme, "rediss"),
+		prefix:  "ksm-scim:",
+	}
+	if len(uri.Port()) == 0 {
+		rs.address = net.JoinHostPort(uri.Hostname(), "6379")
+	}
+	if len(uri.Hostname()) == 0 {
+		err = fmt.Errorf("Redis state store \"%s\": host is missing", uri.Redacted())
+		return
+	}
+	if uri.User != nil {
+		rs.username = uri.User.Username()
+		rs.password, _ = uri.User.Password()
+		if len(rs.password) == 0 {
+			// "redis://password@host" form
+			rs.username, rs.password = "", rs.username
+		}
+		RegisterSecrets(rs.password)
+	}
+	if db := strings.Trim(uri.Path, "/"); len(db) > 0 {
+		if rs.db, err = strconv.Atoi(db); err != nil || rs.db < 0 {
+			err = fmt.Errorf("Redis state store \"%s\": database \"%s\" must be a non-negative number", uri.Redacted(), db)
+			return
+		}
+	}
+	if uri.Query().Has("prefix") {
+		rs.prefix = uri.Query().Get("prefix")
+	}
+	store = rs
+	return
+}
+
+func (rs *redisStateStore) connect() (err error) {
+	var dialer = &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	if rs.useTls {
+		var host, _, _ = net.SplitHostPort(rs.address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", rs.address, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", rs.address)
+	}
+	if err != nil {
+		return
+	}
+	rs.conn = conn
+	rs.reader = bufio.NewReader(conn)
+	if len(rs.password) > 0 {
+		var args = []string{"AUTH", rs.password}
+		if len(rs.username) > 0 {
+			args = []string{"AUTH", rs.username, rs.password}
+		}
+		if _, err = rs.roundTrip(args); err != nil {
+			rs.close()
+			return
+		}
+	}
+	if rs.db > 0 {
+		if _, err = rs.roundTrip([]string{"SELECT", strconv.Itoa(rs.db)}); err != nil {
+			rs.close()
+		}
+	}
+	return
+}
+
+func (rs *redisStateStore) close() {
+	if rs.conn != nil {
+		_ = rs.conn.Close()
+	}
+	rs.conn = nil
+	rs.reader = nil
+}
+
+// command sends the command and reads the reply. A broken connection is opened again once
+func (rs *redisStateStore) command(args ...string) (reply any, err error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if rs.conn == nil {
+			if err = rs.connect(); err != nil {
+				return
+			}
+		}
+		if reply, err = rs.roundTrip(args); err == nil {
+			return
+		}
+		var re redisError
+		if errors.As(err, &re) {
+			return
+		}
+		rs.close()
+	}
+	return
+}
+
+func (rs *redisStateStore) roundTrip(args []string) (reply any, err error) {
+	_ = rs.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		sb.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	if _, err = io.WriteString(rs.conn, sb.String()); err != nil {
+		return
+	}
+	reply, err = readRedisReply(rs.reader)
+	return
+}
+
+// readRedisReply parses a RESP reply: simple strings as string, integers as int64, bulk strings as []byte (nil if missing)
+// and arrays as []any
+func readRedisReply(reader *bufio.Reader) (reply any, err error) {
+	var line string
+	if line, err = reader.ReadString('\n'); err != nil {
+		return
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		err = errors.New("Redis protocol error: empty reply")
+		return
+	}
+	var value = line[1:]
+	switch line[0] {
+	case '+':
+		reply = value
+	case '-':
+		err = redisError(value)
+	case ':':
+		reply, err = strconv.ParseInt(value, 10, 64)
+	case '$':
+		var size int
+		if size, err = strconv.Atoi(value); err != nil || size < 0 {
+			return
+		}
+		var data = make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return
+		}
+		reply = data[:size]
+	case '*':
+		var count int
+		if count, err = strconv.Atoi(value); err != nil || count < 0 {
+			return
+		}
+		var items = make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			var item any
+			if item, err = readRedisReply(reader); err != nil {
+				return
+			}
+			items = append(items, item)
+		}
+		reply = items
+	default:
+		err = fmt.Errorf("Redis protocol error: unexpected reply \"%s\"", line)
+	}
+	return
+}
+
+func (rs *redisStateStore) Load(key string) (data []byte, err error) {
+	var reply any
+	if reply, err = rs.command("GET", rs.prefix+key); err != nil {
+		return
+	}
+	data, _ = reply.([]byte)
+	return
+}
+
+func (rs *redisStateStore) Save(key string, data []byte) (err error) {
+	_, err = rs.command("SET", rs.prefix+key, string(data))
+	return
+}
+
+func (rs *redisStateStore) Delete(key string) (err error) {
+	_, err = rs.command("DEL", rs.prefix+key)
+	return
+}
+
+func (rs *redisStateStore) List(prefix string) (keys []string, err error) {
+	var pattern = redisGlobEscape(rs.prefix+prefix) + "*"
+	var cursor = "0"
+	for {
+		var reply any
+		if reply, err = rs.command("SCAN", cursor, "MATCH", pattern, "COUNT", "1000"); err != nil {
+			return
+		}
+		var items, ok = reply.([]any)
+		if !ok || len(items) != 2 {
+			err = errors.New("Redis protocol error: unexpected SCAN reply")
+			return
+		}
+		var next, _ = items[0].([]byte)
+		var names, _ = items[1].([]any)
+		for _, name := range names {
+			if key, ok := name.([]byte); ok {
+				keys = append(keys, strings.TrimPrefix(string(key), rs.prefix))
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || len(cursor) == 0 {
+			break
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// redisGlobEscape escapes the glob characters of the SCAN pattern
+func redisGlobEscape(text string) string {
+	var sb strings.Builder
+	for _, ch := range text {
+		switch ch {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(ch)
+	}
+	return sb.String()
+}
+
+// redisUnlockScript deletes the lock only if it is still held with the token
+const redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// redisRenewScript extends the lock only if it is still held with the token
+const redisRenewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+func (rs *redisStateStore) TryLock(name string, ttl time.Duration) (release func(), acquired bool, err error) {
+	var key = rs.prefix + "locks/" + name
+	var token = newLockToken()
+	var reply any
+	if reply, err = rs.command("SET", key, token, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		return
+	}
+	if reply == nil {
+		// the lock is held by another replica
+		return
+	}
+	acquired = true
+	var stop = keepLock(name, ttl, func() (held bool, er1 error) {
+		var renewed any
+		if renewed, er1 = rs.command("EVAL", redisRenewScript, "1", key, token, strconv.FormatInt(ttl.Milliseconds(), 10)); er1 == nil {
+			var count, _ = renewed.(int64)
+			held = count > 0
+		}
+		return
+	})
+	release = func() {
+		stop()
+		_, _ = rs.command("EVAL", redisUnlockScript, "1", key, token)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package scim
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRedisReply(t *testing.T) {
+	var tests = []struct {
+		name    string
+		input   string
+		reply   any
+		wantErr string
+	}{
+		{name: "simple string", input: "+OK\r\n", reply: "OK"},
+		{name: "integer", input: ":42\r\n", reply: int64(42)},
+		{name: "negative integer", input: ":-1\r\n", reply: int64(-1)},
+		{name: "bulk string", input: "$5\r\nhello\r\n", reply: []byte("hello")},
+		{name: "bulk string with CRLF", input: "$4\r\na\r\nb\r\n", reply: []byte("a\r\nb")},
+		{name: "empty bulk string", input: "$0\r\n\r\n", reply: []byte{}},
+		{name: "missing bulk string", input: "$-1\r\n", reply: nil},
+		{name: "array", input: "*3\r\n$1\r\n0\r\n:7\r\n*1\r\n+key\r\n", reply: []any{[]byte("0"), int64(7), []any{"key"}}},
+		{name: "empty array", input: "*0\r\n", reply: []any{}},
+		{name: "missing array", input: "*-1\r\n", reply: nil},
+		{name: "error", input: "-WRONGTYPE wrong kind of value\r\n", wantErr: "Redis error: WRONGTYPE wrong kind of value"},
+		{name: "empty reply", input: "\r\n", wantErr: "Redis protocol error: empty reply"},
+		{name: "unexpected reply", input: "?what\r\n", wantErr: "Redis protocol error: unexpected reply \"?what\""},
+		{name: "truncated bulk string", input: "$5\r\nhel", wantErr: "unexpected EOF"},
+		{name: "truncated array", input: "*2\r\n:1\r\n", wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reply, err = readRedisReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if len(tt.wantErr) > 0 {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(reply, tt.reply) {
+				t.Errorf("reply = %#v, want %#v", reply, tt.reply)
+			}
+		})
+	}
+}
+
+func TestReadRedisReplyErrorType(t *testing.T) {
+	var _, err = readRedisReply(bufio.NewReader(strings.NewReader("-ERR unknown command\r\n")))
+	var re redisError
+	if !errors.As(err, &re) {
+		t.Fatalf("error = %v, want redisError", err)
+	}
+}
+
+func TestReadRedisReplyConsecutive(t *testing.T) {
+	var reader = bufio.NewReader(strings.NewReader("+OK\r\n$3\r\nabc\r\n:1\r\n"))
+	var want = []any{"OK", []byte("abc"), int64(1)}
+	for i, w := range want {
+		var reply, err = readRedisReply(reader)
+		if err != nil {
+			t.Fatalf("reply %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(reply, w) {
+			t.Errorf("reply %d = %#v, want %#v", i, reply, w)
+		}
+	}
+}
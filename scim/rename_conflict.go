@@ -0,0 +1,81 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// Policies of group renames that collide with an existing SCIM group
+const (
+	// RenameConflictSkip keeps the old name of the SCIM group and reports the conflict
+	RenameConflictSkip = "skip"
+	// RenameConflictMerge binds the existing unmanaged SCIM group to the source group.
+	// The previously bound SCIM group is deleted as any group missing in the source
+	RenameConflictMerge = "merge"
+)
+
+// ParseRenameConflictPolicy validates the group rename conflict policy. Empty policy is "skip"
+func ParseRenameConflictPolicy(policy string) (result string, err error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", RenameConflictSkip:
+		result = RenameConflictSkip
+	case RenameConflictMerge:
+		result = RenameConflictMerge
+	default:
+		err = fmt.Errorf("group rename conflict policy \"%s\" is not supported. Valid policies are skip, merge", policy)
+	}
+	return
+}
+
+// findRenameConflict returns another SCIM group that already has the new name of the renamed group
+func (s *sync) findRenameConflict(renamed *scimGroup, name string) *scimGroup {
+	var fold = cases.Fold()
+	var folded = fold.String(name)
+	for _, sg := range sortedValues(s.scimGroups, scimGroupSortKey) {
+		if sg.Id != renamed.Id && fold.String(sg.Name) == folded {
+			return sg
+		}
+	}
+	return nil
+}
+
+// canMergeRename returns true if the renamed source group is merged into the conflicting SCIM group
+func (s *sync) canMergeRename(conflict *scimGroup) bool {
+	return s.renameConflicts == RenameConflictMerge && len(conflict.ExternalId) == 0
+}
+
+// describeRenameConflict explains why the group rename is skipped
+func describeRenameConflict(renamed *scimGroup, group *Group, conflict *scimGroup) string {
+	if len(conflict.ExternalId) > 0 {
+		return fmt.Sprintf("PATCH group \"%s\" rename to \"%s\" skipped: SCIM group \"%s\" already exists and is bound to external ID \"%s\"",
+			renamed.Name, group.Name, conflict.Name, conflict.ExternalId)
+	}
+	return fmt.Sprintf("PATCH group \"%s\" rename to \"%s\" skipped: SCIM group \"%s\" already exists and is not controlled by SCIM",
+		renamed.Name, group.Name, conflict.Name)
+}
+
+// mergeRenamedGroup binds the conflicting unmanaged SCIM group to the renamed source group
+func (s *sync) mergeRenamedGroup(group *Group, renamed *scimGroup, conflict *scimGroup) (success string, failure string) {
+	var value = map[string]any{"externalId": s.externalId(group.Id)}
+	var inverse = map[string]any{"externalId": conflict.ExternalId}
+	if conflict.Name != group.Name {
+		value["displayName"] = group.Name
+		inverse["displayName"] = conflict.Name
+	}
+	if er1 := s.patchResource("Groups", conflict.Id, makePatchPayload(makePatchOperation("replace", "", value))); er1 != nil {
+		failure = fmt.Sprintf("PATCH group \"%s\" merge error: %s", conflict.Name, er1.Error())
+		return
+	}
+	s.recordChange(phaseGroups, "PATCH", "Groups", conflict.Id, group.Name, &ScimOperation{
+		Method:       "PATCH",
+		ResourceType: "Groups",
+		ResourceId:   conflict.Id,
+		Payload:      makePatchPayload(makePatchOperation("replace", "", inverse)),
+	})
+	conflict.ExternalId = s.externalId(group.Id)
+	conflict.Name = group.Name
+	success = fmt.Sprintf("SCIM merged renamed group \"%s\" into existing group \"%s\"", renamed.Name, group.Name)
+	return
+}
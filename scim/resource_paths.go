@@ -0,0 +1,77 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScimPaths override the resource paths of SCIM targets that do not use the standard "Users" and "Groups" endpoints
+type ScimPaths struct {
+	// BasePath is inserted between the SCIM URL and the resource path, e.g. "tenants/acme"
+	BasePath string
+	// Users is the path of the Users endpoint, e.g. "users"
+	Users string
+	// Groups is the path of the Groups endpoint, e.g. "groups"
+	Groups string
+}
+
+func (sp *ScimPaths) String() string {
+	return fmt.Sprintf("base=%s, users=%s, groups=%s", sp.BasePath, sp.Users, sp.Groups)
+}
+
+// ParseScimPaths parses "base=path", "users=path" and "groups=path" entries separated by comma or new line
+func ParseScimPaths(entries []string) (paths *ScimPaths, err error) {
+	for _, entry := range entries {
+		var name, value, ok = strings.Cut(entry, "=")
+		if !ok {
+			err = fmt.Errorf("resource path \"%s\" is not in \"name=path\" format", entry)
+			return
+		}
+		value = strings.Trim(strings.TrimSpace(value), "/")
+		if strings.ContainsAny(value, "?#") {
+			err = fmt.Errorf("resource path \"%s\": path must not contain a query or fragment", entry)
+			return
+		}
+		if paths == nil {
+			paths = new(ScimPaths)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "base":
+			paths.BasePath = value
+		case "users":
+			paths.Users = value
+		case "groups":
+			paths.Groups = value
+		default:
+			err = fmt.Errorf("resource path \"%s\": unsupported name. Valid names are base, users, groups", entry)
+			return
+		}
+		if len(value) == 0 && !strings.EqualFold(strings.TrimSpace(name), "base") {
+			err = fmt.Errorf("resource path \"%s\": path is empty", entry)
+			return
+		}
+	}
+	return
+}
+
+// resourcePaths maps the SCIM resource type, the first path, to the configured resource path and prepends the base path
+func (s *sync) resourcePaths(paths []string) []string {
+	if s.scimPaths == nil || len(paths) == 0 {
+		return paths
+	}
+	var result []string
+	for _, segment := range strings.Split(s.scimPaths.BasePath, "/") {
+		if len(segment) > 0 {
+			result = append(result, segment)
+		}
+	}
+	var resource = paths[0]
+	switch {
+	case resource == "Users" && len(s.scimPaths.Users) > 0:
+		resource = s.scimPaths.Users
+	case resource == "Groups" && len(s.scimPaths.Groups) > 0:
+		resource = s.scimPaths.Groups
+	}
+	result = append(result, strings.Split(resource, "/")...)
+	return append(result, paths[1:]...)
+}
@@ -0,0 +1,148 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// IResultSink receives the outcome of every successful sync run
+type IResultSink interface {
+	Export(stat *SyncStat) error
+}
+
+// IErrorSink is implemented by result sinks that also report sync runs that failed with an error
+type IErrorSink interface {
+	ExportError(runId string, err error) error
+}
+
+// NewResultSink creates IResultSink from the connection string
+// sinkUri: "bigquery://<project>/<dataset>/<table>", "googlechat+https://<webhook>", "googlechat://spaces/<id>",
+// "jira+https://<email>:<token>@<site>?project=<key>" or "servicenow+https://<user>:<password>@<instance>"
+func NewResultSink(sinkUri string) (sink IResultSink, err error) {
+	sinkUri = strings.TrimSpace(sinkUri)
+	var uri *url.URL
+	if uri, err = url.Parse(sinkUri); err != nil {
+		return
+	}
+	switch strings.ToLower(uri.Scheme) {
+	case "bigquery":
+		var path = strings.Split(strings.Trim(uri.Path, "/"), "/")
+		if len(uri.Host) == 0 || len(path) != 2 || len(path[0]) == 0 || len(path[1]) == 0 {
+			err = fmt.Errorf("result sink \"%s\": expected \"bigquery://<project>/<dataset>/<table>\"", sinkUri)
+			return
+		}
+		sink = NewBigQuerySink(uri.Host, path[0], path[1])
+	case "googlechat", "googlechat+https":
+		if sink, err = newGoogleChatSinkFromUri(uri); err != nil {
+			err = fmt.Errorf("%w, \"%s\"", err, SanitizeText(sinkUri))
+		}
+	case "jira+https", "servicenow+https":
+		if sink, err = newTicketSinkFromUri(uri); err != nil {
+			err = fmt.Errorf("%w, \"%s\"", err, SanitizeText(sinkUri))
+		}
+	case "":
+		err = fmt.Errorf("result sink \"%s\": scheme is missing", sinkUri)
+	default:
+		err = fmt.Errorf("result sink scheme \"%s\" is not supported", uri.Scheme)
+	}
+	return
+}
+
+// NewResultSinks creates result sinks from the list of connection strings
+func NewResultSinks(sinkUris []string) (sinks []IResultSink, err error) {
+	for _, sinkUri := range sinkUris {
+		var sink IResultSink
+		if sink, err = NewResultSink(sinkUri); err != nil {
+			return
+		}
+		sinks = append(sinks, sink)
+	}
+	return
+}
+
+type bigQuerySink struct {
+	projectId string
+	datasetId string
+	tableId   string
+}
+
+// NewBigQuerySink creates IResultSink that streams sync results into the BigQuery table.
+// Every executed operation is appended as an "operation" row, followed by one "summary" row per run.
+// Uses Application Default Credentials
+func NewBigQuerySink(projectId string, datasetId string, tableId string) IResultSink {
+	return &bigQuerySink{
+		projectId: projectId,
+		datasetId: datasetId,
+		tableId:   tableId,
+	}
+}
+
+func (bq *bigQuerySink) rows(stat *SyncStat) (rows []*bigquery.TableDataInsertAllRequestRows) {
+	for i, op := range stat.Operations {
+		rows = append(rows, &bigquery.TableDataInsertAllRequestRows{
+			InsertId: fmt.Sprintf("%s-op-%d", stat.RunId, i),
+			Json: map[string]bigquery.JsonValue{
+				"run_id":        stat.RunId,
+				"row_type":      "operation",
+				"timestamp":     stat.Finished.UTC().Format(time.RFC3339),
+				"operation_id":  op.OperationId,
+				"phase":         op.Phase,
+				"method":        op.Method,
+				"resource_type": op.ResourceType,
+				"resource_id":   op.ResourceId,
+				"name":          op.Name,
+				"reversible":    op.Inverse != nil,
+			},
+		})
+	}
+	rows = append(rows, &bigquery.TableDataInsertAllRequestRows{
+		InsertId: fmt.Sprintf("%s-summary", stat.RunId),
+		Json: map[string]bigquery.JsonValue{
+			"run_id":             stat.RunId,
+			"row_type":           "summary",
+			"timestamp":          stat.Finished.UTC().Format(time.RFC3339),
+			"started":            stat.Started.UTC().Format(time.RFC3339),
+			"duration_seconds":   stat.Finished.Sub(stat.Started).Seconds(),
+			"success_groups":     len(stat.SuccessGroups),
+			"failed_groups":      len(stat.FailedGroups),
+			"success_users":      len(stat.SuccessUsers),
+			"failed_users":       len(stat.FailedUsers),
+			"skipped_users":      len(stat.SkippedUsers),
+			"success_membership": len(stat.SuccessMembership),
+			"failed_membership":  len(stat.FailedMembership),
+		},
+	})
+	return
+}
+
+func (bq *bigQuerySink) Export(stat *SyncStat) (err error) {
+	var ctx = context.Background()
+	var svc *bigquery.Service
+	if svc, err = bigquery.NewService(ctx, option.WithScopes(bigquery.BigqueryInsertdataScope)); err != nil {
+		return
+	}
+	var rq = &bigquery.TableDataInsertAllRequest{
+		Rows: bq.rows(stat),
+	}
+	var rs *bigquery.TableDataInsertAllResponse
+	if rs, err = svc.Tabledata.InsertAll(bq.projectId, bq.datasetId, bq.tableId, rq).Context(ctx).Do(); err != nil {
+		return
+	}
+	if len(rs.InsertErrors) > 0 {
+		var messages []string
+		for _, ie := range rs.InsertErrors {
+			for _, ep := range ie.Errors {
+				messages = append(messages, fmt.Sprintf("row %d: %s", ie.Index, ep.Message))
+			}
+		}
+		err = errors.New("BigQuery insert error: " + strings.Join(messages, "; "))
+	}
+	return
+}
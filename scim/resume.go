@@ -0,0 +1,172 @@
+package scim
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resumeStateKey is the state store key of the work left by a sync run that ran out of its time budget
+const resumeStateKey = "resume"
+
+// ResumeState lists the users and groups a partial sync run planned to change but did not reach.
+// The next run processes them first
+type ResumeState struct {
+	RunId string    `json:"runId"`
+	Saved time.Time `json:"saved"`
+	// Users are emails of source users
+	Users []string `json:"users,omitempty"`
+	// Groups are names of source groups
+	Groups []string `json:"groups,omitempty"`
+}
+
+// LoadResumeState reads the work left by the last partial sync run. Returns nil if the last run was complete
+func LoadResumeState(store IStateStore) (state *ResumeState, err error) {
+	var data []byte
+	if data, err = store.Load(resumeStateKey); err != nil || data == nil {
+		return
+	}
+	state = new(ResumeState)
+	err = json.Unmarshal(data, state)
+	return
+}
+
+func saveResumeState(store IStateStore, state *ResumeState) (err error) {
+	var data []byte
+	if data, err = json.Marshal(state); err != nil {
+		return
+	}
+	err = store.Save(resumeStateKey, data)
+	return
+}
+
+// SameWork reports whether both states leave the same users and groups.
+// A partial run that leaves the work of the run it resumed made no progress
+func (rs *ResumeState) SameWork(other *ResumeState) bool {
+	if rs == nil || other == nil {
+		return rs == other
+	}
+	return equalStrings(rs.Users, other.Users) && equalStrings(rs.Groups, other.Groups)
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// makeResumeState returns the planned changes of the run that are not in its journal
+func makeResumeState(stat *SyncStat) (state *ResumeState) {
+	state = &ResumeState{
+		RunId: stat.RunId,
+		Saved: time.Now(),
+	}
+	if stat.Plan == nil {
+		return
+	}
+	var done = NewSet[string]()
+	for _, entry := range stat.Operations {
+		done.Add(entry.ResourceType + "\x00" + strings.ToLower(entry.Name))
+	}
+	var users = NewSet[string]()
+	var groups = NewSet[string]()
+	for _, change := range stat.Plan.Changes {
+		var name = strings.ToLower(change.Name)
+		if len(name) == 0 || done.Has(change.ResourceType+"\x00"+name) {
+			continue
+		}
+		switch change.ResourceType {
+		case "Users":
+			users.Add(name)
+		case "Groups":
+			groups.Add(name)
+		}
+	}
+	state.Users = users.ToArray()
+	sort.Strings(state.Users)
+	state.Groups = groups.ToArray()
+	sort.Strings(state.Groups)
+	return
+}
+
+// loadResume reads the work left by an interrupted full run. Targeted runs do not resume
+func (s *sync) loadResume() {
+	s.resumeUsers = nil
+	s.resumeGroups = nil
+	s.resumedRunId = ""
+	if s.stateStore == nil || !s.runScope.IsEmpty() {
+		return
+	}
+	var state, err = LoadResumeState(s.stateStore)
+	if err != nil {
+		log.Printf("Failed to read the resume state: %s", err.Error())
+		return
+	}
+	if state == nil {
+		return
+	}
+	s.resumedRunId = state.RunId
+	s.resumeUsers = MakeSet[string](state.Users)
+	s.resumeGroups = MakeSet[string](state.Groups)
+	log.Printf("Resuming sync run \"%s\": %d user(s) and %d group(s) left by the run are processed first",
+		state.RunId, len(state.Users), len(state.Groups))
+}
+
+// storeResume saves the work left by a partial run, or clears the resume state once a full run completes
+func (s *sync) storeResume(stat *SyncStat) {
+	if s.stateStore == nil || !s.runScope.IsEmpty() {
+		return
+	}
+	var err error
+	if stat.Partial {
+		var state = makeResumeState(stat)
+		if err = saveResumeState(s.stateStore, state); err == nil {
+			log.Printf("Sync run \"%s\" is partial: %d user(s) and %d group(s) are resumed by the next run",
+				stat.RunId, len(state.Users), len(state.Groups))
+		}
+	} else if len(s.resumedRunId) > 0 {
+		err = s.stateStore.Delete(resumeStateKey)
+	}
+	if err != nil {
+		log.Printf("Failed to store the resume state: %s", err.Error())
+	}
+}
+
+// userOrderKey sorts users left by the interrupted run first
+func (s *sync) userOrderKey(u *User) string {
+	if s.resumeUsers != nil && s.resumeUsers.Has(strings.ToLower(u.Email)) {
+		return "0" + userSortKey(u)
+	}
+	return "1" + userSortKey(u)
+}
+
+// groupOrderKey sorts groups left by the interrupted run first
+func (s *sync) groupOrderKey(g *Group) string {
+	if s.resumeGroups != nil && s.resumeGroups.Has(strings.ToLower(g.Name)) {
+		return "0" + groupSortKey(g)
+	}
+	return "1" + groupSortKey(g)
+}
+
+// orderedUsers enumerates source users with the users left by the interrupted run first
+func (s *sync) orderedUsers(cb func(*User)) {
+	if len(s.resumeUsers) == 0 {
+		s.source.Users(cb)
+		return
+	}
+	var users = make(map[string]*User)
+	s.source.Users(func(user *User) {
+		users[user.Id] = user
+	})
+	for _, user := range sortedValues(users, s.userOrderKey) {
+		cb(user)
+	}
+}
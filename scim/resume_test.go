@@ -0,0 +1,81 @@
+package scim
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMakeResumeState(t *testing.T) {
+	var plan = &SyncPlan{Changes: []*PlannedChange{
+		{ResourceType: "Users", Action: PlanActionCreate, Name: "B@example.com"},
+		{ResourceType: "Users", Action: PlanActionUpdate, Name: "a@example.com"},
+		{ResourceType: "Users", Action: PlanActionMembership, Name: "a@example.com"},
+		{ResourceType: "Users", Action: PlanActionDelete, Name: "c@example.com"},
+		{ResourceType: "Groups", Action: PlanActionCreate, Name: "Sales"},
+		{ResourceType: "Groups", Action: PlanActionUpdate, Name: "engineering"},
+		{ResourceType: "Users", Action: PlanActionCreate},
+	}}
+	var tests = []struct {
+		name   string
+		stat   *SyncStat
+		users  []string
+		groups []string
+	}{
+		{name: "no plan", stat: &SyncStat{RunId: "run-1"}},
+		{name: "nothing done", stat: &SyncStat{RunId: "run-1", Plan: plan},
+			users: []string{"a@example.com", "b@example.com", "c@example.com"}, groups: []string{"engineering", "sales"}},
+		{name: "partly done", stat: &SyncStat{RunId: "run-1", Plan: plan, Operations: []*JournalEntry{
+			{ResourceType: "Users", Name: "A@Example.com"},
+			{ResourceType: "Groups", Name: "Sales"},
+			// a user and a group of the same name are different resources
+			{ResourceType: "Groups", Name: "c@example.com"},
+		}}, users: []string{"b@example.com", "c@example.com"}, groups: []string{"engineering"}},
+		{name: "all done", stat: &SyncStat{RunId: "run-1", Plan: plan, Operations: []*JournalEntry{
+			{ResourceType: "Users", Name: "a@example.com"},
+			{ResourceType: "Users", Name: "b@example.com"},
+			{ResourceType: "Users", Name: "c@example.com"},
+			{ResourceType: "Groups", Name: "sales"},
+			{ResourceType: "Groups", Name: "Engineering"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state = makeResumeState(tt.stat)
+			if state.RunId != tt.stat.RunId || state.Saved.IsZero() {
+				t.Errorf("run ID = %s, saved = %v, want %s and the current time", state.RunId, state.Saved, tt.stat.RunId)
+			}
+			if !reflect.DeepEqual(state.Users, tt.users) {
+				t.Errorf("users = %v, want %v", state.Users, tt.users)
+			}
+			if !reflect.DeepEqual(state.Groups, tt.groups) {
+				t.Errorf("groups = %v, want %v", state.Groups, tt.groups)
+			}
+			if !state.SameWork(&ResumeState{Users: tt.users, Groups: tt.groups}) {
+				t.Error("state does not leave the same work as the expected state")
+			}
+		})
+	}
+}
+
+func TestResumeStateSameWork(t *testing.T) {
+	var state = &ResumeState{RunId: "run-1", Users: []string{"a@example.com"}, Groups: []string{"sales"}}
+	var tests = []struct {
+		name  string
+		state *ResumeState
+		other *ResumeState
+		same  bool
+	}{
+		{name: "both nil", same: true},
+		{name: "nil", state: state},
+		{name: "other run", state: state, other: &ResumeState{RunId: "run-2", Users: []string{"a@example.com"}, Groups: []string{"sales"}}, same: true},
+		{name: "user done", state: state, other: &ResumeState{Groups: []string{"sales"}}},
+		{name: "other group", state: state, other: &ResumeState{Users: []string{"a@example.com"}, Groups: []string{"engineering"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := tt.state.SameWork(tt.other); same != tt.same {
+				t.Errorf("same work = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
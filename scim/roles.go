@@ -0,0 +1,93 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RoleMapping assigns SCIM roles to members of source groups
+type RoleMapping = GroupValueMapping
+
+// ParseRoleMapping parses "group=role" entries separated by comma or new line. A group may be listed several times
+func ParseRoleMapping(entries []string) (RoleMapping, error) {
+	return parseGroupValueMapping("role", entries)
+}
+
+// rolesEnabled returns true if the roles phase runs
+func (s *sync) rolesEnabled() bool {
+	return len(s.roleMapping) > 0 && s.phaseEnabled(SyncPhaseRoles)
+}
+
+// syncRoles sets SCIM roles of provisioned users from their source group membership. Runs after users and memberships are synced
+func (s *sync) syncRoles() (successes []string, failures []string) {
+	var keeperUserLookup = make(map[string]*scimUser)
+	for _, v := range s.scimUsers {
+		keeperUserLookup[foldEmail(v.UserName)] = v
+	}
+	var roles = s.resolveGroupValues(s.roleMapping)
+	s.source.Users(func(user *User) {
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			return
+		}
+		var keeperUser, ok = keeperUserLookup[foldEmail(userName)]
+		if !ok {
+			return
+		}
+		var target, changed = roles.target(user, keeperUser.Roles)
+		if !changed || !s.inScopeUser(user) {
+			return
+		}
+		if !s.inCanaryUser(user) {
+			s.deferCanary(fmt.Sprintf("set user \"%s\" roles", user.Email))
+			return
+		}
+		var operation = makePatchOperation("replace", "", map[string]any{"roles": multiValuesToScim(target)})
+		var inverse = makePatchOperation("replace", "", map[string]any{"roles": multiValuesToScim(keeperUser.Roles)})
+		if er1 := s.patchResource("Users", keeperUser.Id, makePatchPayload(operation)); er1 == nil {
+			s.recordChange(phaseRoles, "PATCH", "Users", keeperUser.Id, keeperUser.Email, &ScimOperation{
+				Method:       "PATCH",
+				ResourceType: "Users",
+				ResourceId:   keeperUser.Id,
+				Payload:      makePatchPayload(inverse),
+			})
+			keeperUser.Roles = target
+			if len(target) > 0 {
+				successes = append(successes, fmt.Sprintf("SCIM set user \"%s\" roles: %s", user.Email, strings.Join(target, ", ")))
+			} else {
+				successes = append(successes, fmt.Sprintf("SCIM removed user \"%s\" roles", user.Email))
+			}
+		} else {
+			failures = append(failures, fmt.Sprintf("PATCH user \"%s\" roles error: %s", user.Email, er1.Error()))
+		}
+	})
+	return
+}
+
+// planRoles projects role changes of existing SCIM users
+func (s *sync) planRoles(plan *SyncPlan, userMatches map[string]*scimUser) {
+	var roles = s.resolveGroupValues(s.roleMapping)
+	s.source.Users(func(user *User) {
+		var su, ok = userMatches[user.Id]
+		if !ok {
+			return
+		}
+		var target, changed = roles.target(user, su.Roles)
+		if !changed {
+			return
+		}
+		var before = append([]string{}, su.Roles...)
+		sort.Strings(before)
+		plan.RoleChanges++
+		plan.Changes = append(plan.Changes, &PlannedChange{
+			ResourceType: "Users",
+			Action:       PlanActionRoles,
+			Id:           su.Id,
+			ExternalId:   user.Id,
+			Name:         user.Email,
+			Before:       map[string]any{"roles": before},
+			After:        map[string]any{"roles": target},
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/text/cases"
+)
+
+// RunScope restricts the changes of a single sync run to listed users and groups.
+// Changes outside of the scope are skipped without being reported
+type RunScope struct {
+	// Users are user emails (case-insensitive) or Google user IDs
+	Users []string `json:"users,omitempty"`
+	// Groups are group names, group emails (case-insensitive) or Google group IDs. Members of the groups are in the scope too
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (rs *RunScope) String() string {
+	var parts []string
+	if len(rs.Users) > 0 {
+		parts = append(parts, fmt.Sprintf("user(s) %s", strings.Join(rs.Users, ", ")))
+	}
+	if len(rs.Groups) > 0 {
+		parts = append(parts, fmt.Sprintf("group(s) %s", strings.Join(rs.Groups, ", ")))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// IsEmpty returns true if the scope lists no user and no group
+func (rs *RunScope) IsEmpty() bool {
+	return rs == nil || (len(rs.Users) == 0 && len(rs.Groups) == 0)
+}
+
+// SyncUser reconciles a single user, by email or Google user ID, with SCIM: the user is created, updated or deleted
+// if it no longer exists in the source, and its group memberships and manager are synced. The configured run scope is ignored
+func (s *sync) SyncUser(user string) (stat *SyncStat, err error) {
+	if user = strings.TrimSpace(user); len(user) == 0 {
+		err = errors.New("user email or ID is required")
+		return
+	}
+	return s.syncScope(&RunScope{Users: []string{user}})
+}
+
+// SyncGroup reconciles a single group, by name, email or Google group ID, with SCIM: the group is created, updated or
+// deleted if it no longer exists in the source, and its members are synced as with SyncUser. The configured run scope is ignored
+func (s *sync) SyncGroup(group string) (stat *SyncStat, err error) {
+	if group = strings.TrimSpace(group); len(group) == 0 {
+		err = errors.New("group name or ID is required")
+		return
+	}
+	return s.syncScope(&RunScope{Groups: []string{group}})
+}
+
+// syncScope runs the sync restricted to the scope
+func (s *sync) syncScope(scope *RunScope) (stat *SyncStat, err error) {
+	var runScope = s.runScope
+	s.runScope = scope
+	defer func() {
+		s.runScope = runScope
+	}()
+	return s.Sync()
+}
+
+// selectRunScope resolves the run scope to source users and groups. Does nothing if the run is not scoped
+func (s *sync) selectRunScope() {
+	s.scopeUsers = nil
+	s.scopeGroups = nil
+	if s.runScope.IsEmpty() {
+		return
+	}
+	var fold = cases.Fold()
+	var users = NewSet[string]()
+	for _, u := range s.runScope.Users {
+		users.Add(foldEmail(strings.TrimSpace(u)))
+	}
+	var groups = NewSet[string]()
+	for _, g := range s.runScope.Groups {
+		groups.Add(fold.String(strings.TrimSpace(g)))
+	}
+	s.scopeGroups = NewSet[string]()
+	s.source.Groups(func(group *Group) {
+		if groups.Has(group.Id) || groups.Has(fold.String(group.Name)) || groups.Has(fold.String(group.AttributeString(SourceAttributeEmail))) {
+			s.scopeGroups.Add(group.Id)
+		}
+	})
+	s.scopeUsers = NewSet[string]()
+	s.source.Users(func(user *User) {
+		if users.Has(user.Id) || users.Has(foldEmail(user.Email)) {
+			s.scopeUsers.Add(user.Id)
+			return
+		}
+		for _, groupId := range user.Groups {
+			if s.scopeGroups.Has(groupId) {
+				s.scopeUsers.Add(user.Id)
+				return
+			}
+		}
+	})
+	var notFound []string
+	for _, u := range s.runScope.Users {
+		var found = false
+		s.source.Users(func(user *User) {
+			if !found && (user.Id == u || foldEmail(user.Email) == foldEmail(u)) {
+				found = true
+			}
+		})
+		if !found {
+			notFound = append(notFound, u)
+		}
+	}
+	if len(notFound) > 0 {
+		log.Printf("Run scope: user(s) %s are not found in the source. Only their deletes are applied", strings.Join(notFound, ", "))
+	}
+	log.Printf("Run scope: changes are applied to %d user(s) and %d group(s) selected by %s", len(s.scopeUsers), len(s.scopeGroups), s.runScope)
+}
+
+// inScopeUser returns true if the source user is in the run scope
+func (s *sync) inScopeUser(user *User) bool {
+	return s.scopeUsers == nil || s.scopeUsers.Has(user.Id)
+}
+
+// inScopeGroup returns true if the source group is in the run scope
+func (s *sync) inScopeGroup(group *Group) bool {
+	return s.scopeGroups == nil || s.scopeGroups.Has(group.Id)
+}
+
+// inScopeDeleteUser returns true if the SCIM user missing in the source is listed in the run scope
+func (s *sync) inScopeDeleteUser(su *scimUser) bool {
+	if s.scopeUsers == nil {
+		return true
+	}
+	for _, u := range s.runScope.Users {
+		var name = foldEmail(u)
+		if s.externalId(u) == su.ExternalId || name == foldEmail(su.Email) || name == foldEmail(su.UserName) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScopeDeleteGroup returns true if the SCIM group missing in the source is listed in the run scope
+func (s *sync) inScopeDeleteGroup(sg *scimGroup) bool {
+	if s.scopeGroups == nil {
+		return true
+	}
+	var fold = cases.Fold()
+	for _, g := range s.runScope.Groups {
+		if s.externalId(g) == sg.ExternalId || g == sg.Id || fold.String(g) == fold.String(sg.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	gosync "sync"
+)
+
+var secretRegistry = struct {
+	lock    gosync.Mutex
+	secrets Set[string]
+}{secrets: NewSet[string]()}
+
+// RegisterSecrets adds values, e.g. the SCIM token, that are removed from error messages, logs and reports
+func RegisterSecrets(secrets ...string) {
+	secretRegistry.lock.Lock()
+	defer secretRegistry.lock.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= 4 {
+			secretRegistry.secrets.Add(secret)
+		}
+	}
+}
+
+// registerCredentialSecrets registers the private key of the service account credentials JSON
+func registerCredentialSecrets(credentials []byte) {
+	if len(credentials) == 0 {
+		return
+	}
+	var key struct {
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyId string `json:"private_key_id"`
+	}
+	if err := json.Unmarshal(credentials, &key); err == nil {
+		RegisterSecrets(key.PrivateKey, key.PrivateKeyId)
+	}
+}
+
+func registeredSecrets() []string {
+	secretRegistry.lock.Lock()
+	defer secretRegistry.lock.Unlock()
+	return secretRegistry.secrets.ToArray()
+}
+
+// SanitizeText removes registered secrets, bearer tokens, private keys and credential fields from the text
+func SanitizeText(text string) string {
+	return redactSecrets(text, registeredSecrets())
+}
+
+// sanitizedError hides secrets in the message of the wrapped error. The wrapped error is still available to errors.As
+type sanitizedError struct {
+	message string
+	err     error
+}
+
+func (se *sanitizedError) Error() string {
+	return se.message
+}
+
+func (se *sanitizedError) Unwrap() error {
+	return se.err
+}
+
+// SanitizeError returns the error with secrets removed from its message
+func SanitizeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var message = err.Error()
+	var sanitized = SanitizeText(message)
+	if sanitized == message {
+		return err
+	}
+	return &sanitizedError{message: sanitized, err: err}
+}
+
+// sanitizeStat removes secrets from every text of the sync result before it is reported,
+// including operations, the sync plan and match decisions
+func sanitizeStat(stat *SyncStat) {
+	if stat != nil {
+		sanitizeValue(reflect.ValueOf(stat).Elem())
+	}
+}
+
+// sanitizeValue removes secrets from the strings of the exported fields, elements and map values of the value
+func sanitizeValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(SanitizeText(v.String()))
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			sanitizeValue(v.Elem())
+		}
+	case reflect.Interface:
+		if !v.IsNil() && v.CanSet() {
+			// the value of an interface cannot be changed in place
+			var value = copyValue(v.Elem())
+			sanitizeValue(value)
+			v.Set(value)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				sanitizeValue(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			sanitizeValue(v.Index(i))
+		}
+	case reflect.Map:
+		var it = v.MapRange()
+		for it.Next() {
+			var value = copyValue(it.Value())
+			sanitizeValue(value)
+			v.SetMapIndex(it.Key(), value)
+		}
+	}
+}
+
+// copyValue returns a settable copy of the value
+func copyValue(v reflect.Value) reflect.Value {
+	var value = reflect.New(v.Type()).Elem()
+	value.Set(v)
+	return value
+}
+
+// sanitizingWriter removes secrets from the log output
+type sanitizingWriter struct {
+	out io.Writer
+}
+
+func (w *sanitizingWriter) Write(p []byte) (n int, err error) {
+	if _, err = w.out.Write([]byte(SanitizeText(string(p)))); err != nil {
+		return
+	}
+	n = len(p)
+	return
+}
@@ -0,0 +1,103 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fillStrings sets every string reachable from the value to the text. Pointers are allocated,
+// slices get one element, maps one entry and empty interfaces the text itself
+func fillStrings(v reflect.Value, text string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillStrings(v.Elem(), text)
+	case reflect.Interface:
+		if v.Type().NumMethod() == 0 {
+			v.Set(reflect.ValueOf(text))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fillStrings(v.Field(i), text)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillStrings(v.Index(0), text)
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		var key = reflect.New(v.Type().Key()).Elem()
+		var value = reflect.New(v.Type().Elem()).Elem()
+		fillStrings(value, text)
+		v.SetMapIndex(key, value)
+	}
+}
+
+// collectStrings adds every string reachable from the value by its path
+func collectStrings(v reflect.Value, path string, texts map[string][]string) {
+	switch v.Kind() {
+	case reflect.String:
+		texts[path] = append(texts[path], v.String())
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collectStrings(v.Elem(), path, texts)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				collectStrings(v.Field(i), path+"."+v.Type().Field(i).Name, texts)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			collectStrings(v.Index(i), path+"[]", texts)
+		}
+	case reflect.Map:
+		var it = v.MapRange()
+		for it.Next() {
+			collectStrings(it.Value(), path+"{}", texts)
+		}
+	}
+}
+
+func TestSanitizeStatEveryString(t *testing.T) {
+	const secret = "stat-secret-5e0c9a"
+	RegisterSecrets(secret)
+
+	var stat = new(SyncStat)
+	fillStrings(reflect.ValueOf(stat).Elem(), "error: "+secret)
+	stat.Operations = append(stat.Operations, &JournalEntry{Inverse: &ScimOperation{
+		Payload: map[string]any{
+			"displayName": secret,
+			"members":     []any{map[string]any{"value": secret}},
+		},
+	}})
+
+	var before = make(map[string][]string)
+	collectStrings(reflect.ValueOf(stat), "SyncStat", before)
+	for _, path := range []string{"SyncStat.FailedUsers[]", "SyncStat.Operations[].Inverse.Payload",
+		"SyncStat.Plan.Changes[].Name", "SyncStat.MatchDecisions[].Rationale", "SyncStat.BudgetDeferred[]"} {
+		if _, ok := before[path]; !ok {
+			t.Errorf("%s is not filled", path)
+		}
+	}
+
+	sanitizeStat(stat)
+
+	var after = make(map[string][]string)
+	collectStrings(reflect.ValueOf(stat), "SyncStat", after)
+	if len(after) != len(before) {
+		t.Errorf("%d string fields after sanitizing, want %d", len(after), len(before))
+	}
+	for path, texts := range after {
+		for _, text := range texts {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s is not sanitized: %s", path, text)
+			}
+		}
+	}
+}
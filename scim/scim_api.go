@@ -5,20 +5,50 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
+	"time"
 )
 
 type scimUser struct {
 	User
+	UserName   string
 	ExternalId string
+	ManagerId  string
+	Roles      []string
+	// Entitlements are values of the "entitlements" attribute
+	Entitlements []string
+	// StateAttributes are the values of attributes controlled by the user state mapping
+	StateAttributes map[string]any
+	// Photos is the value of the "photos" attribute as returned by the SCIM server
+	Photos []any
+	meta   scimMeta
 }
 
 type scimGroup struct {
 	Group
 	ExternalId string
+	meta       scimMeta
+}
+
+// scimMeta contains the timestamps of the "meta" attribute. Zero if the SCIM server does not report them
+type scimMeta struct {
+	Created      time.Time
+	LastModified time.Time
+}
+
+func parseScimMeta(resourceObject map[string]any) (meta scimMeta) {
+	if jo, ok := resourceObject["meta"].(map[string]any); ok {
+		if value, ok := toString(jo["created"]); ok {
+			meta.Created, _ = time.Parse(time.RFC3339, value)
+		}
+		if value, ok := toString(jo["lastModified"]); ok {
+			meta.LastModified, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return
 }
 
 func parseScimGroup(groupObject map[string]any) (result *scimGroup) {
@@ -32,6 +62,7 @@ func parseScimGroup(groupObject map[string]any) (result *scimGroup) {
 		result.Id = id
 		result.Name = name
 		result.ExternalId, _ = toString(groupObject["externalId"])
+		result.meta = parseScimMeta(groupObject)
 	}
 	return
 }
@@ -47,9 +78,22 @@ func parseScimUser(userObject map[string]any) (result *scimUser) {
 	}
 	result = new(scimUser)
 	result.Id = userId
+	result.UserName = email
 	result.Email = email
+	if ja, ok := userObject["emails"].([]any); ok {
+		for _, je := range ja {
+			if jo, ok := je.(map[string]any); ok {
+				var value, _ = toString(jo["value"])
+				if primary, _ := toBoolean(jo["primary"]); primary && len(value) > 0 {
+					result.Email = value
+					break
+				}
+			}
+		}
+	}
 	result.Active, _ = toBoolean(userObject["active"])
 	result.ExternalId, _ = toString(userObject["externalId"])
+	result.meta = parseScimMeta(userObject)
 	result.FullName, _ = toString(userObject["displayName"])
 	var j any
 	var jo map[string]any
@@ -59,6 +103,21 @@ func parseScimUser(userObject map[string]any) (result *scimUser) {
 			result.LastName, _ = toString(jo["familyName"])
 		}
 	}
+	if j = userObject[enterpriseUserSchema]; j != nil {
+		if jo, ok = j.(map[string]any); ok {
+			if jm, ok := jo["manager"].(map[string]any); ok {
+				result.ManagerId, _ = toString(jm["value"])
+			}
+		}
+	}
+	result.PhoneNumbers = parseScimPhones(userObject["phoneNumbers"])
+	result.Addresses = parseScimAddresses(userObject["addresses"])
+	result.PreferredLanguage, _ = toString(userObject["preferredLanguage"])
+	result.Locale, _ = toString(userObject["locale"])
+	result.Timezone, _ = toString(userObject["timezone"])
+	result.Roles = parseScimMultiValues(userObject["roles"])
+	result.Entitlements = parseScimMultiValues(userObject["entitlements"])
+	result.Photos, _ = userObject["photos"].([]any)
 	if j = userObject["groups"]; j != nil {
 		var ja []any
 		if ja, ok = j.([]any); ok {
@@ -75,24 +134,41 @@ func parseScimUser(userObject map[string]any) (result *scimUser) {
 	return
 }
 
+// groupsUnsupportedNotice is reported when the SCIM server does not implement the Groups endpoint
+const groupsUnsupportedNotice = "SCIM server does not support the Groups endpoint. The sync runs in users-only mode: groups and memberships are not provisioned"
+
+// isUnsupportedEndpoint returns true if the SCIM server does not implement the resource endpoint
+func isUnsupportedEndpoint(err error) bool {
+	var status = scimStatus(err)
+	return status == http.StatusNotFound || status == http.StatusNotImplemented
+}
+
 func (s *sync) populateScim() (err error) {
 	s.scimGroups = make(map[string]*scimGroup)
+	s.groupsUnsupported = false
 	if err = s.getResources("Groups", func(ro map[string]any) {
 		if g := parseScimGroup(ro); g != nil {
 			s.scimGroups[g.Id] = g
 		}
 	}); err != nil {
-		return
+		if !isUnsupportedEndpoint(err) {
+			return
+		}
+		log.Printf("Notice: %s (%s)", groupsUnsupportedNotice, err.Error())
+		s.groupsUnsupported = true
+		err = nil
 	}
 
 	s.scimUsers = make(map[string]*scimUser)
 	if err = s.getResources("Users", func(ro map[string]any) {
 		if user := parseScimUser(ro); user != nil {
+			s.readStateAttributes(user, ro)
 			s.scimUsers[user.Id] = user
 		}
 	}); err != nil {
 		return
 	}
+	s.separateForeignResources()
 	return
 }
 
@@ -102,7 +178,7 @@ func (s *sync) composeUrl(paths ...string) (result *url.URL, err error) {
 		return
 	}
 	var ruri *url.URL
-	for _, path := range paths {
+	for _, path := range s.resourcePaths(paths) {
 		if ruri, err = url.Parse(path); err != nil {
 			return
 		}
@@ -116,10 +192,88 @@ func (s *sync) composeUrl(paths ...string) (result *url.URL, err error) {
 	return
 }
 
+// httpClient returns the client of SCIM requests. The trace transport is the innermost middleware,
+// so it logs the requests as modified by the middleware chain
+func (s *sync) httpClient() *http.Client {
+	if !s.trace && len(s.middleware) == 0 && !s.compressRequests {
+		if s.baseClient != nil {
+			return s.baseClient
+		}
+		return defaultScimClient()
+	}
+	if s.client == nil || s.clientTrace != s.trace {
+		var transport = defaultScimTransport()
+		if s.baseClient != nil && s.baseClient.Transport != nil {
+			transport = s.baseClient.Transport
+		}
+		if s.compressRequests {
+			transport = newGzipRequestTransport(transport)
+		}
+		if s.trace {
+			transport = newTraceTransport(transport, "SCIM", s.token)
+		}
+		for i := len(s.middleware) - 1; i >= 0; i-- {
+			transport = s.middleware[i](transport)
+		}
+		s.client = &http.Client{Transport: transport}
+		if s.baseClient != nil {
+			s.client.Timeout = s.baseClient.Timeout
+			s.client.Jar = s.baseClient.Jar
+			s.client.CheckRedirect = s.baseClient.CheckRedirect
+		}
+		s.clientTrace = s.trace
+	}
+	return s.client
+}
+
+// nextOperationId returns a correlation ID of the next SCIM request in the run
+func (s *sync) nextOperationId() string {
+	s.operationLock.Lock()
+	defer s.operationLock.Unlock()
+	if len(s.runId) == 0 {
+		s.runId = newRunId()
+	}
+	s.operationNo++
+	s.lastOperationId = fmt.Sprintf("%s-%05d", s.runId, s.operationNo)
+	return s.lastOperationId
+}
+
 func (s *sync) executeRequest(rq *http.Request) (response map[string]any, err error) {
-	client := http.DefaultClient
+	response, _, _, err = s.executeConditionalRequest(rq)
+	return
+}
+
+// executeConditionalRequest executes the request that may carry "If-None-Match" or "If-Modified-Since" header.
+// notModified is true if the server responded with "304 Not Modified"
+func (s *sync) executeConditionalRequest(rq *http.Request) (response map[string]any, header http.Header, notModified bool, err error) {
+	if rq.Method != http.MethodGet && s.budgetExhausted() {
+		err = fmt.Errorf("%s SCIM request skipped: %w", rq.Method, ErrTimeBudgetExhausted)
+		return
+	}
+	client := s.httpClient()
+	var operationId = s.nextOperationId()
+	rq.Header.Set("X-Request-Id", operationId)
+	rq.Header.Set("X-Correlation-Id", s.runId)
+	rq.Header.Set("User-Agent", s.userAgent())
+	var resourcePath = strings.TrimPrefix(rq.URL.Path, "/")
+	if uri, er1 := url.Parse(s.baseUrl); er1 == nil {
+		resourcePath = strings.Trim(strings.TrimPrefix(rq.URL.Path, uri.Path), "/")
+	}
+	if pos := strings.Index(resourcePath, "/"); pos > 0 {
+		resourcePath = resourcePath[:pos]
+	}
+	setLogLabel("resource_type", resourcePath)
+	defer setLogLabel("resource_type", "")
+	s.debugLogger(fmt.Sprintf("SCIM request %s: %s %s", operationId, rq.Method, rq.URL.Path))
 	var rs *http.Response
 	if rs, err = client.Do(rq); err != nil {
+		err = withStack(SanitizeError(fmt.Errorf("%s SCIM request %s error: %w", rq.Method, operationId, err)))
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	header = rs.Header
+	if rs.StatusCode == http.StatusNotModified {
+		notModified = true
 		return
 	}
 	var body []byte
@@ -135,11 +289,17 @@ func (s *sync) executeRequest(rq *http.Request) (response map[string]any, err er
 			scimUrl = scimUrl[len(s.baseUrl):]
 			scimUrl = strings.Trim(scimUrl, "/")
 		}
-		if len(body) > 0 {
-			err = fmt.Errorf("%s SCIM \"%s\" error: %s", rq.Method, scimUrl, string(body))
-		} else {
-			err = fmt.Errorf("%s SCIM \"%s\" error: Status code %d", rq.Method, scimUrl, rs.StatusCode)
+		var se = parseScimError(rs.StatusCode, body)
+		switch {
+		case len(se.ScimType) > 0 || len(se.Detail) > 0:
+			se.message = fmt.Sprintf("%s SCIM \"%s\" (request %s) error: %s", rq.Method, scimUrl, operationId, se.describe())
+		case len(body) > 0:
+			se.message = fmt.Sprintf("%s SCIM \"%s\" (request %s) error: %s", rq.Method, scimUrl, operationId, string(body))
+		default:
+			se.message = fmt.Sprintf("%s SCIM \"%s\" (request %s) error: Status code %d", rq.Method, scimUrl, operationId, rs.StatusCode)
 		}
+		se.message = SanitizeText(se.message)
+		err = withStack(se)
 		return
 	}
 	if (rs.StatusCode == 200 || rs.StatusCode == 201) && len(body) > 0 {
@@ -148,7 +308,32 @@ func (s *sync) executeRequest(rq *http.Request) (response map[string]any, err er
 	return
 }
 
+// patchResource sends the PATCH request in the configured PATCH style and the dialect of the target.
+// A rejected request is retried with alternate encodings in the "auto" PATCH style or if dialect probing is enabled
 func (s *sync) patchResource(resourceType string, resourceId string, payload any) (err error) {
+	if s.dialect.GroupMembership && resourceType == "Users" && hasMembershipOperations(payload) {
+		if payload, err = s.patchMembershipByGroups(resourceId, payload); err != nil || payload == nil {
+			return
+		}
+	}
+	if s.dialect.PutUpdates && !hasMembershipOperations(payload) {
+		return s.putPatched(resourceType, resourceId, payload)
+	}
+	if s.usePathPatch() {
+		if converted := toPathPatch(payload); converted != nil {
+			payload = converted
+		}
+	}
+	if err = s.sendPatch(resourceType, resourceId, payload); err == nil || !isRejectedPatch(err) {
+		return
+	}
+	if s.probeWorkarounds(resourceType, resourceId, payload) {
+		err = nil
+	}
+	return
+}
+
+func (s *sync) sendPatch(resourceType string, resourceId string, payload any) (err error) {
 	var uri *url.URL
 	if uri, err = s.composeUrl(resourceType, resourceId); err != nil {
 		return
@@ -187,7 +372,18 @@ func (s *sync) postResource(resourceType string, payload any) (resource map[stri
 	}
 	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
 
-	resource, err = s.executeRequest(rq)
+	var header http.Header
+	if resource, header, _, err = s.executeConditionalRequest(rq); err != nil {
+		return
+	}
+	if resource["id"] == nil {
+		// the resource was created. A failed lookup only leaves the new resource out of the rest of the run
+		if created, er1 := s.readCreatedResource(resourceType, header.Get("Location"), payload); er1 == nil && created != nil {
+			resource = created
+		} else if er1 != nil {
+			s.debugLogger(fmt.Sprintf("SCIM \"%s\" resource was created without ID. Lookup failed: %s", resourceType, er1.Error()))
+		}
+	}
 	return
 }
 
@@ -213,8 +409,19 @@ func (s *sync) getResources(resourceType string, cb func(map[string]any)) (err e
 		return
 	}
 
+	var cache = s.loadListingCache(resourceType)
+	var pages, unchanged int
+	var deliver = func(page *scimPage) {
+		pages++
+		if page.notModified {
+			unchanged++
+		}
+		for _, ro := range page.resources {
+			cb(ro)
+		}
+	}
+
 	var startIndex int64 = 1
-	var count = 500
 	var attempt = 0
 	for {
 		attempt += 1
@@ -222,51 +429,31 @@ func (s *sync) getResources(resourceType string, cb func(map[string]any)) (err e
 			err = fmt.Errorf("get SCIM resource \"%s\" canceled", resourceType)
 			return
 		}
-		var ruri = new(url.URL)
-		*ruri = *uri
-		ruri.Query().Add("startIndex", strconv.FormatInt(startIndex, 10))
-		ruri.Query().Add("count", strconv.Itoa(count))
-
-		var rq *http.Request
-		if rq, err = http.NewRequest("GET", ruri.String(), nil); err != nil {
+		var page *scimPage
+		if page, err = s.getResourcePage(uri, resourceType, startIndex, scimPageSize, cache); err != nil {
 			return
 		}
-		rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+		deliver(page)
+		startIndex = page.startIndex + page.itemsPerPage
+		var totalResults = page.totalResults
 
-		var jo map[string]any
-		if jo, err = s.executeRequest(rq); err != nil {
-			return
-		}
-		var j any
-		var ok bool
-		if j, ok = jo["Resources"]; ok {
-			var jr []any
-			if jr, ok = j.([]any); ok {
-				for _, j = range jr {
-					var jor map[string]any
-					if jor, ok = j.(map[string]any); ok {
-						cb(jor)
-					}
-				}
+		if attempt == 1 && startIndex <= totalResults && page.honorsPaging(1, page.itemsPerPage) {
+			var rest []*scimPage
+			if rest, err = s.getResourcePagesParallel(uri, resourceType, startIndex, page.itemsPerPage, totalResults, cache); err != nil {
+				return
+			}
+			for _, page = range rest {
+				deliver(page)
+				startIndex = page.startIndex + page.itemsPerPage
+				totalResults = page.totalResults
 			}
 		}
-		var itemsPerPage int64 = 0
-		if itemsPerPage, ok = toInt64(jo["itemsPerPage"]); !ok {
-			err = fmt.Errorf("response does not conform to SCIM specification: missing \"itemsPerPage\"")
-			return
-		}
-		if startIndex, ok = toInt64(jo["startIndex"]); !ok {
-			err = fmt.Errorf("response does not conform to SCIM specification: missing \"startIndex\"")
-			return
-		}
-		startIndex += itemsPerPage
 
-		var totalResults int64 = 0
-		if totalResults, ok = toInt64(jo["totalResults"]); !ok {
-			err = fmt.Errorf("response does not conform to SCIM specification: missing \"totalResults\"")
-			return
-		}
-		if startIndex >= totalResults {
+		if page.itemsPerPage <= 0 || startIndex > totalResults {
+			if cache != nil {
+				s.debugLogger(fmt.Sprintf("SCIM \"%s\" listing: %d of %d page(s) not modified", resourceType, unchanged, pages))
+				s.saveListingCache(resourceType, cache)
+			}
 			return
 		}
 	}
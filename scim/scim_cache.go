@@ -0,0 +1,90 @@
+package scim
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	gosync "sync"
+)
+
+const scimListingCacheKey = "scim-listing-"
+
+// scimListingCache keeps SCIM listing pages with their validators, so unchanged pages are not downloaded again
+type scimListingCache struct {
+	// Pages are keyed by startIndex
+	Pages map[string]*scimCachedPage `json:"pages"`
+	// Pages are requested concurrently
+	lock gosync.Mutex
+}
+
+type scimCachedPage struct {
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"lastModified,omitempty"`
+	Response     map[string]any `json:"response"`
+}
+
+// loadListingCache returns the cached listing of the resource type. Returns nil if listing caching is disabled
+func (s *sync) loadListingCache(resourceType string) (cache *scimListingCache) {
+	if !s.cacheListings || s.stateStore == nil {
+		return
+	}
+	cache = &scimListingCache{Pages: make(map[string]*scimCachedPage)}
+	if data, err := s.stateStore.Load(scimListingCacheKey + resourceType); err == nil && len(data) > 0 {
+		if err = json.Unmarshal(data, cache); err != nil || cache.Pages == nil {
+			cache.Pages = make(map[string]*scimCachedPage)
+		}
+	}
+	return
+}
+
+// saveListingCache stores the listing pages. Errors are logged only
+func (s *sync) saveListingCache(resourceType string, cache *scimListingCache) {
+	var data, err = json.Marshal(cache)
+	if err == nil {
+		err = s.stateStore.Save(scimListingCacheKey+resourceType, data)
+	}
+	if err != nil {
+		log.Printf("Failed to store SCIM \"%s\" listing cache: %s", resourceType, err.Error())
+	}
+}
+
+// setConditionalHeaders makes the page request conditional on the cached page validators
+func (c *scimListingCache) setConditionalHeaders(rq *http.Request, startIndex int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if page, ok := c.Pages[strconv.FormatInt(startIndex, 10)]; ok {
+		if len(page.ETag) > 0 {
+			rq.Header.Set("If-None-Match", page.ETag)
+		} else if len(page.LastModified) > 0 {
+			rq.Header.Set("If-Modified-Since", page.LastModified)
+		}
+	}
+}
+
+// cachedPage returns the cached page after "304 Not Modified" response
+func (c *scimListingCache) cachedPage(startIndex int64) map[string]any {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if page, ok := c.Pages[strconv.FormatInt(startIndex, 10)]; ok {
+		return page.Response
+	}
+	return nil
+}
+
+// storePage keeps the page if the server returned a validator. Pages without validator are never requested conditionally
+func (c *scimListingCache) storePage(startIndex int64, header http.Header, response map[string]any) {
+	var key = strconv.FormatInt(startIndex, 10)
+	var page = &scimCachedPage{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Response:     response,
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(page.ETag) == 0 && len(page.LastModified) == 0 {
+		delete(c.Pages, key)
+		return
+	}
+	c.Pages[key] = page
+}
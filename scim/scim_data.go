@@ -1,5 +1,12 @@
 package scim
 
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
 type SyncDebugLogger func(string)
 
 var NilLogger SyncDebugLogger = func(string) {}
@@ -12,25 +19,171 @@ type ICrmDataSource interface {
 	DebugLogger() SyncDebugLogger
 	SetDebugLogger(SyncDebugLogger)
 	LoadErrors() bool
+	Resolutions() []*EntryResolution
+}
+
+// Kinds of "SCIM Group" configuration entry resolution
+const (
+	ResolvedGroup   = "group"
+	ResolvedUser    = "user"
+	ResolvedNothing = "nothing"
+)
+
+// EntryResolution describes what a configured "SCIM Group" entry resolved to
+type EntryResolution struct {
+	Entry   string
+	Kind    string
+	Matches []string
+	// Error is the classified API error if the entry was not resolved due to an error
+	Error string
+}
+
+func (er *EntryResolution) String() string {
+	if er.Kind == ResolvedNothing {
+		if len(er.Error) > 0 {
+			return fmt.Sprintf("\"%s\": not resolved (%s)", er.Entry, er.Error)
+		}
+		return fmt.Sprintf("\"%s\": not resolved", er.Entry)
+	}
+	return fmt.Sprintf("\"%s\": %s %s", er.Entry, er.Kind, strings.Join(er.Matches, ", "))
+}
+
+// ILoadFailureSource is implemented by data sources that can explain why LoadErrors is set
+type ILoadFailureSource interface {
+	LoadFailures() []string
 }
 
 type SyncStat struct {
-	SuccessUsers      []string
-	FailedUsers       []string
-	SuccessGroups     []string
-	FailedGroups      []string
-	SuccessMembership []string
-	FailedMembership  []string
+	RunId             string          `json:"runId"`
+	Started           time.Time       `json:"started"`
+	Finished          time.Time       `json:"finished"`
+	Operations        []*JournalEntry `json:"operations,omitempty"`
+	Plan              *SyncPlan       `json:"plan,omitempty"`
+	SafeModeReasons   []string        `json:"safeModeReasons,omitempty"`
+	SuccessUsers      []string        `json:"successUsers,omitempty"`
+	FailedUsers       []string        `json:"failedUsers,omitempty"`
+	SkippedUsers      []*SkippedUser  `json:"skippedUsers,omitempty"`
+	SuccessGroups     []string        `json:"successGroups,omitempty"`
+	FailedGroups      []string        `json:"failedGroups,omitempty"`
+	SuccessMembership []string        `json:"successMembership,omitempty"`
+	FailedMembership  []string        `json:"failedMembership,omitempty"`
+	// CanaryDeferred are changes outside of the canary scope that the full run would make
+	CanaryDeferred []string `json:"canaryDeferred,omitempty"`
+	// CapacityWarnings are teams that rejected new members due to the SCIM member limit
+	CapacityWarnings []string `json:"capacityWarnings,omitempty"`
+	// PendingDeletions are deactivated users deleted in the source that are kept until the deletion grace period ends
+	PendingDeletions []string `json:"pendingDeletions,omitempty"`
+	// SourceApi counts API calls of the data source, if the data source supports it
+	SourceApi *SourceApiStats `json:"sourceApi,omitempty"`
+	// Notices describe reduced functionality of the run, e.g. the users-only mode
+	Notices []string `json:"notices,omitempty"`
+	// Partial is set if the run stopped starting changes because its time budget ran out
+	Partial bool `json:"partial,omitempty"`
+	// BudgetDeferred are changes that were not started because the time budget ran out
+	BudgetDeferred []string `json:"budgetDeferred,omitempty"`
+	// Memory is the memory usage of the run, for sizing the memory of the Cloud Function or container
+	Memory *MemoryStats `json:"memory,omitempty"`
+	// MatchDecisions are groups bound by a heuristic rather than by external ID, with the rationale and confidence
+	MatchDecisions []*MatchDecision `json:"matchDecisions,omitempty"`
+	// RolledBackRunId is the run reverted by a rollback run
+	RolledBackRunId string `json:"rolledBackRunId,omitempty"`
 }
+
+// ScimMiddleware wraps the transport of SCIM requests, e.g. to sign requests, add headers or collect metrics
+type ScimMiddleware func(next http.RoundTripper) http.RoundTripper
+
 type IScimSync interface {
 	Source() ICrmDataSource
 	Sync() (*SyncStat, error)
+	SyncUser(user string) (*SyncStat, error)
+	SyncGroup(group string) (*SyncStat, error)
+	Plan() (*SyncPlan, error)
+	Simulate() (*Simulation, error)
+	// ScimUsers, ScimGroups and SourceUsers return the data loaded by the last run, ordered by email or name.
+	// They are empty before the first run and safe to call while a run is in progress. The returned entries must not be modified
+	ScimUsers() []*ScimUser
+	ScimGroups() []*ScimGroup
+	SourceUsers() []*User
 	Verbose() bool
+	// Deprecated: use WithVerbose with NewScimSync or NewSync.
 	SetVerbose(bool)
+	// DryRun returns true if Sync reports the sync plan without making any change. See WithDryRun
+	DryRun() bool
+	Trace() bool
 	UpdateUsers() bool
+	// Deprecated: set ScimEndpointParameters.UpdateUsers and use WithParameters.
 	SetUpdateUsers(bool)
 	Destructive() int32
+	// Deprecated: use WithDestructivePolicy with NewScimSync or NewSync.
 	SetDestructive(int32)
+	MaxDeletes() int32
+	DeleteGraceDays() int32
+	DeleteGraceRuns() int32
+	SyncManager() bool
+	UserNameFormat() string
+	AllowedDomains() []string
+	Attributes() []string
+	// UserComparator decides which attribute differences of existing users are patched. nil patches every difference
+	UserComparator() UserComparator
+	GroupPolicies() map[string]GroupPolicy
+	UserStates() map[string]*UserStateRule
+	// ExternalIdPrefix is the namespace prefix of externalId values, e.g. "google:". Empty prefix keeps raw source IDs
+	ExternalIdPrefix() string
+	// Cooperative restricts the sync to SCIM resources with the external ID prefix. Other resources are never changed
+	Cooperative() bool
+	// CreateWithGroups sends the groups of new users in the "groups" attribute of the POST request
+	CreateWithGroups() bool
+	// CreateWithMembers sends the existing SCIM users of new groups in the "members" attribute of the POST request
+	CreateWithMembers() bool
+	// TimeBudget is the execution time of a sync run. Close to the end of the budget no SCIM change is started,
+	// the progress is stored and the run reports a partial result. Zero means no limit
+	TimeBudget() time.Duration
+	// UserNotifier sends email to users created by the sync and to users scheduled for deletion.
+	// Templates by event ("provisioned", "deprovisioning") replace the default messages
+	UserNotifier() IMailSender
+	// RenameConflictPolicy is "skip" or "merge": how a group rename that collides with an existing SCIM group is resolved
+	RenameConflictPolicy() string
+	// PatchStyle is "value", "path" or "auto": how attributes of PATCH requests are sent
+	PatchStyle() string
+	// ProbeDialect retries rejected writes with alternate encodings: path operations, GET and PUT, group-side membership.
+	// The encoding the server accepts is used for later writes and kept in the state store
+	ProbeDialect() bool
+	// CompressRequests sends SCIM request bodies compressed with gzip. Responses are always accepted compressed
+	CompressRequests() bool
+	// ListParallelism is the number of SCIM listing pages fetched concurrently. 0 is the default of 4, 1 pages sequentially
+	ListParallelism() int32
+	// GroupMatchFallback is "none", "position" or "overlap[:threshold]": how groups left unmatched by external ID and name are paired
+	GroupMatchFallback() string
+	// MembershipTransaction is "off", "add-first" or "remove-first": how membership additions and removals of a user are ordered
+	MembershipTransaction() string
+	// RoleMapping assigns SCIM roles to members of source groups in the roles phase
+	RoleMapping() RoleMapping
+	// EntitlementMapping assigns SCIM entitlements to members of source groups. Entitlements are set with user attributes
+	EntitlementMapping() EntitlementMapping
+	ScimPaths() *ScimPaths
+	StateStore() IStateStore
+	// HistoryRuns is the number of most recent sync runs kept in the state store. Zero keeps all runs
+	HistoryRuns() int32
+	CacheListings() bool
+	StrictResolution() bool
+	Phases() []string
+	// PhaseOrder returns the preferred execution order of sync steps. Dependencies between steps take precedence
+	PhaseOrder() []string
+	SyncCaps() *SyncCaps
+	ResultSinks() []IResultSink
+	Canary() *CanaryScope
+	RunScope() *RunScope
+	DefaultGroups() []string
+	DriftReport() (*DriftReport, error)
+	// UnmanagedReport lists SCIM users and groups outside the sync scope. No change is made
+	UnmanagedReport() (*UnmanagedReport, error)
+	DriftReportHook() ISyncHook
+	PreSyncHook() ISyncHook
+	PostSyncHook() ISyncHook
+	Policy() IPlanPolicy
+	RunId() string
+	Rollback(runId string) (*SyncStat, error)
+	BackfillExternalIds() (*SyncStat, error)
 }
 
 type User struct {
@@ -41,23 +194,137 @@ type User struct {
 	LastName  string
 	Active    bool
 	Groups    []string
+	Manager   string
+	// State is the source account state: "active", "suspended" or "archived"
+	State string
+
+	EmployeeId string
+
+	PhoneNumbers      []PhoneNumber
+	Addresses         []Address
+	PhotoEtag         string
+	PreferredLanguage string
+	Locale            string
+	Timezone          string
+
+	// Attributes are source attributes without a dedicated field. See SourceAttribute constants
+	Attributes map[string]any
+	// SchemaVersion is the ModelVersion populated by the data source
+	SchemaVersion int
 }
 
 type Group struct {
 	Id   string
 	Name string
+
+	// Attributes are source attributes without a dedicated field. See SourceAttribute constants
+	Attributes map[string]any
+	// SchemaVersion is the ModelVersion populated by the data source
+	SchemaVersion int
 }
 
 type ScimEndpointParameters struct {
-	Url         string
-	Token       string
-	Verbose     bool
-	UpdateUsers bool
-	Destructive int32
+	Url   string
+	Token string
+	// Paths override the SCIM resource paths. nil uses the standard "Users" and "Groups" endpoints
+	Paths           *ScimPaths
+	Verbose         bool
+	Trace           bool
+	UpdateUsers     bool
+	Destructive     int32
+	MaxDeletes      int32
+	DeleteGraceDays int32
+	DeleteGraceRuns int32
+	GroupPolicies   map[string]GroupPolicy
+	// RenameConflicts is the policy of group renames that collide with an existing SCIM group: "skip" or "merge"
+	RenameConflicts string
+	SyncManager     bool
+	Attributes      []string
+	UserNameFormat  string
+	AllowedDomains  []string
+	// StrictResolution aborts the sync if any "SCIM Group" entry cannot be resolved
+	StrictResolution bool
+	Phases           []string
+	SyncCaps         *SyncCaps
+	StateStore       string
+	// CacheListings caches SCIM listing pages in the state store and downloads only modified pages
+	CacheListings bool
+	// WriteBackStatus stores the sync run summary in the Keeper SCIM record. KSM configuration only
+	WriteBackStatus bool
+	ResultSinks     []string
+	Canary          *CanaryScope
+	// DefaultGroups are SCIM groups every newly created user is added to
+	DefaultGroups []string
+	// DriftReport is a shell command or HTTP URL that receives the drift report every DriftReportInterval
+	DriftReport         string
+	DriftReportInterval time.Duration
+	PreSyncHook         string
+	PostSyncHook        string
+	PolicyUrl           string
+	// UserStates map source account states to SCIM active flag and attributes
+	UserStates map[string]*UserStateRule
+	// RoleMapping assigns SCIM roles to members of source groups
+	RoleMapping RoleMapping
+	// EntitlementMapping assigns SCIM entitlements to members of source groups
+	EntitlementMapping EntitlementMapping
+	// ExternalIdPrefix is the namespace prefix of externalId values, e.g. "google:"
+	ExternalIdPrefix string
+	// Cooperative manages only SCIM resources with the external ID prefix
+	Cooperative bool
+	// HistoryRuns is the number of most recent sync runs kept in the state store. Zero keeps all runs
+	HistoryRuns int32
+	// TelemetryUrl is the HTTPS endpoint that receives anonymized run metrics. Telemetry is disabled if empty
+	TelemetryUrl string
+	// UserAgentSuffix is appended to the User-Agent header of SCIM and Google API requests
+	UserAgentSuffix string
+	// MembershipTransaction is the policy of membership changes that add and remove groups: "off", "add-first" or "remove-first"
+	MembershipTransaction string
+	// IgnoredAttributes are user attributes whose differences do not require a PATCH of existing users
+	IgnoredAttributes []string
+	// CreateWithGroups adds memberships of new users in the create request
+	CreateWithGroups bool
+	// CreateWithMembers adds members of new groups in the create request
+	CreateWithMembers bool
+	// OutputFormat is the format of the sync statistics output: "full" or "summary"
+	OutputFormat string
+	// OutputLimit is the number of entries listed per section of the sync statistics output
+	OutputLimit int32
+	// TimeBudget is the execution time of a sync run. Zero means no limit
+	TimeBudget time.Duration
+	// UserNotify is the "smtp://", "smtps://" or "gmail://" connection string of user notifications
+	UserNotify string
+	// NotifyTemplates are the message templates of user notifications by event
+	NotifyTemplates map[string]string
+	// PhaseOrder is the preferred execution order of sync steps
+	PhaseOrder []string
+	// GroupMatchFallback pairs groups left unmatched by external ID and name: "none", "position" or "overlap[:threshold]"
+	GroupMatchFallback string
+	// PatchStyle sends PATCH attributes as a value map or as path operations: "value", "path" or "auto"
+	PatchStyle string
+	// ProbeDialect finds the write encoding of the SCIM target by retrying rejected writes
+	ProbeDialect bool
+	// CompressRequests compresses SCIM request bodies with gzip
+	CompressRequests bool
+	// ListParallelism is the number of SCIM listing pages fetched concurrently
+	ListParallelism int32
 }
 
 type GoogleEndpointParameters struct {
 	AdminAccount string
 	Credentials  []byte
-	ScimGroups   []string
+	// ImpersonateServiceAccount is the service account chain impersonated before domain-wide delegation.
+	// The last account is delegated. Credentials, or Application Default Credentials if empty, must be allowed to impersonate the chain
+	ImpersonateServiceAccount []string
+	ScimGroups                []string
+	TimezoneField             string
+	CustomerId                string
+	Domain                    string
+	// SkipNestedGroups treats nested groups as opaque: their members are not provisioned
+	SkipNestedGroups bool
+	// ExcludedGroups are nested groups, by email or email pattern, whose members are not provisioned
+	ExcludedGroups []string
+	// MaxNestedDepth and MaxNestedGroups limit the nested group expansion of every group. Zero means the default limit
+	MaxNestedDepth  int32
+	MaxNestedGroups int32
+	Trace           bool
 }
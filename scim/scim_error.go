@@ -0,0 +1,103 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// scimErrorSchema is the schema of SCIM error responses
+const scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+// SCIM error types, RFC 7644 section 3.12
+const (
+	ScimTypeInvalidFilter = "invalidFilter"
+	ScimTypeTooMany       = "tooMany"
+	ScimTypeUniqueness    = "uniqueness"
+	ScimTypeMutability    = "mutability"
+	ScimTypeInvalidSyntax = "invalidSyntax"
+	ScimTypeInvalidPath   = "invalidPath"
+	ScimTypeNoTarget      = "noTarget"
+	ScimTypeInvalidValue  = "invalidValue"
+	ScimTypeInvalidVers   = "invalidVers"
+	ScimTypeSensitive     = "sensitive"
+)
+
+// ScimError is a SCIM request that failed with an error status code.
+// ScimType and Detail are parsed from the SCIM error response and are empty if the server did not send one
+type ScimError struct {
+	Status   int
+	ScimType string
+	Detail   string
+	message  string
+	body     []byte
+}
+
+func (se *ScimError) Error() string {
+	return se.message
+}
+
+// IsRetryable returns true for rate limits and server errors. Rejected requests fail the same way when retried
+func (se *ScimError) IsRetryable() bool {
+	return se.Status == http.StatusTooManyRequests ||
+		(se.Status >= 500 && se.Status != http.StatusNotImplemented)
+}
+
+// parseScimError reads the SCIM error response. The "status" attribute is a string in RFC 7644, some servers send a number
+func parseScimError(statusCode int, body []byte) (se *ScimError) {
+	se = &ScimError{
+		Status: statusCode,
+		body:   body,
+	}
+	var response struct {
+		Schemas  []string        `json:"schemas"`
+		Status   json.RawMessage `json:"status"`
+		ScimType string          `json:"scimType"`
+		Detail   string          `json:"detail"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &response) != nil {
+		return
+	}
+	se.ScimType = response.ScimType
+	se.Detail = response.Detail
+	if len(response.Status) > 0 {
+		var status string
+		if json.Unmarshal(response.Status, &status) != nil {
+			status = string(response.Status)
+		}
+		if code, er1 := strconv.Atoi(status); er1 == nil && code >= 400 {
+			se.Status = code
+		}
+	}
+	return
+}
+
+// describe formats the error of the SCIM response for reports, e.g. "409 uniqueness: User already exists"
+func (se *ScimError) describe() string {
+	var text = strconv.Itoa(se.Status)
+	if len(se.ScimType) > 0 {
+		text += " " + se.ScimType
+	}
+	if len(se.Detail) > 0 {
+		text += ": " + se.Detail
+	} else if len(se.body) > 0 {
+		text += ": " + string(se.body)
+	}
+	return text
+}
+
+// IsScimErrorType returns true if the error is a SCIM error of the scimType
+func IsScimErrorType(err error, scimType string) bool {
+	var se *ScimError
+	return errors.As(err, &se) && se.ScimType == scimType
+}
+
+// scimStatus returns the status code of the SCIM error, or zero for other errors
+func scimStatus(err error) int {
+	var se *ScimError
+	if errors.As(err, &se) {
+		return se.Status
+	}
+	return 0
+}
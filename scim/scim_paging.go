@@ -0,0 +1,156 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	gosync "sync"
+	"sync/atomic"
+)
+
+const scimPageSize = 500
+const defaultListParallelism = 4
+
+// scimPage is a page of SCIM listing response
+type scimPage struct {
+	resources    []map[string]any
+	startIndex   int64
+	itemsPerPage int64
+	totalResults int64
+	notModified  bool
+}
+
+// honorsPaging reports whether the page starts at the requested index and holds pageSize resources.
+// Only then the start indexes of the following pages can be computed in advance
+func (p *scimPage) honorsPaging(startIndex int64, pageSize int64) bool {
+	return p.startIndex == startIndex && pageSize > 0 && p.itemsPerPage == pageSize && int64(len(p.resources)) == pageSize
+}
+
+func (s *sync) listWorkers() int {
+	if s.listParallelism > 0 {
+		return int(s.listParallelism)
+	}
+	return defaultListParallelism
+}
+
+// getResourcePage requests a listing page. "304 Not Modified" pages are returned from the listing cache
+func (s *sync) getResourcePage(uri *url.URL, resourceType string, startIndex int64, count int, cache *scimListingCache) (page *scimPage, err error) {
+	var ruri = new(url.URL)
+	*ruri = *uri
+	var query = ruri.Query()
+	query.Set("startIndex", strconv.FormatInt(startIndex, 10))
+	query.Set("count", strconv.Itoa(count))
+	ruri.RawQuery = query.Encode()
+
+	var rq *http.Request
+	if rq, err = http.NewRequest("GET", ruri.String(), nil); err != nil {
+		return
+	}
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	if cache != nil {
+		cache.setConditionalHeaders(rq, startIndex)
+	}
+
+	var jo map[string]any
+	var header http.Header
+	var notModified bool
+	if jo, header, notModified, err = s.executeConditionalRequest(rq); err != nil {
+		return
+	}
+	if notModified {
+		if cache == nil {
+			err = fmt.Errorf("get SCIM resource \"%s\": unexpected \"304 Not Modified\" response", resourceType)
+			return
+		}
+		if jo = cache.cachedPage(startIndex); jo == nil {
+			err = fmt.Errorf("get SCIM resource \"%s\": page %d is not cached", resourceType, startIndex)
+			return
+		}
+	} else if cache != nil {
+		cache.storePage(startIndex, header, jo)
+	}
+
+	page = &scimPage{notModified: notModified}
+	if jr, ok := jo["Resources"].([]any); ok {
+		for _, j := range jr {
+			if jor, ok := j.(map[string]any); ok {
+				page.resources = append(page.resources, jor)
+			}
+		}
+	}
+	var ok bool
+	if page.itemsPerPage, ok = toInt64(jo["itemsPerPage"]); !ok {
+		err = fmt.Errorf("response does not conform to SCIM specification: missing \"itemsPerPage\"")
+		return
+	}
+	if page.startIndex, ok = toInt64(jo["startIndex"]); !ok {
+		err = fmt.Errorf("response does not conform to SCIM specification: missing \"startIndex\"")
+		return
+	}
+	if page.totalResults, ok = toInt64(jo["totalResults"]); !ok {
+		err = fmt.Errorf("response does not conform to SCIM specification: missing \"totalResults\"")
+		return
+	}
+	return
+}
+
+// getResourcePagesParallel fetches the listing pages from startIndex up to totalResults with bounded concurrency.
+// Pages are returned in listing order up to the first page that does not honor the requested start index or size;
+// the caller continues sequentially from there
+func (s *sync) getResourcePagesParallel(uri *url.URL, resourceType string, startIndex int64, pageSize int64, totalResults int64, cache *scimListingCache) (pages []*scimPage, err error) {
+	var workers = s.listWorkers()
+	if workers <= 1 {
+		return
+	}
+	var indexes []int64
+	for index := startIndex; index <= totalResults; index += pageSize {
+		indexes = append(indexes, index)
+	}
+	if workers > len(indexes) {
+		workers = len(indexes)
+	}
+	s.debugLogger(fmt.Sprintf("SCIM \"%s\" listing: fetching %d page(s) with %d concurrent request(s)", resourceType, len(indexes), workers))
+
+	// the client is created before the workers start, so they share it
+	_ = s.httpClient()
+
+	var results = make([]*scimPage, len(indexes))
+	var errs = make([]error, len(indexes))
+	var failed atomic.Bool
+	var queue = make(chan int)
+	var wg gosync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for no := range queue {
+				if failed.Load() {
+					continue
+				}
+				if results[no], errs[no] = s.getResourcePage(uri, resourceType, indexes[no], int(pageSize), cache); errs[no] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	for no := range indexes {
+		queue <- no
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, err = range errs {
+		if err != nil {
+			return
+		}
+	}
+	for no, page := range results {
+		pages = append(pages, page)
+		if no < len(results)-1 && !page.honorsPaging(indexes[no], pageSize) {
+			s.debugLogger(fmt.Sprintf("SCIM \"%s\" listing: page %d does not honor paging, continuing sequentially", resourceType, indexes[no]))
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"testing"
+)
+
+// listingServer serves a SCIM listing of users "u1" to "u<total>"
+type listingServer struct {
+	total int
+	// maxPage caps the page size regardless of the requested count
+	maxPage int
+	// ignoreStart serves every request from the first resource
+	ignoreStart bool
+	// failAt responds with an error to the request of this start index
+	failAt int
+
+	lock      gosync.Mutex
+	requested []int
+}
+
+func (ls *listingServer) ServeHTTP(w http.ResponseWriter, rq *http.Request) {
+	var startIndex, _ = strconv.Atoi(rq.URL.Query().Get("startIndex"))
+	var count, _ = strconv.Atoi(rq.URL.Query().Get("count"))
+	ls.lock.Lock()
+	ls.requested = append(ls.requested, startIndex)
+	ls.lock.Unlock()
+	if startIndex == ls.failAt {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if startIndex < 1 || ls.ignoreStart {
+		startIndex = 1
+	}
+	if ls.maxPage > 0 && count > ls.maxPage {
+		count = ls.maxPage
+	}
+	var resources = make([]map[string]any, 0)
+	for i := startIndex; i < startIndex+count && i <= ls.total; i++ {
+		resources = append(resources, map[string]any{"id": fmt.Sprintf("u%d", i)})
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		"totalResults": ls.total,
+		"itemsPerPage": len(resources),
+		"startIndex":   startIndex,
+		"Resources":    resources,
+	})
+}
+
+func (ls *listingServer) requestedIndexes() []int {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	var indexes = append([]int(nil), ls.requested...)
+	sort.Ints(indexes)
+	return indexes
+}
+
+func pageIds(pages []*scimPage) (ids []string) {
+	for _, page := range pages {
+		for _, resource := range page.resources {
+			ids = append(ids, resource["id"].(string))
+		}
+	}
+	return
+}
+
+func TestGetResourcePagesParallel(t *testing.T) {
+	var tests = []struct {
+		name        string
+		server      *listingServer
+		parallelism int32
+		// the caller fetched the first page of pageSize resources
+		pageSize  int64
+		ids       []string
+		requested []int
+		wantErr   bool
+	}{
+		{name: "pages honored", server: &listingServer{total: 7}, parallelism: 2, pageSize: 2,
+			ids: []string{"u3", "u4", "u5", "u6", "u7"}, requested: []int{3, 5, 7}},
+		{name: "more workers than pages", server: &listingServer{total: 5}, parallelism: 8, pageSize: 2,
+			ids: []string{"u3", "u4", "u5"}, requested: []int{3, 5}},
+		{name: "last page full", server: &listingServer{total: 6}, parallelism: 4, pageSize: 2,
+			ids: []string{"u3", "u4", "u5", "u6"}, requested: []int{3, 5}},
+		{name: "server caps page size", server: &listingServer{total: 7, maxPage: 1}, parallelism: 3, pageSize: 2,
+			ids: []string{"u3"}, requested: []int{3, 5, 7}},
+		{name: "server ignores start index", server: &listingServer{total: 7, ignoreStart: true}, parallelism: 3, pageSize: 2,
+			ids: []string{"u1", "u2"}, requested: []int{3, 5, 7}},
+		{name: "page error", server: &listingServer{total: 7, failAt: 5}, parallelism: 3, pageSize: 2,
+			wantErr: true},
+		{name: "sequential", server: &listingServer{total: 7}, parallelism: 1, pageSize: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server = httptest.NewServer(tt.server)
+			defer server.Close()
+			var s = &sync{baseUrl: server.URL, token: "token", listParallelism: tt.parallelism}
+			var uri, _ = url.Parse(server.URL + "/Users")
+			var pages, err = s.getResourcePagesParallel(uri, "Users", 3, tt.pageSize, int64(tt.server.total), nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ids := pageIds(pages); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("resources = %v, want %v", ids, tt.ids)
+			}
+			if requested := tt.server.requestedIndexes(); !reflect.DeepEqual(requested, tt.requested) {
+				t.Errorf("requested start indexes = %v, want %v", requested, tt.requested)
+			}
+		})
+	}
+}
+
+func TestGetResources(t *testing.T) {
+	var tests = []struct {
+		name        string
+		server      *listingServer
+		parallelism int32
+		total       int
+	}{
+		{name: "empty", server: &listingServer{total: 0}, parallelism: 4},
+		{name: "single page", server: &listingServer{total: 3}, parallelism: 4, total: 3},
+		{name: "parallel pages", server: &listingServer{total: 2*scimPageSize + 1}, parallelism: 4, total: 2*scimPageSize + 1},
+		{name: "full last page", server: &listingServer{total: 2 * scimPageSize}, parallelism: 4, total: 2 * scimPageSize},
+		{name: "sequential pages", server: &listingServer{total: 2*scimPageSize + 1}, parallelism: 1, total: 2*scimPageSize + 1},
+		{name: "capped page size", server: &listingServer{total: 7, maxPage: 2}, parallelism: 4, total: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server = httptest.NewServer(tt.server)
+			defer server.Close()
+			var s = &sync{baseUrl: server.URL, token: "token", listParallelism: tt.parallelism}
+			var ids []string
+			var err = s.getResources("Users", func(resource map[string]any) {
+				ids = append(ids, resource["id"].(string))
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(ids) != tt.total {
+				t.Fatalf("got %d resources, want %d", len(ids), tt.total)
+			}
+			for i, id := range ids {
+				if want := fmt.Sprintf("u%d", i+1); id != want {
+					t.Fatalf("resource #%d = %s, want %s", i+1, id, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// sentryDsnEnv is the environment variable of the Sentry DSN that receives unrecoverable errors
+const sentryDsnEnv = "SENTRY_DSN"
+
+// sentryTimeout limits a Sentry request
+const sentryTimeout = 10 * time.Second
+
+// errorTags are the log labels sent with error reports, e.g. the run ID
+var errorTags gosync.Map
+
+// sentryStoreRequest parses the DSN "https://<key>@<host>/<project>" and returns the store endpoint and the auth header
+func sentryStoreRequest(dsn string) (endpoint string, auth string, err error) {
+	var uri *url.URL
+	if uri, err = url.Parse(dsn); err != nil {
+		err = fmt.Errorf("sentry DSN: %w", err)
+		return
+	}
+	var key = uri.User.Username()
+	var path = strings.Trim(uri.Path, "/")
+	var projectId = path
+	if pos := strings.LastIndexByte(path, '/'); pos >= 0 {
+		projectId = path[pos+1:]
+		path = path[:pos+1]
+	} else {
+		path = ""
+	}
+	if len(key) == 0 || len(projectId) == 0 || len(uri.Host) == 0 {
+		err = fmt.Errorf("sentry DSN: expected \"https://<key>@<host>/<project>\"")
+		return
+	}
+	RegisterSecrets(key)
+	endpoint = fmt.Sprintf("%s://%s/%sapi/%s/store/", uri.Scheme, uri.Host, path, projectId)
+	auth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=ksm-scim/%s, sentry_key=%s", GetBuildInfo().Version, key)
+	if secret, ok := uri.User.Password(); ok && len(secret) > 0 {
+		RegisterSecrets(secret)
+		auth += ", sentry_secret=" + secret
+	}
+	return
+}
+
+// sentryFrames converts the stack of the error to Sentry frames, the oldest call first
+func sentryFrames(err error) (frames []any) {
+	var stack = errorFrames(err)
+	for i := len(stack) - 1; i >= 0; i-- {
+		var frame = stack[i]
+		var module, function = "", frame.Function
+		var pos = strings.LastIndexByte(function, '/') + 1
+		if dot := strings.IndexByte(function[pos:], '.'); dot >= 0 {
+			module, function = function[:pos+dot], function[pos+dot+1:]
+		}
+		frames = append(frames, map[string]any{
+			"function": function,
+			"module":   module,
+			"abs_path": frame.File,
+			"filename": path.Base(frame.File),
+			"lineno":   frame.Line,
+			"in_app":   strings.HasPrefix(module, "github.com/keeper-security/ksm-google-scim"),
+		})
+	}
+	return
+}
+
+// sentryEvent builds the Sentry event of the error with the stack where the error was created
+// and the current log labels as tags
+func sentryEvent(err error) map[string]any {
+	var eventId = make([]byte, 16)
+	_, _ = rand.Read(eventId)
+	var tags = make(map[string]string)
+	errorTags.Range(func(key, value any) bool {
+		tags[key.(string)] = value.(string)
+		return true
+	})
+	var cause = err
+	if se, ok := err.(*stackError); ok {
+		cause = se.err
+	}
+	var exception = map[string]any{
+		"type":  fmt.Sprintf("%T", cause),
+		"value": SanitizeText(err.Error()),
+	}
+	if frames := sentryFrames(err); len(frames) > 0 {
+		exception["stacktrace"] = map[string]any{"frames": frames}
+	}
+	var event = map[string]any{
+		"event_id":  hex.EncodeToString(eventId),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"logger":    "ksm-scim",
+		"release":   GetBuildInfo().Version,
+		"exception": map[string]any{
+			"values": []any{exception},
+		},
+	}
+	if len(tags) > 0 {
+		event["tags"] = tags
+	}
+	if host, er1 := os.Hostname(); er1 == nil {
+		event["server_name"] = host
+	}
+	return event
+}
+
+// reportToSentry sends the error to the Sentry project of the DSN
+func reportToSentry(dsn string, err error) (er1 error) {
+	var endpoint, auth string
+	if endpoint, auth, er1 = sentryStoreRequest(dsn); er1 != nil {
+		return
+	}
+	var payload []byte
+	if payload, er1 = json.Marshal(sentryEvent(err)); er1 != nil {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), sentryTimeout)
+	defer cancel()
+	var rq *http.Request
+	if rq, er1 = http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload)); er1 != nil {
+		return
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set("User-Agent", UserAgent())
+	rq.Header.Set("X-Sentry-Auth", auth)
+	var rs *http.Response
+	if rs, er1 = http.DefaultClient.Do(rq); er1 != nil {
+		er1 = SanitizeError(fmt.Errorf("sentry request error: %w", er1))
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	if rs.StatusCode >= 300 {
+		var body, _ = io.ReadAll(io.LimitReader(rs.Body, 1024))
+		er1 = fmt.Errorf("sentry error: status code %d: %s", rs.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return
+}
@@ -0,0 +1,253 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceSnapshot is the source roster resolved by a sync run
+type SourceSnapshot struct {
+	RunId  string           `json:"runId"`
+	Taken  time.Time        `json:"taken"`
+	Users  []*SnapshotUser  `json:"users"`
+	Groups []*SnapshotGroup `json:"groups"`
+}
+
+// SnapshotUser is a source user of the snapshot
+type SnapshotUser struct {
+	Id     string `json:"id"`
+	Email  string `json:"email"`
+	Active bool   `json:"active"`
+	State  string `json:"state,omitempty"`
+	// Groups are source group IDs of the user, including groups the user is a member of through nested groups
+	Groups []string `json:"groups,omitempty"`
+}
+
+// SnapshotGroup is a source group of the snapshot
+type SnapshotGroup struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func runSnapshotKey(runId string) string {
+	return fmt.Sprintf("runs/%s/snapshot", runId)
+}
+
+// takeSnapshot captures the populated source roster in stable order
+func (s *sync) takeSnapshot() (snapshot *SourceSnapshot) {
+	snapshot = &SourceSnapshot{
+		RunId: s.runId,
+		Taken: time.Now(),
+	}
+	var groups = make(map[string]*Group)
+	s.source.Groups(func(group *Group) {
+		groups[group.Id] = group
+	})
+	for _, group := range sortedValues(groups, groupSortKey) {
+		snapshot.Groups = append(snapshot.Groups, &SnapshotGroup{Id: group.Id, Name: group.Name})
+	}
+	var users = make(map[string]*User)
+	s.source.Users(func(user *User) {
+		users[user.Id] = user
+	})
+	for _, user := range sortedValues(users, userSortKey) {
+		var userGroups = append([]string{}, user.Groups...)
+		sort.Strings(userGroups)
+		snapshot.Users = append(snapshot.Users, &SnapshotUser{
+			Id:     user.Id,
+			Email:  user.Email,
+			Active: user.Active,
+			State:  user.State,
+			Groups: userGroups,
+		})
+	}
+	return
+}
+
+func saveSourceSnapshot(store IStateStore, snapshot *SourceSnapshot) (err error) {
+	var data []byte
+	if data, err = json.Marshal(snapshot); err != nil {
+		return
+	}
+	err = store.Save(runSnapshotKey(snapshot.RunId), data)
+	return
+}
+
+// LoadSourceSnapshot reads the source roster of the sync run from the state store
+func LoadSourceSnapshot(store IStateStore, runId string) (snapshot *SourceSnapshot, err error) {
+	var data []byte
+	if data, err = store.Load(runSnapshotKey(runId)); err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("source snapshot of sync run \"%s\" was not found", runId)
+		return
+	}
+	snapshot = new(SourceSnapshot)
+	err = json.Unmarshal(data, snapshot)
+	return
+}
+
+// SnapshotDiff lists source changes between two sync runs. Users are identified by email, groups by name
+type SnapshotDiff struct {
+	FromRunId     string            `json:"fromRunId"`
+	ToRunId       string            `json:"toRunId"`
+	Joiners       []string          `json:"joiners,omitempty"`
+	Leavers       []string          `json:"leavers,omitempty"`
+	Activated     []string          `json:"activated,omitempty"`
+	Deactivated   []string          `json:"deactivated,omitempty"`
+	EmailChanges  []string          `json:"emailChanges,omitempty"`
+	GroupsAdded   []string          `json:"groupsAdded,omitempty"`
+	GroupsRemoved []string          `json:"groupsRemoved,omitempty"`
+	GroupsRenamed []string          `json:"groupsRenamed,omitempty"`
+	Moves         []*MembershipMove `json:"moves,omitempty"`
+}
+
+// MembershipMove is a change of the groups of a user present in both runs
+type MembershipMove struct {
+	Email  string   `json:"email"`
+	Joined []string `json:"joined,omitempty"`
+	Left   []string `json:"left,omitempty"`
+}
+
+// IsEmpty returns true if the source did not change between the runs
+func (sd *SnapshotDiff) IsEmpty() bool {
+	return len(sd.Joiners) == 0 && len(sd.Leavers) == 0 && len(sd.Activated) == 0 && len(sd.Deactivated) == 0 &&
+		len(sd.EmailChanges) == 0 && len(sd.GroupsAdded) == 0 && len(sd.GroupsRemoved) == 0 && len(sd.GroupsRenamed) == 0 &&
+		len(sd.Moves) == 0
+}
+
+// DiffSnapshots compares source rosters of two sync runs. Users and groups are matched by source ID
+func DiffSnapshots(from *SourceSnapshot, to *SourceSnapshot) (diff *SnapshotDiff) {
+	diff = &SnapshotDiff{
+		FromRunId: from.RunId,
+		ToRunId:   to.RunId,
+	}
+	var groupNames = make(map[string]string)
+	var fromGroups = make(map[string]*SnapshotGroup)
+	for _, g := range from.Groups {
+		fromGroups[g.Id] = g
+		groupNames[g.Id] = g.Name
+	}
+	var toGroups = make(map[string]*SnapshotGroup)
+	for _, g := range to.Groups {
+		toGroups[g.Id] = g
+		groupNames[g.Id] = g.Name
+	}
+	for _, g := range to.Groups {
+		if fg, ok := fromGroups[g.Id]; !ok {
+			diff.GroupsAdded = append(diff.GroupsAdded, g.Name)
+		} else if fg.Name != g.Name {
+			diff.GroupsRenamed = append(diff.GroupsRenamed, fmt.Sprintf("%s -> %s", fg.Name, g.Name))
+		}
+	}
+	for _, g := range from.Groups {
+		if _, ok := toGroups[g.Id]; !ok {
+			diff.GroupsRemoved = append(diff.GroupsRemoved, g.Name)
+		}
+	}
+	var names = func(groupIds []string) (result []string) {
+		for _, groupId := range groupIds {
+			if name, ok := groupNames[groupId]; ok {
+				result = append(result, name)
+			} else {
+				result = append(result, groupId)
+			}
+		}
+		sort.Strings(result)
+		return
+	}
+
+	var fromUsers = make(map[string]*SnapshotUser)
+	for _, u := range from.Users {
+		fromUsers[u.Id] = u
+	}
+	var toUsers = make(map[string]*SnapshotUser)
+	for _, u := range to.Users {
+		toUsers[u.Id] = u
+	}
+	for _, u := range to.Users {
+		var fu, ok = fromUsers[u.Id]
+		if !ok {
+			diff.Joiners = append(diff.Joiners, u.Email)
+			continue
+		}
+		if foldEmail(fu.Email) != foldEmail(u.Email) {
+			diff.EmailChanges = append(diff.EmailChanges, fmt.Sprintf("%s -> %s", fu.Email, u.Email))
+		}
+		if fu.Active != u.Active {
+			if u.Active {
+				diff.Activated = append(diff.Activated, u.Email)
+			} else {
+				diff.Deactivated = append(diff.Deactivated, u.Email)
+			}
+		}
+		var before = MakeSet[string](fu.Groups)
+		var after = MakeSet[string](u.Groups)
+		var joined, left []string
+		for _, groupId := range u.Groups {
+			if !before.Has(groupId) {
+				joined = append(joined, groupId)
+			}
+		}
+		for _, groupId := range fu.Groups {
+			if !after.Has(groupId) {
+				left = append(left, groupId)
+			}
+		}
+		if len(joined) > 0 || len(left) > 0 {
+			diff.Moves = append(diff.Moves, &MembershipMove{
+				Email:  u.Email,
+				Joined: names(joined),
+				Left:   names(left),
+			})
+		}
+	}
+	for _, u := range from.Users {
+		if _, ok := toUsers[u.Id]; !ok {
+			diff.Leavers = append(diff.Leavers, u.Email)
+		}
+	}
+	return
+}
+
+func (sd *SnapshotDiff) String() string {
+	if sd.IsEmpty() {
+		return fmt.Sprintf("No source changes between sync runs \"%s\" and \"%s\"\n", sd.FromRunId, sd.ToRunId)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Source changes between sync runs \"%s\" and \"%s\":\n", sd.FromRunId, sd.ToRunId))
+	var section = func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		sb.WriteString(fmt.Sprintf("%s:\n", title))
+		for _, line := range lines {
+			sb.WriteString(fmt.Sprintf("\t%s\n", line))
+		}
+	}
+	section("Joiners", sd.Joiners)
+	section("Leavers", sd.Leavers)
+	section("Activated", sd.Activated)
+	section("Deactivated", sd.Deactivated)
+	section("Email changes", sd.EmailChanges)
+	section("Groups added", sd.GroupsAdded)
+	section("Groups removed", sd.GroupsRemoved)
+	section("Groups renamed", sd.GroupsRenamed)
+	var moves []string
+	for _, move := range sd.Moves {
+		var parts []string
+		if len(move.Joined) > 0 {
+			parts = append(parts, "joined "+strings.Join(move.Joined, ", "))
+		}
+		if len(move.Left) > 0 {
+			parts = append(parts, "left "+strings.Join(move.Left, ", "))
+		}
+		moves = append(moves, fmt.Sprintf("%s: %s", move.Email, strings.Join(parts, "; ")))
+	}
+	section("Group moves", moves)
+	return sb.String()
+}
@@ -0,0 +1,69 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// stackDepth limits the number of frames kept with an error
+const stackDepth = 64
+
+// stackError is an error with the call stack where it was created
+type stackError struct {
+	err   error
+	stack []uintptr
+}
+
+func (se *stackError) Error() string {
+	return se.err.Error()
+}
+
+func (se *stackError) Unwrap() error {
+	return se.err
+}
+
+// withStack attaches the call stack of the caller to the error. An error that carries a stack already is returned as is
+func withStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	var se *stackError
+	if errors.As(err, &se) {
+		return err
+	}
+	var stack = make([]uintptr, stackDepth)
+	var n = runtime.Callers(2, stack)
+	return &stackError{err: err, stack: stack[:n]}
+}
+
+// errorFrames returns the call stack where the error was created, the innermost call first.
+// Empty if the error carries no stack
+func errorFrames(err error) (frames []runtime.Frame) {
+	var se *stackError
+	if !errors.As(err, &se) || len(se.stack) == 0 {
+		return
+	}
+	var cf = runtime.CallersFrames(se.stack)
+	for {
+		var frame, more = cf.Next()
+		if len(frame.Function) > 0 {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	return
+}
+
+// formatFrames writes the frames in the layout of a Go stack trace, so Error Reporting can parse it
+func formatFrames(frames []runtime.Frame) string {
+	var sb strings.Builder
+	sb.WriteString("goroutine 1 [running]:\n")
+	for _, frame := range frames {
+		_, _ = fmt.Fprintf(&sb, "%s(...)\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return sb.String()
+}
@@ -0,0 +1,126 @@
+package scim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	gosync "sync"
+)
+
+// Formats of the sync statistics output
+const (
+	// StatOutputFull lists every entry of the sync statistics
+	StatOutputFull = "full"
+	// StatOutputSummary lists the number of entries of every section and the first entries of failure sections only
+	StatOutputSummary = "summary"
+)
+
+// defaultSummaryLimit is the number of entries listed per failure section in summary output
+const defaultSummaryLimit = 10
+
+// ParseStatOutput validates the format of the sync statistics output. Empty format is "full"
+func ParseStatOutput(format string) (result string, err error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", StatOutputFull:
+		result = StatOutputFull
+	case StatOutputSummary:
+		result = StatOutputSummary
+	default:
+		err = fmt.Errorf("output format \"%s\" is not supported. Supported formats are full, summary", format)
+	}
+	return
+}
+
+var statOutput = struct {
+	lock   gosync.Mutex
+	format string
+	limit  int32
+}{format: StatOutputFull}
+
+// SetStatOutput sets the format of WriteSyncStat. limit is the number of entries listed per section; 0 lists every entry
+// in full output and the first 10 failures in summary output
+func SetStatOutput(format string, limit int32) {
+	statOutput.lock.Lock()
+	defer statOutput.lock.Unlock()
+	statOutput.format = format
+	statOutput.limit = limit
+}
+
+// WriteSyncStat writes the sync statistics in the format set with SetStatOutput
+func WriteSyncStat(w io.Writer, syncStat *SyncStat) {
+	statOutput.lock.Lock()
+	var format, limit = statOutput.format, statOutput.limit
+	statOutput.lock.Unlock()
+	WriteSyncStatFormat(w, syncStat, format, limit)
+}
+
+// WriteSyncStatFormat writes the sync statistics in the format. limit is applied as in SetStatOutput.
+// Entries are streamed through a buffer, so thousands of entries do not build one large string
+func WriteSyncStatFormat(w io.Writer, syncStat *SyncStat, format string, statLimit int32) {
+	if syncStat == nil {
+		return
+	}
+	var limit = int(statLimit)
+	var summary = format == StatOutputSummary
+	if summary && limit == 0 {
+		limit = defaultSummaryLimit
+	}
+
+	var bw = bufio.NewWriter(w)
+	defer func() { _ = bw.Flush() }()
+	// details are listed in summary output too
+	var section = func(title string, entries []string, details bool) {
+		if len(entries) == 0 {
+			return
+		}
+		if summary {
+			_, _ = fmt.Fprintf(bw, "%s: %d\n", title, len(entries))
+			if !details {
+				return
+			}
+		} else {
+			_, _ = fmt.Fprintf(bw, "%s:\n", title)
+		}
+		for i, txt := range entries {
+			if limit > 0 && i >= limit {
+				_, _ = fmt.Fprintf(bw, "\t... and %d more\n", len(entries)-limit)
+				break
+			}
+			_, _ = fmt.Fprintf(bw, "\t%s\n", txt)
+		}
+		_ = bw.Flush()
+	}
+
+	if syncStat.Plan != nil {
+		_, _ = fmt.Fprintf(bw, "Sync Plan: %s\n", syncStat.Plan)
+	}
+	if syncStat.SourceApi != nil {
+		_, _ = fmt.Fprintf(bw, "Google API: %s\n", syncStat.SourceApi)
+	}
+	if syncStat.Memory != nil {
+		_, _ = fmt.Fprintf(bw, "Memory: %s\n", syncStat.Memory)
+	}
+	var skipped []string
+	for _, su := range syncStat.SkippedUsers {
+		skipped = append(skipped, su.String())
+	}
+	var decisions []string
+	for _, md := range syncStat.MatchDecisions {
+		decisions = append(decisions, md.String())
+	}
+	section("Safe Mode", syncStat.SafeModeReasons, true)
+	section("Notices", syncStat.Notices, true)
+	section("Group Success", syncStat.SuccessGroups, false)
+	section("Group Failure", syncStat.FailedGroups, true)
+	section("Group Match Decisions", decisions, true)
+	section("User Success", syncStat.SuccessUsers, false)
+	section("User Failure", syncStat.FailedUsers, true)
+	section("User Skipped", skipped, true)
+	section("Pending Deletions", syncStat.PendingDeletions, false)
+	section("Membership Success", syncStat.SuccessMembership, false)
+	section("Membership Failure", syncStat.FailedMembership, true)
+	section("Capacity Warnings", syncStat.CapacityWarnings, true)
+	section("Canary Deferred", syncStat.CanaryDeferred, false)
+	section("Time Budget Deferred", syncStat.BudgetDeferred, false)
+}
@@ -0,0 +1,81 @@
+package scim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// IStateLocker is implemented by state stores shared by several replicas of the sync
+type IStateLocker interface {
+	// TryLock acquires the named lock for ttl. acquired is false if another holder has the lock.
+	// The holder extends the lock by ttl while it runs, so ttl bounds only the time a crashed holder blocks others.
+	// release stops the renewal and frees the lock; an expired lock is freed by the store
+	TryLock(name string, ttl time.Duration) (release func(), acquired bool, err error)
+}
+
+// syncLockName is the lock held by a sync run
+const syncLockName = "sync"
+
+// syncLockTtl bounds the time a crashed replica blocks other replicas. Held locks are renewed, so runs may take longer
+const syncLockTtl = time.Hour
+
+func newLockToken() string {
+	var token = make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// keepLock calls renew every third of the ttl until stop is called or renew reports that the lock is lost.
+// stop returns after the last renewal finished
+func keepLock(name string, ttl time.Duration, renew func() (held bool, err error)) (stop func()) {
+	var done = make(chan struct{})
+	var stopped = make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var ticker = time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				var held, err = renew()
+				if err != nil {
+					log.Printf("Failed to renew lock \"%s\": %s", name, err.Error())
+				} else if !held {
+					log.Printf("Lock \"%s\" expired before it was renewed", name)
+					return
+				}
+			}
+		}
+	}()
+	stop = func() {
+		close(done)
+		<-stopped
+	}
+	return
+}
+
+// acquireSyncLock prevents replicas that share the state store from running the sync at the same time
+func (s *sync) acquireSyncLock() (release func(), err error) {
+	release = func() {}
+	var locker, ok = s.stateStore.(IStateLocker)
+	if !ok {
+		return
+	}
+	var acquired bool
+	var unlock func()
+	if unlock, acquired, err = locker.TryLock(syncLockName, syncLockTtl); err != nil {
+		err = fmt.Errorf("acquire sync lock: %w", err)
+		return
+	}
+	if !acquired {
+		err = fmt.Errorf("sync skipped: another replica is running the sync")
+		return
+	}
+	release = unlock
+	return
+}
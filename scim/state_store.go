@@ -0,0 +1,145 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IStateStore persists small pieces of state between sync runs.
+// Keys are slash separated paths, e.g. "runs/<run-id>/journal"
+type IStateStore interface {
+	// Load returns nil data and no error if the key does not exist
+	Load(key string) ([]byte, error)
+	Save(key string, data []byte) error
+	Delete(key string) error
+	// List returns all keys starting with prefix in lexicographical order
+	List(prefix string) ([]string, error)
+}
+
+// NewStateStore creates IStateStore from the connection string
+// storeUri: folder path, "file://" URI, "redis://" / "rediss://" URI of a store shared by several replicas,
+// or "firestore://project/collection" URI of the Cloud Function deployment.
+// "keeper://" stores are created with NewKeeperStateStore
+func NewStateStore(storeUri string) (store IStateStore, err error) {
+	storeUri = strings.TrimSpace(storeUri)
+	if len(storeUri) == 0 {
+		err = errors.New("state store is not configured")
+		return
+	}
+	var uri *url.URL
+	if uri, err = url.Parse(storeUri); err != nil || len(uri.Scheme) <= 1 {
+		// plain folder path. A single letter scheme is a Windows drive
+		err = nil
+		store = NewFileStateStore(storeUri)
+		return
+	}
+	switch strings.ToLower(uri.Scheme) {
+	case "file":
+		store = NewFileStateStore(filepath.FromSlash(uri.Host + uri.Path))
+	case "redis", "rediss":
+		store, err = NewRedisStateStore(storeUri)
+	case "firestore":
+		store, err = NewFirestoreStateStore(storeUri)
+	case "keeper":
+		err = errors.New("\"keeper://\" state store requires the configuration from Keeper Secrets Manager")
+	default:
+		err = fmt.Errorf("state store scheme \"%s\" is not supported", uri.Scheme)
+	}
+	return
+}
+
+type fileStateStore struct {
+	folder string
+}
+
+// NewFileStateStore creates IStateStore that keeps every key in a separate file
+// folder: root folder of the state store. It is created on first write
+func NewFileStateStore(folder string) IStateStore {
+	return &fileStateStore{
+		folder: folder,
+	}
+}
+
+func (fs *fileStateStore) keyPath(key string) (path string, err error) {
+	var components = strings.Split(key, "/")
+	for _, c := range components {
+		if len(c) == 0 || c == "." || c == ".." {
+			err = fmt.Errorf("invalid state store key \"%s\"", key)
+			return
+		}
+	}
+	path = filepath.Join(append([]string{fs.folder}, components...)...)
+	return
+}
+
+func (fs *fileStateStore) Load(key string) (data []byte, err error) {
+	var path string
+	if path, err = fs.keyPath(key); err != nil {
+		return
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	return
+}
+
+func (fs *fileStateStore) Save(key string, data []byte) (err error) {
+	var path string
+	if path, err = fs.keyPath(key); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	var tmpPath = path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, path)
+	return
+}
+
+func (fs *fileStateStore) Delete(key string) (err error) {
+	var path string
+	if path, err = fs.keyPath(key); err != nil {
+		return
+	}
+	if err = os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	return
+}
+
+func (fs *fileStateStore) List(prefix string) (keys []string, err error) {
+	err = filepath.WalkDir(fs.folder, func(path string, d os.DirEntry, er1 error) error {
+		if er1 != nil {
+			if errors.Is(er1, os.ErrNotExist) {
+				return nil
+			}
+			return er1
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		var rel string
+		if rel, er1 = filepath.Rel(fs.folder, path); er1 != nil {
+			return er1
+		}
+		var key = filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return
+}
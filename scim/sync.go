@@ -4,38 +4,131 @@ import (
 	"errors"
 	"fmt"
 	"log"
-
-	"golang.org/x/text/cases"
+	"net/http"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
 )
 
 // NewScimSync creates IScimSync interface for syncing with external CRMs
 // source: external CRM data source
 // url: base SCIM URL
 // token: SCIM token
-func NewScimSync(source ICrmDataSource, url string, token string) IScimSync {
+func NewScimSync(source ICrmDataSource, url string, token string, options ...SyncOption) IScimSync {
 	var s = &sync{
 		source:  source,
 		baseUrl: url,
 		token:   token,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	RegisterSecrets(token)
 	source.SetDebugLogger(s.debugLogger)
 	return s
 }
 
 type sync struct {
-	source      ICrmDataSource
-	scimUsers   map[string]*scimUser
-	scimGroups  map[string]*scimGroup
-	baseUrl     string
-	token       string
-	verbose     bool
-	updateUsers bool
-	destructive int32
+	source              ICrmDataSource
+	scimUsers           map[string]*scimUser
+	scimGroups          map[string]*scimGroup
+	baseUrl             string
+	token               string
+	verbose             bool
+	dryRun              bool
+	userComparator      UserComparator
+	logger              SyncDebugLogger
+	updateUsers         bool
+	destructive         int32
+	maxDeletes          int32
+	deletes             int32
+	deleteGraceDays     int32
+	deleteGraceRuns     int32
+	groupPolicies       map[string]GroupPolicy
+	userStates          map[string]*UserStateRule
+	roleMapping         RoleMapping
+	renameConflicts     string
+	groupMatchFallback  string
+	matchDecisions      []*MatchDecision
+	patchStyle          string
+	probeDialect        bool
+	dialect             ServerDialect
+	probes              int
+	compressRequests    bool
+	listParallelism     int32
+	membershipTx        string
+	externalIdPrefix    string
+	cooperative         bool
+	foreignUsers        *userInventory
+	foreignGroups       map[string]*scimGroup
+	entitlementMapping  EntitlementMapping
+	entitlements        *groupValues
+	syncManager         bool
+	attributes          Set[string]
+	userNameFormat      string
+	allowedDomains      Set[string]
+	trace               bool
+	strict              bool
+	phases              []string
+	phaseOrder          []string
+	syncCaps            *SyncCaps
+	stateStore          IStateStore
+	historyRuns         int32
+	cacheListings       bool
+	groupsUnsupported   bool
+	scimPaths           *ScimPaths
+	middleware          []ScimMiddleware
+	userAgentSuffix     string
+	client              *http.Client
+	baseClient          *http.Client
+	clientTrace         bool
+	resultSinks         []IResultSink
+	canary              *CanaryScope
+	canaryUsers         Set[string]
+	canaryGroups        Set[string]
+	canaryDeferred      []string
+	pendingDeletions    []string
+	runScope            *RunScope
+	capacityRejects     map[string]int
+	defaultGroups       []string
+	driftReportHook     ISyncHook
+	driftReportInterval time.Duration
+	createdUsers        Set[string]
+	unresolvedUsers     []string
+	createWithGroups    bool
+	createGroupsDenied  bool
+	createWithMembers   bool
+	createMembersDenied bool
+	timeBudget          time.Duration
+	deadline            time.Time
+	resumedRunId        string
+	resumeUsers         Set[string]
+	resumeGroups        Set[string]
+	mailSender          IMailSender
+	notifyTemplates     map[string]string
+	notifications       []*UserNotification
+	scopeUsers          Set[string]
+	scopeGroups         Set[string]
+	preSyncHook         ISyncHook
+	postSyncHook        ISyncHook
+	policy              IPlanPolicy
+	runId               string
+	operationNo         int
+	operationLock       gosync.Mutex
+	lastOperationId     string
+	journal             *RunJournal
+	indexes             *syncIndexes
+	indexLock           gosync.RWMutex
 }
 
 func (s *sync) debugLogger(message string) {
 	if s.verbose {
-		log.Println(message)
+		if s.logger != nil {
+			s.logger(message)
+		} else {
+			log.Println(message)
+		}
 	}
 }
 func (s *sync) Source() ICrmDataSource {
@@ -43,41 +136,371 @@ func (s *sync) Source() ICrmDataSource {
 }
 func (s *sync) Verbose() bool              { return s.verbose }
 func (s *sync) SetVerbose(value bool)      { s.verbose = value }
+func (s *sync) DryRun() bool               { return s.dryRun }
 func (s *sync) UpdateUsers() bool          { return s.updateUsers }
 func (s *sync) SetUpdateUsers(value bool)  { s.updateUsers = value }
 func (s *sync) Destructive() int32         { return s.destructive }
 func (s *sync) SetDestructive(value int32) { s.destructive = value }
+func (s *sync) MaxDeletes() int32          { return s.maxDeletes }
+func (s *sync) DeleteGraceDays() int32     { return s.deleteGraceDays }
+func (s *sync) DeleteGraceRuns() int32     { return s.deleteGraceRuns }
+func (s *sync) SyncManager() bool          { return s.syncManager }
+func (s *sync) Trace() bool                { return s.trace }
+func (s *sync) Cooperative() bool          { return s.cooperative }
+func (s *sync) CreateWithGroups() bool     { return s.createWithGroups }
+func (s *sync) HistoryRuns() int32         { return s.historyRuns }
+func (s *sync) CacheListings() bool        { return s.cacheListings }
+func (s *sync) UserNameFormat() string     { return s.userNameFormat }
+func (s *sync) AllowedDomains() []string {
+	return s.allowedDomains.ToArray()
+}
+func (s *sync) setAllowedDomains(domains []string) {
+	s.allowedDomains = NewSet[string]()
+	for _, domain := range domains {
+		s.allowedDomains.Add(foldDomain(domain))
+	}
+}
+func (s *sync) Attributes() []string {
+	return s.attributes.ToArray()
+}
+func (s *sync) UserComparator() UserComparator {
+	return s.userComparator
+}
+func (s *sync) GroupPolicies() map[string]GroupPolicy {
+	return s.groupPolicies
+}
+func (s *sync) UserStates() map[string]*UserStateRule {
+	return s.userStates
+}
+func (s *sync) RoleMapping() RoleMapping {
+	return s.roleMapping
+}
+func (s *sync) EntitlementMapping() EntitlementMapping {
+	return s.entitlementMapping
+}
+func (s *sync) RenameConflictPolicy() string {
+	return s.renameConflicts
+}
+func (s *sync) PatchStyle() string {
+	return s.patchStyle
+}
+func (s *sync) CompressRequests() bool {
+	return s.compressRequests
+}
+func (s *sync) ListParallelism() int32 {
+	return s.listParallelism
+}
+func (s *sync) ProbeDialect() bool {
+	return s.probeDialect
+}
+func (s *sync) GroupMatchFallback() string {
+	return s.groupMatchFallback
+}
+func (s *sync) MembershipTransaction() string {
+	return s.membershipTx
+}
+func (s *sync) CreateWithMembers() bool {
+	return s.createWithMembers
+}
+func (s *sync) UserNotifier() IMailSender {
+	return s.mailSender
+}
+func (s *sync) TimeBudget() time.Duration {
+	return s.timeBudget
+}
+func (s *sync) ExternalIdPrefix() string {
+	return s.externalIdPrefix
+}
+func (s *sync) ScimPaths() *ScimPaths {
+	return s.scimPaths
+}
+func (s *sync) StateStore() IStateStore {
+	return s.stateStore
+}
+func (s *sync) StrictResolution() bool {
+	return s.strict
+}
+func (s *sync) Phases() []string {
+	return s.phases
+}
+func (s *sync) PhaseOrder() []string {
+	return s.phaseOrder
+}
+func (s *sync) SyncCaps() *SyncCaps {
+	return s.syncCaps
+}
+func (s *sync) ResultSinks() []IResultSink {
+	return s.resultSinks
+}
+func (s *sync) Canary() *CanaryScope {
+	return s.canary
+}
+func (s *sync) RunScope() *RunScope {
+	return s.runScope
+}
+func (s *sync) DefaultGroups() []string {
+	return s.defaultGroups
+}
+func (s *sync) DriftReportHook() ISyncHook {
+	return s.driftReportHook
+}
+func (s *sync) PreSyncHook() ISyncHook {
+	return s.preSyncHook
+}
+func (s *sync) PostSyncHook() ISyncHook {
+	return s.postSyncHook
+}
+func (s *sync) Policy() IPlanPolicy {
+	return s.policy
+}
+func (s *sync) RunId() string {
+	return s.runId
+}
+
+// beginRun starts a new sync run. All SCIM requests of the run share the run ID
+func (s *sync) beginRun() {
+	s.runId = newRunId()
+	s.operationNo = 0
+	s.lastOperationId = ""
+	setLogLabel("run_id", s.runId)
+}
 
 func (s *sync) Sync() (stat *SyncStat, err error) {
-	if err = s.Source().Populate(); err != nil {
+	if s.dryRun {
+		return s.dryRunSync()
+	}
+	s.beginRun()
+	// the lock is taken before any state is read, so the state of a run in progress on another replica is never used
+	var releaseLock func()
+	if releaseLock, err = s.acquireSyncLock(); err != nil {
 		return
 	}
-	if s.Source().LoadErrors() {
-		s.debugLogger("Switching to the Safe Mode due to errors")
+	defer releaseLock()
+	s.loadDialect()
+	s.startTimeBudget()
+	defer func() {
+		s.deadline = time.Time{}
+	}()
+	s.journal = nil
+	s.deletes = 0
+	s.createdUsers = NewSet[string]()
+	s.unresolvedUsers = nil
+	s.createGroupsDenied = false
+	s.createMembersDenied = false
+	s.notifications = nil
+	s.matchDecisions = nil
+	s.pendingDeletions = nil
+	var syncUsers = s.updateUsers && s.phaseEnabled(SyncPhaseUsers)
+	if syncUsers && s.gracePeriodEnabled() && s.stateStore == nil {
+		err = errors.New("grace period of user deletion requires a state store")
+		return
+	}
+	if syncUsers && s.attributeEnabled(AttributePhotos) && s.stateStore == nil {
+		err = errors.New("user photo sync requires a state store")
+		return
+	}
+	if err = s.checkCooperative(); err != nil {
+		return
+	}
+	log.Printf("Sync run ID: %s", s.runId)
+	var memory = startMemoryMonitor()
+	s.journal = &RunJournal{
+		RunId:   s.runId,
+		Started: time.Now(),
+	}
+	defer func() {
+		s.journal.Finished = time.Now()
+		var memoryStats = memory.finish()
+		if s.stateStore != nil && len(s.journal.Entries) > 0 {
+			if er1 := saveRunJournal(s.stateStore, s.journal); er1 != nil {
+				log.Printf("Failed to store journal of sync run \"%s\": %s", s.runId, er1.Error())
+			}
+		}
+		err = SanitizeError(err)
+		if stat != nil {
+			deferBudgetFailures(stat)
+			sanitizeStat(stat)
+			stat.Started = s.journal.Started
+			stat.Finished = s.journal.Finished
+			stat.Operations = s.journal.Entries
+			stat.Memory = memoryStats
+			s.storeResume(stat)
+			s.sendNotifications()
+			s.exportResults(stat)
+			if s.stateStore != nil {
+				// operations are kept in the journal
+				var record = *stat
+				record.Operations = nil
+				if er1 := saveRunStat(s.stateStore, &record); er1 != nil {
+					log.Printf("Failed to store statistics of sync run \"%s\": %s", s.runId, er1.Error())
+				}
+			}
+		}
+		if err != nil {
+			s.exportError(err)
+		}
+		s.pruneRunHistory()
+		s.runPostSyncHook(stat, err)
+		s.journal = nil
+	}()
+	var safeModeReasons []string
+	if safeModeReasons, err = s.populate(); err != nil {
+		return
+	}
+	s.loadResume()
+	if s.stateStore != nil {
+		if er1 := saveSourceSnapshot(s.stateStore, s.takeSnapshot()); er1 != nil {
+			log.Printf("Failed to store source snapshot of sync run \"%s\": %s", s.runId, er1.Error())
+		}
+	}
+	if len(safeModeReasons) > 0 {
+		var destructive = s.destructive
 		s.destructive = -1
+		defer func() {
+			s.destructive = destructive
+		}()
+	}
+	s.selectCanary()
+	s.selectRunScope()
+	// the graph is built after SCIM is loaded: groups and membership are skipped if SCIM does not support groups
+	var steps []string
+	if steps, err = s.operationGraph(syncUsers).order(s.phaseOrder); err != nil {
+		return
+	}
+	var plan = s.planSync()
+	log.Printf("Sync plan: %s", plan)
+	if err = s.checkSyncCaps(plan); err != nil {
+		return
+	}
+	if err = s.checkPolicy(plan); err != nil {
+		return
 	}
-	if err = s.populateScim(); err != nil {
+	if err = s.runPreSyncHook(plan); err != nil {
 		return
 	}
-	var syncStat = new(SyncStat)
-	s.debugLogger("Synchronize groups")
-	if syncStat.SuccessGroups, syncStat.FailedGroups, err = s.syncGroups(); err != nil {
+	s.sendDriftReport()
+	var syncStat = &SyncStat{RunId: s.runId, Plan: plan, SafeModeReasons: safeModeReasons}
+	if s.groupsUnsupported {
+		syncStat.Notices = append(syncStat.Notices, groupsUnsupportedNotice)
+	}
+	if sas, ok := s.source.(ISourceApiStatsSource); ok {
+		syncStat.SourceApi = sas.ApiStats()
+	}
+	if len(s.phases) > 0 || len(s.phaseOrder) > 0 {
+		log.Printf("Sync steps: %s", strings.Join(steps, ", "))
+	}
+	for _, step := range steps {
+		if err = s.runStep(step, syncStat); err != nil {
+			return
+		}
+	}
+	syncStat.CanaryDeferred = s.canaryDeferred
+	syncStat.PendingDeletions = s.pendingDeletions
+	syncStat.MatchDecisions = s.sortedMatchDecisions()
+	stat = syncStat
+	return
+}
+
+// populate loads the source and SCIM data.
+// Returns the reasons to switch to the Safe Mode if the source reported load errors
+func (s *sync) populate() (safeModeReasons []string, err error) {
+	if err = s.Source().Populate(); err != nil {
+		err = withStack(err)
 		return
 	}
-	if s.updateUsers {
-		s.debugLogger("Synchronize users")
-		if syncStat.SuccessUsers, syncStat.FailedUsers, err = s.syncUsers(); err != nil {
+	s.applyUserStates()
+	s.entitlements = s.resolveGroupValues(s.entitlementMapping)
+	if s.verbose {
+		for _, resolution := range s.Source().Resolutions() {
+			log.Printf("SCIM Group entry %s", resolution)
+		}
+	}
+	if s.Source().LoadErrors() {
+		if lfs, ok := s.Source().(ILoadFailureSource); ok {
+			safeModeReasons = lfs.LoadFailures()
+		}
+		if len(safeModeReasons) == 0 {
+			safeModeReasons = []string{"data source reported load errors"}
+		}
+		if s.strict {
+			err = withStack(fmt.Errorf("sync aborted in strict resolution mode: %s", strings.Join(safeModeReasons, "; ")))
 			return
 		}
+		log.Printf("Switching to the Safe Mode due to errors: %s", strings.Join(safeModeReasons, "; "))
+	}
+	err = s.populateScim()
+	if err == nil {
+		s.publishIndexes()
 	}
-	s.debugLogger("Synchronize membership")
-	if syncStat.SuccessMembership, syncStat.FailedMembership, err = s.syncMembership(); err != nil {
+	return
+}
+
+// Plan projects the changes of a sync run without making any change
+func (s *sync) Plan() (plan *SyncPlan, err error) {
+	s.beginRun()
+	var safeModeReasons []string
+	if safeModeReasons, err = s.populate(); err != nil {
 		return
 	}
-	stat = syncStat
+	if len(safeModeReasons) > 0 {
+		var destructive = s.destructive
+		s.destructive = -1
+		defer func() {
+			s.destructive = destructive
+		}()
+	}
+	plan = s.planSync()
+	if s.stateStore != nil {
+		if er1 := saveRunPlan(s.stateStore, plan); er1 != nil {
+			log.Printf("Failed to store plan of run \"%s\": %s", s.runId, er1.Error())
+		}
+		s.pruneRunHistory()
+	}
+	return
+}
+
+// Simulate projects the changes of a sync run under every destructive level in SimulatedDestructiveLevels.
+// Source and SCIM data are loaded once; no change is made
+func (s *sync) Simulate() (simulation *Simulation, err error) {
+	s.beginRun()
+	simulation = &Simulation{}
+	if simulation.SafeModeReasons, err = s.populate(); err != nil {
+		return
+	}
+	var destructive = s.destructive
+	defer func() {
+		s.destructive = destructive
+	}()
+	for _, level := range SimulatedDestructiveLevels {
+		s.destructive = level
+		simulation.Plans = append(simulation.Plans, &SimulatedPlan{
+			Destructive: level,
+			Plan:        s.planSync(),
+		})
+	}
 	return
 }
 
+// exportResults sends sync statistics to every result sink. Sink errors do not fail the sync
+func (s *sync) exportResults(stat *SyncStat) {
+	for _, sink := range s.resultSinks {
+		if er1 := sink.Export(stat); er1 != nil {
+			log.Printf("Failed to export results of sync run \"%s\": %s", s.runId, er1.Error())
+		}
+	}
+}
+
+// exportError reports the error of the sync run to the sinks that support it
+func (s *sync) exportError(syncErr error) {
+	for _, sink := range s.resultSinks {
+		if es, ok := sink.(IErrorSink); ok {
+			if er1 := es.ExportError(s.runId, syncErr); er1 != nil {
+				log.Printf("Failed to export error of sync run \"%s\": %s", s.runId, er1.Error())
+			}
+		}
+	}
+}
+
 func (s *sync) syncGroups() (successes []string, failures []string, err error) {
 	if s.scimGroups == nil {
 		err = errors.New("SCIM groups were not populated")
@@ -94,96 +517,135 @@ func (s *sync) syncGroups() (successes []string, failures []string, err error) {
 	})
 
 	var er1 error
-	var fold = cases.Fold()
 
-	for matchRound := 0; matchRound < 3; matchRound++ {
-		if len(keeperGroups) == 0 || len(externalGroups) == 0 {
-			break
+	for _, match := range s.matchGroups(externalGroups, keeperGroups) {
+		var group, keeperGroup = match.group, match.scimGroup
+		var value = make(map[string]any)
+		var inverse = make(map[string]any)
+		if keeperGroup.ExternalId != s.externalId(group.Id) {
+			value["externalId"] = s.externalId(group.Id)
+			inverse["externalId"] = keeperGroup.ExternalId
+		}
+		if keeperGroup.Name != group.Name {
+			value["displayName"] = group.Name
+			inverse["displayName"] = keeperGroup.Name
 		}
 
-		var groupLookup = make(map[string]*scimGroup)
-		switch matchRound {
-		case 0:
-			for _, v := range keeperGroups {
-				groupLookup[v.ExternalId] = v
-			}
-		case 1:
-			for _, v := range keeperGroups {
-				groupLookup[fold.String(v.Name)] = v
-			}
-		case 2:
-			var extKeys []string
-			for k := range externalGroups {
-				extKeys = append(extKeys, k)
-			}
-			var scimKeys []string
-			for k, v := range keeperGroups {
-				if len(v.ExternalId) > 0 {
-					scimKeys = append(scimKeys, k)
-				}
-			}
-			var minKeys = len(extKeys)
-			if minKeys > len(scimKeys) {
-				minKeys = len(scimKeys)
-			}
-			for i := 0; i < minKeys; i++ {
-				groupLookup[extKeys[i]] = keeperGroups[scimKeys[i]]
+		if !s.inScopeGroup(group) {
+			value = nil
+		}
+		var conflict *scimGroup
+		if _, ok := value["displayName"]; ok {
+			conflict = s.findRenameConflict(keeperGroup, group.Name)
+		}
+		if conflict != nil && s.canMergeRename(conflict) && s.inCanaryGroup(group) {
+			var success, failure = s.mergeRenamedGroup(group, keeperGroup, conflict)
+			if len(success) > 0 {
+				s.matchDecisions = append(s.matchDecisions, &MatchDecision{
+					Action:     MatchActionMerge,
+					SourceId:   group.Id,
+					SourceName: group.Name,
+					ScimId:     conflict.Id,
+					ScimName:   conflict.Name,
+					Rule:       matchByName,
+					Confidence: 1,
+					Rationale:  fmt.Sprintf("renamed group has the name of the unmanaged SCIM group; \"%s\" was bound to the group before", keeperGroup.Name),
+				})
+				successes = append(successes, success)
+			} else {
+				failures = append(failures, failure)
 			}
+			delete(keeperGroups, conflict.Id)
+			delete(externalGroups, group.Id)
+			continue
 		}
-
-		for _, group := range externalGroups {
-			var key string
-			switch matchRound {
-			case 0, 2:
-				key = group.Id
-			case 1:
-				key = fold.String(group.Name)
-			default:
-				continue
+		if conflict != nil {
+			failures = append(failures, describeRenameConflict(keeperGroup, group, conflict))
+			delete(value, "displayName")
+			delete(inverse, "displayName")
+			if len(conflict.ExternalId) == 0 {
+				// the unmanaged group may be deleted in full destructive mode. Keep it until the conflict is resolved
+				delete(keeperGroups, conflict.Id)
 			}
-
-			if keeperGroup, ok := groupLookup[key]; ok {
-				var value = make(map[string]any)
-				if keeperGroup.ExternalId != group.Id {
-					value["externalId"] = group.Id
-				}
-				if keeperGroup.Name != group.Name {
-					value["displayName"] = group.Name
-				}
-
-				if len(value) > 0 {
-					var op = make(map[string]any)
-					op["op"] = "replace"
-					op["value"] = value
-					var payload = make(map[string]any)
-					payload["schemas"] = []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"}
-					payload["Operations"] = []any{op}
-					if er1 = s.patchResource("Groups", keeperGroup.Id, payload); er1 == nil {
-						keeperGroup.ExternalId = group.Id
-						keeperGroup.Name = group.Name
-						successes = append(successes, fmt.Sprintf("SCIM updated group \"%s\"", group.Name))
-					} else {
-						failures = append(failures, fmt.Sprintf("PATCH group \"%s\" error: %s", group.Name, er1.Error()))
-					}
+		}
+		if len(value) > 0 && !s.inCanaryGroup(group) {
+			s.deferCanary(fmt.Sprintf("update group \"%s\"", group.Name))
+		} else if len(value) > 0 {
+			var op = make(map[string]any)
+			op["op"] = "replace"
+			op["value"] = value
+			var payload = make(map[string]any)
+			payload["schemas"] = []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"}
+			payload["Operations"] = []any{op}
+			if er1 = s.patchResource("Groups", keeperGroup.Id, payload); er1 == nil {
+				s.recordChange(phaseGroups, "PATCH", "Groups", keeperGroup.Id, group.Name, &ScimOperation{
+					Method:       "PATCH",
+					ResourceType: "Groups",
+					ResourceId:   keeperGroup.Id,
+					Payload:      makePatchPayload(makePatchOperation("replace", "", inverse)),
+				})
+				s.recordMatchDecision(MatchActionBind, match, keeperGroup)
+				keeperGroup.ExternalId = s.externalId(group.Id)
+				if conflict == nil {
+					keeperGroup.Name = group.Name
 				}
-				delete(keeperGroups, keeperGroup.Id)
-				delete(externalGroups, group.Id)
+				successes = append(successes, fmt.Sprintf("SCIM updated group \"%s\"", group.Name))
+			} else {
+				failures = append(failures, fmt.Sprintf("PATCH group \"%s\" error: %s", group.Name, er1.Error()))
 			}
 		}
+		delete(keeperGroups, keeperGroup.Id)
+		delete(externalGroups, group.Id)
 	}
 	if len(externalGroups) > 0 {
-		for _, group := range externalGroups {
+		for _, group := range sortedValues(externalGroups, s.groupOrderKey) {
+			if !s.inScopeGroup(group) {
+				continue
+			}
+			if !s.inCanaryGroup(group) {
+				s.deferCanary(fmt.Sprintf("create group \"%s\"", group.Name))
+				continue
+			}
+			if sg := checkGroupCreate(group, s.foreignGroups); sg != nil {
+				failures = append(failures, fmt.Sprintf("POST group \"%s\" skipped: SCIM group with the same name is provisioned by another SCIM client", group.Name))
+				continue
+			}
+			if sg := checkGroupCreate(group, s.scimGroups); sg != nil {
+				failures = append(failures, fmt.Sprintf("POST group \"%s\" skipped: SCIM group with the same name is bound to external ID \"%s\"", group.Name, sg.ExternalId))
+				continue
+			}
 			var payload = make(map[string]any)
 			payload["schemas"] = []string{"urn:ietf:params:scim:schemas:core:2.0:Group"}
 			payload["displayName"] = group.Name
-			payload["externalId"] = group.Id
+			payload["externalId"] = s.externalId(group.Id)
+
+			if members := s.createMembersValue(group); len(members) > 0 {
+				payload["members"] = members
+			}
 
 			var added map[string]any
-			if added, er1 = s.postResource("Groups", payload); er1 == nil {
+			added, er1 = s.postResource("Groups", payload)
+			if er1 != nil && payload["members"] != nil && isRejectedPatch(er1) && !IsScimErrorType(er1, ScimTypeUniqueness) {
+				// the server does not accept members on create. Members are added by the membership phase
+				log.Printf("SCIM server rejected group creation with members: %s. Groups are created without members", er1.Error())
+				s.createMembersDenied = true
+				delete(payload, "members")
+				added, er1 = s.postResource("Groups", payload)
+			}
+			if er1 == nil {
+				var inverse *ScimOperation
+				var memberCount int
 				if sg := parseScimGroup(added); sg != nil {
 					s.scimGroups[sg.Id] = sg
+					memberCount = s.applyCreatedMembers(sg, added)
+					inverse = &ScimOperation{
+						Method:       "DELETE",
+						ResourceType: "Groups",
+						ResourceId:   sg.Id,
+					}
 				}
-				successes = append(successes, fmt.Sprintf("SCIM added group \"%s\"", group.Name))
+				s.recordChange(phaseGroups, "POST", "Groups", "", group.Name, inverse)
+				successes = append(successes, describeCreatedGroup(group.Name, memberCount))
 			} else {
 				failures = append(failures, fmt.Sprintf("POST group \"%s\" error: %s", group.Name, er1.Error()))
 			}
@@ -191,13 +653,34 @@ func (s *sync) syncGroups() (successes []string, failures []string, err error) {
 	}
 
 	if len(keeperGroups) > 0 {
-		for groupId, group := range keeperGroups {
+		for _, group := range sortedValues(keeperGroups, scimGroupSortKey) {
+			var groupId = group.Id
 			if s.destructive >= 0 {
-				if s.destructive > 0 || len(group.ExternalId) > 0 {
+				var policy = s.groupPolicy(group)
+				if policy == GroupPolicyCreateOnly || policy == GroupPolicyMembership {
+					if s.verbose {
+						failures = append(failures, fmt.Sprintf("DELETE group \"%s\": delete skipped since the group policy is \"%s\"", group.Name, policy))
+					}
+					continue
+				}
+				if s.destructive > 0 || policy == GroupPolicyManaged || len(group.ExternalId) > 0 {
+					if !s.inScopeDeleteGroup(group) {
+						continue
+					}
+					if !s.inCanaryDelete(s.sourceId(group.ExternalId), group.Id) {
+						s.deferCanary(fmt.Sprintf("delete group \"%s\"", group.Name))
+						continue
+					}
+					if !s.reserveDelete() {
+						failures = append(failures, fmt.Sprintf("DELETE group \"%s\": delete deferred to a subsequent run since the limit of %d deletes per run is reached", group.Name, s.maxDeletes))
+						continue
+					}
 					if er1 = s.deleteResource("Groups", groupId); er1 == nil {
+						s.recordChange(phaseGroups, "DELETE", "Groups", groupId, group.Name, nil)
 						delete(s.scimGroups, groupId)
 						successes = append(successes, fmt.Sprintf("SCIM deleted group \"%s\"", group.Name))
 					} else {
+						s.releaseDelete()
 						failures = append(failures, fmt.Sprintf("DELETE group \"%s\" error: %s", group.Name, er1))
 					}
 				} else {
@@ -213,7 +696,22 @@ func (s *sync) syncGroups() (successes []string, failures []string, err error) {
 	return
 }
 
-func (s *sync) syncUsers() (successes []string, failures []string, err error) {
+// reserveDelete checks the per-run deletion limit and counts the delete towards it.
+// A failed delete gives the slot back with releaseDelete, so only successful deletes count
+func (s *sync) reserveDelete() bool {
+	if s.maxDeletes > 0 && s.deletes >= s.maxDeletes {
+		return false
+	}
+	s.deletes++
+	return true
+}
+func (s *sync) releaseDelete() {
+	if s.deletes > 0 {
+		s.deletes--
+	}
+}
+
+func (s *sync) syncUsers() (successes []string, failures []string, skipped []*SkippedUser, err error) {
 	if s.scimUsers == nil {
 		err = errors.New("SCIM users were not populated")
 		return
@@ -223,43 +721,107 @@ func (s *sync) syncUsers() (successes []string, failures []string, err error) {
 		keeperUsers[k] = v
 	}
 
+	var er1 error
+	var ok bool
+
 	var externalUsers = make(map[string]*User)
+	var skippedUsers = newSkippedUserIndex()
 	s.source.Users(func(user *User) {
+		if su := s.validateSourceUser(user); su != nil {
+			skipped = append(skipped, su)
+			s.addSkippedUser(skippedUsers, user)
+			return
+		}
 		externalUsers[user.Id] = user
 	})
+	// skipped users still exist in the source. Never delete them
+	for k, v := range keeperUsers {
+		if skippedUsers.has(v) {
+			delete(keeperUsers, k)
+		}
+	}
+	var now = time.Now()
 
-	var er1 error
-	var fold = cases.Fold()
-	var ok bool
-
-	if len(keeperUsers) > 0 && len(externalUsers) > 0 {
-		var userLookup = make(map[string]*scimUser)
-		for _, v := range s.scimUsers {
-			userLookup[fold.String(v.Email)] = v
+	var pendingDeletions map[string]*pendingDeletion
+	if s.gracePeriodEnabled() {
+		if pendingDeletions, err = loadPendingDeletions(s.stateStore); err != nil {
+			return
+		}
+		for userId := range pendingDeletions {
+			if _, ok = s.scimUsers[userId]; !ok {
+				delete(pendingDeletions, userId)
+			}
 		}
+		defer func() {
+			if er2 := savePendingDeletions(s.stateStore, pendingDeletions); er2 != nil {
+				log.Printf("Failed to store pending user deletions: %s", er2.Error())
+			}
+		}()
+	}
 
-		for _, user := range externalUsers {
-			var keeperUser *scimUser
-			if keeperUser, ok = userLookup[fold.String(user.Email)]; !ok {
-				continue
+	var photoEtags map[string]string
+	if s.attributeEnabled(AttributePhotos) {
+		if photoEtags, err = loadPhotoEtags(s.stateStore); err != nil {
+			return
+		}
+		for userId := range photoEtags {
+			if _, ok = s.scimUsers[userId]; !ok {
+				delete(photoEtags, userId)
 			}
+		}
+		defer func() {
+			if er2 := savePhotoEtags(s.stateStore, photoEtags); er2 != nil {
+				log.Printf("Failed to store user photo etags: %s", er2.Error())
+			}
+		}()
+	}
+
+	if len(keeperUsers) > 0 && len(externalUsers) > 0 {
+		for _, match := range s.matchUsers(externalUsers, keeperUsers) {
+			var user, keeperUser = match.user, match.scimUser
 			var value = make(map[string]any)
-			if keeperUser.ExternalId != user.Id {
-				value["externalId"] = user.Id
+			var inverse = make(map[string]any)
+			if userName := s.userName(user); len(userName) > 0 && foldEmail(keeperUser.UserName) != foldEmail(userName) {
+				value["userName"] = userName
+				inverse["userName"] = keeperUser.UserName
+			}
+			if keeperUser.ExternalId != s.externalId(user.Id) {
+				value["externalId"] = s.externalId(user.Id)
+				inverse["externalId"] = keeperUser.ExternalId
 			}
 			if keeperUser.FullName != user.FullName {
 				value["displayName"] = user.FullName
+				inverse["displayName"] = keeperUser.FullName
 			}
 			if keeperUser.LastName != user.LastName {
 				value["name.familyName"] = user.LastName
+				inverse["name.familyName"] = keeperUser.LastName
 			}
 			if keeperUser.FirstName != user.FirstName {
 				value["name.givenName"] = user.FirstName
+				inverse["name.givenName"] = keeperUser.FirstName
 			}
 			if keeperUser.Active != user.Active {
 				value["active"] = user.Active
+				inverse["active"] = keeperUser.Active
+			}
+			s.diffUserAttributes(user, keeperUser, value, inverse)
+			var photoChanged bool
+			if photoEtags != nil {
+				if photoChanged, er1 = s.diffUserPhoto(user, keeperUser, photoEtags[keeperUser.Id], value, inverse); er1 != nil {
+					failures = append(failures, fmt.Sprintf("GET user \"%s\" photo error: %s", user.Email, er1.Error()))
+				}
 			}
-			if len(value) > 0 {
+			s.compareUser(user, value, inverse)
+			if _, ok = value[AttributePhotos]; !ok {
+				photoChanged = false
+			}
+			if !s.inScopeUser(user) {
+				value = nil
+			}
+			if len(value) > 0 && !s.inCanaryUser(user) {
+				s.deferCanary(fmt.Sprintf("update user \"%s\"", user.Email))
+			} else if len(value) > 0 {
 				var op = make(map[string]any)
 				op["op"] = "replace"
 				op["value"] = value
@@ -267,57 +829,286 @@ func (s *sync) syncUsers() (successes []string, failures []string, err error) {
 				payload["schemas"] = []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"}
 				payload["Operations"] = []any{op}
 				if er1 = s.patchResource("Users", keeperUser.Id, payload); er1 == nil {
-					keeperUser.ExternalId = user.Id
-					keeperUser.FullName = user.FullName
-					keeperUser.FirstName = user.FirstName
-					keeperUser.LastName = user.LastName
-					keeperUser.Active = user.Active
+					s.recordChange(phaseUsers, "PATCH", "Users", keeperUser.Id, user.Email, &ScimOperation{
+						Method:       "PATCH",
+						ResourceType: "Users",
+						ResourceId:   keeperUser.Id,
+						Payload:      makePatchPayload(makePatchOperation("replace", "", inverse)),
+					})
+					var applied = NewSet[string]()
+					for key := range value {
+						applied.Add(key)
+					}
+					s.copyAppliedAttributes(user, keeperUser, value, applied)
+					if photoChanged {
+						keeperUser.Photos, _ = value[AttributePhotos].([]any)
+						if len(user.PhotoEtag) > 0 {
+							photoEtags[keeperUser.Id] = user.PhotoEtag
+						} else {
+							delete(photoEtags, keeperUser.Id)
+						}
+					}
 					successes = append(successes, fmt.Sprintf("SCIM updated user \"%s\"", user.Email))
+				} else if len(value) > 1 && isRejectedPatch(er1) {
+					var applied, attributeFailures = s.patchUserAttributes(keeperUser, user.Email, value, inverse)
+					failures = append(failures, attributeFailures...)
+					if len(applied) > 0 {
+						s.copyAppliedAttributes(user, keeperUser, value, applied)
+						if photoChanged && applied.Has(AttributePhotos) {
+							keeperUser.Photos, _ = value[AttributePhotos].([]any)
+							if len(user.PhotoEtag) > 0 {
+								photoEtags[keeperUser.Id] = user.PhotoEtag
+							} else {
+								delete(photoEtags, keeperUser.Id)
+							}
+						}
+						var names = applied.ToArray()
+						sort.Strings(names)
+						successes = append(successes, fmt.Sprintf("SCIM partially updated user \"%s\": %s", user.Email, strings.Join(names, ", ")))
+					}
 				} else {
 					failures = append(failures, fmt.Sprintf("PATCH user \"%s\" error: %s", user.Email, er1.Error()))
 				}
 			}
 			delete(externalUsers, user.Id)
 			delete(keeperUsers, keeperUser.Id)
+			delete(pendingDeletions, keeperUser.Id)
 		}
 	}
 
 	if len(externalUsers) > 0 {
-		for _, user := range externalUsers {
-			if !user.Active {
+		var newUsers = sortedValues(externalUsers, s.userOrderKey)
+		var managerLookup = make(map[string]*scimUser)
+		if s.syncManager {
+			newUsers = sortUsersByManager(newUsers)
+			for _, v := range s.scimUsers {
+				managerLookup[foldEmail(v.Email)] = v
+			}
+		}
+		var inventory = newUserInventory(s.scimUsers)
+		for _, user := range newUsers {
+			if !user.Active || !s.inScopeUser(user) {
+				continue
+			}
+			if !s.inCanaryUser(user) {
+				s.deferCanary(fmt.Sprintf("create user \"%s\"", user.Email))
+				continue
+			}
+			var userName = s.userName(user)
+			var existing, skip = inventory.checkUserCreate(user, userName, keeperUsers)
+			if skip != nil {
+				skipped = append(skipped, skip)
+				continue
+			}
+			if existing != nil {
+				// SCIM user with the same email is not bound to any source user. Bind it instead of creating a duplicate
+				var value = map[string]any{
+					"userName":        userName,
+					"externalId":      s.externalId(user.Id),
+					"displayName":     user.FullName,
+					"name.givenName":  user.FirstName,
+					"name.familyName": user.LastName,
+					"active":          user.Active,
+				}
+				var inverse = map[string]any{
+					"userName":        existing.UserName,
+					"externalId":      existing.ExternalId,
+					"displayName":     existing.FullName,
+					"name.givenName":  existing.FirstName,
+					"name.familyName": existing.LastName,
+					"active":          existing.Active,
+				}
+				if er1 = s.patchResource("Users", existing.Id, makePatchPayload(makePatchOperation("replace", "", value))); er1 == nil {
+					s.recordChange(phaseUsers, "PATCH", "Users", existing.Id, user.Email, &ScimOperation{
+						Method:       "PATCH",
+						ResourceType: "Users",
+						ResourceId:   existing.Id,
+						Payload:      makePatchPayload(makePatchOperation("replace", "", inverse)),
+					})
+					existing.UserName = userName
+					existing.ExternalId = s.externalId(user.Id)
+					existing.FullName = user.FullName
+					existing.FirstName = user.FirstName
+					existing.LastName = user.LastName
+					existing.Active = user.Active
+					inventory.add(existing)
+					successes = append(successes, fmt.Sprintf("SCIM updated user \"%s\" instead of adding: the user exists with user name \"%s\"", user.Email, inverse["userName"]))
+				} else {
+					failures = append(failures, fmt.Sprintf("PATCH user \"%s\" error: %s", user.Email, er1.Error()))
+				}
+				delete(keeperUsers, existing.Id)
+				delete(pendingDeletions, existing.Id)
 				continue
 			}
 			var payload = make(map[string]any)
 			payload["schemas"] = []string{"urn:ietf:params:scim:schemas:core:2.0:User",
 				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"}
-			payload["userName"] = user.Email
-			payload["externalId"] = user.Id
+			payload["userName"] = userName
+			if userName != user.Email {
+				payload["emails"] = []any{map[string]any{"value": user.Email, "type": "work", "primary": true}}
+			}
+			payload["externalId"] = s.externalId(user.Id)
 			payload["displayName"] = user.FullName
 			var name = make(map[string]any)
 			name["givenName"] = user.FirstName
 			name["familyName"] = user.LastName
 			payload["name"] = name
 			payload["active"] = user.Active
-			if payload, er1 = s.postResource("Users", payload); er1 == nil {
+			s.addUserAttributes(user, payload)
+			var photoAdded bool
+			if photoEtags != nil && len(user.PhotoEtag) > 0 {
+				var photos []any
+				if photos, er1 = s.userPhotoValue(user); er1 == nil {
+					payload[AttributePhotos] = photos
+					photoAdded = true
+				} else {
+					failures = append(failures, fmt.Sprintf("GET user \"%s\" photo error: %s", user.Email, er1.Error()))
+				}
+			}
+			if s.syncManager && len(user.Manager) > 0 {
+				if manager, ok := managerLookup[foldEmail(user.Manager)]; ok {
+					payload[enterpriseUserSchema] = managerValue(manager.Id)
+				}
+			}
+			if su := validateUserPayload(user, payload); su != nil {
+				skipped = append(skipped, su)
+				continue
+			}
+			if groups := s.createGroupsValue(user); len(groups) > 0 {
+				payload["groups"] = groups
+			}
+			var response map[string]any
+			response, er1 = s.postResource("Users", payload)
+			if er1 != nil && payload["groups"] != nil && isRejectedPatch(er1) && !IsScimErrorType(er1, ScimTypeUniqueness) {
+				// the server does not accept memberships on create. Memberships are added by the membership phase
+				log.Printf("SCIM server rejected user creation with groups: %s. Users are created without groups", er1.Error())
+				s.createGroupsDenied = true
+				delete(payload, "groups")
+				response, er1 = s.postResource("Users", payload)
+			}
+			if er1 == nil {
+				payload = response
+				var inverse *ScimOperation
 				if au := parseScimUser(payload); au != nil {
+					s.readStateAttributes(au, payload)
 					s.scimUsers[au.Id] = au
+					inventory.add(au)
+					managerLookup[foldEmail(au.Email)] = au
+					if photoAdded {
+						photoEtags[au.Id] = user.PhotoEtag
+					}
+					inverse = &ScimOperation{
+						Method:       "DELETE",
+						ResourceType: "Users",
+						ResourceId:   au.Id,
+					}
+				} else {
+					s.unresolvedUsers = append(s.unresolvedUsers, userName)
+				}
+				s.recordChange(phaseUsers, "POST", "Users", "", user.Email, inverse)
+				if inverse != nil {
+					s.createdUsers.Add(inverse.ResourceId)
 				}
 				successes = append(successes, fmt.Sprintf("SCIM added user \"%s\"", user.Email))
+				s.queueNotification(NotifyProvisioned, user, "")
+			} else if IsScimErrorType(er1, ScimTypeUniqueness) {
+				// the user exists in SCIM but is not visible to the sync, e.g. it belongs to another node
+				skipped = append(skipped, &SkippedUser{
+					Id:     user.Id,
+					Email:  user.Email,
+					Reason: SkipReasonScimUniqueness,
+					Detail: fmt.Sprintf("SCIM server rejected the user as a duplicate: %s", er1.Error()),
+				})
 			} else {
 				failures = append(failures, fmt.Sprintf("POST user \"%s\" error: %s", user.Email, er1.Error()))
 			}
 		}
 	}
 	if len(keeperUsers) > 0 {
-		for _, user := range keeperUsers {
-			if !user.Active {
+		for _, user := range sortedValues(keeperUsers, scimUserSortKey) {
+			var pending *pendingDeletion
+			if pendingDeletions != nil {
+				pending = pendingDeletions[user.Id]
+			}
+			if !user.Active && pending == nil {
+				continue
+			}
+			if s.destructive >= 0 && !s.inScopeDeleteUser(user) {
+				continue
+			}
+			if s.destructive >= 0 && !s.inCanaryDelete(s.sourceId(user.ExternalId), user.Id) {
+				s.deferCanary(fmt.Sprintf("delete user \"%s\"", user.Email))
+				continue
+			}
+			if s.destructive >= 0 && s.keepDeletedUsers() {
+				// the user state mapping deactivates users deleted in the source instead of deleting them
+				delete(pendingDeletions, user.Id)
+				if !user.Active {
+					continue
+				}
+				var value = map[string]any{"active": false}
+				var inverse = map[string]any{"active": true}
+				s.diffStateAttributes(UserStateDeleted, user, value, inverse)
+				if er1 = s.patchResource("Users", user.Id, makePatchPayload(makePatchOperation("replace", "", value))); er1 == nil {
+					s.recordChange(phaseUsers, "PATCH", "Users", user.Id, user.Email, &ScimOperation{
+						Method:       "PATCH",
+						ResourceType: "Users",
+						ResourceId:   user.Id,
+						Payload:      makePatchPayload(makePatchOperation("replace", "", inverse)),
+					})
+					user.Active = false
+					s.copyStateAttributes(UserStateDeleted, user)
+					successes = append(successes, fmt.Sprintf("SCIM deactivated user \"%s\": the user is deleted in the source", user.Email))
+				} else {
+					failures = append(failures, fmt.Sprintf("PATCH user \"%s\" deactivation error: %s", user.Email, er1.Error()))
+				}
 				continue
 			}
 			if s.destructive >= 0 {
+				if pendingDeletions != nil {
+					if pending == nil {
+						pending = &pendingDeletion{
+							Email: user.Email,
+							Since: now,
+						}
+						pendingDeletions[user.Id] = pending
+					} else {
+						pending.Runs++
+					}
+					if days, runs := s.remainingGrace(pending, now); days > 0 || runs > 0 {
+						if user.Active {
+							var payload = makePatchPayload(makePatchOperation("replace", "", map[string]any{"active": false}))
+							if er1 = s.patchResource("Users", user.Id, payload); er1 == nil {
+								s.recordChange(phaseUsers, "PATCH", "Users", user.Id, user.Email, &ScimOperation{
+									Method:       "PATCH",
+									ResourceType: "Users",
+									ResourceId:   user.Id,
+									Payload:      makePatchPayload(makePatchOperation("replace", "", map[string]any{"active": true})),
+								})
+								user.Active = false
+								successes = append(successes, fmt.Sprintf("SCIM deactivated user \"%s\": deletion in %s", user.Email, describeGrace(days, runs)))
+								s.queueNotification(NotifyDeprovisioning, &user.User, describeGrace(days, runs))
+							} else {
+								delete(pendingDeletions, user.Id)
+								failures = append(failures, fmt.Sprintf("PATCH user \"%s\" deactivation error: %s", user.Email, er1.Error()))
+							}
+						} else {
+							s.pendingDeletions = append(s.pendingDeletions, fmt.Sprintf("User \"%s\": deletion in %s", user.Email, describeGrace(days, runs)))
+						}
+						continue
+					}
+				}
+				if !s.reserveDelete() {
+					failures = append(failures, fmt.Sprintf("DELETE user \"%s\": delete deferred to a subsequent run since the limit of %d deletes per run is reached", user.Email, s.maxDeletes))
+					continue
+				}
 				if er1 = s.deleteResource("Users", user.Id); er1 == nil {
+					s.recordChange(phaseUsers, "DELETE", "Users", user.Id, user.Email, nil)
 					delete(s.scimUsers, user.Id)
+					delete(pendingDeletions, user.Id)
 					successes = append(successes, fmt.Sprintf("SCIM deleted user \"%s\"", user.Email))
 				} else {
+					s.releaseDelete()
 					failures = append(failures, fmt.Sprintf("DELETE user \"%s\" error: %s", user.Email, er1.Error()))
 				}
 			} else {
@@ -329,27 +1120,33 @@ func (s *sync) syncUsers() (successes []string, failures []string, err error) {
 }
 
 func (s *sync) syncMembership() (successes []string, failures []string, err error) {
-	var fold = cases.Fold()
 	var keeperUserLookup = make(map[string]*scimUser)
 	for _, v := range s.scimUsers {
-		keeperUserLookup[fold.String(v.Email)] = v
+		keeperUserLookup[foldEmail(v.UserName)] = v
 	}
 	var keeperGroupMap = make(map[string]string)
 	for _, v := range s.scimGroups {
 		keeperGroupMap[v.ExternalId] = v.Id
 	}
+	var defaultGroupIds, defaultFailures = s.resolveDefaultGroups()
+	failures = append(failures, defaultFailures...)
+	var defaultGroups = MakeSet[string](defaultGroupIds)
 	var ok bool
 	var keeperUser *scimUser
 	var keeperGroup *scimGroup
-	s.source.Users(func(user *User) {
-		if keeperUser, ok = keeperUserLookup[fold.String(user.Email)]; !ok {
+	s.orderedUsers(func(user *User) {
+		var userName = s.userName(user)
+		if len(userName) == 0 {
+			return
+		}
+		if keeperUser, ok = keeperUserLookup[foldEmail(userName)]; !ok {
 			return
 		}
 		var keeperGroupId string
 		var keeperUserGroups = MakeSet[string](keeperUser.Groups)
 		var addGroups, removeGroups []string
 		for _, externalGroupId := range user.Groups {
-			if keeperGroupId, ok = keeperGroupMap[externalGroupId]; ok {
+			if keeperGroupId, ok = keeperGroupMap[s.externalId(externalGroupId)]; ok {
 				if keeperUserGroups.Has(keeperGroupId) {
 					keeperUserGroups.Delete(keeperGroupId)
 				} else {
@@ -357,29 +1154,60 @@ func (s *sync) syncMembership() (successes []string, failures []string, err erro
 				}
 			}
 		}
-		if len(keeperUserGroups) > 0 {
-			if s.destructive > 0 {
-				removeGroups = append(removeGroups, keeperUserGroups.ToArray()...)
-			} else {
-				for keeperGroupId = range keeperUserGroups {
-					if keeperGroup, ok = s.scimGroups[keeperGroupId]; ok {
-						if len(keeperGroup.ExternalId) > 0 {
-							removeGroups = append(removeGroups, keeperGroupId)
-						} else {
-							if s.verbose {
-								failures = append(failures, fmt.Sprintf("Remove team \"%s\" from user \"%s\" skipped. Team is not controlled by SCIM", keeperGroup.Name, user.Email))
-							}
-						}
-					} else {
-						if s.verbose {
-							failures = append(failures, fmt.Sprintf("Remove team Id \"%s\" from user \"%s\" skipped. Team is outside of SCIM node", keeperGroupId, user.Email))
-						}
-					}
+		if s.createdUsers.Has(keeperUser.Id) {
+			var adding = MakeSet[string](addGroups)
+			for _, groupId := range defaultGroupIds {
+				if !keeperUserGroups.Has(groupId) && !adding.Has(groupId) {
+					addGroups = append(addGroups, groupId)
 				}
 			}
 		}
-		if len(addGroups) > 0 || len(removeGroups) > 0 {
+		for keeperGroupId = range keeperUserGroups {
+			if defaultGroups.Has(keeperGroupId) || s.isForeignGroup(keeperGroupId) {
+				continue
+			}
+			var policy = GroupPolicyDefault
+			if keeperGroup, ok = s.scimGroups[keeperGroupId]; ok {
+				policy = s.groupPolicy(keeperGroup)
+			}
+			switch {
+			case policy == GroupPolicyCreateOnly:
+				if s.verbose {
+					failures = append(failures, fmt.Sprintf("Remove team \"%s\" from user \"%s\" skipped. Team policy is \"%s\"", keeperGroup.Name, user.Email, policy))
+				}
+			case s.destructive > 0 || policy == GroupPolicyMembership || policy == GroupPolicyManaged:
+				removeGroups = append(removeGroups, keeperGroupId)
+			case !ok:
+				if s.verbose {
+					failures = append(failures, fmt.Sprintf("Remove team Id \"%s\" from user \"%s\" skipped. Team is outside of SCIM node", keeperGroupId, user.Email))
+				}
+			case len(keeperGroup.ExternalId) > 0:
+				removeGroups = append(removeGroups, keeperGroupId)
+			default:
+				if s.verbose {
+					failures = append(failures, fmt.Sprintf("Remove team \"%s\" from user \"%s\" skipped. Team is not controlled by SCIM", keeperGroup.Name, user.Email))
+				}
+			}
+		}
+		if !s.inScopeUser(user) {
+			return
+		}
+		sort.Strings(removeGroups)
+		if (len(addGroups) > 0 || len(removeGroups) > 0) && !s.inCanaryUser(user) {
+			s.deferCanary(fmt.Sprintf("change user \"%s\" membership: %d added; %d removed", keeperUser.Email, len(addGroups), len(removeGroups)))
+			return
+		}
+		if len(addGroups) > 0 && len(removeGroups) > 0 && s.destructive >= 0 && s.membershipTransactionEnabled() {
+			var success, failure = s.applyMembershipTransaction(keeperUser, addGroups, removeGroups)
+			if len(success) > 0 {
+				successes = append(successes, success)
+			}
+			if len(failure) > 0 {
+				failures = append(failures, failure)
+			}
+		} else if len(addGroups) > 0 || len(removeGroups) > 0 {
 			var operations []any
+			var inverseOperations []any
 			var values []any
 			for _, groupId := range addGroups {
 				var value = make(map[string]any)
@@ -392,6 +1220,7 @@ func (s *sync) syncMembership() (successes []string, failures []string, err erro
 				op["path"] = "groups"
 				op["value"] = values
 				operations = append(operations, op)
+				inverseOperations = append(inverseOperations, makePatchOperation("remove", "groups", values))
 			}
 			values = nil
 			for _, groupId := range removeGroups {
@@ -406,6 +1235,7 @@ func (s *sync) syncMembership() (successes []string, failures []string, err erro
 					op["path"] = "groups"
 					op["value"] = values
 					operations = append(operations, op)
+					inverseOperations = append(inverseOperations, makePatchOperation("add", "groups", values))
 				} else {
 					failures = append(failures, fmt.Sprintf("REMOVE membership for user \"%s\" skipped since the \"Safe Mode\" is enforced", user.Email))
 				}
@@ -416,7 +1246,19 @@ func (s *sync) syncMembership() (successes []string, failures []string, err erro
 			payload["Operations"] = operations
 
 			if er1 := s.patchResource("Users", keeperUser.Id, payload); er1 == nil {
+				s.recordChange(phaseMembership, "PATCH", "Users", keeperUser.Id, keeperUser.Email, &ScimOperation{
+					Method:       "PATCH",
+					ResourceType: "Users",
+					ResourceId:   keeperUser.Id,
+					Payload:      makePatchPayload(inverseOperations...),
+				})
 				successes = append(successes, fmt.Sprintf("SCIM changed user \"%s\" membership: %d added; %d removed", keeperUser.Email, len(addGroups), len(removeGroups)))
+			} else if isCapacityError(er1) && len(addGroups) > 0 {
+				var added, removed, groupFailures = s.applyMembershipByGroup(keeperUser, addGroups, removeGroups)
+				if added > 0 || removed > 0 {
+					successes = append(successes, fmt.Sprintf("SCIM changed user \"%s\" membership: %d added; %d removed", keeperUser.Email, added, removed))
+				}
+				failures = append(failures, groupFailures...)
 			} else {
 				failures = append(failures, fmt.Sprintf("PATCH user \"%s\" membership error: %s", keeperUser.Email, er1.Error()))
 			}
@@ -0,0 +1,238 @@
+package scim
+
+import (
+	"net/http"
+	"time"
+)
+
+// SyncOption configures the sync engine created by NewScimSync
+type SyncOption func(*sync)
+
+// WithVerbose enables debug logging
+func WithVerbose(value bool) SyncOption {
+	return func(s *sync) {
+		s.verbose = value
+	}
+}
+
+// WithDestructivePolicy sets the deletion policy: positive deletes all unmatched resources,
+// zero deletes only resources controlled by SCIM, negative is the Safe Mode
+func WithDestructivePolicy(value int32) SyncOption {
+	return func(s *sync) {
+		s.destructive = value
+	}
+}
+
+// WithDryRun makes Sync return the sync plan without making any change
+func WithDryRun(value bool) SyncOption {
+	return func(s *sync) {
+		s.dryRun = value
+	}
+}
+
+// WithHTTPClient sets the client of SCIM requests. Trace and middleware wrap the transport of the client
+func WithHTTPClient(client *http.Client) SyncOption {
+	return func(s *sync) {
+		s.baseClient = client
+		s.client = nil
+	}
+}
+
+// WithUserComparator decides which attribute differences of existing users are patched
+func WithUserComparator(comparator UserComparator) SyncOption {
+	return func(s *sync) {
+		s.userComparator = comparator
+	}
+}
+
+// WithLogger receives debug messages of the sync engine and the data source instead of the standard logger.
+// Messages are sent only if the sync is verbose
+func WithLogger(logger SyncDebugLogger) SyncOption {
+	return func(s *sync) {
+		s.logger = logger
+	}
+}
+
+// WithParameters applies the sync settings of the SCIM endpoint parameters. Url and Token are the arguments of NewScimSync.
+// Settings that are built from the parameters, such as the state store, result sinks, hooks, policy and user notifier,
+// have their own options; NewSync builds and applies them
+func WithParameters(parameters *ScimEndpointParameters) SyncOption {
+	return func(s *sync) {
+		s.verbose = parameters.Verbose
+		s.trace = parameters.Trace
+		s.updateUsers = parameters.UpdateUsers
+		s.destructive = parameters.Destructive
+		s.maxDeletes = parameters.MaxDeletes
+		s.deleteGraceDays = parameters.DeleteGraceDays
+		s.deleteGraceRuns = parameters.DeleteGraceRuns
+		s.scimPaths = parameters.Paths
+		s.externalIdPrefix = parameters.ExternalIdPrefix
+		s.groupPolicies = parameters.GroupPolicies
+		s.userStates = parameters.UserStates
+		s.renameConflicts = parameters.RenameConflicts
+		s.groupMatchFallback = parameters.GroupMatchFallback
+		s.patchStyle = parameters.PatchStyle
+		s.probeDialect = parameters.ProbeDialect
+		s.dialect = ServerDialect{}
+		s.compressRequests = parameters.CompressRequests
+		s.client = nil
+		s.listParallelism = parameters.ListParallelism
+		s.membershipTx = parameters.MembershipTransaction
+		s.roleMapping = parameters.RoleMapping
+		s.entitlementMapping = parameters.EntitlementMapping
+		s.syncManager = parameters.SyncManager
+		s.cacheListings = parameters.CacheListings
+		s.cooperative = parameters.Cooperative
+		s.createWithGroups = parameters.CreateWithGroups
+		s.createWithMembers = parameters.CreateWithMembers
+		s.timeBudget = parameters.TimeBudget
+		s.historyRuns = parameters.HistoryRuns
+		s.attributes = MakeSet[string](parameters.Attributes)
+		if len(parameters.IgnoredAttributes) > 0 {
+			s.userComparator = NewIgnoreAttributesComparator(parameters.IgnoredAttributes)
+		}
+		s.userNameFormat = parameters.UserNameFormat
+		s.setAllowedDomains(parameters.AllowedDomains)
+		s.strict = parameters.StrictResolution
+		s.phases = parameters.Phases
+		s.phaseOrder = parameters.PhaseOrder
+		s.syncCaps = parameters.SyncCaps
+		s.canary = parameters.Canary
+		s.defaultGroups = parameters.DefaultGroups
+		s.userAgentSuffix = parameters.UserAgentSuffix
+	}
+}
+
+// WithTrace logs SCIM requests and responses with secrets removed
+func WithTrace(value bool) SyncOption {
+	return func(s *sync) {
+		s.trace = value
+	}
+}
+
+// WithScimPaths overrides the SCIM resource paths. nil uses the standard "Users" and "Groups" endpoints
+func WithScimPaths(paths *ScimPaths) SyncOption {
+	return func(s *sync) {
+		s.scimPaths = paths
+	}
+}
+
+// WithExternalIdPrefix sets the namespace prefix of externalId values, e.g. "google:"
+func WithExternalIdPrefix(prefix string) SyncOption {
+	return func(s *sync) {
+		s.externalIdPrefix = prefix
+	}
+}
+
+// WithUserNameFormat sets the template of SCIM user names. Empty format uses the primary email
+func WithUserNameFormat(format string) SyncOption {
+	return func(s *sync) {
+		s.userNameFormat = format
+	}
+}
+
+// WithTimeBudget sets the execution time of a sync run. Zero means no limit
+func WithTimeBudget(budget time.Duration) SyncOption {
+	return func(s *sync) {
+		s.timeBudget = budget
+	}
+}
+
+// WithRunScope limits the sync run to the users and groups of the scope. nil or empty scope syncs everything
+func WithRunScope(scope *RunScope) SyncOption {
+	return func(s *sync) {
+		s.runScope = scope
+	}
+}
+
+// WithStateStore keeps the run history, journals and sync state in the store
+func WithStateStore(store IStateStore) SyncOption {
+	return func(s *sync) {
+		s.stateStore = store
+	}
+}
+
+// WithResultSinks exports the statistics of every run to the sinks
+func WithResultSinks(sinks ...IResultSink) SyncOption {
+	return func(s *sync) {
+		s.resultSinks = append(s.resultSinks, sinks...)
+	}
+}
+
+// WithUserNotifier sends email to users created by the sync and to users scheduled for deletion.
+// Templates by event replace the default messages
+func WithUserNotifier(sender IMailSender, templates map[string]string) SyncOption {
+	return func(s *sync) {
+		s.mailSender = sender
+		s.notifyTemplates = templates
+	}
+}
+
+// WithPreSyncHook sends the sync plan to the hook before any change. A hook error aborts the run
+func WithPreSyncHook(hook ISyncHook) SyncOption {
+	return func(s *sync) {
+		s.preSyncHook = hook
+	}
+}
+
+// WithPostSyncHook sends the outcome of every run to the hook. Hook errors are logged only
+func WithPostSyncHook(hook ISyncHook) SyncOption {
+	return func(s *sync) {
+		s.postSyncHook = hook
+	}
+}
+
+// WithDriftReportHook delivers the drift report to the hook. Zero interval means DefaultDriftReportInterval
+func WithDriftReportHook(hook ISyncHook, interval time.Duration) SyncOption {
+	return func(s *sync) {
+		s.driftReportHook = hook
+		s.driftReportInterval = interval
+	}
+}
+
+// WithPolicy blocks the sync run if the plan violates the policy or the policy cannot be evaluated
+func WithPolicy(policy IPlanPolicy) SyncOption {
+	return func(s *sync) {
+		s.policy = policy
+	}
+}
+
+// WithMiddleware wraps the transport of SCIM requests. The first middleware is the outermost
+func WithMiddleware(middleware ...ScimMiddleware) SyncOption {
+	return func(s *sync) {
+		s.middleware = append(s.middleware, middleware...)
+		s.client = nil
+	}
+}
+
+// WithUserAgentSuffix appends an identifying string to the User-Agent header of SCIM requests.
+// Empty suffix uses the suffix set with SetUserAgentSuffix
+func WithUserAgentSuffix(suffix string) SyncOption {
+	return func(s *sync) {
+		s.userAgentSuffix = suffix
+	}
+}
+
+// userAgent identifies the client in SCIM requests of the sync
+func (s *sync) userAgent() string {
+	if len(s.userAgentSuffix) > 0 {
+		return userAgentWithSuffix(s.userAgentSuffix)
+	}
+	return UserAgent()
+}
+
+// dryRunNotice is reported by sync runs that make no change
+const dryRunNotice = "Dry run: no change was made"
+
+// dryRunSync projects the changes of the sync run
+func (s *sync) dryRunSync() (stat *SyncStat, err error) {
+	var plan *SyncPlan
+	if plan, err = s.Plan(); err != nil {
+		return
+	}
+	stat = &SyncStat{RunId: s.runId, Plan: plan, Notices: []string{dryRunNotice}}
+	if sas, ok := s.source.(ISourceApiStatsSource); ok {
+		stat.SourceApi = sas.ApiStats()
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TelemetrySchemaVersion is the version of the telemetry document
+const TelemetrySchemaVersion = 1
+
+// telemetryTimeout limits the telemetry request. Telemetry never delays a sync run for long
+const telemetryTimeout = 10 * time.Second
+
+// telemetryCounts are the outcome counters of a resource type
+type telemetryCounts struct {
+	Success int `json:"success"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// telemetryDocument is the anonymized summary of a sync run.
+// It contains no run ID, names, emails, identifiers or error messages
+type telemetryDocument struct {
+	SchemaVersion    int             `json:"schemaVersion"`
+	Version          string          `json:"version"`
+	GoVersion        string          `json:"goVersion"`
+	Platform         string          `json:"platform"`
+	DurationSeconds  float64         `json:"durationSeconds"`
+	Operations       int             `json:"operations"`
+	Users            telemetryCounts `json:"users"`
+	Groups           telemetryCounts `json:"groups"`
+	Membership       telemetryCounts `json:"membership"`
+	SkipReasons      map[string]int  `json:"skipReasons,omitempty"`
+	SafeMode         bool            `json:"safeMode"`
+	CanaryDeferred   int             `json:"canaryDeferred,omitempty"`
+	CapacityWarnings int             `json:"capacityWarnings,omitempty"`
+	// SourceRequests, SourceRetries and SourceErrors count Google API calls. Errors are counted by error class
+	SourceRequests int            `json:"sourceRequests,omitempty"`
+	SourceRetries  int            `json:"sourceRetries,omitempty"`
+	SourceErrors   map[string]int `json:"sourceErrors,omitempty"`
+}
+
+func makeTelemetryDocument(stat *SyncStat) *telemetryDocument {
+	var bi = GetBuildInfo()
+	var document = &telemetryDocument{
+		SchemaVersion:    TelemetrySchemaVersion,
+		Version:          bi.Version,
+		GoVersion:        bi.GoVersion,
+		Platform:         bi.Platform,
+		DurationSeconds:  stat.Finished.Sub(stat.Started).Seconds(),
+		Operations:       len(stat.Operations),
+		Users:            telemetryCounts{Success: len(stat.SuccessUsers), Failed: len(stat.FailedUsers), Skipped: len(stat.SkippedUsers)},
+		Groups:           telemetryCounts{Success: len(stat.SuccessGroups), Failed: len(stat.FailedGroups)},
+		Membership:       telemetryCounts{Success: len(stat.SuccessMembership), Failed: len(stat.FailedMembership)},
+		SafeMode:         len(stat.SafeModeReasons) > 0,
+		CanaryDeferred:   len(stat.CanaryDeferred),
+		CapacityWarnings: len(stat.CapacityWarnings),
+	}
+	for _, su := range stat.SkippedUsers {
+		if document.SkipReasons == nil {
+			document.SkipReasons = make(map[string]int)
+		}
+		document.SkipReasons[su.Reason]++
+	}
+	if stat.SourceApi != nil {
+		document.SourceRequests = stat.SourceApi.Requests
+		document.SourceRetries = stat.SourceApi.Retries
+		document.SourceErrors = stat.SourceApi.Errors
+	}
+	return document
+}
+
+type telemetrySink struct {
+	endpoint string
+}
+
+// ValidateTelemetryUrl checks the telemetry endpoint. Telemetry is sent over HTTPS only
+func ValidateTelemetryUrl(endpoint string) (result string, err error) {
+	result = strings.TrimSpace(endpoint)
+	if len(result) == 0 {
+		return
+	}
+	var u *url.URL
+	if u, err = url.Parse(result); err != nil || !strings.EqualFold(u.Scheme, "https") || len(u.Host) == 0 {
+		err = fmt.Errorf("telemetry URL \"%s\" must be an \"https://\" URL", endpoint)
+	}
+	return
+}
+
+// NewTelemetrySink creates IResultSink that posts the anonymized summary of every sync run to the endpoint.
+// Telemetry is opt-in: the sink is created only when the telemetry URL is configured
+func NewTelemetrySink(endpoint string) IResultSink {
+	return &telemetrySink{
+		endpoint: endpoint,
+	}
+}
+
+func (ts *telemetrySink) Export(stat *SyncStat) (err error) {
+	var payload []byte
+	if payload, err = json.Marshal(makeTelemetryDocument(stat)); err != nil {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), telemetryTimeout)
+	defer cancel()
+	var rq *http.Request
+	if rq, err = http.NewRequestWithContext(ctx, "POST", ts.endpoint, bytes.NewReader(payload)); err != nil {
+		return
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set("User-Agent", UserAgent())
+	var rs *http.Response
+	if rs, err = http.DefaultClient.Do(rq); err != nil {
+		err = fmt.Errorf("telemetry request error: %w", err)
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	if rs.StatusCode >= 300 {
+		var body, _ = io.ReadAll(io.LimitReader(rs.Body, 1024))
+		err = fmt.Errorf("telemetry error: status code %d: %s", rs.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return
+}
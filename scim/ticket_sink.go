@@ -0,0 +1,347 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ticketTimeout limits requests of a ticket system
+const ticketTimeout = 60 * time.Second
+
+// ticketEntryLimit is the number of entries listed per section of the ticket description
+const ticketEntryLimit = 50
+
+// ticketSystem opens tickets in Jira Cloud or ServiceNow
+type ticketSystem interface {
+	// createTicket returns the internal ID of the ticket, used for attachments, and its key shown to people
+	createTicket(ctx context.Context, summary string, description string) (id string, key string, err error)
+	attach(ctx context.Context, id string, fileName string, data []byte) error
+}
+
+type ticketSink struct {
+	system      ticketSystem
+	threshold   int
+	destructive bool
+}
+
+// NewTicketSink creates IResultSink that opens a ticket when a sync run fails, has at least threshold failed changes,
+// or, if destructive is set, deletes or deactivates SCIM resources. The SyncStat is attached to the ticket as JSON
+func NewTicketSink(system ticketSystem, threshold int, destructive bool) IResultSink {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &ticketSink{
+		system:      system,
+		threshold:   threshold,
+		destructive: destructive,
+	}
+}
+
+// newTicketSinkFromUri parses "jira+https://<email>:<api token>@<site>.atlassian.net?project=<key>"
+// or "servicenow+https://<user>:<password>@<instance>.service-now.com".
+// Common parameters: "threshold" number of failures, default 1; "destructive=false" ignores deletes and deactivations
+func newTicketSinkFromUri(uri *url.URL) (sink IResultSink, err error) {
+	if uri.User != nil {
+		var password, _ = uri.User.Password()
+		RegisterSecrets(password)
+	}
+	var query = uri.Query()
+	var threshold = 1
+	if value := query.Get("threshold"); len(value) > 0 {
+		if threshold, err = strconv.Atoi(value); err != nil || threshold < 1 {
+			err = fmt.Errorf("result sink: \"threshold\" must be a positive number")
+			return
+		}
+	}
+	var destructive = true
+	if value := query.Get("destructive"); len(value) > 0 {
+		if destructive, err = strconv.ParseBool(value); err != nil {
+			err = fmt.Errorf("result sink: \"destructive\" must be true or false")
+			return
+		}
+	}
+	if uri.User == nil || len(uri.Host) == 0 {
+		err = fmt.Errorf("result sink: user name, password and host are required")
+		return
+	}
+	var password, _ = uri.User.Password()
+	var client = &ticketClient{
+		baseUrl:  "https://" + uri.Host,
+		user:     uri.User.Username(),
+		password: password,
+	}
+	var system ticketSystem
+	switch strings.ToLower(uri.Scheme) {
+	case "jira+https":
+		var project = query.Get("project")
+		if len(project) == 0 {
+			err = fmt.Errorf("result sink: Jira \"project\" is required")
+			return
+		}
+		var issueType = query.Get("type")
+		if len(issueType) == 0 {
+			issueType = "Task"
+		}
+		system = &jiraTickets{client: client, project: project, issueType: issueType}
+	default:
+		var table = query.Get("table")
+		if len(table) == 0 {
+			table = "incident"
+		}
+		system = &serviceNowTickets{client: client, table: table, assignmentGroup: query.Get("assignment_group")}
+	}
+	sink = NewTicketSink(system, threshold, destructive)
+	return
+}
+
+// destructiveOperations returns executed changes that delete or deactivate SCIM resources
+func destructiveOperations(stat *SyncStat) (entries []*JournalEntry) {
+	for _, op := range stat.Operations {
+		if op.Method == "DELETE" || isDeactivation(op) {
+			entries = append(entries, op)
+		}
+	}
+	return
+}
+
+// isDeactivation returns true if the inverse operation of the change activates the user
+func isDeactivation(op *JournalEntry) bool {
+	if op.Method != "PATCH" || op.ResourceType != "Users" || op.Inverse == nil {
+		return false
+	}
+	var operations, _ = op.Inverse.Payload["Operations"].([]any)
+	for _, o := range operations {
+		if operation, ok := o.(map[string]any); ok {
+			if value, ok := operation["value"].(map[string]any); ok {
+				if active, ok := value["active"].(bool); ok && active {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ts *ticketSink) Export(stat *SyncStat) (err error) {
+	var failures = len(stat.FailedGroups) + len(stat.FailedUsers) + len(stat.FailedMembership)
+	var destructive []*JournalEntry
+	if ts.destructive {
+		destructive = destructiveOperations(stat)
+	}
+	var reasons []string
+	if failures >= ts.threshold {
+		reasons = append(reasons, fmt.Sprintf("%d failed change(s)", failures))
+	}
+	if len(destructive) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d destructive change(s)", len(destructive)))
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	var summary = fmt.Sprintf("Keeper SCIM sync run %s: %s", stat.RunId, strings.Join(reasons, ", "))
+	var data []byte
+	if data, err = json.MarshalIndent(stat, "", "  "); err != nil {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), ticketTimeout)
+	defer cancel()
+	var id, key string
+	if id, key, err = ts.system.createTicket(ctx, summary, ticketDescription(stat, destructive)); err != nil {
+		return
+	}
+	if err = ts.system.attach(ctx, id, fmt.Sprintf("sync-stat-%s.json", stat.RunId), data); err != nil {
+		err = fmt.Errorf("ticket %s: attach sync stat: %w", key, err)
+	}
+	return
+}
+
+func (ts *ticketSink) ExportError(runId string, syncErr error) (err error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), ticketTimeout)
+	defer cancel()
+	_, _, err = ts.system.createTicket(ctx, fmt.Sprintf("Keeper SCIM sync run %s failed", runId),
+		fmt.Sprintf("Sync run %s failed: %s\n", runId, SanitizeText(syncErr.Error())))
+	return
+}
+
+// ticketDescription formats the run summary, destructive changes and failures as plain text
+func ticketDescription(stat *SyncStat, destructive []*JournalEntry) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Sync run: %s\n", stat.RunId))
+	sb.WriteString(fmt.Sprintf("Started: %s\n", stat.Started.UTC().Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("Finished: %s\n", stat.Finished.UTC().Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("Groups: %d changed, %d failed\n", len(stat.SuccessGroups), len(stat.FailedGroups)))
+	sb.WriteString(fmt.Sprintf("Users: %d changed, %d failed, %d skipped\n", len(stat.SuccessUsers), len(stat.FailedUsers), len(stat.SkippedUsers)))
+	sb.WriteString(fmt.Sprintf("Memberships: %d changed, %d failed\n", len(stat.SuccessMembership), len(stat.FailedMembership)))
+	var section = func(title string, entries []string) {
+		if len(entries) == 0 {
+			return
+		}
+		sb.WriteString(fmt.Sprintf("\n%s:\n", title))
+		for i, entry := range entries {
+			if i >= ticketEntryLimit {
+				sb.WriteString(fmt.Sprintf("... and %d more\n", len(entries)-ticketEntryLimit))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("- %s\n", entry))
+		}
+	}
+	var changes []string
+	for _, op := range destructive {
+		var action = "deleted"
+		if op.Method != "DELETE" {
+			action = "deactivated"
+		}
+		changes = append(changes, fmt.Sprintf("%s %s \"%s\" (%s)", action, op.ResourceType, op.Name, op.ResourceId))
+	}
+	section("Destructive Changes", changes)
+	section("Group Failure", stat.FailedGroups)
+	section("User Failure", stat.FailedUsers)
+	section("Membership Failure", stat.FailedMembership)
+	section("Safe Mode", stat.SafeModeReasons)
+	sb.WriteString("\nThe complete sync statistics are attached as JSON.\n")
+	return sb.String()
+}
+
+// ticketClient sends authenticated requests to the REST API of the ticket system
+type ticketClient struct {
+	baseUrl  string
+	user     string
+	password string
+}
+
+func (tc *ticketClient) do(ctx context.Context, path string, contentType string, body []byte, headers map[string]string) (response []byte, err error) {
+	var rq *http.Request
+	if rq, err = http.NewRequestWithContext(ctx, "POST", tc.baseUrl+path, bytes.NewReader(body)); err != nil {
+		return
+	}
+	rq.SetBasicAuth(tc.user, tc.password)
+	rq.Header.Set("Content-Type", contentType)
+	rq.Header.Set("Accept", "application/json")
+	rq.Header.Set("User-Agent", UserAgent())
+	for k, v := range headers {
+		rq.Header.Set(k, v)
+	}
+	var rs *http.Response
+	if rs, err = http.DefaultClient.Do(rq); err != nil {
+		err = SanitizeError(fmt.Errorf("ticket request error: %w", err))
+		return
+	}
+	defer func() { _ = rs.Body.Close() }()
+	if response, err = io.ReadAll(io.LimitReader(rs.Body, 1024*1024)); err != nil {
+		return
+	}
+	if rs.StatusCode >= 300 {
+		var text = string(response)
+		if len(text) > 1024 {
+			text = text[:1024]
+		}
+		err = fmt.Errorf("ticket error: status code %d: %s", rs.StatusCode, strings.TrimSpace(text))
+	}
+	return
+}
+
+type jiraTickets struct {
+	client    *ticketClient
+	project   string
+	issueType string
+}
+
+func (jt *jiraTickets) createTicket(ctx context.Context, summary string, description string) (id string, key string, err error) {
+	var payload []byte
+	if payload, err = json.Marshal(map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": jt.project},
+			"issuetype":   map[string]string{"name": jt.issueType},
+			"summary":     summary,
+			"description": description,
+		},
+	}); err != nil {
+		return
+	}
+	var response []byte
+	if response, err = jt.client.do(ctx, "/rest/api/2/issue", "application/json", payload, nil); err != nil {
+		return
+	}
+	var issue struct {
+		Id  string `json:"id"`
+		Key string `json:"key"`
+	}
+	if err = json.Unmarshal(response, &issue); err != nil {
+		return
+	}
+	id = issue.Id
+	key = issue.Key
+	return
+}
+
+func (jt *jiraTickets) attach(ctx context.Context, id string, fileName string, data []byte) (err error) {
+	var body bytes.Buffer
+	var writer = multipart.NewWriter(&body)
+	var part io.Writer
+	if part, err = writer.CreateFormFile("file", fileName); err != nil {
+		return
+	}
+	if _, err = part.Write(data); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	_, err = jt.client.do(ctx, fmt.Sprintf("/rest/api/2/issue/%s/attachments", url.PathEscape(id)), writer.FormDataContentType(),
+		body.Bytes(), map[string]string{"X-Atlassian-Token": "no-check"})
+	return
+}
+
+type serviceNowTickets struct {
+	client          *ticketClient
+	table           string
+	assignmentGroup string
+}
+
+func (st *serviceNowTickets) createTicket(ctx context.Context, summary string, description string) (id string, key string, err error) {
+	var fields = map[string]string{
+		"short_description": summary,
+		"description":       description,
+	}
+	if len(st.assignmentGroup) > 0 {
+		fields["assignment_group"] = st.assignmentGroup
+	}
+	var payload []byte
+	if payload, err = json.Marshal(fields); err != nil {
+		return
+	}
+	var response []byte
+	if response, err = st.client.do(ctx, "/api/now/table/"+url.PathEscape(st.table), "application/json", payload, nil); err != nil {
+		return
+	}
+	var record struct {
+		Result struct {
+			SysId  string `json:"sys_id"`
+			Number string `json:"number"`
+		} `json:"result"`
+	}
+	if err = json.Unmarshal(response, &record); err != nil {
+		return
+	}
+	id = record.Result.SysId
+	key = record.Result.Number
+	return
+}
+
+func (st *serviceNowTickets) attach(ctx context.Context, id string, fileName string, data []byte) (err error) {
+	var query = url.Values{}
+	query.Set("table_name", st.table)
+	query.Set("table_sys_id", id)
+	query.Set("file_name", fileName)
+	_, err = st.client.do(ctx, "/api/now/attachment/file?"+query.Encode(), "application/json", data, nil)
+	return
+}
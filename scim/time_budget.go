@@ -0,0 +1,80 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTimeBudgetExhausted is the error of SCIM changes that were not started because the run is close to its time budget
+var ErrTimeBudgetExhausted = errors.New("time budget exhausted")
+
+// timeBudgetReserve is the time kept at the end of the budget to store the run state and report the result
+const timeBudgetReserve = 30 * time.Second
+
+// functionTimeoutVariable is set by the first generation Cloud Functions runtime
+const functionTimeoutVariable = "FUNCTION_TIMEOUT_SEC"
+
+// FunctionTimeBudget returns the execution time left to the Cloud Function invocation that started at the time:
+// the context deadline, or the FUNCTION_TIMEOUT_SEC timeout. Zero if the deadline is unknown
+func FunctionTimeBudget(ctx context.Context, started time.Time) (budget time.Duration) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(os.Getenv(functionTimeoutVariable))); err == nil && seconds > 0 {
+		budget = time.Duration(seconds)*time.Second - time.Since(started)
+		if budget <= 0 {
+			budget = time.Nanosecond
+		}
+	}
+	return
+}
+
+// startTimeBudget sets the deadline of the run. The run has no deadline if the time budget is not set
+func (s *sync) startTimeBudget() {
+	s.deadline = time.Time{}
+	if s.timeBudget > 0 {
+		s.deadline = time.Now().Add(s.timeBudget)
+	}
+}
+
+// budgetExhausted returns true if the run is within the reserve of its deadline and must not start SCIM changes
+func (s *sync) budgetExhausted() bool {
+	if s.deadline.IsZero() {
+		return false
+	}
+	var reserve = timeBudgetReserve
+	if s.timeBudget < 4*reserve {
+		reserve = s.timeBudget / 4
+	}
+	return time.Until(s.deadline) < reserve
+}
+
+// deferBudgetFailures moves the changes refused for the time budget from failures to BudgetDeferred and marks the run partial
+func deferBudgetFailures(stat *SyncStat) {
+	var marker = ErrTimeBudgetExhausted.Error()
+	var split = func(entries []string) (failures []string) {
+		for _, entry := range entries {
+			if strings.Contains(entry, marker) {
+				stat.BudgetDeferred = append(stat.BudgetDeferred, entry)
+			} else {
+				failures = append(failures, entry)
+			}
+		}
+		return
+	}
+	stat.FailedGroups = split(stat.FailedGroups)
+	stat.FailedUsers = split(stat.FailedUsers)
+	stat.FailedMembership = split(stat.FailedMembership)
+	if len(stat.BudgetDeferred) > 0 {
+		stat.Partial = true
+		var notice = fmt.Sprintf("Time budget exhausted: %d change(s) were not started and are left to the next run", len(stat.BudgetDeferred))
+		log.Print(notice)
+		stat.Notices = append(stat.Notices, notice)
+	}
+}
@@ -0,0 +1,109 @@
+package scim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const redacted = "***REDACTED***"
+
+var sensitiveJsonFields = regexp.MustCompile(`("(?:private_key|private_key_id|access_token|refresh_token|id_token|client_secret|assertion|password)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+var sensitiveFormFields = regexp.MustCompile(`((?:^|[&?])(?:assertion|access_token|refresh_token|client_secret|token)=)[^&\s"]*`)
+var bearerTokens = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+var privateKeys = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+// redactSecrets removes tokens and credentials from the text
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) >= 4 {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	text = sensitiveJsonFields.ReplaceAllString(text, `$1"`+redacted+`"`)
+	text = sensitiveFormFields.ReplaceAllString(text, "${1}"+redacted)
+	text = bearerTokens.ReplaceAllString(text, "${1}"+redacted)
+	text = privateKeys.ReplaceAllString(text, redacted)
+	return text
+}
+
+func redactHeaders(headers http.Header, secrets []string) string {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		for _, value := range headers[name] {
+			switch strings.ToLower(name) {
+			case "authorization", "proxy-authorization", "cookie", "set-cookie":
+				if pos := strings.Index(value, " "); pos > 0 {
+					value = value[:pos+1] + redacted
+				} else {
+					value = redacted
+				}
+			default:
+				value = redactSecrets(value, secrets)
+			}
+			sb.WriteString(fmt.Sprintf("\t%s: %s\n", name, value))
+		}
+	}
+	return sb.String()
+}
+
+// traceTransport logs HTTP requests and responses with secrets redacted
+type traceTransport struct {
+	next    http.RoundTripper
+	prefix  string
+	secrets []string
+}
+
+// newTraceTransport wraps the transport with trace logging
+// prefix: name of the API in the log
+// secrets: values that must never appear in the log
+func newTraceTransport(next http.RoundTripper, prefix string, secrets ...string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &traceTransport{
+		next:    next,
+		prefix:  prefix,
+		secrets: secrets,
+	}
+}
+
+func (tt *traceTransport) RoundTrip(rq *http.Request) (rs *http.Response, err error) {
+	var body []byte
+	if rq.Body != nil && rq.GetBody != nil {
+		var rc io.ReadCloser
+		if rc, err = rq.GetBody(); err == nil {
+			body, _ = io.ReadAll(rc)
+			_ = rc.Close()
+		}
+		err = nil
+	}
+	log.Printf("%s >> %s %s\n%s%s", tt.prefix, rq.Method, redactSecrets(rq.URL.String(), tt.secrets),
+		redactHeaders(rq.Header, tt.secrets), redactSecrets(string(body), tt.secrets))
+
+	if rs, err = tt.next.RoundTrip(rq); err != nil {
+		log.Printf("%s << %s %s error: %s", tt.prefix, rq.Method, redactSecrets(rq.URL.String(), tt.secrets), redactSecrets(err.Error(), tt.secrets))
+		return
+	}
+	if rs.Body != nil {
+		if body, err = io.ReadAll(rs.Body); err != nil {
+			_ = rs.Body.Close()
+			rs = nil
+			return
+		}
+		_ = rs.Body.Close()
+		rs.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	log.Printf("%s << %s\n%s%s", tt.prefix, rs.Status, redactHeaders(rs.Header, tt.secrets), redactSecrets(string(body), tt.secrets))
+	return
+}
@@ -0,0 +1,112 @@
+package scim
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection reuse of SCIM requests. The default transport keeps 2 idle connections per host,
+// so concurrent requests to the SCIM server open new TLS connections
+const (
+	scimMaxIdleConns        = 64
+	scimMaxIdleConnsPerHost = 16
+	scimIdleConnTimeout     = 90 * time.Second
+)
+
+// gzipRequestThreshold is the size of request bodies that are compressed
+const gzipRequestThreshold = 1024
+
+var scimTransport struct {
+	once      gosync.Once
+	transport http.RoundTripper
+	client    *http.Client
+}
+
+// defaultScimTransport returns the shared transport of SCIM requests: HTTP/2 if the server supports it,
+// gzip compressed responses and idle connections kept for reuse
+func defaultScimTransport() http.RoundTripper {
+	scimTransport.once.Do(func() {
+		var transport *http.Transport
+		if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+			transport = dt.Clone()
+		} else {
+			transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+		}
+		transport.ForceAttemptHTTP2 = true
+		transport.DisableCompression = false
+		transport.MaxIdleConns = scimMaxIdleConns
+		transport.MaxIdleConnsPerHost = scimMaxIdleConnsPerHost
+		transport.IdleConnTimeout = scimIdleConnTimeout
+		scimTransport.transport = transport
+		scimTransport.client = &http.Client{Transport: transport}
+	})
+	return scimTransport.transport
+}
+
+// defaultScimClient returns the shared client of SCIM requests
+func defaultScimClient() *http.Client {
+	defaultScimTransport()
+	return scimTransport.client
+}
+
+// gzipRequestTransport compresses request bodies with gzip. If the server responds with
+// "415 Unsupported Media Type", the request is sent again uncompressed and compression is turned off
+type gzipRequestTransport struct {
+	next     http.RoundTripper
+	disabled atomic.Bool
+}
+
+func newGzipRequestTransport(next http.RoundTripper) http.RoundTripper {
+	return &gzipRequestTransport{next: next}
+}
+
+func (gt *gzipRequestTransport) RoundTrip(rq *http.Request) (rs *http.Response, err error) {
+	if gt.disabled.Load() || rq.Body == nil || rq.GetBody == nil || rq.ContentLength < gzipRequestThreshold ||
+		len(rq.Header.Get("Content-Encoding")) > 0 {
+		return gt.next.RoundTrip(rq)
+	}
+	// the request is sent with bodies from GetBody, so the original body is closed unread
+	_ = rq.Body.Close()
+	var body io.ReadCloser
+	if body, err = rq.GetBody(); err != nil {
+		return
+	}
+	var data []byte
+	data, err = io.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		return
+	}
+	var buffer bytes.Buffer
+	var writer = gzip.NewWriter(&buffer)
+	if _, err = writer.Write(data); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	var compressed = buffer.Bytes()
+	var crq = rq.Clone(rq.Context())
+	crq.Body = io.NopCloser(bytes.NewReader(compressed))
+	crq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	crq.ContentLength = int64(len(compressed))
+	crq.Header.Set("Content-Encoding", "gzip")
+	if rs, err = gt.next.RoundTrip(crq); err != nil || rs.StatusCode != http.StatusUnsupportedMediaType {
+		return
+	}
+	_, _ = io.Copy(io.Discard, rs.Body)
+	_ = rs.Body.Close()
+	gt.disabled.Store(true)
+	var orq = rq.Clone(rq.Context())
+	if orq.Body, err = rq.GetBody(); err != nil {
+		return
+	}
+	return gt.next.RoundTrip(orq)
+}
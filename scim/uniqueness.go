@@ -0,0 +1,74 @@
+package scim
+
+import (
+	"fmt"
+
+	"golang.org/x/text/cases"
+)
+
+// userInventory indexes SCIM users by the attributes the SCIM server keeps unique
+type userInventory struct {
+	userNames map[string]*scimUser
+	emails    map[string]*scimUser
+}
+
+func newUserInventory(users map[string]*scimUser) *userInventory {
+	var inventory = &userInventory{
+		userNames: make(map[string]*scimUser),
+		emails:    make(map[string]*scimUser),
+	}
+	for _, v := range users {
+		inventory.add(v)
+	}
+	return inventory
+}
+
+func (ui *userInventory) add(user *scimUser) {
+	if len(user.UserName) > 0 {
+		ui.userNames[foldEmail(user.UserName)] = user
+	}
+	if len(user.Email) > 0 {
+		ui.emails[foldEmail(user.Email)] = user
+	}
+}
+
+// checkUserCreate validates the user create against SCIM inventory.
+// Returns a SCIM user that should be updated instead of the create,
+// or a SkippedUser if the create would fail with a uniqueness error
+// unbound: SCIM users not matched to any source user
+func (ui *userInventory) checkUserCreate(user *User, userName string, unbound map[string]*scimUser) (existing *scimUser, skip *SkippedUser) {
+	if su, ok := ui.userNames[foldEmail(userName)]; ok {
+		skip = &SkippedUser{
+			Id:     user.Id,
+			Email:  user.Email,
+			Reason: SkipReasonDuplicateUserName,
+			Detail: fmt.Sprintf("userName \"%s\" is already used by SCIM user \"%s\"", userName, su.Email),
+		}
+		return
+	}
+	if su, ok := ui.emails[foldEmail(user.Email)]; ok {
+		if _, ok = unbound[su.Id]; ok {
+			existing = su
+			return
+		}
+		skip = &SkippedUser{
+			Id:     user.Id,
+			Email:  user.Email,
+			Reason: SkipReasonDuplicateEmail,
+			Detail: fmt.Sprintf("email is already used by SCIM user \"%s\"", su.UserName),
+		}
+	}
+	return
+}
+
+// checkGroupCreate returns a SCIM group with the same display name if any
+func checkGroupCreate(group *Group, groups map[string]*scimGroup) *scimGroup {
+	var fold = cases.Fold()
+	var name = fold.String(group.Name)
+	for _, v := range groups {
+		if fold.String(v.Name) == name {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package scim
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/text/cases"
+)
+
+// Reasons a SCIM resource is outside the sync scope
+const (
+	// UnmanagedNoExternalId resources have no external ID and match no source user or group
+	UnmanagedNoExternalId = "no-external-id"
+	// UnmanagedForeign resources carry the external ID of another SCIM client in cooperative mode
+	UnmanagedForeign = "foreign"
+)
+
+// unmanagedAgeBuckets are the upper limits in days of the age buckets of the report
+var unmanagedAgeBuckets = []int{30, 90, 365}
+
+// UnmanagedReport lists SCIM users and groups that the sync never touches: resources outside the sync scope,
+// e.g. accounts created manually in Keeper. Shadow accounts are found here
+type UnmanagedReport struct {
+	RunId     string            `json:"runId"`
+	Generated time.Time         `json:"generated"`
+	Summary   string            `json:"summary"`
+	UserAges  map[string]int    `json:"userAges"`
+	GroupAges map[string]int    `json:"groupAges"`
+	Users     []*UnmanagedEntry `json:"users"`
+	Groups    []*UnmanagedEntry `json:"groups"`
+}
+
+// UnmanagedEntry is a SCIM resource outside the sync scope
+type UnmanagedEntry struct {
+	Id         string `json:"id"`
+	ExternalId string `json:"externalId,omitempty"`
+	Name       string `json:"name"`
+	// Active is the active flag of a user
+	Active *bool `json:"active,omitempty"`
+	// Reason is "no-external-id" or "foreign"
+	Reason       string     `json:"reason"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// AgeDays is the number of days since the resource was created. Omitted if the SCIM server does not report "meta.created"
+	AgeDays *int `json:"ageDays,omitempty"`
+}
+
+// ageBucket returns the age bucket label of the entry
+func (ue *UnmanagedEntry) ageBucket() string {
+	if ue.AgeDays == nil {
+		return "unknown"
+	}
+	var lower = 0
+	for _, upper := range unmanagedAgeBuckets {
+		if *ue.AgeDays < upper {
+			return fmt.Sprintf("%d-%dd", lower, upper)
+		}
+		lower = upper
+	}
+	return fmt.Sprintf("%dd+", lower)
+}
+
+func newUnmanagedEntry(id string, externalId string, name string, reason string, meta scimMeta, now time.Time) (entry *UnmanagedEntry) {
+	entry = &UnmanagedEntry{
+		Id:         id,
+		ExternalId: externalId,
+		Name:       name,
+		Reason:     reason,
+	}
+	if !meta.Created.IsZero() {
+		var created = meta.Created
+		var days = int(now.Sub(created).Hours() / 24)
+		entry.Created = &created
+		entry.AgeDays = &days
+	}
+	if !meta.LastModified.IsZero() {
+		var modified = meta.LastModified
+		entry.LastModified = &modified
+	}
+	return
+}
+
+// unmanagedReport collects SCIM users and groups with no external ID of this sync that match no source resource
+func (s *sync) unmanagedReport() (report *UnmanagedReport) {
+	var now = time.Now().UTC()
+	report = &UnmanagedReport{
+		RunId:     s.runId,
+		Generated: now,
+		UserAges:  make(map[string]int),
+		GroupAges: make(map[string]int),
+		Users:     []*UnmanagedEntry{},
+		Groups:    []*UnmanagedEntry{},
+	}
+	var fold = cases.Fold()
+
+	var sourceGroupNames = NewSet[string]()
+	s.source.Groups(func(group *Group) {
+		sourceGroupNames.Add(fold.String(group.Name))
+	})
+	var addGroup = func(sg *scimGroup, reason string) {
+		if sourceGroupNames.Has(fold.String(sg.Name)) {
+			return
+		}
+		report.Groups = append(report.Groups, newUnmanagedEntry(sg.Id, sg.ExternalId, sg.Name, reason, sg.meta, now))
+	}
+	for _, sg := range s.scimGroups {
+		if len(sg.ExternalId) == 0 {
+			addGroup(sg, UnmanagedNoExternalId)
+		}
+	}
+	for _, sg := range s.foreignGroups {
+		addGroup(sg, UnmanagedForeign)
+	}
+
+	var sourceUserNames = NewSet[string]()
+	var sourceExternalIds = NewSet[string]()
+	s.source.Users(func(user *User) {
+		sourceUserNames.Add(foldEmail(s.userName(user)))
+		sourceExternalIds.Add(s.externalId(user.Id))
+	})
+	var addUser = func(su *scimUser, reason string) {
+		if sourceUserNames.Has(foldEmail(su.UserName)) || (len(su.ExternalId) > 0 && sourceExternalIds.Has(su.ExternalId)) {
+			return
+		}
+		var entry = newUnmanagedEntry(su.Id, su.ExternalId, su.UserName, reason, su.meta, now)
+		var active = su.Active
+		entry.Active = &active
+		report.Users = append(report.Users, entry)
+	}
+	for _, su := range s.scimUsers {
+		if len(su.ExternalId) == 0 {
+			addUser(su, UnmanagedNoExternalId)
+		}
+	}
+	if s.foreignUsers != nil {
+		for _, su := range s.foreignUsers.userNames {
+			addUser(su, UnmanagedForeign)
+		}
+	}
+
+	for _, entries := range [][]*UnmanagedEntry{report.Users, report.Groups} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+	}
+	for _, entry := range report.Users {
+		report.UserAges[entry.ageBucket()]++
+	}
+	for _, entry := range report.Groups {
+		report.GroupAges[entry.ageBucket()]++
+	}
+	report.Summary = fmt.Sprintf("%d user(s) and %d group(s) in SCIM are outside the sync scope and are never changed by the sync",
+		len(report.Users), len(report.Groups))
+	return
+}
+
+// UnmanagedReport loads the source and SCIM data and reports SCIM resources outside the sync scope. No change is made
+func (s *sync) UnmanagedReport() (report *UnmanagedReport, err error) {
+	s.beginRun()
+	if _, err = s.populate(); err != nil {
+		return
+	}
+	report = s.unmanagedReport()
+	return
+}
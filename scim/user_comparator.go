@@ -0,0 +1,69 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UserComparator decides which attribute differences between a source user and its SCIM user require a PATCH
+type UserComparator interface {
+	// Differs returns true if the SCIM value of the attribute is replaced with the source value.
+	// attribute is the PATCH path, e.g. "displayName" or "name.givenName". It is called only for attributes that differ
+	Differs(user *User, attribute string, scimValue any, sourceValue any) bool
+}
+
+// DefaultUserComparator replaces every attribute that differs from the source
+type DefaultUserComparator struct{}
+
+func (DefaultUserComparator) Differs(*User, string, any, any) bool {
+	return true
+}
+
+// ignoredAttributes are user attributes that a comparator may ignore.
+// "externalId" and "active" are never ignored: they control the ownership and the access of the user
+var ignoredAttributes = append([]string{"displayName", "name.givenName", "name.familyName", "entitlements"}, supportedAttributes...)
+
+// ParseIgnoredAttributes validates user attributes whose differences do not require a PATCH
+func ParseIgnoredAttributes(entries []string) (attributes []string, err error) {
+	for _, entry := range entries {
+		var found = false
+		for _, a := range ignoredAttributes {
+			if strings.EqualFold(a, entry) {
+				attributes = append(attributes, a)
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("attribute \"%s\" cannot be ignored. Ignored attributes are %s", entry, strings.Join(ignoredAttributes, ", "))
+			return
+		}
+	}
+	return
+}
+
+type ignoreAttributesComparator struct {
+	attributes Set[string]
+}
+
+// NewIgnoreAttributesComparator creates comparator that ignores differences of the listed attributes
+func NewIgnoreAttributesComparator(attributes []string) UserComparator {
+	return &ignoreAttributesComparator{attributes: MakeSet[string](attributes)}
+}
+
+func (iac *ignoreAttributesComparator) Differs(_ *User, attribute string, _ any, _ any) bool {
+	return !iac.attributes.Has(attribute)
+}
+
+// compareUser removes the attributes that the user comparator does not count as changed from PATCH value and its inverse
+func (s *sync) compareUser(user *User, value map[string]any, inverse map[string]any) {
+	if s.userComparator == nil {
+		return
+	}
+	for key, sourceValue := range value {
+		if !s.userComparator.Differs(user, key, inverse[key], sourceValue) {
+			delete(value, key)
+			delete(inverse, key)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package scim
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events of user notifications
+const (
+	// NotifyProvisioned is sent to a user created by the sync
+	NotifyProvisioned = "provisioned"
+	// NotifyDeprovisioning is sent to a user deactivated by the sync and scheduled for deletion after the grace period
+	NotifyDeprovisioning = "deprovisioning"
+)
+
+var defaultNotifyTemplates = map[string]string{
+	NotifyProvisioned: `Subject: Your Keeper account is ready
+
+Hello {{.FirstName}},
+
+A Keeper account was created for {{.Email}} by your organization.
+Look for the Keeper invitation in your inbox to finish the setup.
+`,
+	NotifyDeprovisioning: `Subject: Your Keeper account is scheduled for deletion
+
+Hello {{.FirstName}},
+
+Your Keeper account {{.Email}} was deactivated because it is no longer assigned to you in Google Workspace.
+The account will be deleted in {{.Grace}}. Contact your administrator if this is a mistake.
+`,
+}
+
+// UserNotification is the data of the notification template
+type UserNotification struct {
+	Event     string
+	Email     string
+	FirstName string
+	LastName  string
+	FullName  string
+	RunId     string
+	// Grace is the time left before the account is deleted, e.g. "7 day(s)". Deprovisioning only
+	Grace string
+}
+
+// IMailSender delivers plain text email messages
+type IMailSender interface {
+	SendMail(to string, subject string, body string) error
+}
+
+// IGmailSource is implemented by data sources that send email through the Gmail API with their credentials
+type IGmailSource interface {
+	// GmailSender returns IMailSender that sends as the sender. Empty sender means the admin account
+	GmailSender(sender string) (IMailSender, error)
+}
+
+// NewMailSender creates IMailSender from the connection string
+// senderUri: "smtp://[user:password@]host[:port]?from=address" with STARTTLS, "smtps://..." with TLS,
+// or "gmail://[sender]" that uses the delegated credentials of the Google Workspace source
+func NewMailSender(senderUri string, source ICrmDataSource) (sender IMailSender, err error) {
+	senderUri = strings.TrimSpace(senderUri)
+	var uri *url.URL
+	if uri, err = url.Parse(senderUri); err != nil {
+		err = fmt.Errorf("user notification \"%s\": %w", SanitizeText(senderUri), err)
+		return
+	}
+	switch strings.ToLower(uri.Scheme) {
+	case "smtp", "smtps":
+		var from = uri.Query().Get("from")
+		if len(uri.Hostname()) == 0 || !isValidEmail(from) {
+			err = fmt.Errorf("user notification: expected \"%s://[user:password@]host[:port]?from=address\"", uri.Scheme)
+			return
+		}
+		var ss = &smtpSender{
+			host:     uri.Hostname(),
+			port:     uri.Port(),
+			from:     from,
+			implicit: strings.EqualFold(uri.Scheme, "smtps"),
+		}
+		if len(ss.port) == 0 {
+			ss.port = "587"
+			if ss.implicit {
+				ss.port = "465"
+			}
+		}
+		if uri.User != nil {
+			ss.username = uri.User.Username()
+			ss.password, _ = uri.User.Password()
+			RegisterSecrets(ss.password)
+		}
+		sender = ss
+	case "gmail":
+		var gs, ok = source.(IGmailSource)
+		if !ok {
+			err = fmt.Errorf("user notification \"gmail://\" requires the Google Workspace source")
+			return
+		}
+		var from = uri.Host
+		if len(uri.User.String()) > 0 {
+			from = uri.User.String() + "@" + uri.Host
+		}
+		sender, err = gs.GmailSender(from)
+	case "":
+		err = fmt.Errorf("user notification \"%s\": scheme is missing", SanitizeText(senderUri))
+	default:
+		err = fmt.Errorf("user notification scheme \"%s\" is not supported", uri.Scheme)
+	}
+	return
+}
+
+// ParseNotifyTemplate parses the notification template. The first line may set the subject: "Subject: text"
+func ParseNotifyTemplate(event string, text string) (subject *template.Template, body *template.Template, err error) {
+	var subjectText = "Keeper account notification"
+	if first, rest, ok := strings.Cut(text, "\n"); ok && strings.HasPrefix(strings.ToLower(first), "subject:") {
+		subjectText = strings.TrimSpace(first[len("subject:"):])
+		text = strings.TrimLeft(rest, "\r\n")
+	}
+	if subject, err = template.New(event + "-subject").Parse(subjectText); err != nil {
+		err = fmt.Errorf("notification template \"%s\": %w", event, err)
+		return
+	}
+	if body, err = template.New(event).Parse(text); err != nil {
+		err = fmt.Errorf("notification template \"%s\": %w", event, err)
+	}
+	return
+}
+
+// loadNotifyTemplates validates the templates by event. Empty templates are left out, so the default message is sent
+func loadNotifyTemplates(values map[string]string) (templates map[string]string, err error) {
+	for event, text := range values {
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+		if _, _, err = ParseNotifyTemplate(event, text); err != nil {
+			return
+		}
+		if templates == nil {
+			templates = make(map[string]string)
+		}
+		templates[event] = text
+	}
+	return
+}
+
+// notifyEnabled returns true if users are notified about the event
+func (s *sync) notifyEnabled() bool {
+	return s.mailSender != nil
+}
+
+// queueNotification schedules the notification of the user. Notifications are sent at the end of the run
+func (s *sync) queueNotification(event string, user *User, grace string) {
+	if !s.notifyEnabled() || len(user.Email) == 0 {
+		return
+	}
+	s.notifications = append(s.notifications, &UserNotification{
+		Event:     event,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		FullName:  user.FullName,
+		RunId:     s.runId,
+		Grace:     grace,
+	})
+}
+
+// sendNotifications delivers the queued notifications. Failures are logged only
+func (s *sync) sendNotifications() {
+	var notifications = s.notifications
+	s.notifications = nil
+	if len(notifications) == 0 {
+		return
+	}
+	var sent = 0
+	for _, n := range notifications {
+		var text, ok = s.notifyTemplates[n.Event]
+		if !ok {
+			text = defaultNotifyTemplates[n.Event]
+		}
+		var subjectTemplate, bodyTemplate, err = ParseNotifyTemplate(n.Event, text)
+		var subject, body bytes.Buffer
+		if err == nil {
+			err = subjectTemplate.Execute(&subject, n)
+		}
+		if err == nil {
+			err = bodyTemplate.Execute(&body, n)
+		}
+		if err == nil {
+			err = s.mailSender.SendMail(n.Email, subject.String(), body.String())
+		}
+		if err != nil {
+			log.Printf("Failed to notify user \"%s\" (%s): %s", n.Email, n.Event, SanitizeText(err.Error()))
+			continue
+		}
+		sent++
+	}
+	log.Printf("Sent %d of %d user notification(s)", sent, len(notifications))
+}
+
+// composeMail builds the RFC 5322 message with the quoted-printable UTF-8 text body
+func composeMail(from string, to string, subject string, body string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("From: %s\r\n", from))
+	buffer.WriteString(fmt.Sprintf("To: %s\r\n", to))
+	buffer.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject)))
+	buffer.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	var qp = quotedprintable.NewWriter(&buffer)
+	_, _ = qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	_ = qp.Close()
+	return buffer.Bytes()
+}
+
+type smtpSender struct {
+	host     string
+	port     string
+	from     string
+	username string
+	password string
+	implicit bool
+}
+
+func (ss *smtpSender) SendMail(to string, subject string, body string) (err error) {
+	if _, err = mail.ParseAddress(to); err != nil {
+		return
+	}
+	var address = net.JoinHostPort(ss.host, ss.port)
+	var auth smtp.Auth
+	if len(ss.username) > 0 {
+		auth = smtp.PlainAuth("", ss.username, ss.password, ss.host)
+	}
+	var message = composeMail(ss.from, to, subject, body)
+	if !ss.implicit {
+		// smtp.SendMail upgrades the connection with STARTTLS if the server supports it
+		return smtp.SendMail(address, auth, ss.from, []string{to}, message)
+	}
+	var conn *tls.Conn
+	if conn, err = tls.Dial("tcp", address, &tls.Config{ServerName: ss.host}); err != nil {
+		return
+	}
+	var client *smtp.Client
+	if client, err = smtp.NewClient(conn, ss.host); err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() { _ = client.Close() }()
+	if auth != nil {
+		if err = client.Auth(auth); err != nil {
+			return
+		}
+	}
+	if err = client.Mail(ss.from); err != nil {
+		return
+	}
+	if err = client.Rcpt(to); err != nil {
+		return
+	}
+	var w, er1 = client.Data()
+	if er1 != nil {
+		err = er1
+		return
+	}
+	if _, err = w.Write(message); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	err = client.Quit()
+	return
+}
@@ -0,0 +1,212 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source account states
+const (
+	UserStateActive    = "active"
+	UserStateSuspended = "suspended"
+	UserStateArchived  = "archived"
+	// UserStateDeleted is the state of SCIM users missing in the source
+	UserStateDeleted = "deleted"
+)
+
+// Actions of the user state mapping
+const (
+	UserStateActionActive   = "active"
+	UserStateActionInactive = "inactive"
+	UserStateActionDelete   = "delete"
+)
+
+var userStates = []string{UserStateActive, UserStateSuspended, UserStateArchived, UserStateDeleted}
+
+// UserStateRule defines how users in a source account state are provisioned
+type UserStateRule struct {
+	// Action is "active" or "inactive". The deleted state accepts "delete" (default) or "inactive", i.e. deactivate and never delete
+	Action string
+	// Attributes are SCIM attributes set for users in the state, e.g. target-specific extension fields
+	// "urn:ietf:params:scim:schemas:extension:keeper:2.0:User:locked"
+	Attributes map[string]any
+}
+
+// ParseUserStateMapping parses "state=action" and "state.attribute=value" entries separated by comma or new line.
+// State is one of "active", "suspended", "archived", "deleted". Attribute values "true" and "false" are booleans
+func ParseUserStateMapping(entries []string) (mapping map[string]*UserStateRule, err error) {
+	for _, entry := range entries {
+		var pos = strings.Index(entry, "=")
+		if pos <= 0 {
+			err = fmt.Errorf("user state mapping \"%s\" is not in \"state=action\" or \"state.attribute=value\" format", entry)
+			return
+		}
+		var key = strings.TrimSpace(entry[:pos])
+		var value = strings.TrimSpace(entry[pos+1:])
+		var state, attribute = key, ""
+		if dot := strings.Index(key, "."); dot > 0 {
+			state, attribute = key[:dot], strings.TrimSpace(key[dot+1:])
+		}
+		state = strings.ToLower(strings.TrimSpace(state))
+		if !MakeSet[string](userStates).Has(state) {
+			err = fmt.Errorf("user state mapping \"%s\": unsupported state. Valid states are %s", entry, strings.Join(userStates, ", "))
+			return
+		}
+		if mapping == nil {
+			mapping = make(map[string]*UserStateRule)
+		}
+		var rule, ok = mapping[state]
+		if !ok {
+			rule = new(UserStateRule)
+			mapping[state] = rule
+		}
+		if len(attribute) > 0 {
+			if rule.Attributes == nil {
+				rule.Attributes = make(map[string]any)
+			}
+			switch strings.ToLower(value) {
+			case "true":
+				rule.Attributes[attribute] = true
+			case "false":
+				rule.Attributes[attribute] = false
+			default:
+				rule.Attributes[attribute] = value
+			}
+			continue
+		}
+		var action = strings.ToLower(value)
+		switch {
+		case action == UserStateActionInactive:
+		case action == UserStateActionActive && state != UserStateDeleted:
+		case action == UserStateActionDelete && state == UserStateDeleted:
+		default:
+			if state == UserStateDeleted {
+				err = fmt.Errorf("user state mapping \"%s\": unsupported action. Valid actions are delete, inactive", entry)
+			} else {
+				err = fmt.Errorf("user state mapping \"%s\": unsupported action. Valid actions are active, inactive", entry)
+			}
+			return
+		}
+		rule.Action = action
+	}
+	return
+}
+
+// applyUserStates overrides the active flag of source users by the user state mapping
+func (s *sync) applyUserStates() {
+	if len(s.userStates) == 0 {
+		return
+	}
+	s.source.Users(func(user *User) {
+		if rule, ok := s.userStates[user.State]; ok && len(rule.Action) > 0 {
+			user.Active = rule.Action == UserStateActionActive
+		}
+	})
+}
+
+// keepDeletedUsers returns true if SCIM users missing in the source are deactivated instead of deleted
+func (s *sync) keepDeletedUsers() bool {
+	var rule, ok = s.userStates[UserStateDeleted]
+	return ok && rule.Action == UserStateActionInactive
+}
+
+// stateAttributePaths returns attributes of all state rules
+func (s *sync) stateAttributePaths() (paths []string) {
+	var found = NewSet[string]()
+	for _, state := range userStates {
+		if rule, ok := s.userStates[state]; ok {
+			for path := range rule.Attributes {
+				if !found.Has(path) {
+					found.Add(path)
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+	return
+}
+
+// splitAttributePath splits a SCIM attribute path into the schema URN and the attribute name.
+// Core schema attributes have no schema URN
+func splitAttributePath(path string) (schema string, name string) {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		if pos := strings.LastIndex(path, ":"); pos > 0 {
+			return path[:pos], path[pos+1:]
+		}
+	}
+	return "", path
+}
+
+func getScimAttribute(object map[string]any, path string) any {
+	var schema, name = splitAttributePath(path)
+	if len(schema) == 0 {
+		return object[name]
+	}
+	if extension, ok := object[schema].(map[string]any); ok {
+		return extension[name]
+	}
+	return nil
+}
+
+func setScimAttribute(object map[string]any, path string, value any) {
+	var schema, name = splitAttributePath(path)
+	if len(schema) == 0 {
+		object[name] = value
+		return
+	}
+	var extension, ok = object[schema].(map[string]any)
+	if !ok {
+		extension = make(map[string]any)
+		object[schema] = extension
+	}
+	extension[name] = value
+	if schemas, ok := object["schemas"].([]string); ok && !MakeSet[string](schemas).Has(schema) {
+		object["schemas"] = append(schemas, schema)
+	}
+}
+
+// readStateAttributes keeps the SCIM user attributes controlled by the user state mapping
+func (s *sync) readStateAttributes(user *scimUser, userObject map[string]any) {
+	for _, path := range s.stateAttributePaths() {
+		if value := getScimAttribute(userObject, path); value != nil {
+			if user.StateAttributes == nil {
+				user.StateAttributes = make(map[string]any)
+			}
+			user.StateAttributes[path] = value
+		}
+	}
+}
+
+// addStateAttributes sets the attributes of the user state to the POST payload
+func (s *sync) addStateAttributes(user *User, payload map[string]any) {
+	if rule, ok := s.userStates[user.State]; ok {
+		for path, value := range rule.Attributes {
+			setScimAttribute(payload, path, value)
+		}
+	}
+}
+
+// diffStateAttributes adds the attributes of the user state that differ from the SCIM user to the PATCH values
+func (s *sync) diffStateAttributes(state string, keeperUser *scimUser, value map[string]any, inverse map[string]any) {
+	if rule, ok := s.userStates[state]; ok {
+		for path, v := range rule.Attributes {
+			var current = keeperUser.StateAttributes[path]
+			if current == nil || fmt.Sprint(current) != fmt.Sprint(v) {
+				value[path] = v
+				inverse[path] = current
+			}
+		}
+	}
+}
+
+// copyStateAttributes updates the attributes of the SCIM user after successful PATCH
+func (s *sync) copyStateAttributes(state string, keeperUser *scimUser) {
+	if rule, ok := s.userStates[state]; ok && len(rule.Attributes) > 0 {
+		if keeperUser.StateAttributes == nil {
+			keeperUser.StateAttributes = make(map[string]any)
+		}
+		for path, value := range rule.Attributes {
+			keeperUser.StateAttributes[path] = value
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package scim
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultUserNameFormat makes the primary email the SCIM userName
+const DefaultUserNameFormat = "{email}"
+
+var userNamePlaceholder = regexp.MustCompile(`\{([A-Za-z]+)(?::([^{}]+))?}`)
+
+var userNamePlaceholders = []string{"email", "localPart", "domain", "employeeId", "firstName", "lastName"}
+
+// ParseUserNameFormat validates the source of SCIM userName.
+// format: "email", "employeeId" or a template with placeholders, e.g. "{localPart}@corp.example.com".
+// "{attribute:name}" is replaced with the source attribute, e.g. "{attribute:HR.Login}"
+func ParseUserNameFormat(format string) (result string, err error) {
+	format = strings.TrimSpace(format)
+	switch strings.ToLower(format) {
+	case "", "email":
+		result = DefaultUserNameFormat
+		return
+	case "employeeid":
+		result = "{employeeId}"
+		return
+	}
+	var matches = userNamePlaceholder.FindAllStringSubmatch(format, -1)
+	if len(matches) == 0 {
+		err = fmt.Errorf("user name format \"%s\" does not contain any placeholder. Supported placeholders are {%s}", format, strings.Join(userNamePlaceholders, "}, {"))
+		return
+	}
+	for _, m := range matches {
+		var found = m[1] == "attribute" && len(m[2]) > 0
+		for _, p := range userNamePlaceholders {
+			if m[1] == p && len(m[2]) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("user name format \"%s\": unsupported placeholder \"%s\"", format, m[0])
+			return
+		}
+	}
+	result = format
+	return
+}
+
+// formatUserName builds SCIM userName for the source user.
+// Returns an empty string if any placeholder resolves to an empty value
+func formatUserName(format string, user *User) (result string) {
+	if len(format) == 0 || format == DefaultUserNameFormat {
+		return user.Email
+	}
+	var localPart, domain = user.Email, ""
+	if pos := strings.LastIndex(user.Email, "@"); pos >= 0 {
+		localPart, domain = user.Email[:pos], user.Email[pos+1:]
+	}
+	var missing = false
+	result = userNamePlaceholder.ReplaceAllStringFunc(format, func(placeholder string) (value string) {
+		switch placeholder {
+		case "{email}":
+			value = user.Email
+		case "{localPart}":
+			value = localPart
+		case "{domain}":
+			value = domain
+		case "{employeeId}":
+			value = user.EmployeeId
+		case "{firstName}":
+			value = user.FirstName
+		case "{lastName}":
+			value = user.LastName
+		default:
+			if m := userNamePlaceholder.FindStringSubmatch(placeholder); m != nil && m[1] == "attribute" {
+				value = user.AttributeString(m[2])
+			}
+		}
+		if len(value) == 0 {
+			missing = true
+		}
+		return
+	})
+	if missing {
+		result = ""
+	}
+	return
+}
+
+func (s *sync) userName(user *User) string {
+	return formatUserName(s.userNameFormat, user)
+}
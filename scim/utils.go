@@ -1,10 +1,21 @@
 package scim
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// newRunId generates a sortable identifier of a sync run
+func newRunId() string {
+	var suffix = make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
 func ParseScimGroups(fields []map[string]any) (groups []string) {
 	for _, field := range fields {
 		var v any
@@ -114,6 +125,31 @@ func toInt64(intf interface{}) (result int64, ok bool) {
 	return
 }
 
+// sortedValues returns the map values ordered by the sort key, so map contents are processed in a stable order
+func sortedValues[K comparable, V any](m map[K]V, sortKey func(V) string) (values []V) {
+	values = make([]V, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return sortKey(values[i]) < sortKey(values[j])
+	})
+	return
+}
+
+func groupSortKey(g *Group) string {
+	return strings.ToLower(g.Name) + "\x00" + g.Id
+}
+func userSortKey(u *User) string {
+	return strings.ToLower(u.Email) + "\x00" + u.Id
+}
+func scimGroupSortKey(g *scimGroup) string {
+	return groupSortKey(&g.Group)
+}
+func scimUserSortKey(u *scimUser) string {
+	return userSortKey(&u.User)
+}
+
 type Set[K comparable] map[K]struct{}
 
 func NewSet[K comparable]() Set[K] {
@@ -0,0 +1,138 @@
+package scim
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	gosync "sync"
+)
+
+// Build information. Release builds set it with linker flags, e.g.
+//
+//	go build -ldflags "-X github.com/keeper-security/ksm-google-scim/scim.Version=1.2.0 -X github.com/keeper-security/ksm-google-scim/scim.Commit=$(git rev-parse --short HEAD) -X github.com/keeper-security/ksm-google-scim/scim.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values that are not set are taken from the build information Go embeds in the binary
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// BuildInfo identifies the client build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// GetBuildInfo returns the version, git commit and build date of the binary
+func GetBuildInfo() *BuildInfo {
+	var bi = &BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if len(bi.Version) == 0 && info.Main.Version != "(devel)" {
+			bi.Version = info.Main.Version
+		}
+		var modified bool
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if len(bi.Commit) == 0 {
+					bi.Commit = setting.Value
+					if len(bi.Commit) > 12 {
+						bi.Commit = bi.Commit[:12]
+					}
+				}
+			case "vcs.time":
+				if len(bi.BuildDate) == 0 {
+					bi.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && len(Commit) == 0 && len(bi.Commit) > 0 {
+			bi.Commit += "-dirty"
+		}
+	}
+	if len(bi.Version) == 0 {
+		bi.Version = "devel"
+	}
+	return bi
+}
+
+func (bi *BuildInfo) String() string {
+	var text = fmt.Sprintf("ksm-scim %s", bi.Version)
+	if len(bi.Commit) > 0 {
+		text += fmt.Sprintf(", commit %s", bi.Commit)
+	}
+	if len(bi.BuildDate) > 0 {
+		text += fmt.Sprintf(", built %s", bi.BuildDate)
+	}
+	return text + fmt.Sprintf(", %s %s", bi.GoVersion, bi.Platform)
+}
+
+// userAgentSuffixLimit limits the length of the customer identification string
+const userAgentSuffixLimit = 128
+
+var userAgentSuffix struct {
+	lock  gosync.Mutex
+	value string
+}
+
+// ValidateUserAgentSuffix checks the string appended to the User-Agent header.
+// Only printable ASCII characters are accepted, so the value cannot inject other headers
+func ValidateUserAgentSuffix(suffix string) (result string, err error) {
+	result = strings.TrimSpace(suffix)
+	if len(result) > userAgentSuffixLimit {
+		err = fmt.Errorf("user agent suffix exceeds %d characters", userAgentSuffixLimit)
+		return
+	}
+	for _, ch := range result {
+		if ch < ' ' || ch > '~' {
+			err = fmt.Errorf("user agent suffix \"%s\" contains characters other than printable ASCII", suffix)
+			return
+		}
+	}
+	return
+}
+
+// SetUserAgentSuffix appends an identifying string, e.g. a ticket number or an environment name,
+// to the User-Agent header of every request of the process. The suffix of a sync engine, see WithUserAgentSuffix,
+// takes precedence in its SCIM and Google API requests
+func SetUserAgentSuffix(suffix string) {
+	userAgentSuffix.lock.Lock()
+	defer userAgentSuffix.lock.Unlock()
+	userAgentSuffix.value = strings.TrimSpace(suffix)
+}
+
+// UserAgent identifies the client in SCIM and Google API requests, e.g. "ksm-scim/1.2.0 (3f2a1b9c0d4e; go1.21.6; linux/amd64)",
+// followed by the suffix set with SetUserAgentSuffix
+func UserAgent() string {
+	userAgentSuffix.lock.Lock()
+	var suffix = userAgentSuffix.value
+	userAgentSuffix.lock.Unlock()
+	return userAgentWithSuffix(suffix)
+}
+
+// userAgentWithSuffix returns the User-Agent header followed by the suffix
+func userAgentWithSuffix(suffix string) string {
+	var bi = GetBuildInfo()
+	var commit = bi.Commit
+	if len(commit) == 0 {
+		commit = "unknown"
+	}
+	var userAgent = fmt.Sprintf("ksm-scim/%s (%s; %s; %s)", bi.Version, commit, bi.GoVersion, bi.Platform)
+	if suffix = strings.TrimSpace(suffix); len(suffix) > 0 {
+		userAgent += " " + suffix
+	}
+	return userAgent
+}